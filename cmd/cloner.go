@@ -25,11 +25,16 @@ var (
 	cloneSourceDB             string
 	cloneTargetDB             string
 	cloneAssessmentName       string
+	cloneAssessmentId         string
+	cloneGlobalId             string
 	cloneTargetAssessmentName string
 	cloneOverrideTemplate     bool
 	cloneDeleteOnFailure      bool
 	cloneForceEnvOnly         bool
-	cloneSourceCampaignName   string
+	cloneMissingTemplates     bool
+	cloneSourceCampaignNames  []string
+	cloneTestCaseFilterTerms  []string
+	cloneRetest               bool
 )
 
 // ErrCloneOntoItself is returned when a clone would land on top of the very
@@ -71,7 +76,11 @@ clone is syntactic sugar for a transfer whose source and target are the same VEC
 instance and the same credentials. Because a clone is by definition a COPY, it always
 mints a fresh globalId for the new assessment - that is inherent to the command and
 there is no flag to turn it off. If you want to keep the original globalId you are not
-cloning: use "vat transfer", which exposes --reset-id as an opt-in.`,
+cloning: use "vat transfer", which exposes --reset-id as an opt-in.
+
+--retest sets up a retest: the copy keeps the test plan but every test case is reset to
+Not Performed with its results cleared, and the source globalId is recorded in the
+copy's metadata (vat-retest-of) so the two can be compared later.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Set up a context with signal handling
 		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), vat.VERSION, vat.VatContextValue(version)))
@@ -92,11 +101,22 @@ cloning: use "vat transfer", which exposes --reset-id as an opt-in.`,
 		cloneSourceDB = strings.TrimSpace(cloneSourceDB)
 		cloneTargetDB = strings.TrimSpace(cloneTargetDB)
 		cloneAssessmentName = strings.TrimSpace(cloneAssessmentName)
+		cloneAssessmentId = strings.TrimSpace(cloneAssessmentId)
+		cloneGlobalId = strings.TrimSpace(cloneGlobalId)
 		cloneTargetAssessmentName = strings.TrimSpace(cloneTargetAssessmentName)
-		cloneSourceCampaignName = strings.TrimSpace(cloneSourceCampaignName)
+		for i, name := range cloneSourceCampaignNames {
+			cloneSourceCampaignNames[i] = strings.TrimSpace(name)
+		}
 
-		// Resolve the target db and reject a clone onto itself before touching the network
-		effectiveTargetDB, err := resolveCloneTarget(cloneSourceDB, cloneTargetDB, cloneAssessmentName, cloneTargetAssessmentName, cloneSourceCampaignName != "")
+		testCaseFilter, err := util.NewTestCaseFilter(cloneTestCaseFilterTerms)
+		if err != nil {
+			slog.ErrorContext(ctx, "Invalid --test-case-filter", "error", err)
+			os.Exit(1)
+		}
+
+		// Resolve the target db and reject a clone onto itself before touching the network.
+		// A source selected by id or globalId has no name yet; it is checked again once fetched.
+		effectiveTargetDB, err := resolveCloneTarget(cloneSourceDB, cloneTargetDB, cloneAssessmentName, cloneTargetAssessmentName, len(cloneSourceCampaignNames) > 0)
 		if err != nil {
 			slog.ErrorContext(ctx, "cannot clone an assessment onto itself, pick a different --target-assessment-name or a different target db (--target-db/--target-env)",
 				"db", cloneSourceDB,
@@ -135,23 +155,37 @@ cloning: use "vat transfer", which exposes --reset-id as an opt-in.`,
 		versionContext := context.WithValue(ctx, vat.VECTR_VERSION, vat.VatContextValue(vectrVersion))
 
 		// Fetch the assessment data to clone
-		slog.InfoContext(versionContext, "Fetching assessment data to clone", "hostname", cloneHostname, "db", cloneSourceDB, "assessment-name", cloneAssessmentName)
-		assessmentData, err := vat.SaveAssessmentData(versionContext, client, cloneSourceDB, cloneAssessmentName)
+		slog.InfoContext(versionContext, "Fetching assessment data to clone", "hostname", cloneHostname, "db", cloneSourceDB, "assessment-name", cloneAssessmentName, "assessment-id", cloneAssessmentId, "global-id", cloneGlobalId)
+		assessmentData, err := saveSourceAssessment(versionContext, client, cloneSourceDB, cloneAssessmentName, cloneAssessmentId, cloneGlobalId)
 		if err != nil {
-			slog.ErrorContext(versionContext, "Failed to fetch the assessment data to clone", "db", cloneSourceDB, "assessment-name", cloneAssessmentName, "error", err)
+			slog.ErrorContext(versionContext, "Failed to fetch the assessment data to clone", "db", cloneSourceDB, "assessment-name", cloneAssessmentName, "assessment-id", cloneAssessmentId, "global-id", cloneGlobalId, "error", err)
 			os.Exit(1)
 		}
+		if cloneAssessmentName == "" {
+			cloneAssessmentName = assessmentData.Assessment.Name
+			if _, err := resolveCloneTarget(cloneSourceDB, cloneTargetDB, cloneAssessmentName, cloneTargetAssessmentName, len(cloneSourceCampaignNames) > 0); err != nil {
+				slog.ErrorContext(ctx, "cannot clone an assessment onto itself, pick a different --target-assessment-name or a different target db (--target-db/--target-env)",
+					"db", cloneSourceDB,
+					"assessment-name", cloneAssessmentName,
+					"target-assessment-name", cloneTargetAssessmentName,
+					"error", err)
+				os.Exit(1)
+			}
+		}
 
-		if cloneSourceCampaignName == "" {
+		if len(cloneSourceCampaignNames) == 0 {
 			// A clone is a copy, so it always gets a fresh globalId - this is not a user choice.
 			optionalParams := &vat.RestoreOptionalParams{
 				AssessmentName:             cloneTargetAssessmentName,
 				OverrideAssessmentTemplate: cloneOverrideTemplate,
 				DeleteOnFailure:            cloneDeleteOnFailure,
 				ForceEnvOnly:               cloneForceEnvOnly,
+				CreateMissingTemplates:     cloneMissingTemplates,
 				ResetGlobalId:              true,
+				Retest:                     cloneRetest,
+				TestCaseFilter:             testCaseFilter,
 			}
-			slog.InfoContext(versionContext, "Cloning assessment", "hostname", cloneHostname, "db", effectiveTargetDB, "target-assessment-name", cloneTargetAssessmentName)
+			slog.InfoContext(versionContext, "Cloning assessment", "hostname", cloneHostname, "db", effectiveTargetDB, "target-assessment-name", cloneTargetAssessmentName, "retest", cloneRetest)
 			if err := vat.RestoreAssessment(versionContext, client, effectiveTargetDB, assessmentData, optionalParams); err != nil {
 				switch {
 				case errors.Is(err, vat.ErrAssessmentAlreadyExists):
@@ -174,11 +208,14 @@ cloning: use "vat transfer", which exposes --reset-id as an opt-in.`,
 		} else {
 			// Campaign-only clone into an existing target assessment
 			optionalParams := &vat.RestoreOptionalParams{
-				ForceEnvOnly: cloneForceEnvOnly,
+				DeleteOnFailure:        cloneDeleteOnFailure,
+				ForceEnvOnly:           cloneForceEnvOnly,
+				CreateMissingTemplates: cloneMissingTemplates,
+				TestCaseFilter:         testCaseFilter,
 			}
-			slog.InfoContext(versionContext, "Cloning campaign into target assessment", "source-campaign", cloneSourceCampaignName, "db", effectiveTargetDB, "target-assessment", cloneTargetAssessmentName)
-			if err := vat.RestoreCampaign(versionContext, client, effectiveTargetDB, assessmentData, cloneSourceCampaignName, cloneTargetAssessmentName, optionalParams); err != nil {
-				slog.ErrorContext(versionContext, "Failed to clone campaign into target assessment", "source-campaign", cloneSourceCampaignName, "target-assessment", cloneTargetAssessmentName, "error", err)
+			slog.InfoContext(versionContext, "Cloning campaign into target assessment", "source-campaigns", cloneSourceCampaignNames, "db", effectiveTargetDB, "target-assessment", cloneTargetAssessmentName)
+			if err := vat.RestoreCampaign(versionContext, client, effectiveTargetDB, assessmentData, cloneSourceCampaignNames, cloneTargetAssessmentName, optionalParams); err != nil {
+				slog.ErrorContext(versionContext, "Failed to clone campaign into target assessment", "source-campaigns", cloneSourceCampaignNames, "target-assessment", cloneTargetAssessmentName, "error", err)
 				os.Exit(1)
 			}
 		}
@@ -195,18 +232,23 @@ func init() {
 	cloneCmd.Flags().StringVar(&cloneSourceDB, "env", "", "Alias for --db")
 	cloneCmd.Flags().StringVar(&cloneTargetDB, "target-db", "", "Database to clone the assessment into. Defaults to --db")
 	cloneCmd.Flags().StringVar(&cloneTargetDB, "target-env", "", "Alias for --target-db")
-	cloneCmd.Flags().StringVar(&cloneAssessmentName, "assessment-name", "", "Name of the assessment to clone (required)")
+	cloneCmd.Flags().StringVar(&cloneAssessmentName, "assessment-name", "", "Name of the assessment to clone (this, --assessment-id or --global-id is required)")
+	cloneCmd.Flags().StringVar(&cloneAssessmentId, "assessment-id", "", "Id of the assessment to clone, for when its name is not unique")
+	cloneCmd.Flags().StringVar(&cloneGlobalId, "global-id", "", "GlobalId of the assessment to clone, which stays the same when it is renamed")
 	cloneCmd.Flags().StringVar(&cloneTargetAssessmentName, "target-assessment-name", "", "The assessment name to give the clone (required). The clone always gets a new globalId; use the transfer command if you need to keep the original one.")
 	cloneCmd.Flags().BoolVar(&cloneOverrideTemplate, "override-template-assessment", false, "Ignore the template name in the serialized data and load template test cases anyway")
-	cloneCmd.Flags().BoolVar(&cloneDeleteOnFailure, "delete-on-failure", false, "In the case of a failure, delete the created assessment from VECTR (does not delete template information). Does not affect single campaign inserts.")
-	cloneCmd.Flags().StringVar(&cloneSourceCampaignName, "source-campaign-name", "", "Name of a specific campaign to clone. If set, --target-assessment-name must be an existing assessment.")
+	cloneCmd.Flags().BoolVar(&cloneDeleteOnFailure, "delete-on-failure", false, "In the case of a failure, delete everything the restore created in VECTR: the assessment (or the campaign, for a single campaign insert), defense tools, products, layers and library test cases. Unlike restore, clone keeps its journal in memory only, so a failed clone can't be resumed or passed to vat rollback")
+	cloneCmd.Flags().StringArrayVar(&cloneSourceCampaignNames, "source-campaign-name", nil, "Campaign to clone; repeat for more. Takes an exact name, a glob (*, ?, [...]) or re:<regular expression>. If set, --target-assessment-name must be an existing assessment.")
+	cloneCmd.Flags().StringArrayVar(&cloneTestCaseFilterTerms, "test-case-filter", nil, "Only clone test cases matching field=pattern, where field is technique, status, tag, organization or name; repeat to combine (same field: any matches, different fields: all must)")
 	cloneCmd.Flags().BoolVar(&cloneForceEnvOnly, "force-env-only", false, "Ignore any templates associated with test cases, import them in the env only (DANGEROUS)")
+	cloneCmd.Flags().BoolVar(&cloneMissingTemplates, "create-missing-templates", false, "Create only the library test cases missing from the target instance, from the saved data, and link test cases to them; existing library test cases are left untouched")
+	cloneCmd.Flags().BoolVar(&cloneRetest, "retest", false, "Set up a retest: reset every test case to Not Performed, clear outcomes, outcome notes, defense tool outcomes, attack success and timeline events, and record the source globalId in the clone's metadata")
 
 	// Mark flags as required
 	cloneCmd.MarkFlagRequired("hostname")
 	cloneCmd.MarkFlagRequired("vectr-creds-file")
 	cloneCmd.MarkFlagsOneRequired("db", "env")
-	cloneCmd.MarkFlagRequired("assessment-name")
+	cloneCmd.MarkFlagsOneRequired("assessment-name", "assessment-id", "global-id")
 	cloneCmd.MarkFlagRequired("target-assessment-name")
 
 	// --db/--env and --target-db/--target-env are aliases backed by the same
@@ -214,4 +256,10 @@ func init() {
 	// order decide the database. Exactly one of each pair.
 	cloneCmd.MarkFlagsMutuallyExclusive("db", "env")
 	cloneCmd.MarkFlagsMutuallyExclusive("target-db", "target-env")
+	cloneCmd.MarkFlagsMutuallyExclusive("assessment-name", "assessment-id", "global-id")
+	cloneCmd.MarkFlagsMutuallyExclusive("create-missing-templates", "override-template-assessment")
+	cloneCmd.MarkFlagsMutuallyExclusive("create-missing-templates", "force-env-only")
+	// A retest is a whole assessment; a campaign-only clone lands in an
+	// existing one whose metadata isn't the copy's to set.
+	cloneCmd.MarkFlagsMutuallyExclusive("retest", "source-campaign-name")
 }
@@ -93,6 +93,8 @@ func TestCloneFlagRegistration(t *testing.T) {
 			sharedName string
 		}{
 			{"assessment-name", &cloneAssessmentName, &assessmentName, "assessmentName"},
+			{"assessment-id", &cloneAssessmentId, &assessmentId, "assessmentId"},
+			{"global-id", &cloneGlobalId, &assessmentGlobalId, "assessmentGlobalId"},
 			{"target-assessment-name", &cloneTargetAssessmentName, &targetAssessmentName, "targetAssessmentName"},
 			{"db", &cloneSourceDB, &sourceDB, "sourceDB"},
 			{"target-db", &cloneTargetDB, &targetDB, "targetDB"},
@@ -2,9 +2,11 @@ package main
 
 import (
 	"os"
+	"time"
 
 	"log/slog"
 
+	"sra/vat/internal/dao"
 	"sra/vat/internal/util"
 
 	"github.com/spf13/cobra"
@@ -20,10 +22,24 @@ var (
 	clientKeyFile              string
 	caCertFiles                []string
 	tlsParams                  *util.CustomTlsParams
-	sourceCampaignName         string
+	sourceCampaignNames        []string
+	testCaseFilterTerms        []string
 	forceEnvOnly               bool
+	createMissingTemplates     bool
+	templateMatch              string
 	ignoreVersionCheck         bool
 	resetGlobalId              bool
+	restoreMode                string
+	asTemplate                 bool
+	orgMapFile                 string
+	defenseToolMapFile         string
+	noCreateDefenseTools       bool
+	strictDefenseToolMatch     bool
+	updateOutcomes             bool
+	pageSize                   int
+	maxRetries                 int
+	requestTimeout             time.Duration
+	rateLimit                  float64
 )
 
 // RootCmd is the root command for the CLI
@@ -39,6 +55,18 @@ var RootCmd = &cobra.Command{
 			slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{AddSource: true, Level: slog.LevelInfo})))
 		}
 
+		if pageSize < 1 {
+			slog.Error("--page-size must be at least 1", "page-size", pageSize)
+			os.Exit(1)
+		}
+		dao.SetPageSize(pageSize)
+
+		if maxRetries < 0 || requestTimeout < 0 || rateLimit < 0 {
+			slog.Error("--max-retries, --request-timeout and --rate-limit can't be negative", "max-retries", maxRetries, "request-timeout", requestTimeout, "rate-limit", rateLimit)
+			os.Exit(1)
+		}
+		util.SetClientOptions(util.ClientOptions{MaxRetries: maxRetries, RequestTimeout: requestTimeout, RateLimit: rateLimit})
+
 		if (len(clientCertFile) > 0) != (len(clientKeyFile) > 0) {
 			slog.Error("Both --client-cert-file and --client-key-file must be provided together")
 			os.Exit(1)
@@ -90,17 +118,27 @@ func Execute() {
 	RootCmd.PersistentFlags().StringVar(&clientKeyFile, "client-key-file", "", "Path to the client key file")
 	RootCmd.PersistentFlags().StringSliceVar(&caCertFiles, "ca-cert", []string{}, "Path to a CA certificate file (can be used multiple times)")
 	RootCmd.PersistentFlags().BoolVar(&ignoreVersionCheck, "ignore-version-check", false, "Proceed with a warning when the VECTR version is outside the supported range")
+	RootCmd.PersistentFlags().IntVar(&pageSize, "page-size", dao.DefaultPageSize, "How many items to ask VECTR for per request when listing assessments, defense tools, layers and the like")
+	RootCmd.PersistentFlags().IntVar(&maxRetries, "max-retries", util.DefaultMaxRetries, "How many times to retry a VECTR request that fails with a connection error, 429 or 5xx (0 disables retries)")
+	RootCmd.PersistentFlags().DurationVar(&requestTimeout, "request-timeout", util.DefaultRequestTimeout, "How long a single VECTR request may take before it is abandoned (0 for no limit)")
+	RootCmd.PersistentFlags().Float64Var(&rateLimit, "rate-limit", 0, "The most requests per second to send to each VECTR instance (0 for no limit)")
 	slog.Info("vat started", "version", version)
 
 	// Add subcommands
-	RootCmd.AddCommand(saveCmd)     // From saver.go
-	RootCmd.AddCommand(restoreCmd)  // From restorer.go
-	RootCmd.AddCommand(versionCmd)  // From version.go
-	RootCmd.AddCommand(transferCmd) // From transfer.go
-	RootCmd.AddCommand(cloneCmd)    // From cloner.go
-	RootCmd.AddCommand(licenseCmd)  // From license.go
-	RootCmd.AddCommand(dumpCmd)     // From dumper.go
-	RootCmd.AddCommand(diagCmd)     // From diag.go
+	RootCmd.AddCommand(saveCmd)           // From saver.go
+	RootCmd.AddCommand(restoreCmd)        // From restorer.go
+	RootCmd.AddCommand(versionCmd)        // From version.go
+	RootCmd.AddCommand(transferCmd)       // From transfer.go
+	RootCmd.AddCommand(cloneCmd)          // From cloner.go
+	RootCmd.AddCommand(licenseCmd)        // From license.go
+	RootCmd.AddCommand(dumpCmd)           // From dumper.go
+	RootCmd.AddCommand(diagCmd)           // From diag.go
+	RootCmd.AddCommand(rollbackCmd)       // From rollbacker.go
+	RootCmd.AddCommand(syncCmd)           // From syncer.go
+	RootCmd.AddCommand(saveEnvCmd)        // From envsaver.go
+	RootCmd.AddCommand(restoreEnvCmd)     // From envrestorer.go
+	RootCmd.AddCommand(saveLibraryCmd)    // From libsaver.go
+	RootCmd.AddCommand(restoreLibraryCmd) // From librestorer.go
 
 	// Execute the root command
 	if err := RootCmd.Execute(); err != nil {
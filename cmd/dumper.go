@@ -3,8 +3,11 @@ package main
 import (
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/signal"
@@ -20,8 +23,11 @@ import (
 )
 
 var (
-	filterFile string
-	outputDir  string
+	filterFile     string
+	filterTerms    []string
+	outputDir      string
+	concurrency    int
+	sinceStatePath string
 )
 
 // Create a dump subcommand
@@ -43,6 +49,11 @@ var dumpCmd = &cobra.Command{
 			cancel()
 		}()
 
+		if concurrency < 1 {
+			slog.Error("--concurrency must be at least 1", "concurrency", concurrency)
+			os.Exit(1)
+		}
+
 		// Read credentials from the file
 		credentials, err := os.ReadFile(credentialsFile)
 		if err != nil {
@@ -72,34 +83,27 @@ var dumpCmd = &cobra.Command{
 		versionContext := context.WithValue(ctx, vat.VECTR_VERSION, vat.VatContextValue(vectrVersion))
 
 		// Set up the filter
-		var filter *util.Filter
-		if filterFile != "" {
-			file, err := os.Open(filterFile)
-			if err != nil {
-				slog.Error("Failed to open filter file", "error", err)
-				os.Exit(1)
-			}
-			defer file.Close()
+		filter, err := loadFilter(filterFile, filterTerms)
+		if err != nil {
+			slog.Error("Failed to parse filter", "filter-file", filterFile, "filter", filterTerms, "error", err)
+			os.Exit(1)
+		}
 
-			filter, err = util.NewFilter(file)
+		// Load what the last incremental dump saw, if any
+		var state *vat.DumpState
+		if sinceStatePath != "" {
+			state, err = loadJSONFile(sinceStatePath, "dump state", vat.NewDumpState)
 			if err != nil {
-				slog.Error("Failed to parse filter file", "error", err)
-				os.Exit(1)
-			}
-		} else {
-			r := strings.NewReader(`"*","*"` + "\n")
-			filter, err = util.NewFilter(r)
-			if err != nil {
-				slog.Error("Failed to parse filter file", "error", err)
+				slog.Error("Failed to load dump state", "since-state", sinceStatePath, "error", err)
 				os.Exit(1)
 			}
 		}
 
 		// Call DumpInstance with the filter
-		dumpedData, err := vat.DumpInstance(versionContext, client, filter)
+		dumpedData, err := vat.DumpInstance(versionContext, client, filter, &vat.SaveOptionalParams{Concurrency: concurrency, SinceState: state})
 		if err != nil {
 			// if there is an assessment failure, then keep going, we'll handle it as the assessment level
-			if err != vat.ErrDumpAssessmentFailure || errors.Is(err, vat.ErrDumpAssessmentFailure) {
+			if !errors.Is(err, vat.ErrDumpAssessmentFailure) {
 				slog.Error("Failed to dump instance", "error", err)
 				os.Exit(1)
 			} else {
@@ -114,110 +118,264 @@ var dumpCmd = &cobra.Command{
 		}
 
 		isvCache := make(map[string][]byte)
+		index := []dumpIndexEntry{}
 
 		// Process each assessment
 		for _, entry := range dumpedData {
-			if entry.Err != nil {
+			item := dumpIndexEntry{
+				Db:             entry.Db,
+				AssessmentName: entry.AssessmentName,
+				GlobalId:       entry.GlobalId,
+				Change:         entry.Change,
+			}
+			switch {
+			case entry.Err != nil:
 				slog.Warn("Error dumping assessment", "db", entry.Db, "assessment", entry.AssessmentName, "error", entry.Err)
-				continue
+				item.Error = entry.Err.Error()
+			case entry.Change == vat.DumpUnchanged:
+				slog.Info("Assessment unchanged since the last dump, not rewritten", "db", entry.Db, "assessment", entry.AssessmentName, "global-id", entry.GlobalId)
+				indexUnchangedAssessment(&item)
+			default:
+				if err := writeDumpedAssessment(ctx, entry, vectrVersionHandler, isvCache, &item); err != nil {
+					slog.Warn("Failed to write assessment", "db", entry.Db, "assessment", entry.AssessmentName, "error", err)
+					item.Error = err.Error()
+				} else if state != nil {
+					state.Record(entry)
+				}
 			}
-			subdir := filepath.Join(outputDir, entry.Db)
-			if err := os.MkdirAll(subdir, os.ModePerm); err != nil {
-				slog.Error("Failed to create the subdir", "error", err, "subdir", subdir)
+			index = append(index, item)
+		}
+
+		if state != nil {
+			if err := writeJSONFileAtomic(sinceStatePath, "dump state", state, 0600); err != nil {
+				slog.Error("Failed to write dump state", "since-state", sinceStatePath, "error", err)
 				os.Exit(1)
 			}
+		}
+		indexPath := filepath.Join(outputDir, "index.json")
+		if err := writeJSONFileAtomic(indexPath, "dump index", index, 0644); err != nil {
+			slog.Error("Failed to write dump index", "index", indexPath, "error", err)
+			os.Exit(1)
+		}
+		slog.Info("Wrote dump index", "index", indexPath, "assessment-count", len(index))
+	},
+}
 
-			// Serialize the assessment data to JSON
-			jsonData, err := vat.EncodeToJson(entry.Ad)
-			if err != nil {
-				slog.Warn("Failed to encode assessment data to JSON", "assessment", entry.AssessmentName, "error", err)
-				continue
-			}
+// dumpIndexEntry is one assessment's entry in the index.json every dump
+// writes to --output-dir. Paths are relative to --output-dir and left out for
+// files that weren't written.
+type dumpIndexEntry struct {
+	Db             string
+	AssessmentName string
+	GlobalId       string
+	Change         vat.DumpChange // see --since-state; "new" without it
+	Archive        string         `json:",omitempty"`
+	Digest         string         `json:",omitempty"` // "sha256:<hex>" of the Archive file
+	PassphraseFile string         `json:",omitempty"` // the scrypt passphrase Archive is encrypted with
+	IsvFile        string         `json:",omitempty"`
+	Error          string         `json:",omitempty"`
+}
 
-			// Generate a secure random passphrase
-			passphrase, err := generateRandomPassphrase()
-			if err != nil {
-				slog.Warn("Failed to generate random passphrase", "assessment", entry.AssessmentName, "error", err)
-				continue
-			}
+// archivePath is where an assessment's archive goes, relative to
+// --output-dir; its passphrase file is alongside, with ".passphrase" added.
+func archivePath(db, assessmentName string) string {
+	return filepath.Join(db, assessmentName+".age")
+}
 
-			// Create the output file paths
-			outputFilePath := filepath.Join(subdir, entry.AssessmentName+".age")
-			passphraseFilePath := outputFilePath + ".passphrase"
+// writeDumpedAssessment encrypts entry's assessment data into
+// <output-dir>/<db>/<assessment>.age, with its passphrase alongside, and
+// writes its ISV bundle, if it has one, fetching each bundle once via
+// isvCache. A missing ISV is only a warning. Every file is written under a
+// temporary name and renamed into place once complete, so a failure never
+// leaves a partial archive where a good one (or none) was, nor an archive
+// beside another archive's passphrase. What's written is recorded in item.
+func writeDumpedAssessment(ctx context.Context, entry vat.AssessmentDataEntry, vectrVersionHandler *util.VectrRestApiCaller, isvCache map[string][]byte, item *dumpIndexEntry) error {
+	subdir := filepath.Join(outputDir, entry.Db)
+	if err := os.MkdirAll(subdir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create the subdir %s: %w", subdir, err)
+	}
 
-			// Write the passphrase to a file
-			if err := os.WriteFile(passphraseFilePath, []byte(passphrase), 0600); err != nil {
-				slog.Warn("Failed to write passphrase file", "assessment", entry.AssessmentName, "error", err)
-				continue
-			}
+	// Serialize the assessment data to JSON
+	jsonData, err := vat.EncodeToJson(entry.Ad)
+	if err != nil {
+		return fmt.Errorf("failed to encode assessment data to JSON: %w", err)
+	}
 
-			// Create the output file
-			outputFileHandle, err := os.Create(outputFilePath)
-			if err != nil {
-				slog.Warn("Failed to create output file", "assessment", entry.AssessmentName, "error", err)
-				continue
-			}
-			defer outputFileHandle.Close()
+	// Generate a secure random passphrase
+	passphrase, err := generateRandomPassphrase()
+	if err != nil {
+		return fmt.Errorf("failed to generate random passphrase: %w", err)
+	}
 
-			// Encrypt the data using the age package
-			recipient, err := age.NewScryptRecipient(passphrase)
-			if err != nil {
-				slog.Warn("Failed to create scrypt recipient", "assessment", entry.AssessmentName, "error", err)
-				continue
-			}
+	// Create the output file paths
+	archive := archivePath(entry.Db, entry.AssessmentName)
+	outputFilePath := filepath.Join(outputDir, archive)
+	passphraseFilePath := outputFilePath + ".passphrase"
 
-			encryptor, err := age.Encrypt(outputFileHandle, recipient)
-			if err != nil {
-				slog.Warn("Failed to initialize encryption", "assessment", entry.AssessmentName, "error", err)
-				continue
-			}
-			defer encryptor.Close()
+	// Compress and encrypt the JSON data into a temporary file
+	tmpPath, digest, err := writeArchive(outputFilePath, jsonData, passphrase)
+	if err != nil {
+		return err
+	}
 
-			// Compress the JSON data using GZIP
-			gzipWriter := gzip.NewWriter(encryptor)
-			defer gzipWriter.Close()
+	// Only once both are complete, swap the archive and its passphrase in
+	// together, so a failure leaves the old pair (or none) rather than an
+	// archive beside a passphrase it wasn't encrypted with
+	passphraseTmpPath := passphraseFilePath + ".tmp"
+	if err := os.WriteFile(passphraseTmpPath, []byte(passphrase), 0600); err != nil {
+		os.Remove(passphraseTmpPath)
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write passphrase file: %w", err)
+	}
+	if err := replaceFiles([]string{tmpPath, passphraseTmpPath}, []string{outputFilePath, passphraseFilePath}); err != nil {
+		return fmt.Errorf("failed to move archive and passphrase file into place: %w", err)
+	}
+	item.Archive = archive
+	item.Digest = digest
+	item.PassphraseFile = archive + ".passphrase"
 
-			_, err = gzipWriter.Write(jsonData)
+	var isvPath string
+	if entry.Ad.BundleID != "" {
+		// check the cache for the isv, populate it if it's not there
+		if _, ok := isvCache[entry.Ad.BundleID]; !ok {
+			isv, err := vectrVersionHandler.GetIsv(ctx, entry.Ad.BundleID)
 			if err != nil {
-				slog.Warn("Failed to write compressed data", "assessment", entry.AssessmentName, "error", err)
-				continue
+				slog.WarnContext(ctx, "could not save isv, you will have to do it manually", "test-plan-name", entry.Ad.TemplateAssessment, "hostname", hostname, "db", entry.Db, "assessment-name", entry.AssessmentName)
+			} else {
+				isvCache[entry.Ad.BundleID] = make([]byte, len(isv))
+				copy(isvCache[entry.Ad.BundleID], isv) // cache the isv data
 			}
-
-			var isvPath string
-			if entry.Ad.BundleID != "" {
-				// check the cache for the isv, populate it if it's not there
-				if _, ok := isvCache[entry.Ad.BundleID]; !ok {
-					isv, err := vectrVersionHandler.GetIsv(ctx, entry.Ad.BundleID)
-					if err != nil {
-						slog.WarnContext(ctx, "could not save isv, you will have to do it manually", "test-plan-name", entry.Ad.TemplateAssessment, "hostname", hostname, "db", entry.Db, "assessment-name", entry.AssessmentName)
-					} else {
-						isvCache[entry.Ad.BundleID] = make([]byte, len(isv))
-						copy(isvCache[entry.Ad.BundleID], isv) // cache the isv data
-					}
-				}
-				// if you can find it, then go ahead and write the file
-				if isv, ok := isvCache[entry.Ad.BundleID]; ok {
-					isvPath = fmt.Sprintf("%s.%s.isv", outputFilePath, entry.Ad.BundleID)
-					err := os.WriteFile(isvPath, isv, 0666)
-					if err != nil {
-						slog.WarnContext(ctx, "could not write isv file, you'll have to clean up and do it manually",
-							"file-name", isvPath,
-							"test-plan-name", entry.Ad.TemplateAssessment,
-							"hostname", hostname,
-							"db", entry.Db,
-							"assessment-name", entry.AssessmentName,
-							"error", err)
-					} else {
-						slog.Info("Successfully wrote isv bundle file", "file-path", isvPath)
-					}
-				} else {
-					slog.WarnContext(ctx, "could not find associated isv", "test-plan-name", entry.Ad.TemplateAssessment, "hostname", hostname, "db", entry.Db, "assessment-name", entry.AssessmentName)
-				}
+		}
+		// if you can find it, then go ahead and write the file
+		if isv, ok := isvCache[entry.Ad.BundleID]; ok {
+			isvPath = fmt.Sprintf("%s.%s.isv", outputFilePath, entry.Ad.BundleID)
+			err := writeFileAtomic(isvPath, isv, 0666)
+			if err != nil {
+				slog.WarnContext(ctx, "could not write isv file, you'll have to clean up and do it manually",
+					"file-name", isvPath,
+					"test-plan-name", entry.Ad.TemplateAssessment,
+					"hostname", hostname,
+					"db", entry.Db,
+					"assessment-name", entry.AssessmentName,
+					"error", err)
+			} else {
+				slog.Info("Successfully wrote isv bundle file", "file-path", isvPath)
+				item.IsvFile = fmt.Sprintf("%s.%s.isv", archive, entry.Ad.BundleID)
 			}
+		} else {
+			slog.WarnContext(ctx, "could not find associated isv", "test-plan-name", entry.Ad.TemplateAssessment, "hostname", hostname, "db", entry.Db, "assessment-name", entry.AssessmentName)
+		}
+	}
 
-			slog.Info("Assessment dumped successfully", "assessment", entry.AssessmentName, "output-file", outputFilePath, "passphrase-file", passphraseFilePath, "isv-path (if exists)", isvPath)
+	slog.Info("Assessment dumped successfully", "assessment", entry.AssessmentName, "output-file", outputFilePath, "passphrase-file", passphraseFilePath, "isv-path (if exists)", isvPath)
+	return nil
+}
+
+// writeArchive gzips and age-encrypts data with passphrase into a temporary
+// file next to path, and returns its name and the "sha256:<hex>" digest of
+// what was written. The caller renames it into place. On error nothing is
+// left behind.
+func writeArchive(path string, data []byte, passphrase string) (_ string, _ string, err error) {
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(tmpPath)
 		}
-	},
+	}()
+
+	// Encrypt the data using the age package, hashing what lands on disk
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create scrypt recipient: %w", err)
+	}
+	hash := sha256.New()
+	encryptor, err := age.Encrypt(io.MultiWriter(file, hash), recipient)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to initialize encryption: %w", err)
+	}
+
+	// Compress the JSON data using GZIP
+	gzipWriter := gzip.NewWriter(encryptor)
+	if _, err = gzipWriter.Write(data); err != nil {
+		return "", "", fmt.Errorf("failed to write compressed data: %w", err)
+	}
+
+	// Close innermost first: each flushes into the next
+	if err = gzipWriter.Close(); err != nil {
+		return "", "", fmt.Errorf("failed to finish compressed data: %w", err)
+	}
+	if err = encryptor.Close(); err != nil {
+		return "", "", fmt.Errorf("failed to finish encryption: %w", err)
+	}
+	if err = file.Close(); err != nil {
+		return "", "", fmt.Errorf("failed to close output file: %w", err)
+	}
+	return tmpPath, "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// readArchive decrypts the age-encrypted, gzipped archive at path with
+// passphrase and returns its contents, as writeArchive left them.
+func readArchive(path string, passphrase string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer file.Close()
+
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scrypt identity: %w", err)
+	}
+	decryptor, err := age.Decrypt(file, identity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize decryption: %w", err)
+	}
+	gzipReader, err := gzip.NewReader(decryptor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize GZIP decompression: %w", err)
+	}
+	defer gzipReader.Close()
+
+	data, err := io.ReadAll(gzipReader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read decompressed data: %w", err)
+	}
+	return data, nil
+}
+
+// indexUnchangedAssessment fills in item for an assessment --since-state
+// skipped, from the archive an earlier dump left in --output-dir. A missing
+// archive is only a warning; its state entry has to go for it to be dumped
+// again.
+func indexUnchangedAssessment(item *dumpIndexEntry) {
+	archive := archivePath(item.Db, item.AssessmentName)
+	digest, err := fileDigest(filepath.Join(outputDir, archive))
+	if err != nil {
+		slog.Warn("Unchanged assessment's archive is not in the output dir, remove it from the dump state to dump it again", "db", item.Db, "assessment", item.AssessmentName, "global-id", item.GlobalId, "error", err)
+		return
+	}
+	item.Archive = archive
+	item.Digest = digest
+	item.PassphraseFile = archive + ".passphrase"
+}
+
+// fileDigest returns the "sha256:<hex>" digest of the file at path.
+func fileDigest(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
 }
 
 func init() {
@@ -226,8 +384,12 @@ func init() {
 	dumpCmd.Flags().StringVar(&credentialsFile, "vectr-creds-file", "", "Path to the VECTR credentials file (required)")
 	dumpCmd.Flags().StringVar(&outputDir, "output-dir", "", "Directory to output the assessment files (required)")
 
-	dumpCmd.Flags().StringVar(&filterFile, "filter-file", "", "Path to the filter file (optional)")
+	dumpCmd.Flags().StringVar(&filterFile, "filter-file", "", "Path to the filter file: CSV, or YAML/JSON rules by extension (optional)")
+	dumpCmd.Flags().StringArrayVar(&filterTerms, "filter", nil, fmt.Sprintf("Only dump assessments matching field=pattern or a date condition like created>=date, or not matching !term, where field is one of %s; repeatable", strings.Join(util.AssessmentFilterFields(), ", ")))
+	dumpCmd.Flags().IntVar(&concurrency, "concurrency", 1, "Number of assessments to fetch at once")
+	dumpCmd.Flags().StringVar(&sinceStatePath, "since-state", "", "Path to a dump state file: skip assessments unchanged since the dump that wrote it, and update it (created if missing)")
 	dumpCmd.MarkFlagRequired("hostname")
 	dumpCmd.MarkFlagRequired("credentials-file")
 	dumpCmd.MarkFlagRequired("output-dir")
+	dumpCmd.MarkFlagsMutuallyExclusive("filter-file", "filter")
 }
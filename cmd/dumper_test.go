@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sra/vat"
+
+	"filippo.io/age"
+)
+
+// TestWriteArchive verifies an archive is only ever visible whole: writeArchive
+// leaves it under a temporary name for the caller to rename, its digest
+// matches what ends up on disk, and it decrypts with the passphrase back to
+// the original data.
+func TestWriteArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Assessment.age")
+	data := []byte(`{"assessment": "data"}`)
+
+	tmpPath, digest, err := writeArchive(path, data, "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("archive is at its final path before the rename (stat: %v)", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		t.Fatal(err)
+	}
+	if onDisk, err := fileDigest(path); err != nil || onDisk != digest {
+		t.Errorf("digest = %s, want the file's %s (%v)", digest, onDisk, err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	identity, err := age.NewScryptIdentity("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := age.Decrypt(file, identity)
+	if err != nil {
+		t.Fatalf("could not decrypt archive: %v", err)
+	}
+	gz, err := gzip.NewReader(decrypted)
+	if err != nil {
+		t.Fatalf("could not decompress archive: %v", err)
+	}
+	got, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("archive holds %q, want %q", got, data)
+	}
+}
+
+// TestWriteArchive_FailureLeavesNothing verifies a write that fails after
+// its temporary file was created removes it again.
+func TestWriteArchive_FailureLeavesNothing(t *testing.T) {
+	dir := t.TempDir()
+
+	// age refuses an empty passphrase, once the file is already open
+	if _, _, err := writeArchive(filepath.Join(dir, "Assessment.age"), []byte("{}"), ""); err == nil {
+		t.Fatal("expected an error encrypting with an empty passphrase")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("left %v behind", entries)
+	}
+}
+
+// TestReadArchive verifies readArchive reads back what writeArchive wrote,
+// and refuses the wrong passphrase.
+func TestReadArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "env.age")
+	data := []byte(`{"environment": "data"}`)
+	tmpPath, _, err := writeArchive(path, data, "correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readArchive(path, "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("readArchive = %q, want %q", got, data)
+	}
+	if _, err := readArchive(path, "wrong passphrase"); err == nil {
+		t.Error("expected an error with the wrong passphrase")
+	}
+}
+
+// TestWriteDumpedAssessment verifies a dumped archive always sits beside the
+// passphrase it was encrypted with: rewriting it replaces both, and a
+// rewrite that fails part way leaves the old pair in place.
+func TestWriteDumpedAssessment(t *testing.T) {
+	outputDir = t.TempDir()
+	t.Cleanup(func() { outputDir = "" })
+	entry := vat.AssessmentDataEntry{Db: "db1", AssessmentName: "Assessment", Ad: &vat.AssessmentData{}}
+	archive := filepath.Join(outputDir, archivePath(entry.Db, entry.AssessmentName))
+	checkPair := func() {
+		t.Helper()
+		passphrase, err := getPassphrase(archive + ".passphrase")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readArchive(archive, passphrase); err != nil {
+			t.Errorf("archive does not decrypt with the passphrase beside it: %v", err)
+		}
+	}
+
+	for range 2 {
+		var item dumpIndexEntry
+		if err := writeDumpedAssessment(context.Background(), entry, nil, map[string][]byte{}, &item); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		checkPair()
+	}
+
+	// a directory where the old passphrase would be set aside makes the swap
+	// fail after the old archive already was
+	if err := os.MkdirAll(filepath.Join(archive+".passphrase.bak", "blocker"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeDumpedAssessment(context.Background(), entry, nil, map[string][]byte{}, &dumpIndexEntry{}); err == nil {
+		t.Fatal("expected an error when the passphrase file can't be put in place")
+	}
+	checkPair()
+	leftovers, err := filepath.Glob(filepath.Join(outputDir, entry.Db, "*.tmp"))
+	if err != nil || len(leftovers) != 0 {
+		t.Errorf("left temporary files %v behind (%v)", leftovers, err)
+	}
+	if _, err := os.Stat(archive + ".bak"); !os.IsNotExist(err) {
+		t.Errorf("old archive left set aside (stat: %v)", err)
+	}
+}
+
+// TestReplaceFiles verifies a rename failing after the first file was
+// already replaced puts back every old file and leaves no new one behind.
+func TestReplaceFiles(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "a.age"), filepath.Join(dir, "a.age.passphrase")}
+	tmpPaths := []string{paths[0] + ".tmp", paths[1] + ".tmp"}
+	read := func(path string) string {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	t.Run("old files put back", func(t *testing.T) {
+		for _, path := range paths {
+			if err := os.WriteFile(path, []byte("old"), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// the second temporary file is missing, so its rename fails
+		if err := os.WriteFile(tmpPaths[0], []byte("new"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := replaceFiles(tmpPaths, paths); err == nil {
+			t.Fatal("expected an error when a rename fails")
+		}
+		for _, path := range paths {
+			if got := read(path); got != "old" {
+				t.Errorf("%s holds %q, want the old file", filepath.Base(path), got)
+			}
+		}
+	})
+
+	t.Run("no old files", func(t *testing.T) {
+		for _, path := range paths {
+			if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(tmpPaths[0], []byte("new"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := replaceFiles(tmpPaths, paths); err == nil {
+			t.Fatal("expected an error when a rename fails")
+		}
+	})
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("left %v behind", entries)
+	}
+}
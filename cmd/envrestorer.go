@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"sra/vat"
+	"sra/vat/internal/util"
+
+	"github.com/spf13/cobra"
+)
+
+// Create a restore-env subcommand
+var restoreEnvCmd = &cobra.Command{
+	Use:   "restore-env",
+	Short: "Bring a db's environment configuration in line with a save-env archive, before restoring assessments into it",
+	Long: `Bring a db's environment configuration in line with a save-env archive, before
+restoring assessments into it.
+
+Outcomes are global: they belong to the VECTR instance, not to the db. Outcomes whose
+abbreviation, report text, color or coverage score differ from the archive are only
+listed unless --update-outcomes is passed, which changes them for every db on the
+instance. Their previous settings are kept in the journal, so --delete-on-failure and
+"vat rollback" put them back after a failed run; the journal is removed once
+restore-env succeeds, so the changes of a successful run are not rolled back.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Set up a context with signal handling
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), vat.VERSION, vat.VatContextValue(version)))
+		defer cancel()
+
+		// Handle Ctrl-C (SIGINT) and other termination signals
+		signalChan := make(chan os.Signal, 1)
+		signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			defer signal.Reset()
+			<-signalChan
+			slog.Info("\nReceived interrupt signal, shutting down gracefully. Ctrl+C again to force shutdown...")
+			cancel()
+		}()
+
+		// Read credentials from the file
+		credentials, err := os.ReadFile(credentialsFile)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to read credentials file", "error", err)
+			os.Exit(1)
+		}
+
+		// Read the passphrase
+		passphrase, err := getPassphrase(passphraseFile)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to read passphrase", "error", err)
+			os.Exit(1)
+		}
+
+		decompressed, err := readArchive(inputFile, passphrase)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to read input file", "input-file", inputFile, "error", err)
+			os.Exit(1)
+		}
+		env, err := vat.DecodeEnvironmentJson(decompressed)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to decode environment data, is the input file a save-env archive?", "input-file", inputFile, "error", err)
+			os.Exit(1)
+		}
+
+		defenseToolMapping, err := loadDefenseToolMapping(defenseToolMapFile)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load defense tool mapping file", "defense-tool-map", defenseToolMapFile, "error", err)
+			os.Exit(1)
+		}
+
+		// Journal what gets created, as restore does, so a failed run can be
+		// resumed or handed to vat rollback.
+		var journal *vat.RestoreJournal
+		if resumePath != "" {
+			journal, err = loadJournal(resumePath)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to load restore journal", "resume", resumePath, "error", err)
+				os.Exit(1)
+			}
+			journalPath = resumePath
+		} else {
+			if journalPath == "" {
+				journalPath = inputFile + ".journal.json"
+			}
+			if _, err := os.Stat(journalPath); err == nil {
+				slog.ErrorContext(ctx, "A journal from an earlier restore already exists; resume it with --resume, or delete it to start over", "journal", journalPath)
+				os.Exit(1)
+			}
+		}
+
+		// Set up the VECTR client
+		client, vectrVersionHandler, err := util.SetupVectrClient(hostname, strings.TrimSpace(string(credentials)), tlsParams)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not set up connection to vectr", "hostname", hostname, "error", err)
+			os.Exit(1)
+		}
+
+		// get the VECTR version (side effect - check the creds as well)
+		vectrVersion, err := vectrVersionHandler.GetVersion(ctx)
+		if err != nil {
+			if err == util.ErrInvalidAuth {
+				slog.ErrorContext(ctx, "could not validate creds", "hostname", hostname, "error", err)
+				os.Exit(1)
+			}
+			slog.ErrorContext(ctx, "could not get vectr version", "hostname", hostname, "error", err)
+			os.Exit(1)
+		}
+		slog.InfoContext(ctx, "validated credentials and fetched vectr version", "hostname", hostname, "vectr-version", vectrVersion)
+		enforceVectrVersionCheck(ctx, vectrVersion, hostname)
+		versionContext := context.WithValue(ctx, vat.VECTR_VERSION, vat.VatContextValue(vectrVersion))
+
+		optionalParams := &vat.RestoreOptionalParams{
+			DeleteOnFailure:        deleteOnFailure,
+			DefenseToolMapping:     defenseToolMapping,
+			StrictDefenseToolMatch: strictDefenseToolMatch,
+			UpdateOutcomes:         updateOutcomes,
+			Journal:                journal,
+			JournalWriter:          journalFile(journalPath),
+		}
+		report, err := vat.RestoreEnvironment(versionContext, client, db, env, optionalParams)
+		if err != nil {
+			slog.ErrorContext(versionContext, "Failed to restore environment", "error", err)
+			logKeptJournal(ctx, journalPath)
+			os.Exit(1)
+		}
+		removeJournal(ctx, journalPath)
+
+		if len(report.MissingOrganizations)+len(report.MissingVendors)+len(report.MissingOutcomes) > 0 {
+			slog.WarnContext(ctx, "VECTR's API can't create these, add them by hand before restoring assessments",
+				"organizations", report.MissingOrganizations,
+				"vendors", report.MissingVendors,
+				"outcome-paths", report.MissingOutcomes)
+		}
+		if len(report.DifferingOutcomes) > 0 {
+			slog.WarnContext(ctx, "Outcome settings differ from the archive and were left alone; --update-outcomes changes them for every db on the instance",
+				"outcome-paths", report.DifferingOutcomes)
+		}
+		fmt.Printf("Environment restored to %s: %d defense tool(s) reconciled, %d tag(s) created, %d outcome(s) updated\n", db, report.DefenseTools, report.CreatedTags, report.UpdatedOutcomes)
+	},
+}
+
+func init() {
+	// Add flags to the restore-env command
+	restoreEnvCmd.Flags().StringVar(&db, "db", "", "Database to restore the environment to (required)")
+	restoreEnvCmd.Flags().StringVar(&db, "env", "", "Alias for --db")
+	restoreEnvCmd.Flags().StringVar(&hostname, "hostname", "", "Hostname of the VECTR instance (required)")
+	restoreEnvCmd.Flags().StringVar(&credentialsFile, "vectr-creds-file", "", "Path to the credentials file (required)")
+	restoreEnvCmd.Flags().StringVar(&inputFile, "input-file", "", "Path to the encrypted save-env file (required)")
+	restoreEnvCmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "Path to the file containing the decryption passphrase")
+	restoreEnvCmd.Flags().BoolVar(&deleteOnFailure, "delete-on-failure", false, "In the case of a failure, delete the defense tools, products and layers the restore created (VECTR can't delete tags)")
+	restoreEnvCmd.Flags().StringVar(&defenseToolMapFile, "defense-tool-map", "", "Path to a CSV file pinning source defense tools (by name, or name,product ref,active) to existing target tools (by id or name)")
+	restoreEnvCmd.Flags().BoolVar(&strictDefenseToolMatch, "strict-defense-tool-match", false, "Fail when a defense tool matches more than one target tool or product instead of picking the most recently updated one")
+	restoreEnvCmd.Flags().BoolVar(&updateOutcomes, "update-outcomes", false, "Write the archive's outcome report settings over the target's. Outcomes are global, so this changes them for every db on the instance")
+	restoreEnvCmd.Flags().StringVar(&journalPath, "journal", "", "Path to write the restore journal to (defaults to <input-file>.journal.json). Removed once the restore succeeds.")
+	restoreEnvCmd.Flags().StringVar(&resumePath, "resume", "", "Path to the journal of a failed restore-env to pick up where it stopped")
+
+	// Mark flags as required
+	restoreEnvCmd.MarkFlagsOneRequired("db", "env")
+	restoreEnvCmd.MarkFlagRequired("hostname")
+	restoreEnvCmd.MarkFlagRequired("vectr-creds-file")
+	restoreEnvCmd.MarkFlagRequired("input-file")
+	restoreEnvCmd.MarkFlagsMutuallyExclusive("journal", "resume")
+}
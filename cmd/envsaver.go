@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"sra/vat"
+	"sra/vat/internal/util"
+
+	"github.com/spf13/cobra"
+)
+
+// Create a save-env subcommand
+var saveEnvCmd = &cobra.Command{
+	Use:   "save-env",
+	Short: "Save the environment configuration of a db: defense tools, products, vendors, layers, tags, outcomes and organizations",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Set up a context with signal handling
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), vat.VERSION, vat.VatContextValue(version)))
+		defer cancel()
+
+		// Handle Ctrl-C (SIGINT) and other termination signals
+		signalChan := make(chan os.Signal, 1)
+		signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			defer signal.Reset()
+			<-signalChan
+			fmt.Println("\nReceived interrupt signal, shutting down gracefully...")
+			cancel()
+		}()
+
+		// Read credentials from the file
+		credentials, err := os.ReadFile(credentialsFile)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to read VECTR credentials file", "error", err)
+			os.Exit(1)
+		}
+
+		// Set up the VECTR client
+		client, vectrVersionHandler, err := util.SetupVectrClient(hostname, strings.TrimSpace(string(credentials)), tlsParams)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not set up connection to vectr", "hostname", hostname, "error", err)
+			os.Exit(1)
+		}
+
+		// get the VECTR version (side effect - check the creds as well)
+		vectrVersion, err := vectrVersionHandler.GetVersion(ctx)
+		if err != nil {
+			if err == util.ErrInvalidAuth {
+				slog.ErrorContext(ctx, "could not validate creds", "hostname", hostname, "error", err)
+				os.Exit(1)
+			}
+			slog.ErrorContext(ctx, "could not get vectr version", "hostname", hostname, "error", err)
+			os.Exit(1)
+		}
+		slog.InfoContext(ctx, "validated credentials and fetched vectr version", "hostname", hostname, "vectr-version", vectrVersion)
+		enforceVectrVersionCheck(ctx, vectrVersion, hostname)
+		versionContext := context.WithValue(ctx, vat.VECTR_VERSION, vat.VatContextValue(vectrVersion))
+
+		data, err := vat.SaveEnvironment(versionContext, client, db)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not save environment", "hostname", hostname, "db", db, "error", err)
+			os.Exit(1)
+		}
+
+		// Serialize the data to JSON
+		jsonData, err := vat.EncodeEnvironmentToJson(data)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to encode environment data to JSON", "error", err)
+			os.Exit(1)
+		}
+
+		// Generate a secure random passphrase
+		passphrase, err := generateRandomPassphrase()
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to generate random passphrase", "error", err)
+			os.Exit(1)
+		}
+
+		tmpPath, _, err := writeArchive(outputFile, jsonData, passphrase)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to write environment archive", "output-file", outputFile, "error", err)
+			os.Exit(1)
+		}
+		if err := os.Rename(tmpPath, outputFile); err != nil {
+			os.Remove(tmpPath)
+			slog.ErrorContext(ctx, "Failed to move environment archive into place", "output-file", outputFile, "error", err)
+			os.Exit(1)
+		}
+
+		slog.InfoContext(ctx, "Environment saved successfully", "db", db, "output-file", outputFile)
+
+		fmt.Printf("Environment data saved, compressed, and encrypted to %s\n", outputFile)
+		fmt.Println("Next steps:")
+		fmt.Println("1. Run vat restore-env with this file against the target db before restoring assessments into it.")
+		fmt.Printf("2. Save the live-data passsword (securely!): %s\n", passphrase)
+	},
+}
+
+func init() {
+	// Add flags to the save-env command
+	saveEnvCmd.Flags().StringVar(&hostname, "hostname", "", "Hostname of the VECTR instance (required)")
+	saveEnvCmd.Flags().StringVar(&db, "db", "", "Database to save the environment of (required)")
+	saveEnvCmd.Flags().StringVar(&db, "env", "", "Alias for --db")
+	saveEnvCmd.Flags().StringVar(&credentialsFile, "vectr-creds-file", "", "Path to the VECTR credentials file (required)")
+	saveEnvCmd.Flags().StringVar(&outputFile, "output-file", "", "Path to the output file (required)")
+
+	// Mark flags as required
+	saveEnvCmd.MarkFlagsOneRequired("db", "env")
+	saveEnvCmd.MarkFlagRequired("hostname")
+	saveEnvCmd.MarkFlagRequired("vectr-creds-file")
+	saveEnvCmd.MarkFlagRequired("output-file")
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"sra/vat"
+	"sra/vat/internal/util"
+
+	"github.com/spf13/cobra"
+)
+
+// Create a restore-library subcommand
+var restoreLibraryCmd = &cobra.Command{
+	Use:   "restore-library",
+	Short: "Add the library assessments of a save-library archive, with their library campaigns and test cases, to the VECTR instance's library",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Set up a context with signal handling
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), vat.VERSION, vat.VatContextValue(version)))
+		defer cancel()
+
+		// Handle Ctrl-C (SIGINT) and other termination signals
+		signalChan := make(chan os.Signal, 1)
+		signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			defer signal.Reset()
+			<-signalChan
+			slog.Info("\nReceived interrupt signal, shutting down gracefully. Ctrl+C again to force shutdown...")
+			cancel()
+		}()
+
+		// Read credentials from the file
+		credentials, err := os.ReadFile(credentialsFile)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to read credentials file", "error", err)
+			os.Exit(1)
+		}
+
+		// Read the passphrase
+		passphrase, err := getPassphrase(passphraseFile)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to read passphrase", "error", err)
+			os.Exit(1)
+		}
+
+		decompressed, err := readArchive(inputFile, passphrase)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to read input file", "input-file", inputFile, "error", err)
+			os.Exit(1)
+		}
+		lib, err := vat.DecodeLibraryJson(decompressed)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to decode library data, is the input file a save-library archive?", "input-file", inputFile, "error", err)
+			os.Exit(1)
+		}
+
+		// Journal what gets created, as restore does, so a failed run can be
+		// resumed or handed to vat rollback.
+		var journal *vat.RestoreJournal
+		if resumePath != "" {
+			journal, err = loadJournal(resumePath)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to load restore journal", "resume", resumePath, "error", err)
+				os.Exit(1)
+			}
+			journalPath = resumePath
+		} else {
+			if journalPath == "" {
+				journalPath = inputFile + ".journal.json"
+			}
+			if _, err := os.Stat(journalPath); err == nil {
+				slog.ErrorContext(ctx, "A journal from an earlier restore already exists; resume it with --resume, or delete it to start over", "journal", journalPath)
+				os.Exit(1)
+			}
+		}
+
+		// Set up the VECTR client
+		client, vectrVersionHandler, err := util.SetupVectrClient(hostname, strings.TrimSpace(string(credentials)), tlsParams)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not set up connection to vectr", "hostname", hostname, "error", err)
+			os.Exit(1)
+		}
+
+		// get the VECTR version (side effect - check the creds as well)
+		vectrVersion, err := vectrVersionHandler.GetVersion(ctx)
+		if err != nil {
+			if err == util.ErrInvalidAuth {
+				slog.ErrorContext(ctx, "could not validate creds", "hostname", hostname, "error", err)
+				os.Exit(1)
+			}
+			slog.ErrorContext(ctx, "could not get vectr version", "hostname", hostname, "error", err)
+			os.Exit(1)
+		}
+		slog.InfoContext(ctx, "validated credentials and fetched vectr version", "hostname", hostname, "vectr-version", vectrVersion)
+		enforceVectrVersionCheck(ctx, vectrVersion, hostname)
+		versionContext := context.WithValue(ctx, vat.VECTR_VERSION, vat.VatContextValue(vectrVersion))
+
+		optionalParams := &vat.RestoreOptionalParams{
+			DeleteOnFailure: deleteOnFailure,
+			Journal:         journal,
+			JournalWriter:   journalFile(journalPath),
+		}
+		report, err := vat.RestoreLibrary(versionContext, client, lib, optionalParams)
+		if err != nil {
+			slog.ErrorContext(versionContext, "Failed to restore library", "error", err)
+			logKeptJournal(ctx, journalPath)
+			os.Exit(1)
+		}
+		removeJournal(ctx, journalPath)
+
+		for _, name := range report.Created {
+			fmt.Printf("Created library assessment %s\n", name)
+		}
+		for _, name := range report.Skipped {
+			fmt.Printf("Skipped library assessment %s, it is already in the library\n", name)
+		}
+		if len(report.ReusedCampaigns) > 0 {
+			slog.WarnContext(ctx, "These library campaigns were already in the library and were used as they are, check they match the saved ones", "campaigns", report.ReusedCampaigns)
+		}
+		if len(report.MismatchedCampaigns) > 0 {
+			slog.WarnContext(ctx, "These reused library campaigns have different library test cases than the saved ones", "campaigns", report.MismatchedCampaigns)
+		}
+	},
+}
+
+func init() {
+	// Add flags to the restore-library command
+	restoreLibraryCmd.Flags().StringVar(&hostname, "hostname", "", "Hostname of the VECTR instance (required)")
+	restoreLibraryCmd.Flags().StringVar(&credentialsFile, "vectr-creds-file", "", "Path to the credentials file (required)")
+	restoreLibraryCmd.Flags().StringVar(&inputFile, "input-file", "", "Path to the encrypted save-library file (required)")
+	restoreLibraryCmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "Path to the file containing the decryption passphrase")
+	restoreLibraryCmd.Flags().BoolVar(&deleteOnFailure, "delete-on-failure", false, "In the case of a failure, delete the library assessments, campaigns and test cases the restore created")
+	restoreLibraryCmd.Flags().StringVar(&journalPath, "journal", "", "Path to write the restore journal to (defaults to <input-file>.journal.json). Removed once the restore succeeds.")
+	restoreLibraryCmd.Flags().StringVar(&resumePath, "resume", "", "Path to the journal of a failed restore-library to pick up where it stopped")
+
+	// Mark flags as required
+	restoreLibraryCmd.MarkFlagRequired("hostname")
+	restoreLibraryCmd.MarkFlagRequired("vectr-creds-file")
+	restoreLibraryCmd.MarkFlagRequired("input-file")
+	restoreLibraryCmd.MarkFlagsMutuallyExclusive("journal", "resume")
+}
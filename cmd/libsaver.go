@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"sra/vat"
+	"sra/vat/internal/util"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	libraryAssessmentNames []string
+	libraryPrefixes        []string
+)
+
+// Create a save-library subcommand
+var saveLibraryCmd = &cobra.Command{
+	Use:   "save-library",
+	Short: "Save library assessments with their library campaigns and test cases from the VECTR instance",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Set up a context with signal handling
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), vat.VERSION, vat.VatContextValue(version)))
+		defer cancel()
+
+		// Handle Ctrl-C (SIGINT) and other termination signals
+		signalChan := make(chan os.Signal, 1)
+		signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			defer signal.Reset()
+			<-signalChan
+			fmt.Println("\nReceived interrupt signal, shutting down gracefully...")
+			cancel()
+		}()
+
+		// Parse the selection before touching the network, so a bad pattern fails fast
+		names, err := util.NewNamePatterns(libraryAssessmentNames)
+		if err != nil {
+			slog.ErrorContext(ctx, "Invalid --assessment-name", "error", err)
+			os.Exit(1)
+		}
+		prefixes, err := util.NewNamePatterns(libraryPrefixes)
+		if err != nil {
+			slog.ErrorContext(ctx, "Invalid --prefix", "error", err)
+			os.Exit(1)
+		}
+
+		// Read credentials from the file
+		credentials, err := os.ReadFile(credentialsFile)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to read VECTR credentials file", "error", err)
+			os.Exit(1)
+		}
+
+		// Set up the VECTR client
+		client, vectrVersionHandler, err := util.SetupVectrClient(hostname, strings.TrimSpace(string(credentials)), tlsParams)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not set up connection to vectr", "hostname", hostname, "error", err)
+			os.Exit(1)
+		}
+
+		// get the VECTR version (side effect - check the creds as well)
+		vectrVersion, err := vectrVersionHandler.GetVersion(ctx)
+		if err != nil {
+			if err == util.ErrInvalidAuth {
+				slog.ErrorContext(ctx, "could not validate creds", "hostname", hostname, "error", err)
+				os.Exit(1)
+			}
+			slog.ErrorContext(ctx, "could not get vectr version", "hostname", hostname, "error", err)
+			os.Exit(1)
+		}
+		slog.InfoContext(ctx, "validated credentials and fetched vectr version", "hostname", hostname, "vectr-version", vectrVersion)
+		enforceVectrVersionCheck(ctx, vectrVersion, hostname)
+		versionContext := context.WithValue(ctx, vat.VECTR_VERSION, vat.VatContextValue(vectrVersion))
+
+		data, err := vat.SaveLibrary(versionContext, client, names, prefixes)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not save library", "hostname", hostname, "assessment-name", libraryAssessmentNames, "prefix", libraryPrefixes, "error", err)
+			os.Exit(1)
+		}
+
+		// Serialize the data to JSON
+		jsonData, err := vat.EncodeLibraryToJson(data)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to encode library data to JSON", "error", err)
+			os.Exit(1)
+		}
+
+		// Generate a secure random passphrase
+		passphrase, err := generateRandomPassphrase()
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to generate random passphrase", "error", err)
+			os.Exit(1)
+		}
+
+		tmpPath, _, err := writeArchive(outputFile, jsonData, passphrase)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to write library archive", "output-file", outputFile, "error", err)
+			os.Exit(1)
+		}
+		if err := os.Rename(tmpPath, outputFile); err != nil {
+			os.Remove(tmpPath)
+			slog.ErrorContext(ctx, "Failed to move library archive into place", "output-file", outputFile, "error", err)
+			os.Exit(1)
+		}
+
+		for _, a := range data.Assessments {
+			fmt.Printf("Saved library assessment %s\n", a.Name)
+		}
+		slog.InfoContext(ctx, "Library saved successfully", "assessment-count", len(data.Assessments), "output-file", outputFile)
+
+		fmt.Printf("Library data saved, compressed, and encrypted to %s\n", outputFile)
+		fmt.Printf("Save the live-data passsword (securely!): %s\n", passphrase)
+	},
+}
+
+func init() {
+	// Add flags to the save-library command
+	saveLibraryCmd.Flags().StringVar(&hostname, "hostname", "", "Hostname of the VECTR instance (required)")
+	saveLibraryCmd.Flags().StringVar(&credentialsFile, "vectr-creds-file", "", "Path to the VECTR credentials file (required)")
+	saveLibraryCmd.Flags().StringVar(&outputFile, "output-file", "", "Path to the output file (required)")
+	saveLibraryCmd.Flags().StringArrayVar(&libraryAssessmentNames, "assessment-name", nil, "Library assessment to save, by name with or without its template prefix; repeat for more. Takes an exact name, a glob (*, ?, [...]) or re:<regular expression>.")
+	saveLibraryCmd.Flags().StringArrayVar(&libraryPrefixes, "prefix", nil, "Save every library assessment with this template prefix; repeat for more. Takes the same patterns as --assessment-name.")
+
+	// Mark flags as required
+	saveLibraryCmd.MarkFlagsOneRequired("assessment-name", "prefix")
+	saveLibraryCmd.MarkFlagRequired("hostname")
+	saveLibraryCmd.MarkFlagRequired("vectr-creds-file")
+	saveLibraryCmd.MarkFlagRequired("output-file")
+}
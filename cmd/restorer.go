@@ -22,6 +22,8 @@ import (
 var (
 	inputFile      string
 	passphraseFile string
+	journalPath    string
+	resumePath     string
 )
 
 // Create a restore subcommand
@@ -99,6 +101,65 @@ var restoreCmd = &cobra.Command{
 		}
 		assessmentData := *assessmentDataPtr
 
+		// Load the org mapping before touching the network, so a bad file fails fast
+		orgMapping, err := loadOrgMapping(orgMapFile)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load org mapping file", "org-map", orgMapFile, "error", err)
+			os.Exit(1)
+		}
+
+		defenseToolMapping, err := loadDefenseToolMapping(defenseToolMapFile)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load defense tool mapping file", "defense-tool-map", defenseToolMapFile, "error", err)
+			os.Exit(1)
+		}
+
+		mode, err := vat.ParseRestoreMode(restoreMode)
+		if err != nil {
+			slog.ErrorContext(ctx, "Invalid --mode", "mode", restoreMode, "error", err)
+			os.Exit(1)
+		}
+		if mode == vat.RestoreModeUpdate && (resetGlobalId || len(sourceCampaignNames) > 0) {
+			slog.ErrorContext(ctx, "--mode update can't be combined with --reset-id or --source-campaign-name")
+			os.Exit(1)
+		}
+
+		match, err := vat.ParseTemplateMatch(templateMatch)
+		if err != nil {
+			slog.ErrorContext(ctx, "Invalid --template-match", "template-match", templateMatch, "error", err)
+			os.Exit(1)
+		}
+		if match != vat.TemplateMatchId && (forceEnvOnly || overrideAssessmentTemplate) {
+			slog.ErrorContext(ctx, "--template-match name or id-then-name can't be combined with --force-env-only or --override-template-assessment")
+			os.Exit(1)
+		}
+
+		testCaseFilter, err := util.NewTestCaseFilter(testCaseFilterTerms)
+		if err != nil {
+			slog.ErrorContext(ctx, "Invalid --test-case-filter", "error", err)
+			os.Exit(1)
+		}
+
+		// Every restore keeps a journal of what it has written, so a failed
+		// run can be picked up with --resume instead of cleaned up by hand.
+		var journal *vat.RestoreJournal
+		if resumePath != "" {
+			journal, err = loadJournal(resumePath)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to load restore journal", "resume", resumePath, "error", err)
+				os.Exit(1)
+			}
+			journalPath = resumePath
+		} else {
+			if journalPath == "" {
+				journalPath = inputFile + ".journal.json"
+			}
+			if _, err := os.Stat(journalPath); err == nil {
+				slog.ErrorContext(ctx, "A journal from an earlier restore already exists; resume it with --resume, or delete it to start over", "journal", journalPath)
+				os.Exit(1)
+			}
+		}
+
 		// Set up the VECTR client
 		client, vectrVersionHandler, err := util.SetupVectrClient(hostname, strings.TrimSpace(string(credentials)), tlsParams)
 		if err != nil {
@@ -120,24 +181,37 @@ var restoreCmd = &cobra.Command{
 		enforceVectrVersionCheck(ctx, vectrVersion, hostname)
 		versionContext := context.WithValue(ctx, vat.VECTR_VERSION, vat.VatContextValue(vectrVersion))
 
-		if sourceCampaignName == "" {
+		if len(sourceCampaignNames) == 0 {
 			optionalParams := &vat.RestoreOptionalParams{
 				AssessmentName:             targetAssessmentName,
 				OverrideAssessmentTemplate: overrideAssessmentTemplate,
 				DeleteOnFailure:            deleteOnFailure,
 				ForceEnvOnly:               forceEnvOnly,
+				CreateMissingTemplates:     createMissingTemplates,
+				TemplateMatch:              match,
 				ResetGlobalId:              resetGlobalId,
+				Mode:                       mode,
+				AsTemplate:                 asTemplate,
+				OrgMapping:                 orgMapping,
+				DefenseToolMapping:         defenseToolMapping,
+				NoCreateDefenseTools:       noCreateDefenseTools,
+				StrictDefenseToolMatch:     strictDefenseToolMatch,
+				TestCaseFilter:             testCaseFilter,
+				Journal:                    journal,
+				JournalWriter:              journalFile(journalPath),
 			}
 
 			// Restore the assessment
 			if err := vat.RestoreAssessment(versionContext, client, db, &assessmentData, optionalParams); err != nil {
 				if errors.Is(err, vat.ErrDuplicateGlobalId) {
-					slog.ErrorContext(versionContext, "Failed to restore assessment: this assessment already exists in the target instance under its original globalId, retry with --reset-id to land it as an independent copy", "error", err)
+					slog.ErrorContext(versionContext, "Failed to restore assessment: this assessment already exists in the target instance under its original globalId, retry with --reset-id to land it as an independent copy, or with --mode update to update it", "error", err)
 				} else {
 					slog.ErrorContext(versionContext, "Failed to restore assessment", "error", err)
 				}
+				logKeptJournal(ctx, journalPath)
 				os.Exit(1)
 			}
+			removeJournal(ctx, journalPath)
 			slog.InfoContext(ctx, "Assessment restored successfully")
 		} else {
 			if targetAssessmentName == "" {
@@ -145,18 +219,58 @@ var restoreCmd = &cobra.Command{
 				os.Exit(1)
 			}
 			optionalParams := &vat.RestoreOptionalParams{
-				ForceEnvOnly: forceEnvOnly,
+				DeleteOnFailure:        deleteOnFailure,
+				ForceEnvOnly:           forceEnvOnly,
+				CreateMissingTemplates: createMissingTemplates,
+				TemplateMatch:          match,
+				OrgMapping:             orgMapping,
+				DefenseToolMapping:     defenseToolMapping,
+				NoCreateDefenseTools:   noCreateDefenseTools,
+				StrictDefenseToolMatch: strictDefenseToolMatch,
+				TestCaseFilter:         testCaseFilter,
+				Journal:                journal,
+				JournalWriter:          journalFile(journalPath),
 			}
-			slog.InfoContext(ctx, "Restoring campaign", "source-campaign", sourceCampaignName, "target-assessment", targetAssessmentName)
-			if err := vat.RestoreCampaign(versionContext, client, db, &assessmentData, sourceCampaignName, targetAssessmentName, optionalParams); err != nil {
+			slog.InfoContext(ctx, "Restoring campaign", "source-campaigns", sourceCampaignNames, "target-assessment", targetAssessmentName)
+			if err := vat.RestoreCampaign(versionContext, client, db, &assessmentData, sourceCampaignNames, targetAssessmentName, optionalParams); err != nil {
 				slog.ErrorContext(versionContext, "Failed to restore campaign", "error", err)
+				logKeptJournal(ctx, journalPath)
 				os.Exit(1)
 			}
+			removeJournal(ctx, journalPath)
 			slog.InfoContext(ctx, "Campaign restored successfully")
 		}
 	},
 }
 
+// logKeptJournal points the user at the journal a failed restore left
+// behind, if it got far enough to write one. A journal --delete-on-failure
+// already rolled back has nothing left to resume or roll back, and would only
+// stop a fresh rerun, so it's removed instead.
+func logKeptJournal(ctx context.Context, path string) {
+	journal, err := loadJournal(path)
+	if err != nil {
+		if _, statErr := os.Stat(path); statErr == nil {
+			slog.ErrorContext(ctx, "Restore journal kept but could not be read back", "journal", path, "error", err)
+		}
+		return
+	}
+	if journal.RolledBack {
+		removeJournal(ctx, path)
+		slog.InfoContext(ctx, "The failed restore was rolled back, removed its journal; rerun without --resume to start over", "journal", path)
+		return
+	}
+	slog.ErrorContext(ctx, "Restore journal kept, rerun with --resume to pick up where this restore stopped, or pass it to vat rollback to delete what it created", "journal", path)
+}
+
+// removeJournal deletes the journal of a restore that completed; there's
+// nothing left to resume.
+func removeJournal(ctx context.Context, path string) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.WarnContext(ctx, "could not remove the restore journal", "journal", path, "error", err)
+	}
+}
+
 func init() {
 	// Add flags to the restore command
 	restoreCmd.Flags().StringVar(&db, "db", "", "Database to restore the assessment to (required)")
@@ -167,14 +281,31 @@ func init() {
 	restoreCmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "Path to the file containing the decryption passphrase")
 	restoreCmd.Flags().StringVar(&targetAssessmentName, "target-assessment-name", "", "The assessment name to set in the new instance. Required when using --source-campaign-name.")
 	restoreCmd.Flags().BoolVar(&overrideAssessmentTemplate, "override-template-assessment", false, "Override any set template name in the serialized data and load template test cases anyway")
-	restoreCmd.Flags().BoolVar(&deleteOnFailure, "delete-on-failure", false, "In the case of a failure, delete the created assessment from VECTR (does not delete template information). Does not affect single campaign inserts.")
-	restoreCmd.Flags().StringVar(&sourceCampaignName, "source-campaign-name", "", "Name of a specific campaign to restore from the input file. If set, --target-assessment-name must be an existing assessment.")
+	restoreCmd.Flags().BoolVar(&deleteOnFailure, "delete-on-failure", false, "In the case of a failure, delete everything the restore created in VECTR: the assessment (or the campaign, for a single campaign insert), defense tools, products, layers and library test cases")
+	restoreCmd.Flags().StringArrayVar(&sourceCampaignNames, "source-campaign-name", nil, "Campaign to restore from the input file; repeat for more. Takes an exact name, a glob (*, ?, [...]) or re:<regular expression>. If set, --target-assessment-name must be an existing assessment.")
+	restoreCmd.Flags().StringArrayVar(&testCaseFilterTerms, "test-case-filter", nil, "Only restore test cases matching field=pattern, where field is technique, status, tag, organization or name; repeat to combine (same field: any matches, different fields: all must)")
 	restoreCmd.Flags().BoolVar(&forceEnvOnly, "force-env-only", false, "Ignore any templates associated with test cases, import them in the env only (DANGEROUS)")
+	restoreCmd.Flags().BoolVar(&createMissingTemplates, "create-missing-templates", false, "Create only the library test cases missing from the target instance, from the saved data, and link test cases to them; existing library test cases are left untouched")
+	restoreCmd.Flags().StringVar(&templateMatch, "template-match", string(vat.TemplateMatchId), "How test cases are linked to library test cases in the target instance: id (same library id), name (same name and prefix, falling back to id), or id-then-name (by id, and by name and prefix for the ids the target is missing)")
+	restoreCmd.Flags().StringVar(&orgMapFile, "org-map", "", "Path to a CSV file mapping source organization names to target ones (\"*\" as the source name sets a fallback for orgs missing from the target)")
+	restoreCmd.Flags().StringVar(&defenseToolMapFile, "defense-tool-map", "", "Path to a CSV file pinning source defense tools (by name, or name,product ref,active) to existing target tools (by id or name)")
+	restoreCmd.Flags().BoolVar(&noCreateDefenseTools, "no-create-defense-tools", false, "Never create or modify defense tools, products or layers; fail listing every source tool that has no map entry or existing match")
+	restoreCmd.Flags().BoolVar(&strictDefenseToolMatch, "strict-defense-tool-match", false, "Fail when a defense tool matches more than one target tool or product instead of picking the most recently updated one")
+	restoreCmd.Flags().StringVar(&journalPath, "journal", "", "Path to write the restore journal to (defaults to <input-file>.journal.json). Removed once the restore succeeds.")
+	restoreCmd.Flags().StringVar(&resumePath, "resume", "", "Path to the journal of a failed restore to pick up where it stopped, without creating anything twice")
 	restoreCmd.Flags().BoolVar(&resetGlobalId, "reset-id", false, "Mint a new globalId for the restored assessment instead of reusing the source one. Use this if VECTR rejects the restore with a duplicate globalId error (i.e. the target instance already has a copy of this assessment).")
+	restoreCmd.Flags().StringVar(&restoreMode, "mode", string(vat.RestoreModeCreate), "create: always create a new assessment (fails if it already exists); update: bring the existing assessment with the same globalId (or name) up to date, updating changed test cases and appending missing campaigns, test cases and timeline events")
+	restoreCmd.Flags().BoolVar(&asTemplate, "as-template", false, "Create a library (template) assessment with its campaigns and library test cases from the archive instead, leaving out outcomes, timelines and other environment-only data")
 
 	// Mark flags as required
 	restoreCmd.MarkFlagsOneRequired("db", "env")
 	restoreCmd.MarkFlagRequired("hostname")
 	restoreCmd.MarkFlagRequired("credentials-file")
 	restoreCmd.MarkFlagRequired("input-file")
+	restoreCmd.MarkFlagsMutuallyExclusive("journal", "resume")
+	restoreCmd.MarkFlagsMutuallyExclusive("create-missing-templates", "override-template-assessment")
+	restoreCmd.MarkFlagsMutuallyExclusive("create-missing-templates", "force-env-only")
+	for _, flag := range []string{"source-campaign-name", "mode", "reset-id", "override-template-assessment", "force-env-only", "create-missing-templates", "template-match"} {
+		restoreCmd.MarkFlagsMutuallyExclusive("as-template", flag)
+	}
 }
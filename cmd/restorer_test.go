@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sra/vat"
+)
+
+// TestLogKeptJournal verifies the journal of a failed restore is kept for
+// --resume, unless --delete-on-failure already rolled the restore back, in
+// which case it's removed so a fresh rerun isn't refused.
+func TestLogKeptJournal(t *testing.T) {
+	for name, rolledBack := range map[string]bool{"kept": false, "rolled back": true} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "restore.journal.json")
+			journal := vat.NewRestoreJournal()
+			journal.Db = "db"
+			journal.RolledBack = rolledBack
+			if err := journalFile(path).WriteJournal(context.Background(), journal); err != nil {
+				t.Fatal(err)
+			}
+
+			logKeptJournal(context.Background(), path)
+
+			_, err := os.Stat(path)
+			if exists := err == nil; exists == rolledBack {
+				t.Errorf("journal exists = %v after a failed restore with RolledBack = %v", exists, rolledBack)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"sra/vat"
+	"sra/vat/internal/util"
+
+	"github.com/spf13/cobra"
+)
+
+// Create a rollback subcommand
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback <journal-file>",
+	Short: "Delete everything a failed restore created, using its journal",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Set up a context with signal handling
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), vat.VERSION, vat.VatContextValue(version)))
+		defer cancel()
+
+		// Handle Ctrl-C (SIGINT) and other termination signals
+		signalChan := make(chan os.Signal, 1)
+		signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			defer signal.Reset()
+			<-signalChan
+			slog.Info("\nReceived interrupt signal, shutting down gracefully. Ctrl+C again to force shutdown...")
+			cancel()
+		}()
+
+		path := args[0]
+		journal, err := loadJournal(path)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load restore journal", "journal", path, "error", err)
+			os.Exit(1)
+		}
+
+		// Read credentials from the file
+		credentials, err := os.ReadFile(credentialsFile)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to read credentials file", "error", err)
+			os.Exit(1)
+		}
+
+		// Set up the VECTR client
+		client, vectrVersionHandler, err := util.SetupVectrClient(hostname, strings.TrimSpace(string(credentials)), tlsParams)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not set up connection to vectr", "hostname", hostname, "error", err)
+			os.Exit(1)
+		}
+
+		// get the VECTR version (side effect - check the creds as well)
+		vectrVersion, err := vectrVersionHandler.GetVersion(ctx)
+		if err != nil {
+			if err == util.ErrInvalidAuth {
+				slog.ErrorContext(ctx, "could not validate creds", "hostname", hostname, "error", err)
+				os.Exit(1)
+			}
+			slog.ErrorContext(ctx, "could not get vectr version", "hostname", hostname, "error", err)
+			os.Exit(1)
+		}
+		slog.InfoContext(ctx, "validated credentials and fetched vectr version", "hostname", hostname, "vectr-version", vectrVersion)
+		enforceVectrVersionCheck(ctx, vectrVersion, hostname)
+		versionContext := context.WithValue(ctx, vat.VECTR_VERSION, vat.VatContextValue(vectrVersion))
+
+		if err := vat.RollbackRestore(versionContext, client, journal, journalFile(path)); err != nil {
+			slog.ErrorContext(versionContext, "Failed to roll back the restore, the journal keeps what is left; rerun rollback to retry", "journal", path, "error", err)
+			os.Exit(1)
+		}
+		removeJournal(ctx, path)
+		slog.InfoContext(ctx, "Restore rolled back successfully", "db", journal.Db)
+	},
+}
+
+func init() {
+	// Add flags to the rollback command
+	rollbackCmd.Flags().StringVar(&hostname, "hostname", "", "Hostname of the VECTR instance the restore wrote to (required)")
+	rollbackCmd.Flags().StringVar(&credentialsFile, "vectr-creds-file", "", "Path to the credentials file (required)")
+
+	// Mark flags as required
+	rollbackCmd.MarkFlagRequired("hostname")
+	rollbackCmd.MarkFlagRequired("vectr-creds-file")
+}
@@ -20,12 +20,14 @@ import (
 )
 
 var (
-	db              string
-	assessmentName  string
-	hostname        string
-	credentialsFile string
-	outputFile      string
-	disableBundle   bool
+	db                 string
+	assessmentName     string
+	assessmentId       string
+	assessmentGlobalId string
+	hostname           string
+	credentialsFile    string
+	outputFile         string
+	disableBundle      bool
 )
 
 var saveCmd = &cobra.Command{
@@ -75,11 +77,13 @@ var saveCmd = &cobra.Command{
 		versionContext := context.WithValue(ctx, vat.VECTR_VERSION, vat.VatContextValue(vectrVersion))
 
 		// Call SaveAssessmentData
-		data, err := vat.SaveAssessmentData(versionContext, client, db, assessmentName)
+		data, err := saveSourceAssessment(versionContext, client, db, assessmentName, assessmentId, assessmentGlobalId)
 		if err != nil {
-			slog.ErrorContext(ctx, "could not save assessment", "hostname", hostname, "db", db, "assessment-name", assessmentName, "error", err)
+			slog.ErrorContext(ctx, "could not save assessment", "hostname", hostname, "db", db, "assessment-name", assessmentName, "assessment-id", assessmentId, "global-id", assessmentGlobalId, "error", err)
 			os.Exit(1)
 		}
+		// Name the saved assessment in the logs below however it was selected
+		assessmentName = data.Assessment.Name
 
 		// Serialize the data to JSON
 		jsonData, err := vat.EncodeToJson(data)
@@ -173,14 +177,17 @@ func init() {
 	saveCmd.Flags().StringVar(&hostname, "hostname", "", "Hostname of the VECTR instance (required)")
 	saveCmd.Flags().StringVar(&db, "db", "", "Database to pull the assessment from (required)")
 	saveCmd.Flags().StringVar(&db, "env", "", "Alias for --db")
-	saveCmd.Flags().StringVar(&assessmentName, "assessment-name", "", "Name of the assessment to save (required)")
+	saveCmd.Flags().StringVar(&assessmentName, "assessment-name", "", "Name of the assessment to save (this, --assessment-id or --global-id is required)")
+	saveCmd.Flags().StringVar(&assessmentId, "assessment-id", "", "Id of the assessment to save, for when its name is not unique")
+	saveCmd.Flags().StringVar(&assessmentGlobalId, "global-id", "", "GlobalId of the assessment to save, which stays the same when it is renamed")
 	saveCmd.Flags().StringVar(&credentialsFile, "vectr-creds-file", "", "Path to the VECTR credentials file (required)")
 	saveCmd.Flags().StringVar(&outputFile, "output-file", "", "Path to the output file (required)")
 	saveCmd.Flags().BoolVar(&disableBundle, "disable-bundle", false, "disable downloading the bundle if found")
 
 	// Mark flags as required
 	saveCmd.MarkFlagsOneRequired("db", "env")
-	saveCmd.MarkFlagRequired("assessment-name")
+	saveCmd.MarkFlagsOneRequired("assessment-name", "assessment-id", "global-id")
+	saveCmd.MarkFlagsMutuallyExclusive("assessment-name", "assessment-id", "global-id")
 	saveCmd.MarkFlagRequired("hostname")
 	saveCmd.MarkFlagRequired("credentials-file")
 	saveCmd.MarkFlagRequired("output-file")
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"sra/vat"
+	"sra/vat/internal/util"
+
+	"github.com/spf13/cobra"
+)
+
+var syncStateFile string
+
+// Create a sync subcommand
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Update an assessment in one VECTR instance with what changed in another since the last sync",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Set up a context with signal handling
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), vat.VERSION, vat.VatContextValue(version)))
+		defer cancel()
+
+		// Handle Ctrl-C (SIGINT) and other termination signals
+		signalChan := make(chan os.Signal, 1)
+		signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signalChan
+			slog.Info("Received interrupt signal, shutting down gracefully...")
+			cancel()
+		}()
+
+		// Read source credentials
+		sourceCredentials, err := os.ReadFile(sourceCredentialsFile)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to read source credentials file", "error", err)
+			os.Exit(1)
+		}
+
+		// Read target credentials
+		targetCredentials, err := os.ReadFile(targetCredentialsFile)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to read target credentials file", "error", err)
+			os.Exit(1)
+		}
+
+		// Load the state and mappings before touching the network, so a bad file fails fast
+		state, err := loadJSONFile(syncStateFile, "sync state", vat.NewSyncState)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load sync state file", "state-file", syncStateFile, "error", err)
+			os.Exit(1)
+		}
+
+		orgMapping, err := loadOrgMapping(orgMapFile)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load org mapping file", "org-map", orgMapFile, "error", err)
+			os.Exit(1)
+		}
+
+		defenseToolMapping, err := loadDefenseToolMapping(defenseToolMapFile)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load defense tool mapping file", "defense-tool-map", defenseToolMapFile, "error", err)
+			os.Exit(1)
+		}
+
+		match, err := vat.ParseTemplateMatch(templateMatch)
+		if err != nil {
+			slog.ErrorContext(ctx, "Invalid --template-match", "template-match", templateMatch, "error", err)
+			os.Exit(1)
+		}
+		if match != vat.TemplateMatchId && (forceEnvOnly || overrideAssessmentTemplate) {
+			slog.ErrorContext(ctx, "--template-match name or id-then-name can't be combined with --force-env-only or --override-template-assessment")
+			os.Exit(1)
+		}
+
+		// Set up the source VECTR client
+		sourceClient, sourceVectrVersionHandler, err := util.SetupVectrClient(sourceHostname, strings.TrimSpace(string(sourceCredentials)), tlsParams)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not set up connection to vectr", "hostname", sourceHostname, "error", err)
+			os.Exit(1)
+		}
+
+		// get the VECTR version (side effect - check the creds as well)
+		sourceVectrVersion, err := sourceVectrVersionHandler.GetVersion(ctx)
+		if err != nil {
+			if err == util.ErrInvalidAuth {
+				slog.ErrorContext(ctx, "could not validate source creds", "src-hostname", sourceHostname, "error", err)
+				os.Exit(1)
+			}
+			slog.ErrorContext(ctx, "could not get source vectr version", "src-hostname", sourceHostname, "error", err)
+			os.Exit(1)
+		}
+		slog.InfoContext(ctx, "validated credentials and fetched vectr version from source", "src-hostname", sourceHostname, "src-vectr-version", sourceVectrVersion)
+		enforceVectrVersionCheck(ctx, sourceVectrVersion, sourceHostname)
+		sourceVersionContext := context.WithValue(ctx, vat.VECTR_VERSION, vat.VatContextValue(sourceVectrVersion))
+
+		// Set up the target VECTR client
+		targetClient, targetVectrVersionHandler, err := util.SetupVectrClient(targetHostname, strings.TrimSpace(string(targetCredentials)), tlsParams)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not set up connection to vectr", "hostname", targetHostname, "error", err)
+			os.Exit(1)
+		}
+		// get the VECTR version (side effect - check the creds as well)
+		targetVectrVersion, err := targetVectrVersionHandler.GetVersion(ctx)
+		if err != nil {
+			if err == util.ErrInvalidAuth {
+				slog.ErrorContext(ctx, "could not validate creds", "hostname", targetHostname, "error", err)
+				os.Exit(1)
+			}
+			slog.ErrorContext(ctx, "could not get vectr version", "hostname", targetHostname, "error", err)
+			os.Exit(1)
+		}
+		slog.InfoContext(ctx, "validated credentials and fetched vectr version", "hostname", targetHostname, "vectr-version", targetVectrVersion)
+		enforceVectrVersionCheck(ctx, targetVectrVersion, targetHostname)
+		targetVersionContext := context.WithValue(ctx, vat.VECTR_VERSION, vat.VatContextValue(targetVectrVersion))
+
+		// Fetch the assessment data from the source instance
+		slog.InfoContext(sourceVersionContext, "Fetching assessment data from source instance", "hostname", sourceHostname, "db", sourceDB)
+		assessmentData, err := vat.SaveAssessmentData(sourceVersionContext, sourceClient, sourceDB, assessmentName)
+		if err != nil {
+			slog.ErrorContext(sourceVersionContext, "Failed to fetch assessment data from source instance", "error", err)
+			os.Exit(1)
+		}
+
+		optionalParams := &vat.RestoreOptionalParams{
+			AssessmentName:             targetAssessmentName,
+			OverrideAssessmentTemplate: overrideAssessmentTemplate,
+			DeleteOnFailure:            deleteOnFailure,
+			ForceEnvOnly:               forceEnvOnly,
+			CreateMissingTemplates:     createMissingTemplates,
+			TemplateMatch:              match,
+			OrgMapping:                 orgMapping,
+			DefenseToolMapping:         defenseToolMapping,
+			NoCreateDefenseTools:       noCreateDefenseTools,
+			StrictDefenseToolMatch:     strictDefenseToolMatch,
+		}
+		slog.InfoContext(targetVersionContext, "Syncing assessment data to target instance", "hostname", targetHostname, "db", targetDB, "state-file", syncStateFile)
+		if err := vat.SyncAssessment(targetVersionContext, targetClient, targetDB, assessmentData, state, optionalParams); err != nil {
+			slog.ErrorContext(targetVersionContext, "Failed to sync assessment data to target instance, the sync state is unchanged so the next sync retries it", "error", err)
+			os.Exit(1)
+		}
+		if err := writeJSONFileAtomic(syncStateFile, "sync state", state, 0600); err != nil {
+			slog.ErrorContext(ctx, "Assessment synced but the sync state could not be saved; the next sync resends the same changes", "state-file", syncStateFile, "error", err)
+			os.Exit(1)
+		}
+
+		slog.InfoContext(ctx, "Assessment synced successfully")
+	},
+}
+
+func init() {
+	// Add flags to the sync command
+	syncCmd.Flags().StringVar(&sourceHostname, "source-hostname", "", "Hostname of the source VECTR instance (required)")
+	syncCmd.Flags().StringVar(&sourceCredentialsFile, "source-vectr-creds-file", "", "Path to the source credentials file (required)")
+	syncCmd.Flags().StringVar(&sourceDB, "source-db", "", "Database name in the source VECTR instance (required)")
+	syncCmd.Flags().StringVar(&sourceDB, "source-env", "", "Alias for --source-db")
+	syncCmd.Flags().StringVar(&targetHostname, "target-hostname", "", "Hostname of the target VECTR instance (required)")
+	syncCmd.Flags().StringVar(&targetCredentialsFile, "target-vectr-creds-file", "", "Path to the target credentials file (required)")
+	syncCmd.Flags().StringVar(&targetDB, "target-db", "", "Database name in the target VECTR instance (required)")
+	syncCmd.Flags().StringVar(&targetDB, "target-env", "", "Alias for --target-db")
+	syncCmd.Flags().StringVar(&assessmentName, "assessment-name", "", "Name of the assessment to sync (required)")
+	syncCmd.Flags().StringVar(&targetAssessmentName, "target-assessment-name", "", "The assessment name in the target instance, if it differs and the assessment can't be found by globalId")
+	syncCmd.Flags().StringVar(&syncStateFile, "state-file", "vat-sync-state.json", "Path to the file recording what earlier syncs sent; created if missing")
+	syncCmd.Flags().BoolVar(&overrideAssessmentTemplate, "override-template-assessment", false, "Ignore the template name in the serialized data and load template test cases anyway")
+	syncCmd.Flags().BoolVar(&deleteOnFailure, "delete-on-failure", false, "In the case of a failure, delete everything the sync created in VECTR: campaigns and test cases it appended, defense tools, products, layers and library test cases. Unlike restore, sync keeps its journal in memory only, so a failed sync can't be resumed or passed to vat rollback")
+	syncCmd.Flags().BoolVar(&forceEnvOnly, "force-env-only", false, "Ignore any templates associated with test cases, import them in the env only (DANGEROUS)")
+	syncCmd.Flags().BoolVar(&createMissingTemplates, "create-missing-templates", false, "Create only the library test cases missing from the target instance, from the saved data, and link test cases to them; existing library test cases are left untouched")
+	syncCmd.Flags().StringVar(&templateMatch, "template-match", string(vat.TemplateMatchId), "How test cases are linked to library test cases in the target instance: id (same library id), name (same name and prefix, falling back to id), or id-then-name (by id, and by name and prefix for the ids the target is missing)")
+	syncCmd.Flags().StringVar(&orgMapFile, "org-map", "", "Path to a CSV file mapping source organization names to target ones (\"*\" as the source name sets a fallback for orgs missing from the target)")
+	syncCmd.Flags().StringVar(&defenseToolMapFile, "defense-tool-map", "", "Path to a CSV file pinning source defense tools (by name, or name,product ref,active) to existing target tools (by id or name)")
+	syncCmd.Flags().BoolVar(&noCreateDefenseTools, "no-create-defense-tools", false, "Never create or modify defense tools, products or layers; fail listing every source tool that has no map entry or existing match")
+	syncCmd.Flags().BoolVar(&strictDefenseToolMatch, "strict-defense-tool-match", false, "Fail when a defense tool matches more than one target tool or product instead of picking the most recently updated one")
+
+	// Mark flags as required
+	syncCmd.MarkFlagRequired("source-hostname")
+	syncCmd.MarkFlagRequired("source-vectr-creds-file")
+	syncCmd.MarkFlagsOneRequired("source-db", "source-env")
+	syncCmd.MarkFlagRequired("target-hostname")
+	syncCmd.MarkFlagRequired("target-vectr-creds-file")
+	syncCmd.MarkFlagsOneRequired("target-db", "target-env")
+	syncCmd.MarkFlagRequired("assessment-name")
+	syncCmd.MarkFlagsMutuallyExclusive("create-missing-templates", "override-template-assessment")
+	syncCmd.MarkFlagsMutuallyExclusive("create-missing-templates", "force-env-only")
+}
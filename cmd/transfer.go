@@ -56,6 +56,45 @@ var transferCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
+		// Load the org mapping before touching the network, so a bad file fails fast
+		orgMapping, err := loadOrgMapping(orgMapFile)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load org mapping file", "org-map", orgMapFile, "error", err)
+			os.Exit(1)
+		}
+
+		defenseToolMapping, err := loadDefenseToolMapping(defenseToolMapFile)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load defense tool mapping file", "defense-tool-map", defenseToolMapFile, "error", err)
+			os.Exit(1)
+		}
+
+		mode, err := vat.ParseRestoreMode(restoreMode)
+		if err != nil {
+			slog.ErrorContext(ctx, "Invalid --mode", "mode", restoreMode, "error", err)
+			os.Exit(1)
+		}
+		if mode == vat.RestoreModeUpdate && (resetGlobalId || len(sourceCampaignNames) > 0) {
+			slog.ErrorContext(ctx, "--mode update can't be combined with --reset-id or --source-campaign-name")
+			os.Exit(1)
+		}
+
+		match, err := vat.ParseTemplateMatch(templateMatch)
+		if err != nil {
+			slog.ErrorContext(ctx, "Invalid --template-match", "template-match", templateMatch, "error", err)
+			os.Exit(1)
+		}
+		if match != vat.TemplateMatchId && (forceEnvOnly || overrideAssessmentTemplate) {
+			slog.ErrorContext(ctx, "--template-match name or id-then-name can't be combined with --force-env-only or --override-template-assessment")
+			os.Exit(1)
+		}
+
+		testCaseFilter, err := util.NewTestCaseFilter(testCaseFilterTerms)
+		if err != nil {
+			slog.ErrorContext(ctx, "Invalid --test-case-filter", "error", err)
+			os.Exit(1)
+		}
+
 		// Set up the source VECTR client
 		sourceClient, sourceVectrVersionHandler, err := util.SetupVectrClient(sourceHostname, strings.TrimSpace(string(sourceCredentials)), tlsParams)
 		if err != nil {
@@ -99,25 +138,34 @@ var transferCmd = &cobra.Command{
 
 		// Fetch the assessment data from the source instance
 		slog.InfoContext(sourceVersionContext, "Fetching assessment data from source instance", "hostname", sourceHostname, "db", sourceDB)
-		assessmentData, err := vat.SaveAssessmentData(sourceVersionContext, sourceClient, sourceDB, assessmentName)
+		assessmentData, err := saveSourceAssessment(sourceVersionContext, sourceClient, sourceDB, assessmentName, assessmentId, assessmentGlobalId)
 		if err != nil {
 			slog.ErrorContext(sourceVersionContext, "Failed to fetch assessment data from source instance", "error", err)
 			os.Exit(1)
 		}
 
-		if sourceCampaignName == "" {
+		if len(sourceCampaignNames) == 0 {
 			optionalParams := &vat.RestoreOptionalParams{
 				AssessmentName:             targetAssessmentName,
 				OverrideAssessmentTemplate: overrideAssessmentTemplate,
 				DeleteOnFailure:            deleteOnFailure,
 				ForceEnvOnly:               forceEnvOnly,
+				CreateMissingTemplates:     createMissingTemplates,
+				TemplateMatch:              match,
 				ResetGlobalId:              resetGlobalId,
+				Mode:                       mode,
+				AsTemplate:                 asTemplate,
+				OrgMapping:                 orgMapping,
+				DefenseToolMapping:         defenseToolMapping,
+				NoCreateDefenseTools:       noCreateDefenseTools,
+				StrictDefenseToolMatch:     strictDefenseToolMatch,
+				TestCaseFilter:             testCaseFilter,
 			}
 			// Original full assessment transfer logic
 			slog.InfoContext(targetVersionContext, "Transferring assessment data to target instance", "hostname", targetHostname, "db", targetDB)
 			if err := vat.RestoreAssessment(targetVersionContext, targetClient, targetDB, assessmentData, optionalParams); err != nil {
 				if errors.Is(err, vat.ErrDuplicateGlobalId) {
-					slog.ErrorContext(targetVersionContext, "Failed to transfer assessment data to target instance: this assessment already exists in the target instance under its original globalId, retry with --reset-id to land it as an independent copy", "error", err)
+					slog.ErrorContext(targetVersionContext, "Failed to transfer assessment data to target instance: this assessment already exists in the target instance under its original globalId, retry with --reset-id to land it as an independent copy, or with --mode update to update it", "error", err)
 				} else {
 					slog.ErrorContext(targetVersionContext, "Failed to transfer assessment data to target instance", "error", err)
 				}
@@ -131,10 +179,18 @@ var transferCmd = &cobra.Command{
 			}
 			// Force the env only for the campaigns as well
 			optionalParams := &vat.RestoreOptionalParams{
-				ForceEnvOnly: forceEnvOnly,
+				DeleteOnFailure:        deleteOnFailure,
+				ForceEnvOnly:           forceEnvOnly,
+				CreateMissingTemplates: createMissingTemplates,
+				TemplateMatch:          match,
+				OrgMapping:             orgMapping,
+				DefenseToolMapping:     defenseToolMapping,
+				NoCreateDefenseTools:   noCreateDefenseTools,
+				StrictDefenseToolMatch: strictDefenseToolMatch,
+				TestCaseFilter:         testCaseFilter,
 			}
-			slog.InfoContext(targetVersionContext, "Transferring campaign to target assessment", "source-campaign", sourceCampaignName, "target-assessment", targetAssessmentName)
-			if err := vat.RestoreCampaign(targetVersionContext, targetClient, targetDB, assessmentData, sourceCampaignName, targetAssessmentName, optionalParams); err != nil {
+			slog.InfoContext(targetVersionContext, "Transferring campaign to target assessment", "source-campaigns", sourceCampaignNames, "target-assessment", targetAssessmentName)
+			if err := vat.RestoreCampaign(targetVersionContext, targetClient, targetDB, assessmentData, sourceCampaignNames, targetAssessmentName, optionalParams); err != nil {
 				slog.ErrorContext(targetVersionContext, "Failed to transfer campaign to target instance", "error", err)
 				os.Exit(1)
 			}
@@ -154,13 +210,24 @@ func init() {
 	transferCmd.Flags().StringVar(&targetCredentialsFile, "target-vectr-creds-file", "", "Path to the target credentials file (required)")
 	transferCmd.Flags().StringVar(&targetDB, "target-db", "", "Database name in the target VECTR instance (required)")
 	transferCmd.Flags().StringVar(&targetDB, "target-env", "", "Alias for --target-db")
-	transferCmd.Flags().StringVar(&assessmentName, "assessment-name", "", "Name of the assessment to transfer (required)")
+	transferCmd.Flags().StringVar(&assessmentName, "assessment-name", "", "Name of the assessment to transfer (this, --assessment-id or --global-id is required)")
+	transferCmd.Flags().StringVar(&assessmentId, "assessment-id", "", "Id of the assessment to transfer in the source instance, for when its name is not unique")
+	transferCmd.Flags().StringVar(&assessmentGlobalId, "global-id", "", "GlobalId of the assessment to transfer, which stays the same when it is renamed")
 	transferCmd.Flags().StringVar(&targetAssessmentName, "target-assessment-name", "", "The assessment name to set in the new instance")
 	transferCmd.Flags().BoolVar(&overrideAssessmentTemplate, "override-template-assessment", false, "Ignore the template name in the serialized data and load template test cases anyway")
-	transferCmd.Flags().BoolVar(&deleteOnFailure, "delete-on-failure", false, "In the case of a failure, delete the created assessment from VECTR (does not delete template information). Does not affect single campaign inserts.")
-	transferCmd.Flags().StringVar(&sourceCampaignName, "source-campaign-name", "", "Name of a specific campaign to transfer. If set, --target-assessment-name must be an existing assessment.")
+	transferCmd.Flags().BoolVar(&deleteOnFailure, "delete-on-failure", false, "In the case of a failure, delete everything the restore created in VECTR: the assessment (or the campaign, for a single campaign insert), defense tools, products, layers and library test cases. Unlike restore, transfer keeps its journal in memory only, so a failed transfer can't be resumed or passed to vat rollback")
+	transferCmd.Flags().StringArrayVar(&sourceCampaignNames, "source-campaign-name", nil, "Campaign to transfer; repeat for more. Takes an exact name, a glob (*, ?, [...]) or re:<regular expression>. If set, --target-assessment-name must be an existing assessment.")
+	transferCmd.Flags().StringArrayVar(&testCaseFilterTerms, "test-case-filter", nil, "Only transfer test cases matching field=pattern, where field is technique, status, tag, organization or name; repeat to combine (same field: any matches, different fields: all must)")
 	transferCmd.Flags().BoolVar(&forceEnvOnly, "force-env-only", false, "Ignore any templates associated with test cases, import them in the env only (DANGEROUS)")
+	transferCmd.Flags().BoolVar(&createMissingTemplates, "create-missing-templates", false, "Create only the library test cases missing from the target instance, from the saved data, and link test cases to them; existing library test cases are left untouched")
+	transferCmd.Flags().StringVar(&templateMatch, "template-match", string(vat.TemplateMatchId), "How test cases are linked to library test cases in the target instance: id (same library id), name (same name and prefix, falling back to id), or id-then-name (by id, and by name and prefix for the ids the target is missing)")
+	transferCmd.Flags().StringVar(&orgMapFile, "org-map", "", "Path to a CSV file mapping source organization names to target ones (\"*\" as the source name sets a fallback for orgs missing from the target)")
+	transferCmd.Flags().StringVar(&defenseToolMapFile, "defense-tool-map", "", "Path to a CSV file pinning source defense tools (by name, or name,product ref,active) to existing target tools (by id or name)")
+	transferCmd.Flags().BoolVar(&noCreateDefenseTools, "no-create-defense-tools", false, "Never create or modify defense tools, products or layers; fail listing every source tool that has no map entry or existing match")
+	transferCmd.Flags().BoolVar(&strictDefenseToolMatch, "strict-defense-tool-match", false, "Fail when a defense tool matches more than one target tool or product instead of picking the most recently updated one")
 	transferCmd.Flags().BoolVar(&resetGlobalId, "reset-id", false, "Mint a new globalId for the transferred assessment instead of reusing the source one. Use this if VECTR rejects the transfer with a duplicate globalId error (i.e. the target instance already has a copy of this assessment).")
+	transferCmd.Flags().StringVar(&restoreMode, "mode", string(vat.RestoreModeCreate), "create: always create a new assessment (fails if it already exists); update: bring the existing assessment with the same globalId (or name) up to date, updating changed test cases and appending missing campaigns, test cases and timeline events")
+	transferCmd.Flags().BoolVar(&asTemplate, "as-template", false, "Create a library (template) assessment with its campaigns and library test cases from the source assessment instead, leaving out outcomes, timelines and other environment-only data")
 
 	// Mark flags as required
 	transferCmd.MarkFlagRequired("source-hostname")
@@ -169,5 +236,11 @@ func init() {
 	transferCmd.MarkFlagRequired("target-hostname")
 	transferCmd.MarkFlagRequired("target-credentials-file")
 	transferCmd.MarkFlagsOneRequired("target-db", "target-env")
-	transferCmd.MarkFlagRequired("assessment-name")
+	transferCmd.MarkFlagsOneRequired("assessment-name", "assessment-id", "global-id")
+	transferCmd.MarkFlagsMutuallyExclusive("assessment-name", "assessment-id", "global-id")
+	transferCmd.MarkFlagsMutuallyExclusive("create-missing-templates", "override-template-assessment")
+	transferCmd.MarkFlagsMutuallyExclusive("create-missing-templates", "force-env-only")
+	for _, flag := range []string{"source-campaign-name", "mode", "reset-id", "override-template-assessment", "force-env-only", "create-missing-templates", "template-match"} {
+		transferCmd.MarkFlagsMutuallyExclusive("as-template", flag)
+	}
 }
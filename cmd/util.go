@@ -3,12 +3,18 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"sra/vat"
+	"sra/vat/internal/util"
+
+	"github.com/Khan/genqlient/graphql"
 )
 
 var buffer strings.Builder
@@ -27,6 +33,178 @@ func enforceVectrVersionCheck(ctx context.Context, vectrVersion string, hostname
 	}
 }
 
+// saveSourceAssessment saves the source assessment of save, transfer and
+// clone, selected by whichever one of --assessment-id, --global-id and
+// --assessment-name is set.
+func saveSourceAssessment(ctx context.Context, client graphql.Client, db, name, id, globalId string) (*vat.AssessmentData, error) {
+	switch {
+	case id != "":
+		return vat.SaveAssessmentById(ctx, client, db, id)
+	case globalId != "":
+		return vat.SaveAssessmentByGlobalId(ctx, client, db, globalId)
+	}
+	return vat.SaveAssessmentData(ctx, client, db, name)
+}
+
+// loadOrgMapping reads the --org-map file. No file means no mapping.
+func loadOrgMapping(path string) (*util.OrgMapping, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open org mapping file: %w", err)
+	}
+	defer file.Close()
+	return util.NewOrgMapping(file)
+}
+
+// loadDefenseToolMapping reads the --defense-tool-map file. No file means no
+// mapping.
+func loadDefenseToolMapping(path string) (*util.DefenseToolMapping, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open defense tool mapping file: %w", err)
+	}
+	defer file.Close()
+	return util.NewDefenseToolMapping(file)
+}
+
+// loadFilter builds the dump filter from --filter terms or the --filter-file
+// (YAML or JSON rules by its extension, CSV otherwise). Neither selects every
+// assessment.
+func loadFilter(path string, terms []string) (*util.Filter, error) {
+	if len(terms) > 0 {
+		return util.NewExpressionFilter(terms)
+	}
+	if path == "" {
+		return util.NewFilter(strings.NewReader(`"*","*"` + "\n"))
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open filter file: %w", err)
+	}
+	defer file.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		return util.NewRuleFilter(file)
+	}
+	return util.NewFilter(file)
+}
+
+// journalFile persists a restore journal to disk (see --journal/--resume).
+// Every write replaces the file in one rename, so an interrupted write can't
+// leave a torn journal behind.
+type journalFile string
+
+func (f journalFile) WriteJournal(_ context.Context, journal *vat.RestoreJournal) error {
+	return writeJSONFileAtomic(string(f), "restore journal", journal, 0600)
+}
+
+// loadJournal reads a restore journal written by journalFile.
+func loadJournal(path string) (*vat.RestoreJournal, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read restore journal: %w", err)
+	}
+	journal := &vat.RestoreJournal{}
+	if err := json.Unmarshal(data, journal); err != nil {
+		return nil, fmt.Errorf("failed to decode restore journal: %w", err)
+	}
+	return journal, nil
+}
+
+// loadJSONFile decodes the JSON file at path, such as the `vat sync` or
+// `vat dump --since-state` state file, into the value newValue returns. A
+// missing file is that value as it is, e.g. an empty state. what names the
+// file in errors.
+func loadJSONFile[T any](path, what string, newValue func() *T) (*T, error) {
+	value := newValue()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return value, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", what, err)
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", what, err)
+	}
+	return value, nil
+}
+
+// writeJSONFileAtomic saves value as indented JSON at path, in one rename
+// (see writeFileAtomic). what names the file in errors.
+func writeJSONFileAtomic(path, what string, value any, perm os.FileMode) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", what, err)
+	}
+	if err := writeFileAtomic(path, data, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", what, err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to path under a temporary name and renames it
+// into place, so readers see the old file or the whole new one, never part.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// replaceFiles renames each of tmpPaths over the path at the same index in
+// paths, as one unit: the files already at paths are first set aside under
+// ".bak" and put back if any rename fails, so a failure leaves all of the old
+// files (or none, where there were none) and removes the temporary ones.
+func replaceFiles(tmpPaths, paths []string) error {
+	var setAside, placed []string
+	undo := func() {
+		for _, path := range placed {
+			os.Remove(path)
+		}
+		for _, path := range setAside {
+			os.Rename(path+".bak", path)
+		}
+		for _, tmp := range tmpPaths {
+			os.Remove(tmp)
+		}
+	}
+	for _, path := range paths {
+		err := os.Rename(path, path+".bak")
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			undo()
+			return err
+		}
+		setAside = append(setAside, path)
+	}
+	for i, tmp := range tmpPaths {
+		if err := os.Rename(tmp, paths[i]); err != nil {
+			undo()
+			return err
+		}
+		placed = append(placed, paths[i])
+	}
+	for _, path := range setAside {
+		os.Remove(path + ".bak")
+	}
+	return nil
+}
+
 // getPassphrase reads the passphrase from a file or interactively via readline.
 func getPassphrase(passphraseFile string) (string, error) {
 	if passphraseFile != "" {
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sra/vat"
+)
+
+// TestJSONFile verifies a missing state file loads as an empty state, and
+// that what writeJSONFileAtomic writes loads back the same without leaving
+// its temporary file behind.
+func TestJSONFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump-state.json")
+
+	state, err := loadJSONFile(path, "dump state", vat.NewDumpState)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if state.Assessments == nil || len(state.Assessments) != 0 {
+		t.Fatalf("missing file loaded as %+v, want an empty state", state)
+	}
+
+	state.Assessments["g-1"] = vat.DumpRecord{Db: "db1", AssessmentName: "Q1", UpdateTime: 1700000000000}
+	if err := writeJSONFileAtomic(path, "dump state", state, 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind (stat: %v)", err)
+	}
+	loaded, err := loadJSONFile(path, "dump state", vat.NewDumpState)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := loaded.Assessments["g-1"]; len(loaded.Assessments) != 1 || got != state.Assessments["g-1"] {
+		t.Errorf("loaded %+v, want %+v", loaded.Assessments, state.Assessments)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadJSONFile(path, "dump state", vat.NewDumpState); err == nil {
+		t.Error("expected an error decoding a torn file")
+	}
+}
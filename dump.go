@@ -7,6 +7,8 @@ import (
 	"log/slog"
 	"sra/vat/internal/dao"
 	"sra/vat/internal/util"
+	"sync"
+	"time"
 
 	"github.com/Khan/genqlient/graphql"
 )
@@ -15,8 +17,127 @@ import (
 type AssessmentDataEntry struct {
 	Db             string
 	AssessmentName string
+	GlobalId       string
 	Ad             *AssessmentData
 	Err            error
+
+	// Change is how the assessment compares with SaveOptionalParams.SinceState;
+	// without one every assessment is DumpNew. DumpUnchanged entries are not
+	// fetched and have no Ad.
+	Change DumpChange
+	// Record is what to put in the DumpState once the assessment is written
+	// out (see DumpState.Record).
+	Record DumpRecord
+}
+
+// DumpState is what `vat dump --since-state` remembers between runs: how each
+// assessment looked when it was last dumped, keyed by its globalId.
+type DumpState struct {
+	Assessments map[string]DumpRecord
+}
+
+// DumpRecord is one assessment's entry in DumpState.
+type DumpRecord struct {
+	Db             string
+	AssessmentName string
+	// UpdateTime is the assessment's own updateTime, and LatestUpdateTime the
+	// newest updateTime (VECTR's epoch milliseconds) of the assessment or any
+	// of its campaigns or test cases. VECTR doesn't bump the assessment's own
+	// time for every change inside it, so both are compared.
+	UpdateTime       float64
+	LatestUpdateTime float64
+	DumpedAt         time.Time
+}
+
+// NewDumpState returns an empty DumpState: every assessment is dumped.
+func NewDumpState() *DumpState {
+	return &DumpState{Assessments: map[string]DumpRecord{}}
+}
+
+// DumpChange says how a listed assessment compares with its DumpState record.
+type DumpChange string
+
+const (
+	DumpNew       DumpChange = "new"       // no record, or no globalId to key one by
+	DumpUpdated   DumpChange = "updated"   // recorded, but updated since
+	DumpUnchanged DumpChange = "unchanged" // recorded with the same update times
+)
+
+// change compares record, for the assessment with globalId, with the state.
+func (s *DumpState) change(globalId string, record DumpRecord) DumpChange {
+	prev, ok := s.Assessments[globalId]
+	if globalId == "" || !ok {
+		return DumpNew
+	}
+	if prev.UpdateTime == record.UpdateTime && prev.LatestUpdateTime == record.LatestUpdateTime {
+		return DumpUnchanged
+	}
+	return DumpUpdated
+}
+
+// Record saves ae.Record in the state, once the caller has written ae out.
+// Assessments without a globalId can't be keyed and are left out, so they are
+// dumped on every run.
+func (s *DumpState) Record(ae AssessmentDataEntry) {
+	if ae.GlobalId == "" {
+		return
+	}
+	if s.Assessments == nil {
+		s.Assessments = map[string]DumpRecord{}
+	}
+	record := ae.Record
+	record.DumpedAt = time.Now().UTC()
+	s.Assessments[ae.GlobalId] = record
+}
+
+// assessmentInfo is what a dump filter can select a listed assessment on.
+func assessmentInfo(db string, listed dao.GetAssessmentIdsForDbAssessmentsAssessmentConnectionNodesAssessment) util.AssessmentInfo {
+	info := util.AssessmentInfo{
+		Db:         db,
+		Name:       listed.Name,
+		GlobalId:   listed.GlobalId,
+		Metadata:   map[string][]string{},
+		CreateTime: vectrTime(listed.CreateTime),
+		UpdateTime: vectrTime(listed.UpdateTime),
+	}
+	for _, tag := range listed.Tags {
+		info.Tags = append(info.Tags, tag.Name)
+	}
+	for _, md := range listed.Metadata {
+		info.Metadata[md.Key] = append(info.Metadata[md.Key], md.Value)
+	}
+	return info
+}
+
+// vectrTime converts one of VECTR's epoch millisecond times; zero stays the
+// zero time.
+func vectrTime(ms float64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(int64(ms)).UTC()
+}
+
+// listedUpdateTimes returns the newest updateTime of each assessment in db
+// or any of its campaigns or test cases, by id, like latestUpdateTime for a
+// fetched one.
+func listedUpdateTimes(ctx context.Context, client graphql.Client, db string) (map[string]float64, error) {
+	assessments, err := dao.ListAssessmentUpdateTimesForDb(ctx, client, db)
+	if err != nil {
+		return nil, err
+	}
+	latest := make(map[string]float64, len(assessments))
+	for _, a := range assessments {
+		t := a.UpdateTime
+		for _, c := range a.Campaigns {
+			t = max(t, c.UpdateTime)
+			for _, tc := range c.TestCases {
+				t = max(t, tc.UpdateTime)
+			}
+		}
+		latest[a.Id] = t
+	}
+	return latest, nil
 }
 
 var ErrDumpInstanceFailure = errors.New("error in dump an instance")
@@ -27,17 +148,26 @@ var ErrDumpAssessmentFailure = errors.New("error in dumping an assessment")
 // This function performs the following steps:
 //   - Fetches all databases from the VECTR instance.
 //   - Iterates over each database to check if it should be dumped based on the provided filter.
-//   - Fetches assessments for each eligible database.
-//   - Validates assessments against the filter criteria.
-//   - Processes each assessment to populate the `AssessmentDataEntry` struct.
+//   - Lists the assessments in each eligible database (ids, names, times,
+//     tags and metadata; with optionalParams.SinceState, also the update
+//     times of their campaigns and test cases).
+//   - Checks each listed assessment against the filter criteria (see
+//     util.Filter.Check), and with
+//     optionalParams.SinceState, skips the ones unchanged since the last dump.
+//   - Fetches each matched assessment in full, by id, and processes it to
+//     populate the `AssessmentDataEntry` struct. Up to
+//     optionalParams.Concurrency assessments are fetched at once, sharing
+//     one defense tool lookup per database.
 //
 // Parameters:
 //   - ctx: Context for managing request deadlines, cancellations, and other request-scoped values.
 //   - client: GraphQL client used to make API calls.
 //   - filter: Filter object to determine which databases and assessments should be dumped.
+//   - optionalParams: Optional parameters (see SaveOptionalParams).
 //
 // Returns:
-//   - A slice of `AssessmentDataEntry` structs containing:
+//   - A slice of `AssessmentDataEntry` structs, in listing order whatever the
+//     concurrency, containing:
 //   - Database name.
 //   - Assessment name.
 //   - Serialized assessment data.
@@ -45,10 +175,11 @@ var ErrDumpAssessmentFailure = errors.New("error in dumping an assessment")
 //   - An error if any step in the process fails.
 //
 // Errors:
-//   - Returns `ErrDumpInstanceFailure` if fetching databases fails.
-//   - Returns `ErrDumpAssessmentFailure` if processing any assessment fails.
+//   - Returns `ErrDumpInstanceFailure` if fetching databases or listing assessments fails.
+//   - Returns `ErrDumpAssessmentFailure` if processing any assessment fails,
+//     including assessments left unfetched because ctx was cancelled.
 //   - Returns a wrapped error with additional context if any GraphQL query fails.
-func DumpInstance(ctx context.Context, client graphql.Client, filter *util.Filter) ([]AssessmentDataEntry, error) {
+func DumpInstance(ctx context.Context, client graphql.Client, filter *util.Filter, optionalParams *SaveOptionalParams) ([]AssessmentDataEntry, error) {
 
 	dbs, err := dao.GetAllDatabases(ctx, client)
 	if err != nil {
@@ -57,56 +188,217 @@ func DumpInstance(ctx context.Context, client graphql.Client, filter *util.Filte
 		}
 		return nil, fmt.Errorf("could not get databases for instance: %w: %w", err, ErrDumpInstanceFailure)
 	}
-	// now process each assessment
-	dumpedAssessments := make([]AssessmentDataEntry, 0, len(dbs.Databases))
-	var overallError error
+	// list everything first, then fetch the matched assessments
+	var dumpedAssessments []AssessmentDataEntry
+	var ids []string
 	for _, db := range dbs.Databases {
 		// Check if the database should be dumped
 		if filter.CheckDb(db.Name) {
-			assessments, err := dao.GetBatchAssessmentsForDb(ctx, client, db.Name)
+			// List cheaply first, so only the assessments the filter keeps
+			// are fetched in full
+			assessments, err := dao.ListAssessmentIdsForDb(ctx, client, db.Name)
 			if err != nil {
 				if gqlObject, ok := gqlErrParse(err); ok {
 					slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
 				}
-				return dumpedAssessments, fmt.Errorf("could not dump assessments for db: %s; %w: %w", db.Name, err, ErrDumpInstanceFailure)
+				return nil, fmt.Errorf("could not dump assessments for db: %s; %w: %w", db.Name, err, ErrDumpInstanceFailure)
 			}
-			for _, assessment := range assessments.Assessments.Nodes {
-				// Check if the assessment should be dumped
-				if filter.CheckAssessment(db.Name, assessment.Name) {
-					ae := AssessmentDataEntry{
-						Db:             db.Name,
-						AssessmentName: assessment.Name,
-					}
-					data := &AssessmentData{
-						AssessmentResource: AssessmentResource{},
-						ToolsMap:           map[string]DefenseToolRef{},
-						IdToolsMap:         map[string]DefenseToolRef{},
-						OrgMap:             make(map[string]dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentOrganizationsOrganization),
-						Manifest:           NewManifestMetadata(ctx),
+			// only a state needs the campaign and test case update times
+			var latest map[string]float64
+			if optionalParams.SinceState != nil {
+				latest, err = listedUpdateTimes(ctx, client, db.Name)
+				if err != nil {
+					if gqlObject, ok := gqlErrParse(err); ok {
+						slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
 					}
-					ad, err := saveAssessment(ctx, client, assessment, data, db.Name)
-					if err != nil {
-						if gqlObject, ok := gqlErrParse(err); ok {
-							slog.WarnContext(ctx, "Could not dump assessment", "error", gqlObject, "db", db.Name, "assessment", assessment.Name)
-						}
-						ae.Err = fmt.Errorf("could not dump assessment, db: %s, assessment-name: %s, %w", db.Name, assessment.Name, err)
-						overallError = ErrDumpAssessmentFailure
-						dumpedAssessments = append(dumpedAssessments, ae)
-						// don't return here, just keep processing the data
-						continue
-					}
-					ae.Ad = ad
-					dumpedAssessments = append(dumpedAssessments, ae)
+					return nil, fmt.Errorf("could not list update times for db: %s; %w: %w", db.Name, err, ErrDumpInstanceFailure)
 				}
 			}
+			for _, listed := range assessments {
+				// Check if the assessment should be dumped
+				if !filter.Check(assessmentInfo(db.Name, listed)) {
+					continue
+				}
+				ae := AssessmentDataEntry{
+					Db:             db.Name,
+					AssessmentName: listed.Name,
+					GlobalId:       listed.GlobalId,
+					Change:         DumpNew,
+					Record: DumpRecord{
+						Db:               db.Name,
+						AssessmentName:   listed.Name,
+						UpdateTime:       listed.UpdateTime,
+						LatestUpdateTime: max(listed.UpdateTime, latest[listed.Id]),
+					},
+				}
+				if optionalParams.SinceState != nil {
+					ae.Change = optionalParams.SinceState.change(listed.GlobalId, ae.Record)
+				}
+				if ae.Change == DumpUnchanged {
+					slog.DebugContext(ctx, "Assessment unchanged since the last dump, skipping", "db", db.Name, "assessment-name", listed.Name, "global-id", listed.GlobalId)
+				}
+				dumpedAssessments = append(dumpedAssessments, ae)
+				ids = append(ids, listed.Id)
+			}
 		}
 	}
-	failed := 0
+
+	// every worker shares one set of lookups
+	lookups := newSaveLookups()
+	workers := max(optionalParams.Concurrency, 1)
+	slog.InfoContext(ctx, "Dumping assessments", "assessment-count", len(dumpedAssessments), "concurrency", workers)
+
+	slots := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i := range dumpedAssessments {
+		ae := &dumpedAssessments[i]
+		if ae.Change == DumpUnchanged {
+			continue
+		}
+		// once cancelled, start nothing new, even if a slot is free
+		err := ctx.Err()
+		if err == nil {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+		if err != nil {
+			ae.Err = fmt.Errorf("did not dump assessment, db: %s, assessment-name: %s, %w", ae.Db, ae.AssessmentName, err)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			ad, err := dumpAssessment(ctx, client, ae.Db, ids[i], lookups)
+			if err != nil {
+				if gqlObject, ok := gqlErrParse(err); ok {
+					slog.WarnContext(ctx, "Could not dump assessment", "error", gqlObject, "db", ae.Db, "assessment", ae.AssessmentName)
+				}
+				// don't stop the others, the error stays with this entry
+				ae.Err = fmt.Errorf("could not dump assessment, db: %s, assessment-name: %s, %w", ae.Db, ae.AssessmentName, err)
+				return
+			}
+			ae.Ad = ad
+		}()
+	}
+	wg.Wait()
+
+	var overallError error
+	failed, unchanged := 0, 0
 	for _, ae := range dumpedAssessments {
 		if ae.Err != nil {
 			failed++
+			overallError = ErrDumpAssessmentFailure
+		}
+		if ae.Change == DumpUnchanged {
+			unchanged++
 		}
 	}
-	slog.InfoContext(ctx, "Finished dumping instance", "assessment-count", len(dumpedAssessments), "failed-count", failed)
+	slog.InfoContext(ctx, "Finished dumping instance", "assessment-count", len(dumpedAssessments), "failed-count", failed, "unchanged-count", unchanged)
 	return dumpedAssessments, overallError
 }
+
+// dumpAssessment fetches the assessment with id in db in full and saves it
+// like SaveAssessmentData does.
+//
+// Errors:
+//   - Returns `ErrNoAssessmentsFound` if the assessment is gone since it was
+//     listed.
+//   - Returns a wrapped error with additional context if any GraphQL query fails.
+func dumpAssessment(ctx context.Context, client graphql.Client, db, id string, lookups *saveLookups) (*AssessmentData, error) {
+	r, err := dao.GetAssessmentsByIds(ctx, client, db, []string{id})
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch assessment %s: %w", id, err)
+	}
+	if len(r.AssessmentsByIds.Nodes) == 0 {
+		return nil, fmt.Errorf("assessment %s: %w", id, ErrNoAssessmentsFound)
+	}
+	data := &AssessmentData{
+		AssessmentResource: AssessmentResource{},
+		ToolsMap:           map[string]DefenseToolRef{},
+		IdToolsMap:         map[string]DefenseToolRef{},
+		OrgMap:             make(map[string]dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentOrganizationsOrganization),
+		Manifest:           NewManifestMetadata(ctx),
+	}
+	return saveAssessment(ctx, client, r.AssessmentsByIds.Nodes[0], data, db, lookups)
+}
+
+// saveLookups holds the lookups saveAssessment makes that do not depend on
+// the assessment, so the assessments of one dump fetch each once rather than
+// once apiece: defense tools per database, asset property types per
+// instance. A successful result is kept for the whole dump; a failed one is
+// dropped, so the next caller fetches again. It is safe for concurrent use;
+// a nil *saveLookups fetches on every call.
+type saveLookups struct {
+	mu            sync.Mutex
+	tools         map[string]*lookup[[]dao.GetAllDefenseToolsBluetoolsBlueToolConnectionNodesBlueTool]
+	propertyTypes *lookup[[]dao.GetAllAssetPropertyTypesAssetPropertyTypesAssetPropertyTypeConnectionNodesAssetPropertyType]
+}
+
+// lookup is one cached fetch. Callers that arrive while it runs share its
+// result.
+type lookup[T any] struct {
+	get func() (T, error)
+}
+
+func newLookup[T any](fetch func() (T, error)) *lookup[T] {
+	return &lookup[T]{get: sync.OnceValues(fetch)}
+}
+
+func newSaveLookups() *saveLookups {
+	return &saveLookups{
+		tools: map[string]*lookup[[]dao.GetAllDefenseToolsBluetoolsBlueToolConnectionNodesBlueTool]{},
+	}
+}
+
+// defenseTools returns every defense tool in db.
+func (l *saveLookups) defenseTools(ctx context.Context, client graphql.Client, db string) ([]dao.GetAllDefenseToolsBluetoolsBlueToolConnectionNodesBlueTool, error) {
+	if l == nil {
+		return dao.ListDefenseTools(ctx, client, db)
+	}
+	l.mu.Lock()
+	cached, ok := l.tools[db]
+	if !ok {
+		cached = newLookup(func() ([]dao.GetAllDefenseToolsBluetoolsBlueToolConnectionNodesBlueTool, error) {
+			return dao.ListDefenseTools(ctx, client, db)
+		})
+		l.tools[db] = cached
+	}
+	l.mu.Unlock()
+	tools, err := cached.get()
+	if err != nil {
+		l.mu.Lock()
+		if l.tools[db] == cached {
+			delete(l.tools, db)
+		}
+		l.mu.Unlock()
+	}
+	return tools, err
+}
+
+// assetPropertyTypes returns every asset property type in the instance.
+func (l *saveLookups) assetPropertyTypes(ctx context.Context, client graphql.Client) ([]dao.GetAllAssetPropertyTypesAssetPropertyTypesAssetPropertyTypeConnectionNodesAssetPropertyType, error) {
+	if l == nil {
+		return dao.ListAssetPropertyTypes(ctx, client)
+	}
+	l.mu.Lock()
+	if l.propertyTypes == nil {
+		l.propertyTypes = newLookup(func() ([]dao.GetAllAssetPropertyTypesAssetPropertyTypesAssetPropertyTypeConnectionNodesAssetPropertyType, error) {
+			return dao.ListAssetPropertyTypes(ctx, client)
+		})
+	}
+	cached := l.propertyTypes
+	l.mu.Unlock()
+	types, err := cached.get()
+	if err != nil {
+		l.mu.Lock()
+		if l.propertyTypes == cached {
+			l.propertyTypes = nil
+		}
+		l.mu.Unlock()
+	}
+	return types, err
+}
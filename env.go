@@ -0,0 +1,529 @@
+package vat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"sra/vat/internal/dao"
+
+	"github.com/Khan/genqlient/graphql"
+)
+
+// Environment resource names. An environment archive (see EnvironmentData)
+// carries these instead of the assessment resources, in the same envelope.
+const (
+	ResourceEnvDefenseTools  = "defensetools"
+	ResourceEnvProducts      = "defensetoolproducts"
+	ResourceEnvVendors       = "vendors"
+	ResourceEnvDefenseLayers = "defenselayers"
+	ResourceEnvTags          = "tags"
+	ResourceEnvOutcomes      = "outcomes"
+	ResourceEnvOrganizations = "organizations"
+)
+
+// EnvironmentData is the in-memory model of an environment archive: the
+// configuration of one db, and of the instance around it, that assessments
+// are restored on top of. Like AssessmentData it composes individually
+// versioned resources (see envResourceRegistry) with the file's manifest.
+//
+// Everything is recorded by name rather than id, since ids are per-instance.
+type EnvironmentData struct {
+	Manifest Manifest
+	// DefenseTools is every defense tool in the db, keyed by
+	// DefenseToolRef.Key(), with its product and layers.
+	DefenseTools ToolsMapResource
+	// Products is every defense tool product that isn't built in to VECTR,
+	// whether or not a tool uses it.
+	Products []DefenseToolProductRef
+	// Vendors is the name of every library vendor.
+	Vendors []string
+	// DefenseLayers is every defense layer in the db.
+	DefenseLayers []DefenseLayer
+	// Tags is every tag that isn't built in to VECTR.
+	Tags []EnvTag
+	// Outcomes is every outcome, with the settings that can be changed.
+	Outcomes []EnvOutcome
+	// Organizations is every organization.
+	Organizations []EnvOrganization
+}
+
+// EnvTag is a tag. TagType is VECTR's TagTypeEnum value for it (e.g.
+// TEST_CASE), which is what creating a tag takes.
+type EnvTag struct {
+	Name    string
+	TagType string
+	Color   string
+}
+
+// EnvOutcome is an outcome and its report settings. Path identifies it
+// across instances, as in update mode (see updateExistingAssessment).
+type EnvOutcome struct {
+	Path            string
+	Name            string
+	Abbreviation    string
+	ReportText      string
+	ReportTextColor string
+	CoverageScore   float64
+}
+
+// EnvOrganization is an organization.
+type EnvOrganization struct {
+	Name         string
+	Abbreviation string
+	Description  string
+	Url          string
+}
+
+// envResourceRegistry is resourceRegistry for environment archives. Every
+// resource is required, which is also what tells an environment archive
+// apart from an assessment one on decode; any added later has to be
+// optional, for the same reason as in resourceRegistry.
+var envResourceRegistry = []resourceDescriptor[EnvironmentData]{
+	envResource(ResourceEnvDefenseTools, func(e *EnvironmentData) any { return &e.DefenseTools }),
+	envResource(ResourceEnvProducts, func(e *EnvironmentData) any { return &e.Products }),
+	envResource(ResourceEnvVendors, func(e *EnvironmentData) any { return &e.Vendors }),
+	envResource(ResourceEnvDefenseLayers, func(e *EnvironmentData) any { return &e.DefenseLayers }),
+	envResource(ResourceEnvTags, func(e *EnvironmentData) any { return &e.Tags }),
+	envResource(ResourceEnvOutcomes, func(e *EnvironmentData) any { return &e.Outcomes }),
+	envResource(ResourceEnvOrganizations, func(e *EnvironmentData) any { return &e.Organizations }),
+}
+
+// envResource describes a required environment resource stored as the JSON
+// of the field field returns a pointer to.
+func envResource(name string, field func(*EnvironmentData) any) resourceDescriptor[EnvironmentData] {
+	return resourceDescriptor[EnvironmentData]{
+		Name:     name,
+		Required: ResourceRequired,
+		Encode: func(e *EnvironmentData) (json.RawMessage, error) {
+			return json.Marshal(field(e))
+		},
+		Decode: func(e *EnvironmentData, raw json.RawMessage) error {
+			return json.Unmarshal(raw, field(e))
+		},
+	}
+}
+
+// EncodeEnvironmentToJson serializes an EnvironmentData into the
+// manifest+resource envelope wire format.
+func EncodeEnvironmentToJson(data *EnvironmentData) ([]byte, error) {
+	return encodeEnvelope(data.Manifest, envResourceRegistry, data)
+}
+
+// DecodeEnvironmentJson deserializes an environment archive. An assessment
+// archive fails with ErrMissingRequiredResource.
+func DecodeEnvironmentJson(raw []byte) (*EnvironmentData, error) {
+	e := &EnvironmentData{}
+	manifest, err := decodeEnvelope(raw, envResourceRegistry, e)
+	if err != nil {
+		return nil, err
+	}
+	e.Manifest = manifest
+	return e, nil
+}
+
+// SaveEnvironment captures the environment configuration of db: its defense
+// tools and defense layers, and the instance's defense tool products,
+// vendors, tags, outcomes and organizations.
+//
+// Built-in products and tags are left out, since every instance has them.
+//
+// Errors:
+//   - Returns a wrapped error with additional context if any GraphQL query fails.
+func SaveEnvironment(ctx context.Context, client graphql.Client, db string) (*EnvironmentData, error) {
+	slog.InfoContext(ctx, "Starting SaveEnvironment", "db", db)
+	data := &EnvironmentData{
+		Manifest:     NewManifestMetadata(ctx),
+		DefenseTools: ToolsMapResource{},
+	}
+	fail := func(what string, err error) (*EnvironmentData, error) {
+		if gqlObject, ok := gqlErrParse(err); ok {
+			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+		}
+		return nil, fmt.Errorf("could not fetch %s: %w", what, err)
+	}
+
+	tools, err := dao.ListDefenseTools(ctx, client, db)
+	if err != nil {
+		return fail("defense tools", err)
+	}
+	for _, t := range tools {
+		ref := toDefenseToolRef(t)
+		data.DefenseTools[ref.Key()] = ref
+	}
+
+	layers, err := dao.ListDefensiveLayers(ctx, client, db)
+	if err != nil {
+		return fail("defensive layers", err)
+	}
+	for _, l := range layers {
+		data.DefenseLayers = append(data.DefenseLayers, DefenseLayer{Name: l.Name, Description: l.Description})
+	}
+
+	products, err := dao.ListDefenseToolProducts(ctx, client)
+	if err != nil {
+		return fail("defense tool products", err)
+	}
+	for _, p := range products {
+		if p.Sys {
+			continue
+		}
+		ref := DefenseToolProductRef{Ref: p.Ref, Name: p.Name, VendorName: p.Vendor.Name}
+		for _, l := range p.DefensiveLayers {
+			ref.Layers = append(ref.Layers, DefenseLayer{Name: l.Name, Description: l.Description})
+		}
+		data.Products = append(data.Products, ref)
+	}
+
+	vendors, err := dao.ListLibraryVendors(ctx, client)
+	if err != nil {
+		return fail("vendors", err)
+	}
+	for _, v := range vendors {
+		data.Vendors = append(data.Vendors, v.Name)
+	}
+
+	tagTypes, err := tagTypesById(ctx, client)
+	if err != nil {
+		return fail("tag types", err)
+	}
+	tags, err := dao.ListTags(ctx, client)
+	if err != nil {
+		return fail("tags", err)
+	}
+	for _, t := range tags {
+		if t.Sys {
+			continue
+		}
+		tagType, ok := tagTypes[t.TagTypeId]
+		if !ok {
+			slog.WarnContext(ctx, "tag has an unknown tag type, leaving it out", "tag-name", t.Name, "tag-type-id", t.TagTypeId)
+			continue
+		}
+		data.Tags = append(data.Tags, EnvTag{Name: t.Name, TagType: string(tagType), Color: t.TagColor})
+	}
+
+	outcomes, err := dao.GetAllOutcomes(ctx, client)
+	if err != nil {
+		return fail("outcomes", err)
+	}
+	for _, o := range outcomes.Outcomes {
+		data.Outcomes = append(data.Outcomes, EnvOutcome{
+			Path:            o.Path,
+			Name:            o.Name,
+			Abbreviation:    o.Abbreviation,
+			ReportText:      o.ReportText,
+			ReportTextColor: o.ReportTextColor,
+			CoverageScore:   o.CoverageScore,
+		})
+	}
+
+	orgs, err := dao.ListOrganizations(ctx, client)
+	if err != nil {
+		return fail("organizations", err)
+	}
+	for _, o := range orgs {
+		data.Organizations = append(data.Organizations, EnvOrganization{Name: o.Name, Abbreviation: o.Abbreviation, Description: o.Description, Url: o.Url})
+	}
+
+	slog.InfoContext(ctx, "Finished saving environment", "db", db,
+		"defense-tool-count", len(data.DefenseTools),
+		"product-count", len(data.Products),
+		"vendor-count", len(data.Vendors),
+		"defense-layer-count", len(data.DefenseLayers),
+		"tag-count", len(data.Tags),
+		"outcome-count", len(data.Outcomes),
+		"organization-count", len(data.Organizations))
+	return data, nil
+}
+
+// tagTypesById maps the instance's tag type ids to their TagTypeEnum value,
+// which is the tag type's ref, or failing that its name, in upper snake case.
+// Tag types matching no enum value are left out.
+func tagTypesById(ctx context.Context, client graphql.Client) (map[string]dao.TagTypeEnum, error) {
+	r, err := dao.GetAllTagTypes(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	types := make(map[string]dao.TagTypeEnum, len(r.TagTypes))
+	for _, tt := range r.TagTypes {
+		for _, s := range []string{tt.Ref, tt.Name} {
+			e := dao.TagTypeEnum(strings.ToUpper(strings.NewReplacer(" ", "_", "-", "_").Replace(strings.TrimSpace(s))))
+			if slices.Contains(dao.AllTagTypeEnum, e) {
+				types[tt.Id] = e
+				break
+			}
+		}
+	}
+	return types, nil
+}
+
+// EnvironmentReport is what RestoreEnvironment could not make match: VECTR's
+// API can't create organizations, vendors or outcomes, so any the archive
+// has and the target instance lacks have to be created by hand. Without
+// UpdateOutcomes, outcomes whose report settings differ from the archive's
+// are listed in DifferingOutcomes and left alone.
+type EnvironmentReport struct {
+	MissingOrganizations []string
+	MissingVendors       []string
+	MissingOutcomes      []string // by path
+	DifferingOutcomes    []string // by path
+	CreatedTags          int
+	UpdatedOutcomes      int
+	DefenseTools         int // reconciled
+}
+
+// RestoreEnvironment brings db, and the instance around it, in line with an
+// environment archive before any assessment is restored into it:
+//   - Defense tool products (with their library layers), the db's defense
+//     layers and its defense tools are matched or created the way restore
+//     does (see resolveOrCreateDefenseToolProduct, resolveOrCreateDefenseLayerIds
+//     and reconcileDefenseTools), honouring DefenseToolMapping and
+//     StrictDefenseToolMatch. Existing ones are never modified beyond having
+//     missing layers added.
+//   - Missing tags are created.
+//   - Outcomes are matched by path. Their report settings are only updated
+//     with UpdateOutcomes, and since outcomes are instance-wide that changes
+//     them for every db; otherwise the differences are only reported.
+//   - Organizations and vendors are only checked.
+//
+// Everything it creates except tags is recorded in optionalParams' journal,
+// so DeleteOnFailure and RollbackRestore can remove it again. VECTR has no
+// way to delete a tag. The journal also keeps the settings of every outcome
+// it updates as they were before, which RollbackRestore writes back.
+//
+// Errors:
+//   - Returns the errors reconcileDefenseTools does.
+//   - Returns ErrJournalMismatch if optionalParams' journal is not for an
+//     environment restore into db.
+//   - Returns a wrapped error with additional context if any GraphQL query fails.
+func RestoreEnvironment(ctx context.Context, client graphql.Client, db string, env *EnvironmentData, optionalParams *RestoreOptionalParams) (*EnvironmentReport, error) {
+	slog.InfoContext(ctx, "Starting RestoreEnvironment", "db", db)
+	restoreInfo := NewVatOpMetadata(ctx)
+	if env.Manifest.VectrVersion != "" && env.Manifest.VectrVersion != restoreInfo.VectrVersion {
+		slog.WarnContext(ctx, "Save data does not match version you are loading into. The restore may not work correctly", "save-vectr-version", env.Manifest.VectrVersion, "live-vectr-version", restoreInfo.VectrVersion)
+	}
+	j := optionalParams.journal()
+	if j.SourceAssessmentName != "" || j.AssessmentId != "" || j.AsTemplate || (j.Db != "" && j.Db != db) {
+		return nil, fmt.Errorf("journal is for a restore of %q into %s, not an environment restore into %s: %w", j.SourceAssessmentName, j.Db, db, ErrJournalMismatch)
+	}
+	j.Db = db
+
+	report, err := restoreEnvironment(ctx, client, db, env, optionalParams)
+	if err != nil {
+		if optionalParams.DeleteOnFailure {
+			optionalParams.rollbackOnFailure(ctx, client)
+		}
+		return nil, err
+	}
+	optionalParams.journal().Complete = true
+	if err := optionalParams.checkpoint(ctx); err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "Environment restored successfully", "db", db,
+		"defense-tool-count", report.DefenseTools,
+		"created-tag-count", report.CreatedTags,
+		"updated-outcome-count", report.UpdatedOutcomes)
+	return report, nil
+}
+
+func restoreEnvironment(ctx context.Context, client graphql.Client, db string, env *EnvironmentData, optionalParams *RestoreOptionalParams) (*EnvironmentReport, error) {
+	report := &EnvironmentReport{}
+	fail := func(what string, err error) (*EnvironmentReport, error) {
+		if gqlObject, ok := gqlErrParse(err); ok {
+			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+		}
+		return nil, fmt.Errorf("could not %s: %w", what, err)
+	}
+
+	orgs, err := dao.ListOrganizations(ctx, client)
+	if err != nil {
+		return fail("fetch organizations", err)
+	}
+	report.MissingOrganizations = missingNames(env.Organizations, func(o EnvOrganization) string { return o.Name }, orgs, func(o dao.GetAllOrganizationsOrganizationsOrganizationConnectionNodesOrganization) string {
+		return o.Name
+	})
+	for _, name := range report.MissingOrganizations {
+		slog.WarnContext(ctx, "organization not found in target instance, VECTR's API can't create it", "organization-name", name)
+	}
+
+	vendors, err := dao.ListLibraryVendors(ctx, client)
+	if err != nil {
+		return fail("fetch vendors", err)
+	}
+	report.MissingVendors = missingNames(env.Vendors, func(v string) string { return v }, vendors, func(v dao.GetAllLibraryVendorsLibraryVendorsVendorConnectionNodesVendor) string { return v.Name })
+	for _, name := range report.MissingVendors {
+		slog.WarnContext(ctx, "vendor not found in target instance, VECTR's API can't create it; products of this vendor are created without one", "vendor-name", name)
+	}
+
+	if err := restoreEnvironmentProductsAndLayers(ctx, client, db, env, optionalParams); err != nil {
+		return nil, err
+	}
+	toolIds, err := reconcileDefenseTools(ctx, client, db, env.DefenseTools, optionalParams)
+	if err != nil {
+		return nil, err
+	}
+	report.DefenseTools = len(toolIds)
+
+	tagTypes, err := tagTypesById(ctx, client)
+	if err != nil {
+		return fail("fetch tag types", err)
+	}
+	tags, err := dao.ListTags(ctx, client)
+	if err != nil {
+		return fail("fetch tags", err)
+	}
+	existingTags := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		existingTags[string(tagTypes[t.TagTypeId])+"\x00"+t.Name] = true
+	}
+	for _, t := range env.Tags {
+		if existingTags[t.TagType+"\x00"+t.Name] {
+			continue
+		}
+		if !slices.Contains(dao.AllTagTypeEnum, dao.TagTypeEnum(t.TagType)) {
+			slog.WarnContext(ctx, "tag has a tag type this VECTR version doesn't know, not creating it", "tag-name", t.Name, "tag-type", t.TagType)
+			continue
+		}
+		if _, err := dao.CreateTag(ctx, client, dao.CreateTagInput{Name: t.Name, TagType: dao.TagTypeEnum(t.TagType), TagColor: t.Color}); err != nil {
+			return fail(fmt.Sprintf("create tag %q", t.Name), err)
+		}
+		slog.DebugContext(ctx, "tag created", "tag-name", t.Name, "tag-type", t.TagType)
+		existingTags[t.TagType+"\x00"+t.Name] = true
+		report.CreatedTags++
+	}
+
+	outcomes, err := dao.GetAllOutcomes(ctx, client)
+	if err != nil {
+		return fail("fetch outcomes", err)
+	}
+	outcomesByPath := make(map[string]dao.GetAllOutcomesOutcomesOutcome, len(outcomes.Outcomes))
+	for _, o := range outcomes.Outcomes {
+		outcomesByPath[o.Path] = o
+	}
+	var updates []dao.UpdateOutcomeDataInput
+	j := optionalParams.journal()
+	for _, o := range env.Outcomes {
+		existing, ok := outcomesByPath[o.Path]
+		if !ok {
+			report.MissingOutcomes = append(report.MissingOutcomes, o.Path)
+			slog.WarnContext(ctx, "outcome not found in target instance, VECTR's API can't create it", "outcome-path", o.Path)
+			continue
+		}
+		if existing.Abbreviation == o.Abbreviation && existing.ReportText == o.ReportText && existing.ReportTextColor == o.ReportTextColor && existing.CoverageScore == o.CoverageScore {
+			continue
+		}
+		if !optionalParams.UpdateOutcomes {
+			report.DifferingOutcomes = append(report.DifferingOutcomes, o.Path)
+			slog.WarnContext(ctx, "outcome report settings differ from the archive, pass --update-outcomes to change them for every db", "outcome-path", o.Path)
+			continue
+		}
+		// A resumed restore may already have updated it; the first recorded
+		// settings are the ones from before the restore.
+		if _, ok := j.PriorOutcomes[existing.Id]; !ok {
+			j.PriorOutcomes[existing.Id] = EnvOutcome{
+				Path:            existing.Path,
+				Name:            existing.Name,
+				Abbreviation:    existing.Abbreviation,
+				ReportText:      existing.ReportText,
+				ReportTextColor: existing.ReportTextColor,
+				CoverageScore:   existing.CoverageScore,
+			}
+		}
+		updates = append(updates, dao.UpdateOutcomeDataInput{
+			Id:              existing.Id,
+			Abbreviation:    o.Abbreviation,
+			ReportText:      o.ReportText,
+			ReportTextColor: o.ReportTextColor,
+			CoverageScore:   o.CoverageScore,
+		})
+	}
+	if len(updates) > 0 {
+		// Record the prior settings before the update, which may apply even
+		// if its response never arrives.
+		if err := optionalParams.checkpoint(ctx); err != nil {
+			return nil, err
+		}
+		if _, err := dao.UpdateOutcomes(ctx, client, dao.UpdateOutcomeInput{UpdateOutcomeData: updates}); err != nil {
+			return fail(fmt.Sprintf("update %d outcome(s)", len(updates)), err)
+		}
+	}
+	report.UpdatedOutcomes = len(updates)
+	return report, nil
+}
+
+// restoreEnvironmentProductsAndLayers makes sure every product and db
+// defense layer of the archive exists, including the ones no defense tool
+// uses, which reconcileDefenseTools would never get to.
+func restoreEnvironmentProductsAndLayers(ctx context.Context, client graphql.Client, db string, env *EnvironmentData, optionalParams *RestoreOptionalParams) error {
+	existingProducts, err := dao.ListDefenseToolProducts(ctx, client)
+	if err != nil {
+		if gqlObject, ok := gqlErrParse(err); ok {
+			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+		}
+		return fmt.Errorf("could not fetch defense tool products: %w", err)
+	}
+	productsByRef, productsByName, _ := indexDefenseToolProducts(ctx, existingProducts)
+
+	existingLibraryLayers, err := dao.ListLibraryDefensiveLayers(ctx, client)
+	if err != nil {
+		if gqlObject, ok := gqlErrParse(err); ok {
+			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+		}
+		return fmt.Errorf("could not fetch library defensive layers: %w", err)
+	}
+	libraryLayersByName := make(map[string]dao.GetAllLibraryDefensiveLayersLibraryDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer, len(existingLibraryLayers))
+	for _, l := range existingLibraryLayers {
+		libraryLayersByName[strings.ToLower(l.Name)] = l
+	}
+
+	for _, ref := range env.Products {
+		if _, err := resolveOrCreateDefenseToolProduct(ctx, client, ref, productsByRef, productsByName, libraryLayersByName, optionalParams); err != nil {
+			return err
+		}
+	}
+
+	existingLayers, err := dao.ListDefensiveLayers(ctx, client, db)
+	if err != nil {
+		if gqlObject, ok := gqlErrParse(err); ok {
+			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+		}
+		return fmt.Errorf("could not fetch defensive layers: %w", err)
+	}
+	layersByName := make(map[string]dao.GetAllDefensiveLayersDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer, len(existingLayers))
+	for _, l := range existingLayers {
+		layersByName[strings.ToLower(l.Name)] = l
+	}
+	for _, layer := range env.DefenseLayers {
+		if _, ok := layersByName[strings.ToLower(layer.Name)]; ok {
+			continue
+		}
+		// Resolve the library layer first so one created for it keeps the
+		// saved description; resolveOrCreateDefenseLayerIds only has names.
+		if _, err := resolveOrCreateLibraryDefenseLayerIds(ctx, client, []DefenseLayer{layer}, libraryLayersByName, optionalParams); err != nil {
+			return err
+		}
+		if _, err := resolveOrCreateDefenseLayerIds(ctx, client, db, []string{layer.Name}, layersByName, libraryLayersByName, optionalParams); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// missingNames returns the names of want, in order, that none of have has.
+func missingNames[W, H any](want []W, wantName func(W) string, have []H, haveName func(H) string) []string {
+	names := make(map[string]bool, len(have))
+	for _, h := range have {
+		names[haveName(h)] = true
+	}
+	var missing []string
+	for _, w := range want {
+		if name := wantName(w); !names[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
@@ -49,6 +49,7 @@ const (
 	ResourceOrgMap           = "orgmap"
 	ResourceToolsMap         = "toolsmap"
 	ResourceIdToolsMap       = "idtoolsmap"
+	ResourceAssets           = "assets"
 )
 
 // ResourceRequirement describes whether a resource must be present for vat
@@ -62,10 +63,9 @@ const (
 	ResourceRequired ResourceRequirement = true
 	// ResourceOptional means the resource may be entirely absent from a
 	// file; downstream flow (e.g. restore.go) is expected to check for its
-	// absence and adjust accordingly. None of the resources registered
-	// below are optional today — this exists for a future resource (e.g.
-	// RTA, see FormatVersion's doc comment) that a decoder could legitimately
-	// not find in an older file.
+	// absence and adjust accordingly. Any resource added after vat 2.0
+	// shipped has to be optional, since files saved before it existed will
+	// never carry it (e.g. "assets").
 	ResourceOptional ResourceRequirement = false
 )
 
@@ -83,14 +83,18 @@ var ErrMissingRequiredResource = errors.New("missing required resource")
 // Adding a new resource (e.g. "rta"): add one entry here. Vat binaries built
 // before that entry exists will slog.Warn and skip the resource rather than
 // failing (see DecodeJson).
-type resourceDescriptor struct {
+//
+// T is the in-memory model the registry fills: AssessmentData for an
+// assessment archive, EnvironmentData for an environment archive (see
+// envResourceRegistry in env.go). Both share the envelope and its rules.
+type resourceDescriptor[T any] struct {
 	Name     string
 	Required ResourceRequirement
-	Encode   func(*AssessmentData) (json.RawMessage, error)
-	Decode   func(*AssessmentData, json.RawMessage) error
+	Encode   func(*T) (json.RawMessage, error)
+	Decode   func(*T, json.RawMessage) error
 }
 
-var resourceRegistry = []resourceDescriptor{
+var resourceRegistry = []resourceDescriptor[AssessmentData]{
 	{
 		Name:     ResourceAssessment,
 		Required: ResourceRequired,
@@ -141,6 +145,16 @@ var resourceRegistry = []resourceDescriptor{
 			return json.Unmarshal(raw, &a.IdToolsMap)
 		},
 	},
+	{
+		Name:     ResourceAssets,
+		Required: ResourceOptional,
+		Encode: func(a *AssessmentData) (json.RawMessage, error) {
+			return json.Marshal(a.Assets)
+		},
+		Decode: func(a *AssessmentData, raw json.RawMessage) error {
+			return json.Unmarshal(raw, &a.Assets)
+		},
+	},
 }
 
 // IsResourceRequired reports whether name is a resource vat cannot function
@@ -253,24 +267,54 @@ type ToolsMapResource map[string]DefenseToolRef
 // back to a DefenseToolRef during restore.
 type IdToolsMapResource map[string]DefenseToolRef
 
+// AssetsResource is the "assets" resource: the full record of every target
+// and source referenced by the assessment's test cases, keyed by name. Test
+// cases themselves only link to assets by name (that's all the create input
+// takes), so this is what lets restore recreate a missing asset with its
+// description and property type instead of as a bare name.
+//
+// It is optional: files saved before assets were captured don't carry it,
+// and restore falls back to letting VECTR create assets from the bare names.
+type AssetsResource struct {
+	Targets map[string]Asset
+	Sources map[string]Asset
+}
+
+// Asset is a single target or source. PropertyType is the asset property
+// type's name, which is what restore matches on in the target instance;
+// PropertyTypeId is the source instance's id for it, kept as a fallback for
+// built-in types whose ids are stable across instances. Both are blank for
+// an asset with no property type. VECTR has no description for sources, so
+// Description is always blank for them.
+type Asset struct {
+	Name           string
+	Description    string
+	PropertyType   string
+	PropertyTypeId string
+}
+
 // EncodeToJson serializes an AssessmentData into the manifest+resource
 // envelope wire format.
 func EncodeToJson(data *AssessmentData) ([]byte, error) {
-	// data.Manifest already carries save-time provenance (VatVersion,
+	return encodeEnvelope(data.Manifest, resourceRegistry, data)
+}
+
+// encodeEnvelope serializes data with every resource in registry.
+func encodeEnvelope[T any](manifest Manifest, registry []resourceDescriptor[T], data *T) ([]byte, error) {
+	// manifest already carries save-time provenance (VatVersion,
 	// VectrVersion, Created) stamped by NewManifestMetadata at save time;
 	// only the format version and the resource list are recomputed here,
-	// from resourceRegistry, since those describe this encoding operation
-	// itself rather than when the assessment was originally saved.
-	manifest := data.Manifest
+	// from the registry, since those describe this encoding operation
+	// itself rather than when the data was originally saved.
 	manifest.FormatVersion = FormatVersion
-	manifest.Resources = make([]string, 0, len(resourceRegistry))
+	manifest.Resources = make([]string, 0, len(registry))
 
 	env := envelope{
 		Manifest: manifest,
-		Data:     make(map[string]json.RawMessage, len(resourceRegistry)),
+		Data:     make(map[string]json.RawMessage, len(registry)),
 	}
 
-	for _, d := range resourceRegistry {
+	for _, d := range registry {
 		payload, err := d.Encode(data)
 		if err != nil {
 			return nil, fmt.Errorf("could not marshal %s resource: %w", d.Name, err)
@@ -291,21 +335,30 @@ func EncodeToJson(data *AssessmentData) ([]byte, error) {
 // supported input: a file with no manifest is a hard error, not a silent
 // fallback.
 func DecodeJson(raw []byte) (*AssessmentData, error) {
-	var env envelope
-	if err := json.Unmarshal(raw, &env); err != nil {
+	a := &AssessmentData{}
+	manifest, err := decodeEnvelope(raw, resourceRegistry, a)
+	if err != nil {
 		return nil, err
 	}
+	a.Manifest = manifest
+	return a, nil
+}
 
-	if env.Manifest.FormatVersion == "" || len(env.Manifest.Resources) == 0 {
-		return nil, fmt.Errorf("missing or empty manifest: this file is not in the vat 2.0+ envelope format")
+// decodeEnvelope deserializes raw into data, resource by resource, and
+// returns its manifest. Resources registry doesn't know are skipped; a
+// missing required one is an error.
+func decodeEnvelope[T any](raw []byte, registry []resourceDescriptor[T], data *T) (Manifest, error) {
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return Manifest{}, err
 	}
 
-	a := &AssessmentData{
-		Manifest: env.Manifest,
+	if env.Manifest.FormatVersion == "" || len(env.Manifest.Resources) == 0 {
+		return Manifest{}, fmt.Errorf("missing or empty manifest: this file is not in the vat 2.0+ envelope format")
 	}
 
-	registryByName := make(map[string]resourceDescriptor, len(resourceRegistry))
-	for _, d := range resourceRegistry {
+	registryByName := make(map[string]resourceDescriptor[T], len(registry))
+	for _, d := range registry {
 		registryByName[d.Name] = d
 	}
 
@@ -321,21 +374,21 @@ func DecodeJson(raw []byte) (*AssessmentData, error) {
 			slog.Warn("skipping unknown resource, this vat version does not understand it", "resource", name)
 			continue
 		}
-		if err := d.Decode(a, payload); err != nil {
-			return nil, fmt.Errorf("could not decode %s resource: %w", name, err)
+		if err := d.Decode(data, payload); err != nil {
+			return Manifest{}, fmt.Errorf("could not decode %s resource: %w", name, err)
 		}
 		decoded[name] = true
 	}
 
 	var err error
-	for _, d := range resourceRegistry {
+	for _, d := range registry {
 		if d.Required == ResourceRequired && !decoded[d.Name] {
 			err = errors.Join(err, fmt.Errorf("resource %q: %w", d.Name, ErrMissingRequiredResource))
 		}
 	}
 	if err != nil {
-		return nil, err
+		return Manifest{}, err
 	}
 
-	return a, nil
+	return env.Manifest, nil
 }
@@ -173,6 +173,30 @@ func genToolsMap(t *rapid.T, label string) map[string]vat.DefenseToolRef {
 	return m
 }
 
+func genAssets(t *rapid.T) *vat.AssetsResource {
+	if !rapid.Bool().Draw(t, "assets.present") {
+		return nil
+	}
+	genAssetMap := func(label string) map[string]vat.Asset {
+		n := rapid.IntRange(0, 3).Draw(t, label+".n")
+		m := make(map[string]vat.Asset, n)
+		for i := 0; i < n; i++ {
+			name := rapid.String().Draw(t, label+".key")
+			m[name] = vat.Asset{
+				Name:           name,
+				Description:    rapid.String().Draw(t, label+".Description"),
+				PropertyType:   rapid.String().Draw(t, label+".PropertyType"),
+				PropertyTypeId: rapid.String().Draw(t, label+".PropertyTypeId"),
+			}
+		}
+		return m
+	}
+	return &vat.AssetsResource{
+		Targets: genAssetMap("assets.targets"),
+		Sources: genAssetMap("assets.sources"),
+	}
+}
+
 func genOrgMap(t *rapid.T) map[string]dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentOrganizationsOrganization {
 	n := rapid.IntRange(0, 3).Draw(t, "orgMap.n")
 	m := make(map[string]dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentOrganizationsOrganization, n)
@@ -195,6 +219,7 @@ func genAssessmentData(t *rapid.T) *vat.AssessmentData {
 		IdToolsMap:       genToolsMap(t, "idToolsMap"),
 		OrgMap:           genOrgMap(t),
 		LibraryTestCases: genLibraryTestCasesResource(t),
+		Assets:           genAssets(t),
 		Manifest: vat.Manifest{
 			VatVersion:   rapid.String().Draw(t, "vatVersion"),
 			VectrVersion: rapid.String().Draw(t, "vectrVersion"),
@@ -343,6 +368,7 @@ func TestResourceRequirements(t *testing.T) {
 		vat.ResourceOrgMap:           true,
 		vat.ResourceToolsMap:         true,
 		vat.ResourceIdToolsMap:       true,
+		vat.ResourceAssets:           false,
 	}
 
 	names := vat.ResourceNames()
@@ -432,10 +458,8 @@ func TestDecodeMissingRequiredResourceFails(t *testing.T) {
 //
 // Which resource (if any) is optional is discovered from vat.ResourceNames()
 // rather than hardcoded, since that's a property of the current registry,
-// not of this test. Today every registered resource is required, so this
-// test has nothing to exercise and skips itself; it starts running again
-// the moment a resource is registered as ResourceOptional, with no edit
-// needed here.
+// not of this test. If no resource is registered as ResourceOptional, it
+// has nothing to exercise and skips itself.
 func TestDecodeMissingOptionalResourceSucceeds(t *testing.T) {
 	var optional string
 	for _, name := range vat.ResourceNames() {
@@ -518,6 +542,7 @@ func TestAssessmentDataFieldsAreAccountedFor(t *testing.T) {
 		"OrgMap":             true, // backs vat.ResourceOrgMap
 		"ToolsMap":           true, // backs vat.ResourceToolsMap
 		"IdToolsMap":         true, // backs vat.ResourceIdToolsMap
+		"Assets":             true, // backs vat.ResourceAssets
 	}
 	// Fields that are part of the wire file but travel via the envelope's
 	// manifest, not through resourceRegistry's per-resource dispatch.
@@ -557,3 +582,90 @@ func TestAssessmentDataFieldsAreAccountedFor(t *testing.T) {
 		}
 	}
 }
+
+// TestEnvironmentRoundTrip verifies an environment archive decodes back to
+// what was encoded, and that environment and assessment archives can't be
+// mistaken for one another.
+func TestEnvironmentRoundTrip(t *testing.T) {
+	original := &vat.EnvironmentData{
+		Manifest: vat.Manifest{VatVersion: "1.2.3", VectrVersion: "9.6.0", Created: "2026-01-02T03:04:05Z"},
+		DefenseTools: vat.ToolsMapResource{"Falcon Sensor|crowdstrike-falcon|true": {
+			Name:    "Falcon Sensor",
+			Active:  true,
+			Layers:  []string{"Endpoint"},
+			Product: vat.DefenseToolProductRef{Ref: "crowdstrike-falcon", Name: "Falcon", VendorName: "CrowdStrike"},
+		}},
+		Products:      []vat.DefenseToolProductRef{{Ref: "crowdstrike-falcon", Name: "Falcon", VendorName: "CrowdStrike", Layers: []vat.DefenseLayer{{Name: "Endpoint"}}}},
+		Vendors:       []string{"CrowdStrike"},
+		DefenseLayers: []vat.DefenseLayer{{Name: "Endpoint", Description: "Host sensors"}},
+		Tags:          []vat.EnvTag{{Name: "red-team", TagType: "TEST_CASE", Color: "#F44336"}},
+		Outcomes:      []vat.EnvOutcome{{Path: "Blocked", Name: "Blocked", Abbreviation: "B", CoverageScore: 1}},
+		Organizations: []vat.EnvOrganization{{Name: "SRA", Url: "https://sra.io"}},
+	}
+
+	encoded, err := vat.EncodeEnvironmentToJson(original)
+	if err != nil {
+		t.Fatalf("EncodeEnvironmentToJson failed: %s", err)
+	}
+	decoded, err := vat.DecodeEnvironmentJson(encoded)
+	if err != nil {
+		t.Fatalf("DecodeEnvironmentJson failed: %s", err)
+	}
+	if decoded.Manifest.VatVersion != original.Manifest.VatVersion || decoded.Manifest.VectrVersion != original.Manifest.VectrVersion {
+		t.Errorf("manifest did not round-trip: want %+v, got %+v", original.Manifest, decoded.Manifest)
+	}
+	decoded.Manifest = original.Manifest
+	if !reflect.DeepEqual(original, decoded) {
+		t.Errorf("environment did not round-trip:\nwant: %+v\ngot:  %+v", original, decoded)
+	}
+
+	if _, err := vat.DecodeJson(encoded); !errors.Is(err, vat.ErrMissingRequiredResource) {
+		t.Errorf("DecodeJson of an environment archive: err = %v, want ErrMissingRequiredResource", err)
+	}
+	assessment, err := vat.EncodeToJson(&vat.AssessmentData{Manifest: original.Manifest})
+	if err != nil {
+		t.Fatalf("EncodeToJson failed: %s", err)
+	}
+	if _, err := vat.DecodeEnvironmentJson(assessment); !errors.Is(err, vat.ErrMissingRequiredResource) {
+		t.Errorf("DecodeEnvironmentJson of an assessment archive: err = %v, want ErrMissingRequiredResource", err)
+	}
+}
+
+// TestLibraryRoundTrip verifies a library archive decodes back to what was
+// encoded, and that an assessment archive, which shares its
+// "librarytestcases" resource, doesn't decode as one.
+func TestLibraryRoundTrip(t *testing.T) {
+	original := &vat.LibraryData{
+		Manifest: vat.Manifest{VatVersion: "1.2.3", VectrVersion: "9.6.0"},
+		Assessments: []vat.LibraryAssessment{{
+			Name:          "ACME - Ransomware",
+			KillChainId:   "kc-1",
+			Organizations: []string{"SRA"},
+			Metadata:      []dao.MetadataKeyValuePairInput{{Key: "prefix", Value: "ACME"}},
+			Campaigns:     []vat.LibraryCampaign{{Name: "ACME - Initial Access", TestCases: []string{"lib-1"}}},
+		}},
+		TestCases: vat.LibraryTestCasesResource{"lib-1": {LibraryTestCaseId: "lib-1", Name: "Phishing", AutomationCmd: "whoami"}},
+		OrgMap:    vat.OrgMapResource{"SRA": {Name: "SRA"}},
+	}
+
+	encoded, err := vat.EncodeLibraryToJson(original)
+	if err != nil {
+		t.Fatalf("EncodeLibraryToJson failed: %s", err)
+	}
+	decoded, err := vat.DecodeLibraryJson(encoded)
+	if err != nil {
+		t.Fatalf("DecodeLibraryJson failed: %s", err)
+	}
+	decoded.Manifest = original.Manifest
+	if !reflect.DeepEqual(original, decoded) {
+		t.Errorf("library did not round-trip:\nwant: %+v\ngot:  %+v", original, decoded)
+	}
+
+	assessment, err := vat.EncodeToJson(&vat.AssessmentData{Manifest: original.Manifest})
+	if err != nil {
+		t.Fatalf("EncodeToJson failed: %s", err)
+	}
+	if _, err := vat.DecodeLibraryJson(assessment); !errors.Is(err, vat.ErrMissingRequiredResource) {
+		t.Errorf("DecodeLibraryJson of an assessment archive: err = %v, want ErrMissingRequiredResource", err)
+	}
+}
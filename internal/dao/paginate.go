@@ -0,0 +1,223 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Khan/genqlient/graphql"
+)
+
+// DefaultPageSize is how many nodes a list query asks for per request unless
+// SetPageSize says otherwise.
+const DefaultPageSize = 200
+
+// pageSize is the page size the List* functions request. It is set once at
+// startup, before any query runs.
+var pageSize = DefaultPageSize
+
+// SetPageSize sets how many nodes the List* functions ask for per request.
+// A size below 1 restores DefaultPageSize.
+func SetPageSize(n int) {
+	if n < 1 {
+		n = DefaultPageSize
+	}
+	pageSize = n
+}
+
+// PageInfo is the pageInfo { endCursor hasNextPage } selection every
+// paginated operation makes; genqlient generates a type per operation, all of
+// which satisfy it.
+type PageInfo interface {
+	GetEndCursor() string
+	GetHasNextPage() bool
+}
+
+// ErrPaginationStalled is returned when a connection claims another page but
+// gives no new cursor to fetch it with, which would otherwise loop forever.
+var ErrPaginationStalled = fmt.Errorf("pagination stalled")
+
+// Paginate collects every node of a connection by calling fetch once per
+// page, starting with a blank cursor and following endCursor for as long as
+// hasNextPage is set. fetch's error is returned as is, so callers can still
+// pick a gqlerror.List out of it.
+func Paginate[N any](ctx context.Context, fetch func(first int, after string) ([]N, PageInfo, error)) ([]N, error) {
+	var nodes []N
+	after := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		page, info, err := fetch(pageSize, after)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, page...)
+		if info == nil || !info.GetHasNextPage() {
+			return nodes, nil
+		}
+		if info.GetEndCursor() == "" || info.GetEndCursor() == after {
+			return nil, fmt.Errorf("next page after cursor %q, %d nodes in: %w", after, len(nodes), ErrPaginationStalled)
+		}
+		after = info.GetEndCursor()
+	}
+}
+
+// ListAssessmentIdsForDb returns the id, name, globalId and updateTime of
+// every assessment in db.
+func ListAssessmentIdsForDb(ctx context.Context, client graphql.Client, db string) ([]GetAssessmentIdsForDbAssessmentsAssessmentConnectionNodesAssessment, error) {
+	return Paginate(ctx, func(first int, after string) ([]GetAssessmentIdsForDbAssessmentsAssessmentConnectionNodesAssessment, PageInfo, error) {
+		r, err := GetAssessmentIdsForDb(ctx, client, db, first, after)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r.Assessments.Nodes, &r.Assessments.PageInfo, nil
+	})
+}
+
+// ListAssessmentUpdateTimesForDb returns the id and updateTime of every
+// assessment in db, with the updateTimes of its campaigns and test cases.
+func ListAssessmentUpdateTimesForDb(ctx context.Context, client graphql.Client, db string) ([]GetAssessmentUpdateTimesForDbAssessmentsAssessmentConnectionNodesAssessment, error) {
+	return Paginate(ctx, func(first int, after string) ([]GetAssessmentUpdateTimesForDbAssessmentsAssessmentConnectionNodesAssessment, PageInfo, error) {
+		r, err := GetAssessmentUpdateTimesForDb(ctx, client, db, first, after)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r.Assessments.Nodes, &r.Assessments.PageInfo, nil
+	})
+}
+
+// ListDefenseTools returns every defense tool in db.
+func ListDefenseTools(ctx context.Context, client graphql.Client, db string) ([]GetAllDefenseToolsBluetoolsBlueToolConnectionNodesBlueTool, error) {
+	return Paginate(ctx, func(first int, after string) ([]GetAllDefenseToolsBluetoolsBlueToolConnectionNodesBlueTool, PageInfo, error) {
+		r, err := GetAllDefenseTools(ctx, client, db, first, after)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r.Bluetools.Nodes, &r.Bluetools.PageInfo, nil
+	})
+}
+
+// ListDefenseToolProducts returns every defense tool product.
+func ListDefenseToolProducts(ctx context.Context, client graphql.Client) ([]GetAllDefenseToolProductsDefenseToolProductsDefenseToolProductsConnectionNodesDefenseToolProduct, error) {
+	return Paginate(ctx, func(first int, after string) ([]GetAllDefenseToolProductsDefenseToolProductsDefenseToolProductsConnectionNodesDefenseToolProduct, PageInfo, error) {
+		r, err := GetAllDefenseToolProducts(ctx, client, first, after)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r.DefenseToolProducts.Nodes, &r.DefenseToolProducts.PageInfo, nil
+	})
+}
+
+// ListDefensiveLayers returns every defensive layer in db.
+func ListDefensiveLayers(ctx context.Context, client graphql.Client, db string) ([]GetAllDefensiveLayersDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer, error) {
+	return Paginate(ctx, func(first int, after string) ([]GetAllDefensiveLayersDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer, PageInfo, error) {
+		r, err := GetAllDefensiveLayers(ctx, client, db, first, after)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r.Defensivelayers.Nodes, &r.Defensivelayers.PageInfo, nil
+	})
+}
+
+// ListLibraryDefensiveLayers returns every library defensive layer.
+func ListLibraryDefensiveLayers(ctx context.Context, client graphql.Client) ([]GetAllLibraryDefensiveLayersLibraryDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer, error) {
+	return Paginate(ctx, func(first int, after string) ([]GetAllLibraryDefensiveLayersLibraryDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer, PageInfo, error) {
+		r, err := GetAllLibraryDefensiveLayers(ctx, client, first, after)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r.LibraryDefensivelayers.Nodes, &r.LibraryDefensivelayers.PageInfo, nil
+	})
+}
+
+// ListTags returns every tag, by name.
+func ListTags(ctx context.Context, client graphql.Client) ([]GetAllTagsTagsTagConnectionNodesTag, error) {
+	return Paginate(ctx, func(first int, after string) ([]GetAllTagsTagsTagConnectionNodesTag, PageInfo, error) {
+		r, err := GetAllTags(ctx, client, first, after)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r.Tags.Nodes, &r.Tags.PageInfo, nil
+	})
+}
+
+// ListOrganizations returns every organization, by name.
+func ListOrganizations(ctx context.Context, client graphql.Client) ([]GetAllOrganizationsOrganizationsOrganizationConnectionNodesOrganization, error) {
+	return Paginate(ctx, func(first int, after string) ([]GetAllOrganizationsOrganizationsOrganizationConnectionNodesOrganization, PageInfo, error) {
+		r, err := GetAllOrganizations(ctx, client, first, after)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r.Organizations.Nodes, &r.Organizations.PageInfo, nil
+	})
+}
+
+// ListLibraryVendors returns every library vendor, by name.
+func ListLibraryVendors(ctx context.Context, client graphql.Client) ([]GetAllLibraryVendorsLibraryVendorsVendorConnectionNodesVendor, error) {
+	return Paginate(ctx, func(first int, after string) ([]GetAllLibraryVendorsLibraryVendorsVendorConnectionNodesVendor, PageInfo, error) {
+		r, err := GetAllLibraryVendors(ctx, client, first, after)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r.LibraryVendors.Nodes, &r.LibraryVendors.PageInfo, nil
+	})
+}
+
+// ListAssetPropertyTypes returns every asset property type, by name.
+func ListAssetPropertyTypes(ctx context.Context, client graphql.Client) ([]GetAllAssetPropertyTypesAssetPropertyTypesAssetPropertyTypeConnectionNodesAssetPropertyType, error) {
+	return Paginate(ctx, func(first int, after string) ([]GetAllAssetPropertyTypesAssetPropertyTypesAssetPropertyTypeConnectionNodesAssetPropertyType, PageInfo, error) {
+		r, err := GetAllAssetPropertyTypes(ctx, client, first, after)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r.AssetPropertyTypes.Nodes, &r.AssetPropertyTypes.PageInfo, nil
+	})
+}
+
+// ListTestCasesForDb returns every test case in db with its targets and
+// sources, by name.
+func ListTestCasesForDb(ctx context.Context, client graphql.Client, db string) ([]GetTestCaseforDbTestcasesTestCaseConnectionNodesTestCase, error) {
+	return Paginate(ctx, func(first int, after string) ([]GetTestCaseforDbTestcasesTestCaseConnectionNodesTestCase, PageInfo, error) {
+		r, err := GetTestCaseforDb(ctx, client, db, first, after)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r.Testcases.Nodes, &r.Testcases.PageInfo, nil
+	})
+}
+
+// ListLibraryAssessments returns every library assessment, by name, with its
+// campaigns and their library test case ids.
+func ListLibraryAssessments(ctx context.Context, client graphql.Client) ([]GetAllLibraryAssessmentsLibraryAssessmentsAssessmentConnectionNodesAssessment, error) {
+	return Paginate(ctx, func(first int, after string) ([]GetAllLibraryAssessmentsLibraryAssessmentsAssessmentConnectionNodesAssessment, PageInfo, error) {
+		r, err := GetAllLibraryAssessments(ctx, client, first, after)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r.LibraryAssessments.Nodes, &r.LibraryAssessments.PageInfo, nil
+	})
+}
+
+// ListLibraryCampaigns returns every library campaign, by name, with its
+// library test case ids.
+func ListLibraryCampaigns(ctx context.Context, client graphql.Client) ([]GetAllLibraryCampaignsLibraryCampaignsCampaignConnectionNodesCampaign, error) {
+	return Paginate(ctx, func(first int, after string) ([]GetAllLibraryCampaignsLibraryCampaignsCampaignConnectionNodesCampaign, PageInfo, error) {
+		r, err := GetAllLibraryCampaigns(ctx, client, first, after)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r.LibraryCampaigns.Nodes, &r.LibraryCampaigns.PageInfo, nil
+	})
+}
+
+// ListLibraryTestCasesByName returns every library test case named name,
+// with its metadata.
+func ListLibraryTestCasesByName(ctx context.Context, client graphql.Client, name string) ([]FindLibraryTestCasesByNameLibraryTestcasesTestCaseConnectionNodesTestCase, error) {
+	return Paginate(ctx, func(first int, after string) ([]FindLibraryTestCasesByNameLibraryTestcasesTestCaseConnectionNodesTestCase, PageInfo, error) {
+		r, err := FindLibraryTestCasesByName(ctx, client, name, first, after)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r.LibraryTestcases.Nodes, &r.LibraryTestcases.PageInfo, nil
+	})
+}
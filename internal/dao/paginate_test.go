@@ -0,0 +1,88 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/Khan/genqlient/graphql"
+)
+
+// pagedToolsClient serves GetAllDefenseTools from tools, first nodes at a
+// time, with the index of the next node as the cursor, and records the
+// variables of every request.
+type pagedToolsClient struct {
+	tools     []string
+	stall     bool // claim another page but repeat the cursor
+	variables []map[string]any
+}
+
+func (c *pagedToolsClient) MakeRequest(_ context.Context, req *graphql.Request, resp *graphql.Response) error {
+	raw, err := json.Marshal(req.Variables)
+	if err != nil {
+		return err
+	}
+	var vars map[string]any
+	if err := json.Unmarshal(raw, &vars); err != nil {
+		return err
+	}
+	c.variables = append(c.variables, vars)
+
+	start := 0
+	if after, ok := vars["after"].(string); ok {
+		if start, err = strconv.Atoi(after); err != nil {
+			return fmt.Errorf("bad cursor %q", after)
+		}
+	}
+	end := min(start+int(vars["first"].(float64)), len(c.tools))
+	r := resp.Data.(*GetAllDefenseToolsResponse)
+	for _, name := range c.tools[start:end] {
+		r.Bluetools.Nodes = append(r.Bluetools.Nodes, GetAllDefenseToolsBluetoolsBlueToolConnectionNodesBlueTool{Name: name})
+	}
+	r.Bluetools.PageInfo.HasNextPage = end < len(c.tools)
+	r.Bluetools.PageInfo.EndCursor = strconv.Itoa(end)
+	if c.stall {
+		r.Bluetools.PageInfo.EndCursor = strconv.Itoa(start)
+	}
+	return nil
+}
+
+func TestListDefenseTools_Paginates(t *testing.T) {
+	t.Cleanup(func() { SetPageSize(DefaultPageSize) })
+	SetPageSize(2)
+	client := &pagedToolsClient{tools: []string{"a", "b", "c", "d", "e"}}
+
+	tools, err := ListDefenseTools(context.Background(), client, "test-db")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, tool := range tools {
+		names = append(names, tool.Name)
+	}
+	if fmt.Sprint(names) != "[a b c d e]" {
+		t.Errorf("listed %v, want every tool in order", names)
+	}
+	if len(client.variables) != 3 {
+		t.Fatalf("made %d requests, want 3 pages of 2", len(client.variables))
+	}
+	if _, ok := client.variables[0]["after"]; ok {
+		t.Errorf("first request sent after = %v, want it left out", client.variables[0]["after"])
+	}
+	if client.variables[1]["after"] != "2" || client.variables[2]["after"] != "4" {
+		t.Errorf("requests followed cursors %v, %v, want 2, 4", client.variables[1]["after"], client.variables[2]["after"])
+	}
+}
+
+func TestListDefenseTools_StalledCursor(t *testing.T) {
+	t.Cleanup(func() { SetPageSize(DefaultPageSize) })
+	SetPageSize(2)
+	client := &pagedToolsClient{tools: []string{"a", "b", "c"}, stall: true}
+
+	if _, err := ListDefenseTools(context.Background(), client, "test-db"); !errors.Is(err, ErrPaginationStalled) {
+		t.Errorf("err = %v, want ErrPaginationStalled", err)
+	}
+}
@@ -136,10 +136,12 @@ func (v *VectrRestApiCaller) doer(req *http.Request) (*http.Response, error) {
 	}
 
 	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
 		return nil, ErrInvalidAuth
 	}
 
 	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
 		return nil, fmt.Errorf("unexpected response: %d", resp.StatusCode)
 	}
 	return resp, nil
@@ -207,6 +209,7 @@ func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 // SetupVectrClient initializes a GraphQL client and a VectrVersionHandler for interacting with the VECTR API.
 //
 // This function configures the HTTP client with authentication and optional insecure connection settings.
+// Requests are retried, timed out and rate limited according to the options last passed to SetClientOptions.
 // It sets up the URL for API requests and version checks.
 //
 // Parameters:
@@ -251,10 +254,10 @@ func SetupVectrClient(hostname, key string, tlsParams *CustomTlsParams) (graphql
 	}
 
 	httpClient := http.Client{
-		Transport: &authTransport{
+		Transport: newRetryTransport(&authTransport{
 			key:     key,
 			wrapped: transport,
-		},
+		}, clientOptions),
 	}
 	u := url.URL{
 		Host:   hostname,
@@ -0,0 +1,111 @@
+package util
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefenseToolMapping pins source defense tools to existing tools in the
+// target instance, bypassing restore's automatic matching.
+type DefenseToolMapping struct {
+	byName map[string]string
+	byKey  map[defenseToolMappingKey]string
+}
+
+type defenseToolMappingKey struct {
+	name, productRef string
+	active           bool
+}
+
+// NewDefenseToolMapping parses CSV input to create a DefenseToolMapping object.
+//
+// A record is either:
+//   - "source tool name","target", matching every source tool with that name, or
+//   - "source tool name","source product ref","active","target", matching
+//     exactly one source tool (the same name + product + active identity
+//     vat uses to tell tools apart).
+//
+// The target is either the id or the name of a defense tool that already
+// exists in the target instance.
+//
+// Parameters:
+//   - r: An io.Reader providing CSV input data.
+//
+// Returns:
+//   - A pointer to a `DefenseToolMapping` struct.
+//   - An error if reading the CSV input fails or a record is invalid.
+//
+// Errors:
+//   - Returns an error if the CSV input cannot be read.
+//   - Returns an error if a record has the wrong number of fields, a blank
+//     name or target, or an active column that isn't a boolean.
+//   - Returns an error if a source tool is mapped to two different targets.
+func NewDefenseToolMapping(r io.Reader) (*DefenseToolMapping, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = false
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("could not create the defense tool mapping: %w", err)
+	}
+
+	m := &DefenseToolMapping{
+		byName: make(map[string]string),
+		byKey:  make(map[defenseToolMappingKey]string),
+	}
+	for i, record := range records {
+		for j := range record {
+			record[j] = strings.TrimSpace(record[j])
+		}
+		switch len(record) {
+		case 2:
+			name, target := record[0], record[1]
+			if name == "" || target == "" {
+				return nil, fmt.Errorf("could not create the defense tool mapping: line %d has a blank tool name or target", i+1)
+			}
+			if existing, ok := m.byName[name]; ok && existing != target {
+				return nil, fmt.Errorf("could not create the defense tool mapping: %q is mapped to both %q and %q", name, existing, target)
+			}
+			m.byName[name] = target
+		case 4:
+			name, productRef, target := record[0], record[1], record[3]
+			if name == "" || target == "" {
+				return nil, fmt.Errorf("could not create the defense tool mapping: line %d has a blank tool name or target", i+1)
+			}
+			active, err := strconv.ParseBool(record[2])
+			if err != nil {
+				return nil, fmt.Errorf("could not create the defense tool mapping: line %d has an invalid active value %q: %w", i+1, record[2], err)
+			}
+			key := defenseToolMappingKey{name: name, productRef: productRef, active: active}
+			if existing, ok := m.byKey[key]; ok && existing != target {
+				return nil, fmt.Errorf("could not create the defense tool mapping: %q (product ref %q, active %t) is mapped to both %q and %q", name, productRef, active, existing, target)
+			}
+			m.byKey[key] = target
+		default:
+			return nil, fmt.Errorf("could not create the defense tool mapping: line %d has %d fields, want 2 (name, target) or 4 (name, product ref, active, target)", i+1, len(record))
+		}
+	}
+
+	return m, nil
+}
+
+// Target returns the target tool id or name a source tool is mapped to, and
+// whether it's mapped at all. An entry for the tool's full identity wins
+// over one for its name alone.
+func (m *DefenseToolMapping) Target(name, productRef string, active bool) (string, bool) {
+	if target, ok := m.byKey[defenseToolMappingKey{name: name, productRef: productRef, active: active}]; ok {
+		return target, true
+	}
+	target, ok := m.byName[name]
+	return target, ok
+}
+
+// Len returns the number of entries in the mapping.
+func (m *DefenseToolMapping) Len() int {
+	return len(m.byName) + len(m.byKey)
+}
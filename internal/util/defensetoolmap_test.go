@@ -0,0 +1,45 @@
+package util_test
+
+import (
+	"sra/vat/internal/util"
+	"strings"
+	"testing"
+)
+
+func TestNewDefenseToolMapping(t *testing.T) {
+	m, err := util.NewDefenseToolMapping(strings.NewReader(`"Falcon Sensor","target-tool-1"
+"Falcon Sensor","crowdstrike-falcon","false","Falcon (retired)"
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, ok := m.Target("Falcon Sensor", "crowdstrike-falcon", true); !ok || got != "target-tool-1" {
+		t.Errorf("name entry: got %q, %v; want target-tool-1, true", got, ok)
+	}
+	if got, ok := m.Target("Falcon Sensor", "crowdstrike-falcon", false); !ok || got != "Falcon (retired)" {
+		t.Errorf("full identity entry should win over the name entry: got %q, %v", got, ok)
+	}
+	if _, ok := m.Target("Defender", "msft-defender", true); ok {
+		t.Error("expected no entry for an unmapped tool")
+	}
+	if got := m.Len(); got != 2 {
+		t.Errorf("Len() = %d, want 2", got)
+	}
+}
+
+func TestNewDefenseToolMappingRejectsInvalidInput(t *testing.T) {
+	cases := map[string]string{
+		"conflicting targets": `"A","B"` + "\n" + `"A","C"` + "\n",
+		"blank target":        `"A",""` + "\n",
+		"bad active value":    `"A","ref","maybe","B"` + "\n",
+		"wrong field count":   `"A","ref","B"` + "\n",
+	}
+	for name, input := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := util.NewDefenseToolMapping(strings.NewReader(input)); err == nil {
+				t.Errorf("expected an error for %s, got nil", name)
+			}
+		})
+	}
+}
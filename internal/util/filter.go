@@ -6,8 +6,13 @@ import (
 	"io"
 )
 
+// Filter selects the databases and assessments to dump. It is either a CSV
+// filter of database-assessment pairs (NewFilter) or a rule filter
+// (NewRuleFilter, NewExpressionFilter), which can also select on tags,
+// metadata and dates (see Check).
 type Filter struct {
 	databaseAssessmentPairs map[string]map[string]bool
+	rules                   *assessmentRules
 }
 
 // NewFilter parses CSV input to create a Filter object.
@@ -65,6 +70,7 @@ func NewFilter(r io.Reader) (*Filter, error) {
 //
 // This method checks if the specified database is present in the filter's map of database-assessment pairs.
 // It also considers a wildcard entry ("*") that indicates all databases should be included.
+// A rule filter includes a database that some include rule could match and no db-only exclude rule does.
 //
 // Parameters:
 //   - db: The name of the database to check.
@@ -76,6 +82,9 @@ func NewFilter(r io.Reader) (*Filter, error) {
 // Logic for false cases:
 //   - Returns false if the database is not explicitly listed in the filter and there is no wildcard entry ("*") indicating all databases should be included.
 func (f *Filter) CheckDb(db string) bool {
+	if f.rules != nil {
+		return f.rules.checkDb(db)
+	}
 	// Check for wildcard or specific database
 	return f.databaseAssessmentPairs["*"] != nil || f.databaseAssessmentPairs[db] != nil
 }
@@ -84,6 +93,7 @@ func (f *Filter) CheckDb(db string) bool {
 //
 // This method checks if the specified assessment is present in the filter's map for the given database.
 // It considers wildcard entries ("*") for both databases and assessments, allowing for flexible inclusion criteria.
+// A rule filter only sees the database and name here; use Check to select on every listed field.
 //
 // Parameters:
 //   - db: The name of the database to check.
@@ -96,6 +106,9 @@ func (f *Filter) CheckDb(db string) bool {
 // Logic for false cases:
 //   - Returns false if the assessment is not explicitly listed for the given database and there is no wildcard entry ("*") for either the database or the assessment.
 func (f *Filter) CheckAssessment(db, assessment string) bool {
+	if f.rules != nil {
+		return f.rules.check(AssessmentInfo{Db: db, Name: assessment})
+	}
 	// Check for wildcard for both (why but whatever)
 	if f.databaseAssessmentPairs["*"] != nil && f.databaseAssessmentPairs["*"]["*"] {
 		return true
@@ -0,0 +1,392 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The fields an assessment filter rule can select on. Metadata is written
+// metadata.<key>, e.g. metadata.bundle.
+const (
+	AssessmentFilterDb         = "db"
+	AssessmentFilterAssessment = "assessment"
+	AssessmentFilterGlobalId   = "globalid"
+	AssessmentFilterTag        = "tag"
+	AssessmentFilterMetadata   = "metadata"
+	AssessmentFilterCreated    = "created"
+	AssessmentFilterUpdated    = "updated"
+)
+
+var assessmentFilterFields = []string{
+	AssessmentFilterDb,
+	AssessmentFilterAssessment,
+	AssessmentFilterGlobalId,
+	AssessmentFilterTag,
+	AssessmentFilterMetadata + ".<key>",
+	AssessmentFilterCreated,
+	AssessmentFilterUpdated,
+}
+
+// AssessmentFilterFields returns the fields an assessment filter rule can
+// select on, in the form they're written in a rule or --filter term, so
+// callers can list them without keeping a copy of their own.
+func AssessmentFilterFields() []string {
+	return slices.Clone(assessmentFilterFields)
+}
+
+// AssessmentInfo is what a Filter looks at in an assessment: the fields of
+// the assessment listing, available before the assessment is fetched in
+// full. A zero time is unknown and fails any date condition on it.
+type AssessmentInfo struct {
+	Db         string
+	Name       string
+	GlobalId   string
+	Tags       []string
+	Metadata   map[string][]string // key -> values, a key may repeat
+	CreateTime time.Time
+	UpdateTime time.Time
+}
+
+// timeRange holds an assessment time to [since, before); a zero bound is
+// open.
+type timeRange struct {
+	since, before time.Time
+}
+
+func (r timeRange) match(t time.Time) bool {
+	if !r.since.IsZero() && (t.IsZero() || t.Before(r.since)) {
+		return false
+	}
+	if !r.before.IsZero() && (t.IsZero() || !t.Before(r.before)) {
+		return false
+	}
+	return true
+}
+
+// assessmentRule selects the assessments that hold every one of its
+// conditions. Like a TestCaseFilter, patterns on the same field are
+// alternatives, and tag and metadata patterns match if any of the
+// assessment's values does. An empty rule selects every assessment.
+type assessmentRule struct {
+	db, assessment, globalId, tag []NamePattern
+	metadata                      map[string][]NamePattern
+	created, updated              timeRange
+}
+
+func matchAny(patterns []NamePattern, values ...string) bool {
+	return slices.ContainsFunc(patterns, func(p NamePattern) bool {
+		return slices.ContainsFunc(values, p.Match)
+	})
+}
+
+func (r *assessmentRule) match(a AssessmentInfo) bool {
+	if len(r.db) > 0 && !matchAny(r.db, a.Db) {
+		return false
+	}
+	if len(r.assessment) > 0 && !matchAny(r.assessment, a.Name) {
+		return false
+	}
+	if len(r.globalId) > 0 && !matchAny(r.globalId, a.GlobalId) {
+		return false
+	}
+	if len(r.tag) > 0 && !matchAny(r.tag, a.Tags...) {
+		return false
+	}
+	for key, patterns := range r.metadata {
+		if !matchAny(patterns, a.Metadata[key]...) {
+			return false
+		}
+	}
+	return r.created.match(a.CreateTime) && r.updated.match(a.UpdateTime)
+}
+
+// dbOnly reports whether the rule has no condition but its db patterns, so a
+// database it matches can be judged without listing its assessments.
+func (r *assessmentRule) dbOnly() bool {
+	return len(r.db) > 0 && len(r.assessment) == 0 && len(r.globalId) == 0 && len(r.tag) == 0 && len(r.metadata) == 0 &&
+		r.created == (timeRange{}) && r.updated == (timeRange{})
+}
+
+// assessmentRules is a Filter built by NewRuleFilter or NewExpressionFilter:
+// an assessment is selected if it matches any include rule (or there are
+// none) and no exclude rule.
+type assessmentRules struct {
+	include, exclude []assessmentRule
+}
+
+func (rs *assessmentRules) checkDb(db string) bool {
+	if slices.ContainsFunc(rs.exclude, func(r assessmentRule) bool { return r.dbOnly() && matchAny(r.db, db) }) {
+		return false
+	}
+	return len(rs.include) == 0 || slices.ContainsFunc(rs.include, func(r assessmentRule) bool {
+		return len(r.db) == 0 || matchAny(r.db, db)
+	})
+}
+
+func (rs *assessmentRules) check(a AssessmentInfo) bool {
+	if len(rs.include) > 0 && !slices.ContainsFunc(rs.include, func(r assessmentRule) bool { return r.match(a) }) {
+		return false
+	}
+	return !slices.ContainsFunc(rs.exclude, func(r assessmentRule) bool { return r.match(a) })
+}
+
+// Check determines if an assessment should be included in the dump process,
+// on every field a rule filter can select on. A CSV filter only looks at the
+// database and assessment name, as CheckAssessment does.
+func (f *Filter) Check(a AssessmentInfo) bool {
+	if f.rules == nil {
+		return f.CheckAssessment(a.Db, a.Name)
+	}
+	return f.rules.check(a)
+}
+
+// ErrEmptyFilter is returned for a rule filter file with no rules in it,
+// which would otherwise silently select everything.
+var ErrEmptyFilter = errors.New("filter has no include or exclude rules")
+
+// filterFileSpec is the YAML (or JSON) layout NewRuleFilter reads.
+type filterFileSpec struct {
+	Include []ruleSpec `yaml:"include"`
+	Exclude []ruleSpec `yaml:"exclude"`
+}
+
+type ruleSpec struct {
+	Db         stringList            `yaml:"db"`
+	Assessment stringList            `yaml:"assessment"`
+	GlobalId   stringList            `yaml:"globalid"`
+	Tag        stringList            `yaml:"tag"`
+	Metadata   map[string]stringList `yaml:"metadata"`
+	Created    rangeSpec             `yaml:"created"`
+	Updated    rangeSpec             `yaml:"updated"`
+}
+
+type rangeSpec struct {
+	Since  string `yaml:"since"`
+	Before string `yaml:"before"`
+}
+
+// stringList accepts either a single string or a list of them.
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = stringList{node.Value}
+		return nil
+	}
+	var values []string
+	if err := node.Decode(&values); err != nil {
+		return err
+	}
+	*l = values
+	return nil
+}
+
+// NewRuleFilter parses a YAML or JSON filter file of include and exclude
+// rules into a Filter. Each rule holds any of db, assessment, globalid and
+// tag (a NamePattern or a list of them), metadata (a map of key to NamePatterns),
+// and created and updated ranges (since, inclusive, and before, exclusive,
+// as a date or RFC 3339 time). For example:
+//
+//	include:
+//	  - db: "prod-*"
+//	    tag: [red-team, purple-team]
+//	    updated: {since: 2024-01-01}
+//	exclude:
+//	  - assessment: "re:(?i)scratch"
+//
+// Errors:
+//   - Returns an error if the input is not valid YAML or has unknown keys.
+//   - Returns an error if a pattern or time is invalid.
+//   - Returns `ErrEmptyFilter` if there are no rules.
+func NewRuleFilter(r io.Reader) (*Filter, error) {
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	var spec filterFileSpec
+	if err := decoder.Decode(&spec); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not create the filter: %w", err)
+	}
+	if len(spec.Include) == 0 && len(spec.Exclude) == 0 {
+		return nil, fmt.Errorf("could not create the filter: %w", ErrEmptyFilter)
+	}
+	rules := &assessmentRules{}
+	for i, rs := range spec.Include {
+		rule, err := rs.compile()
+		if err != nil {
+			return nil, fmt.Errorf("could not create the filter: include rule %d: %w", i+1, err)
+		}
+		rules.include = append(rules.include, rule)
+	}
+	for i, rs := range spec.Exclude {
+		rule, err := rs.compile()
+		if err != nil {
+			return nil, fmt.Errorf("could not create the filter: exclude rule %d: %w", i+1, err)
+		}
+		rules.exclude = append(rules.exclude, rule)
+	}
+	return &Filter{rules: rules}, nil
+}
+
+func (rs ruleSpec) compile() (assessmentRule, error) {
+	var rule assessmentRule
+	var err error
+	if rule.db, err = NewNamePatterns(rs.Db); err != nil {
+		return rule, fmt.Errorf("%s: %w", AssessmentFilterDb, err)
+	}
+	if rule.assessment, err = NewNamePatterns(rs.Assessment); err != nil {
+		return rule, fmt.Errorf("%s: %w", AssessmentFilterAssessment, err)
+	}
+	if rule.globalId, err = NewNamePatterns(rs.GlobalId); err != nil {
+		return rule, fmt.Errorf("%s: %w", AssessmentFilterGlobalId, err)
+	}
+	if rule.tag, err = NewNamePatterns(rs.Tag); err != nil {
+		return rule, fmt.Errorf("%s: %w", AssessmentFilterTag, err)
+	}
+	for key, values := range rs.Metadata {
+		patterns, err := NewNamePatterns(values)
+		if err != nil {
+			return rule, fmt.Errorf("%s.%s: %w", AssessmentFilterMetadata, key, err)
+		}
+		if rule.metadata == nil {
+			rule.metadata = map[string][]NamePattern{}
+		}
+		rule.metadata[key] = patterns
+	}
+	for _, r := range []struct {
+		field string
+		spec  rangeSpec
+		to    *timeRange
+	}{
+		{AssessmentFilterCreated, rs.Created, &rule.created},
+		{AssessmentFilterUpdated, rs.Updated, &rule.updated},
+	} {
+		if r.spec.Since != "" {
+			if r.to.since, err = parseFilterTime(r.spec.Since); err != nil {
+				return rule, fmt.Errorf("%s since: %w", r.field, err)
+			}
+		}
+		if r.spec.Before != "" {
+			if r.to.before, err = parseFilterTime(r.spec.Before); err != nil {
+				return rule, fmt.Errorf("%s before: %w", r.field, err)
+			}
+		}
+	}
+	return rule, nil
+}
+
+// parseFilterTime reads a date (as UTC midnight) or an RFC 3339 time.
+func parseFilterTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, want YYYY-MM-DD or RFC 3339", s)
+	}
+	return t, nil
+}
+
+// NewExpressionFilter builds a Filter from the terms of one-off `--filter`
+// expressions:
+//   - "field=pattern", where field is db, assessment, globalid, tag or
+//     metadata.<key> and pattern is a NamePattern. Like a TestCaseFilter, terms on the same
+//     field are alternatives and terms on different fields must all hold.
+//   - "created>=date", "created<date", "updated>=date" and "updated<date".
+//   - Any term prefixed with "!" excludes the assessments it matches instead.
+//
+// Errors:
+//   - Returns an error if a term is malformed or names an unknown field.
+//   - Returns an error if a pattern or date is invalid.
+//   - Returns `ErrEmptyFilter` if there are no terms.
+func NewExpressionFilter(terms []string) (*Filter, error) {
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("could not create the filter: %w", ErrEmptyFilter)
+	}
+	rules := &assessmentRules{}
+	var include assessmentRule
+	included := false
+	for _, term := range terms {
+		negated := false
+		expr := strings.TrimSpace(term)
+		if rest, ok := strings.CutPrefix(expr, "!"); ok {
+			negated, expr = true, rest
+		}
+		var rule assessmentRule
+		target := &include
+		if negated {
+			target = &rule
+		}
+		if err := target.addTerm(expr); err != nil {
+			return nil, fmt.Errorf("could not create the filter: %q: %w", term, err)
+		}
+		if negated {
+			rules.exclude = append(rules.exclude, rule)
+		} else {
+			included = true
+		}
+	}
+	if included {
+		rules.include = []assessmentRule{include}
+	}
+	return &Filter{rules: rules}, nil
+}
+
+// addTerm adds one NewExpressionFilter term, without its "!", to the rule.
+func (r *assessmentRule) addTerm(expr string) error {
+	for _, op := range []string{">=", "<"} {
+		field, value, ok := strings.Cut(expr, op)
+		field = strings.ToLower(strings.TrimSpace(field))
+		if !ok || (field != AssessmentFilterCreated && field != AssessmentFilterUpdated) {
+			continue
+		}
+		t, err := parseFilterTime(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+		tr := &r.created
+		if field == AssessmentFilterUpdated {
+			tr = &r.updated
+		}
+		if op == ">=" {
+			tr.since = t
+		} else {
+			tr.before = t
+		}
+		return nil
+	}
+
+	field, value, ok := strings.Cut(expr, "=")
+	if !ok {
+		return fmt.Errorf("want field=pattern, created>=date or updated<date")
+	}
+	field = strings.TrimSpace(field)
+	p, err := NewNamePattern(strings.TrimSpace(value))
+	if err != nil {
+		return err
+	}
+	if key, ok := strings.CutPrefix(field, AssessmentFilterMetadata+"."); ok && key != "" {
+		if r.metadata == nil {
+			r.metadata = map[string][]NamePattern{}
+		}
+		r.metadata[key] = append(r.metadata[key], p)
+		return nil
+	}
+	switch strings.ToLower(field) {
+	case AssessmentFilterDb:
+		r.db = append(r.db, p)
+	case AssessmentFilterAssessment:
+		r.assessment = append(r.assessment, p)
+	case AssessmentFilterGlobalId:
+		r.globalId = append(r.globalId, p)
+	case AssessmentFilterTag:
+		r.tag = append(r.tag, p)
+	default:
+		return fmt.Errorf("unknown field %q, want one of %s", field, strings.Join(assessmentFilterFields, ", "))
+	}
+	return nil
+}
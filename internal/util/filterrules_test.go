@@ -0,0 +1,235 @@
+package util_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"sra/vat/internal/util"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+var (
+	q1RedTeam = util.AssessmentInfo{
+		Db:         "prod-east",
+		Name:       "Q1 Red Team",
+		GlobalId:   "7f3c9a1e-q1",
+		Tags:       []string{"red-team", "external"},
+		Metadata:   map[string][]string{"bundle": {"Atomic Basics"}},
+		CreateTime: date("2024-01-15"),
+		UpdateTime: date("2024-03-01"),
+	}
+	scratch = util.AssessmentInfo{
+		Db:         "prod-east",
+		Name:       "Scratch Copy",
+		GlobalId:   "b21d04aa-scratch",
+		Tags:       []string{"red-team"},
+		CreateTime: date("2024-02-01"),
+		UpdateTime: date("2024-02-02"),
+	}
+	oldPurple = util.AssessmentInfo{
+		Db:         "prod-west",
+		Name:       "2023 Purple Team",
+		Tags:       []string{"purple-team"},
+		CreateTime: date("2023-05-01"),
+		UpdateTime: date("2023-06-01"),
+	}
+	staging = util.AssessmentInfo{
+		Db:   "staging",
+		Name: "Q1 Red Team",
+		Tags: []string{"red-team"},
+	}
+)
+
+func checkSelected(t *testing.T, filter *util.Filter, want map[string]bool) {
+	t.Helper()
+	for _, a := range []util.AssessmentInfo{q1RedTeam, scratch, oldPurple, staging} {
+		key := a.Db + "/" + a.Name
+		if got := filter.Check(a); got != want[key] {
+			t.Errorf("Check(%s) = %v, want %v", key, got, want[key])
+		}
+	}
+}
+
+func TestNewExpressionFilter(t *testing.T) {
+	cases := map[string]struct {
+		terms []string
+		want  map[string]bool
+	}{
+		"db glob": {
+			[]string{"db=prod-*"},
+			map[string]bool{"prod-east/Q1 Red Team": true, "prod-east/Scratch Copy": true, "prod-west/2023 Purple Team": true},
+		},
+		"same field terms are alternatives": {
+			[]string{"tag=purple-team", "tag=external"},
+			map[string]bool{"prod-east/Q1 Red Team": true, "prod-west/2023 Purple Team": true},
+		},
+		"different fields must all hold": {
+			[]string{"db=prod-*", "tag=red-team"},
+			map[string]bool{"prod-east/Q1 Red Team": true, "prod-east/Scratch Copy": true},
+		},
+		"exclusion": {
+			[]string{"tag=red-team", "!assessment=re:(?i)scratch"},
+			map[string]bool{"prod-east/Q1 Red Team": true, "staging/Q1 Red Team": true},
+		},
+		"exclusion only": {
+			[]string{"!db=staging"},
+			map[string]bool{"prod-east/Q1 Red Team": true, "prod-east/Scratch Copy": true, "prod-west/2023 Purple Team": true},
+		},
+		"globalid": {
+			[]string{"globalid=7f3c9a1e-q1", "globalid=b21d04aa-scratch"},
+			map[string]bool{"prod-east/Q1 Red Team": true, "prod-east/Scratch Copy": true},
+		},
+		"excluded globalid": {
+			[]string{"db=prod-east", "!globalid=b21d04aa-*"},
+			map[string]bool{"prod-east/Q1 Red Team": true},
+		},
+		"metadata": {
+			[]string{"metadata.bundle=Atomic*"},
+			map[string]bool{"prod-east/Q1 Red Team": true},
+		},
+		"updated range, unknown times excluded": {
+			[]string{"updated>=2024-01-01", "updated<2024-03-01"},
+			map[string]bool{"prod-east/Scratch Copy": true},
+		},
+		"created before": {
+			[]string{"created<2024-01-01"},
+			map[string]bool{"prod-west/2023 Purple Team": true},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			filter, err := util.NewExpressionFilter(tc.terms)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			checkSelected(t, filter, tc.want)
+		})
+	}
+}
+
+func TestNewExpressionFilter_Errors(t *testing.T) {
+	for _, terms := range [][]string{
+		nil,
+		{"db"},
+		{"owner=alice"},
+		{"assessment=re:("},
+		{"updated>=last week"},
+		{"metadata.=x"},
+	} {
+		if _, err := util.NewExpressionFilter(terms); err == nil {
+			t.Errorf("NewExpressionFilter(%q): expected an error", terms)
+		}
+	}
+	if _, err := util.NewExpressionFilter(nil); !errors.Is(err, util.ErrEmptyFilter) {
+		t.Errorf("no terms: err = %v, want ErrEmptyFilter", err)
+	}
+}
+
+// TestAssessmentFilterFields verifies every field listed for --filter's
+// help is one a term can actually select on.
+func TestAssessmentFilterFields(t *testing.T) {
+	for _, field := range util.AssessmentFilterFields() {
+		term := field + "=x"
+		switch field {
+		case util.AssessmentFilterCreated, util.AssessmentFilterUpdated:
+			term = field + ">=2024-01-01"
+		default:
+			term = strings.Replace(term, "<key>", "bundle", 1)
+		}
+		if _, err := util.NewExpressionFilter([]string{term}); err != nil {
+			t.Errorf("field %s: %v", field, err)
+		}
+	}
+}
+
+func TestNewRuleFilter(t *testing.T) {
+	cases := map[string]string{
+		"yaml": `
+include:
+  - db: "prod-*"
+    tag: [red-team, purple-team]
+    created: {since: 2024-01-01}
+  - db: staging
+exclude:
+  - assessment: "re:(?i)scratch"
+`,
+		"json": `{
+  "include": [
+    {"db": "prod-*", "tag": ["red-team", "purple-team"], "created": {"since": "2024-01-01T00:00:00Z"}},
+    {"db": ["staging"]}
+  ],
+  "exclude": [{"assessment": "re:(?i)scratch"}]
+}`,
+	}
+	for name, input := range cases {
+		t.Run(name, func(t *testing.T) {
+			filter, err := util.NewRuleFilter(strings.NewReader(input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			checkSelected(t, filter, map[string]bool{"prod-east/Q1 Red Team": true, "staging/Q1 Red Team": true})
+			if !filter.CheckDb("prod-west") || !filter.CheckDb("staging") || filter.CheckDb("dev") {
+				t.Errorf("CheckDb selects the wrong databases")
+			}
+		})
+	}
+}
+
+func TestNewRuleFilter_CheckDbSkipsExcludedDb(t *testing.T) {
+	filter, err := util.NewRuleFilter(strings.NewReader("exclude:\n  - db: staging\n  - db: prod-west\n    tag: purple-team\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if filter.CheckDb("staging") {
+		t.Error("CheckDb(staging) = true, want a db-only exclusion to skip it")
+	}
+	if !filter.CheckDb("prod-west") {
+		t.Error("CheckDb(prod-west) = false, want it listed since only some assessments are excluded")
+	}
+}
+
+func TestNewRuleFilter_Errors(t *testing.T) {
+	for name, input := range map[string]string{
+		"empty":        "",
+		"no rules":     "include: []\n",
+		"unknown key":  "include:\n  - owner: alice\n",
+		"bad pattern":  "include:\n  - assessment: \"re:(\"\n",
+		"bad time":     "include:\n  - updated: {since: yesterday}\n",
+		"bad globalid": "include:\n  - globalid: \"re:(\"\n",
+	} {
+		if _, err := util.NewRuleFilter(strings.NewReader(input)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := util.NewRuleFilter(strings.NewReader("")); !errors.Is(err, util.ErrEmptyFilter) {
+		t.Errorf("empty: err = %v, want ErrEmptyFilter", err)
+	}
+}
+
+func TestCheck_CSVFilterUsesNames(t *testing.T) {
+	filter, err := util.NewFilter(strings.NewReader(`"prod-east","Q1 Red Team"` + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSelected(t, filter, map[string]bool{"prod-east/Q1 Red Team": true})
+}
+
+func TestNewRuleFilter_GlobalId(t *testing.T) {
+	filter, err := util.NewRuleFilter(strings.NewReader("include:\n  - globalid: [7f3c9a1e-q1]\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkSelected(t, filter, map[string]bool{"prod-east/Q1 Red Team": true})
+	if !filter.CheckDb("staging") {
+		t.Error("CheckDb(staging) = false, want every db listed for a globalid rule")
+	}
+}
@@ -0,0 +1,86 @@
+package util
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// OrgMappingFallback is the source name that marks an org mapping file's
+// default/fallback entry.
+const OrgMappingFallback = "*"
+
+// OrgMapping renames organizations on restore, so an assessment can land in
+// an instance whose organizations are named differently from the source.
+type OrgMapping struct {
+	names    map[string]string
+	fallback string
+}
+
+// NewOrgMapping parses CSV input to create an OrgMapping object.
+//
+// Each record is a "source name","target name" pair. A record whose source
+// name is "*" is the fallback: the target every source organization that has
+// no entry of its own and doesn't exist in the target instance is mapped to.
+//
+// Parameters:
+//   - r: An io.Reader providing CSV input data.
+//
+// Returns:
+//   - A pointer to an `OrgMapping` struct.
+//   - An error if reading the CSV input fails or a record is invalid.
+//
+// Errors:
+//   - Returns an error if the CSV input cannot be read.
+//   - Returns an error if a record has a blank source or target name.
+//   - Returns an error if a source name is mapped to two different targets.
+func NewOrgMapping(r io.Reader) (*OrgMapping, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = 2
+	reader.LazyQuotes = false
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("could not create the org mapping: %w", err)
+	}
+
+	m := &OrgMapping{names: make(map[string]string, len(records))}
+	for i, record := range records {
+		source := strings.TrimSpace(record[0])
+		target := strings.TrimSpace(record[1])
+		if source == "" || target == "" {
+			return nil, fmt.Errorf("could not create the org mapping: line %d has a blank organization name", i+1)
+		}
+
+		if source == OrgMappingFallback {
+			if m.fallback != "" && m.fallback != target {
+				return nil, fmt.Errorf("could not create the org mapping: more than one fallback entry (%q, %q)", m.fallback, target)
+			}
+			m.fallback = target
+			continue
+		}
+
+		if existing, ok := m.names[source]; ok && existing != target {
+			return nil, fmt.Errorf("could not create the org mapping: %q is mapped to both %q and %q", source, existing, target)
+		}
+		m.names[source] = target
+	}
+
+	return m, nil
+}
+
+// Target returns the organization name the source organization is explicitly
+// mapped to, and whether it has an entry at all. The fallback is not
+// consulted; see Fallback.
+func (m *OrgMapping) Target(source string) (string, bool) {
+	target, ok := m.names[source]
+	return target, ok
+}
+
+// Fallback returns the fallback organization name, or "" if the mapping has
+// no fallback entry.
+func (m *OrgMapping) Fallback() string {
+	return m.fallback
+}
@@ -0,0 +1,48 @@
+package util_test
+
+import (
+	"sra/vat/internal/util"
+	"strings"
+	"testing"
+)
+
+func TestNewOrgMapping(t *testing.T) {
+	m, err := util.NewOrgMapping(strings.NewReader(`"Acme Red Team","Red Team"
+"Acme Blue Team", "Blue Team"
+"*","Default Org"
+"Acme Red Team","Red Team"
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, ok := m.Target("Acme Red Team"); !ok || got != "Red Team" {
+		t.Errorf("Target(Acme Red Team) = %q, %v; want Red Team, true", got, ok)
+	}
+	if got, ok := m.Target("Acme Blue Team"); !ok || got != "Blue Team" {
+		t.Errorf("Target(Acme Blue Team) = %q, %v; want Blue Team, true", got, ok)
+	}
+	if _, ok := m.Target("Unlisted"); ok {
+		t.Error("expected no explicit entry for an unlisted organization")
+	}
+	if got := m.Fallback(); got != "Default Org" {
+		t.Errorf("Fallback() = %q, want Default Org", got)
+	}
+}
+
+func TestNewOrgMappingRejectsInvalidInput(t *testing.T) {
+	cases := map[string]string{
+		"conflicting targets": `"A","B"` + "\n" + `"A","C"` + "\n",
+		"two fallbacks":       `"*","B"` + "\n" + `"*","C"` + "\n",
+		"blank target":        `"A",""` + "\n",
+		"blank source":        `"","B"` + "\n",
+		"wrong field count":   `"A","B","C"` + "\n",
+	}
+	for name, input := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := util.NewOrgMapping(strings.NewReader(input)); err == nil {
+				t.Errorf("expected an error for %s, got nil", name)
+			}
+		})
+	}
+}
@@ -0,0 +1,354 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+const (
+	// DefaultMaxRetries is how many times a failed request is retried unless
+	// SetClientOptions says otherwise.
+	DefaultMaxRetries = 5
+	// DefaultRequestTimeout bounds a single attempt of a single request.
+	DefaultRequestTimeout = 5 * time.Minute
+
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
+
+// ClientOptions controls how the clients SetupVectrClient builds retry,
+// time out and pace their requests.
+//
+// Fields:
+//   - MaxRetries: How many times a failed request is retried; 0 disables retries.
+//   - RequestTimeout: How long a single attempt may take; 0 means no limit.
+//   - RateLimit: The most requests per second sent to one VECTR instance; 0 means no limit.
+type ClientOptions struct {
+	MaxRetries     int
+	RequestTimeout time.Duration
+	RateLimit      float64
+}
+
+// clientOptions is what SetupVectrClient builds clients with. It is set once
+// at startup, before any client is built.
+var clientOptions = ClientOptions{
+	MaxRetries:     DefaultMaxRetries,
+	RequestTimeout: DefaultRequestTimeout,
+}
+
+// SetClientOptions sets the retry, timeout and rate limit options for every
+// client SetupVectrClient builds from then on. Negative values are treated as 0.
+func SetClientOptions(o ClientOptions) {
+	clientOptions = ClientOptions{
+		MaxRetries:     max(o.MaxRetries, 0),
+		RequestTimeout: max(o.RequestTimeout, 0),
+		RateLimit:      max(o.RateLimit, 0),
+	}
+}
+
+// rateLimiter spaces requests at least interval apart. A zero interval lets
+// every request through immediately.
+type rateLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// newRateLimiter returns a limiter allowing perSecond requests per second,
+// or nil (no limit) if perSecond is 0.
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait blocks until the caller's turn comes up or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	at := now
+	if l.next.After(now) {
+		at = l.next
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+	return sleepContext(ctx, at.Sub(now))
+}
+
+// sleepContext sleeps for d, returning early with ctx's error if ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryTransport is an HTTP transport that retries failed requests with
+// exponential backoff and jitter, times out each attempt and paces requests
+// through a rateLimiter.
+//
+// Connection errors, timeouts, 429 and 5xx responses are retried for reads;
+// TLS failures aren't, since they won't go away on their own. A Retry-After
+// header replaces the backoff, up to maxDelay.
+// A request that changes something (a GraphQL mutation or a REST POST) is only
+// retried when it provably never took effect: it was never written to the
+// connection, or VECTR answered 429. VECTR's clientIds only correlate items
+// within one response and aren't stored, so they can't tell whether a create
+// that timed out was applied; those failures are returned as they are and
+// left to the restore journal and --resume.
+//
+// Fields:
+//   - wrapped: The underlying HTTP RoundTripper to be wrapped.
+//   - maxRetries: How many times a failed request is retried.
+//   - timeout: How long a single attempt may take; 0 means no limit.
+//   - limiter: Paces every attempt, retries included; nil means no limit.
+//   - baseDelay, maxDelay: Bounds of the exponential backoff.
+type retryTransport struct {
+	wrapped    http.RoundTripper
+	maxRetries int
+	timeout    time.Duration
+	limiter    *rateLimiter
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+}
+
+// newRetryTransport wraps transport according to o.
+func newRetryTransport(transport http.RoundTripper, o ClientOptions) *retryTransport {
+	return &retryTransport{
+		wrapped:    transport,
+		maxRetries: o.MaxRetries,
+		timeout:    o.RequestTimeout,
+		limiter:    newRateLimiter(o.RateLimit),
+		baseDelay:  retryBaseDelay,
+		maxDelay:   retryMaxDelay,
+	}
+}
+
+// RoundTrip executes req, retrying it as described on retryTransport.
+//
+// The request body is buffered so every attempt can resend it. When retries
+// run out the last response or error is returned unchanged, so callers see
+// the same status codes and errors they would without retries.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not read request body: %w", err)
+		}
+	}
+	idempotent := isIdempotent(req, body)
+
+	for attempt := 0; ; attempt++ {
+		if err := t.limiter.wait(req.Context()); err != nil {
+			return nil, err
+		}
+
+		resp, written, err := t.attempt(req, body)
+		if attempt >= t.maxRetries || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		var delay time.Duration
+		switch {
+		case err != nil:
+			if !retryableError(err) || (!idempotent && written) {
+				return resp, err
+			}
+			delay = t.backoff(attempt)
+			slog.Warn("Request to VECTR failed, retrying", "url", req.URL.String(), "attempt", attempt+1, "delay", delay, "error", err)
+		case resp.StatusCode == http.StatusTooManyRequests || (idempotent && resp.StatusCode >= http.StatusInternalServerError):
+			delay = t.backoff(attempt)
+			if after, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				delay = min(after, t.maxDelay)
+				if after > t.maxDelay {
+					slog.Warn("VECTR asked to wait longer than the retry delay allows, waiting less", "url", req.URL.String(), "retry-after", after, "delay", delay)
+				}
+			}
+			slog.Warn("VECTR returned a retryable status, retrying", "url", req.URL.String(), "attempt", attempt+1, "delay", delay, "status", resp.StatusCode)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		default:
+			return resp, nil
+		}
+
+		if err := sleepContext(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// attempt sends one copy of req with body, under the per-attempt timeout.
+// written reports whether any of the request reached the connection.
+func (t *retryTransport) attempt(req *http.Request, body []byte) (resp *http.Response, written bool, err error) {
+	ctx := req.Context()
+	cancel := context.CancelFunc(func() {})
+	if t.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, t.timeout)
+	}
+	var mu sync.Mutex
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteHeaders: func() {
+			mu.Lock()
+			written = true
+			mu.Unlock()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			mu.Lock()
+			written = true
+			mu.Unlock()
+		},
+	})
+
+	r := req.Clone(ctx)
+	if body != nil {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+		r.ContentLength = int64(len(body))
+	}
+
+	resp, err = t.wrapped.RoundTrip(r)
+	mu.Lock()
+	defer mu.Unlock()
+	if err != nil {
+		cancel()
+		return nil, written, err
+	}
+	// the attempt's context has to outlive RoundTrip until the body is read
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, written, nil
+}
+
+// backoff returns how long to wait before retry number attempt+1: a random
+// duration up to baseDelay doubled attempt times, capped at maxDelay.
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	ceiling := t.maxDelay
+	if attempt < 32 {
+		ceiling = min(t.baseDelay<<attempt, t.maxDelay)
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling) + 1
+}
+
+// cancelOnClose releases an attempt's timeout context once its response body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// retryableError reports whether a failed attempt may succeed if repeated.
+// Certificate and TLS handshake failures are configuration problems, not
+// transient ones.
+func retryableError(err error) bool {
+	var (
+		certErr      *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+		recordErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+	)
+	return !errors.As(err, &certErr) && !errors.As(err, &authorityErr) && !errors.As(err, &hostnameErr) &&
+		!errors.As(err, &invalidErr) && !errors.As(err, &recordErr) && !errors.As(err, &alertErr)
+}
+
+// parseRetryAfter reads a Retry-After header, given either as a number of
+// seconds or as an HTTP date, into a delay from now.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return max(at.Sub(now), 0), true
+}
+
+// graphqlRequest is the part of a GraphQL request body isIdempotent looks at.
+type graphqlRequest struct {
+	Query         string `json:"query"`
+	OperationName string `json:"operationName"`
+}
+
+var errNotGraphql = errors.New("not a graphql request")
+
+// isIdempotent reports whether req can be sent twice without changing
+// anything twice: a GET or HEAD, or a GraphQL POST whose operation is a query.
+// Anything it can't classify is treated as a mutation.
+func isIdempotent(req *http.Request, body []byte) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		return true
+	case http.MethodPost:
+		op, err := graphqlOperation(body)
+		return err == nil && op == ast.Query
+	default:
+		return false
+	}
+}
+
+// graphqlOperation returns the type of the operation a GraphQL request body runs.
+func graphqlOperation(body []byte) (ast.Operation, error) {
+	var gr graphqlRequest
+	if err := json.Unmarshal(body, &gr); err != nil || gr.Query == "" {
+		return "", errNotGraphql
+	}
+	doc, err := parser.ParseQuery(&ast.Source{Input: gr.Query})
+	if err != nil {
+		return "", err
+	}
+	if len(doc.Operations) == 1 && gr.OperationName == "" {
+		return doc.Operations[0].Operation, nil
+	}
+	if op := doc.Operations.ForName(gr.OperationName); op != nil {
+		return op.Operation, nil
+	}
+	return "", errNotGraphql
+}
@@ -0,0 +1,277 @@
+package util
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	queryBody    = `{"query":"query GetAllAssessments($db: String!) { assessments(db: $db) { nodes { id } } }","operationName":"GetAllAssessments"}`
+	mutationBody = `{"query":"mutation CreateAssessment($db: String!) { assessment(db: $db) { create { assessments { id } } } }","operationName":"CreateAssessment"}`
+)
+
+// scriptedTransport answers each round trip with the next status (or error)
+// in its script, marking the request as written unless unwritten says otherwise.
+type scriptedTransport struct {
+	statuses  []int
+	errs      []error
+	unwritten bool
+	headers   http.Header
+	calls     int
+	bodies    []string
+}
+
+func (s *scriptedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	i := s.calls
+	s.calls++
+	if req.Body != nil {
+		body, _ := io.ReadAll(req.Body)
+		s.bodies = append(s.bodies, string(body))
+	}
+	if trace := httptrace.ContextClientTrace(req.Context()); trace != nil && !s.unwritten {
+		trace.WroteHeaders()
+	}
+	if i < len(s.errs) && s.errs[i] != nil {
+		return nil, s.errs[i]
+	}
+	status := http.StatusOK
+	if i < len(s.statuses) {
+		status = s.statuses[i]
+	}
+	return &http.Response{StatusCode: status, Header: s.headers, Body: io.NopCloser(strings.NewReader("{}")), Request: req}, nil
+}
+
+func testRetryTransport(wrapped http.RoundTripper) *retryTransport {
+	t := newRetryTransport(wrapped, ClientOptions{MaxRetries: 3})
+	t.baseDelay = time.Millisecond
+	t.maxDelay = 5 * time.Millisecond
+	return t
+}
+
+func post(t *testing.T, rt http.RoundTripper, body string) (*http.Response, error) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, "https://vectr.example"+API_PATH, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rt.RoundTrip(req)
+}
+
+func TestRetryTransport(t *testing.T) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})))
+	refused := errors.New("connection refused")
+
+	cases := map[string]struct {
+		body       string
+		transport  *scriptedTransport
+		wantCalls  int
+		wantStatus int
+		wantErr    bool
+	}{
+		"query retried on 502": {
+			body:       queryBody,
+			transport:  &scriptedTransport{statuses: []int{502, 503}},
+			wantCalls:  3,
+			wantStatus: 200,
+		},
+		"query retried on connection error": {
+			body:       queryBody,
+			transport:  &scriptedTransport{errs: []error{refused}},
+			wantCalls:  2,
+			wantStatus: 200,
+		},
+		"query gives up after max retries": {
+			body:       queryBody,
+			transport:  &scriptedTransport{statuses: []int{500, 500, 500, 500, 500}},
+			wantCalls:  4,
+			wantStatus: 500,
+		},
+		"certificate errors are not retried": {
+			body:      queryBody,
+			transport: &scriptedTransport{errs: []error{&url.Error{Op: "Post", Err: x509.UnknownAuthorityError{}}}},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		"client errors are not retried": {
+			body:       queryBody,
+			transport:  &scriptedTransport{statuses: []int{400}},
+			wantCalls:  1,
+			wantStatus: 400,
+		},
+		"mutation not retried on 502": {
+			body:       mutationBody,
+			transport:  &scriptedTransport{statuses: []int{502}},
+			wantCalls:  1,
+			wantStatus: 502,
+		},
+		"mutation not retried once written": {
+			body:      mutationBody,
+			transport: &scriptedTransport{errs: []error{refused}},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		"mutation retried when never written": {
+			body:       mutationBody,
+			transport:  &scriptedTransport{errs: []error{refused}, unwritten: true},
+			wantCalls:  2,
+			wantStatus: 200,
+		},
+		"mutation retried on 429": {
+			body:       mutationBody,
+			transport:  &scriptedTransport{statuses: []int{429}, headers: http.Header{"Retry-After": {"0"}}},
+			wantCalls:  2,
+			wantStatus: 200,
+		},
+		"unparseable body treated as mutation": {
+			body:       `{"query":"not graphql {"}`,
+			transport:  &scriptedTransport{statuses: []int{502}},
+			wantCalls:  1,
+			wantStatus: 502,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			resp, err := post(t, testRetryTransport(tc.transport), tc.body)
+			if tc.transport.calls != tc.wantCalls {
+				t.Errorf("calls = %d, want %d", tc.transport.calls, tc.wantCalls)
+			}
+			for i, b := range tc.transport.bodies {
+				if b != tc.body {
+					t.Errorf("attempt %d sent body %q, want %q", i+1, b, tc.body)
+				}
+			}
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tc.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tc.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRetryTransport_StopsWhenContextCancelled(t *testing.T) {
+	transport := &scriptedTransport{statuses: []int{503, 503, 503, 503}}
+	rt := testRetryTransport(transport)
+	rt.baseDelay, rt.maxDelay = time.Hour, time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://vectr.example"+VERSION_PATH, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.RoundTrip(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if transport.calls != 1 {
+		t.Errorf("calls = %d, want 1", transport.calls)
+	}
+}
+
+func TestRetryTransport_CapsRetryAfter(t *testing.T) {
+	transport := &scriptedTransport{statuses: []int{429}, headers: http.Header{"Retry-After": {"3600"}}}
+	start := time.Now()
+	resp, err := post(t, testRetryTransport(transport), mutationBody)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waited %v for a Retry-After of an hour, want it capped at maxDelay", elapsed)
+	}
+	if resp.StatusCode != http.StatusOK || transport.calls != 2 {
+		t.Errorf("status = %d after %d calls, want 200 after 2", resp.StatusCode, transport.calls)
+	}
+}
+
+func TestRetryTransport_RequestTimeout(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	rt := testRetryTransport(http.DefaultTransport)
+	rt.timeout = 50 * time.Millisecond
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || string(body) != "ok" {
+		t.Errorf("body = %q, %v; want ok", body, err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("calls = %d, want 2", calls.Load())
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(100)
+	start := time.Now()
+	for range 5 {
+		if err := limiter.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("5 requests at 100/s took %v, want at least 40ms", elapsed)
+	}
+	if newRateLimiter(0) != nil {
+		t.Error("newRateLimiter(0) should not limit")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cases := map[string]struct {
+		value  string
+		want   time.Duration
+		wantOk bool
+	}{
+		"seconds":   {"7", 7 * time.Second, true},
+		"http date": {"Mon, 01 Jan 2024 12:00:30 GMT", 30 * time.Second, true},
+		"past date": {"Mon, 01 Jan 2024 11:00:00 GMT", 0, true},
+		"blank":     {"", 0, false},
+		"negative":  {"-1", 0, false},
+		"garbage":   {"soon", 0, false},
+	}
+	for name, tc := range cases {
+		got, ok := parseRetryAfter(tc.value, now)
+		if got != tc.want || ok != tc.wantOk {
+			t.Errorf("%s: parseRetryAfter(%q) = %v, %v; want %v, %v", name, tc.value, got, ok, tc.want, tc.wantOk)
+		}
+	}
+}
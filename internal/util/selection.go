@@ -0,0 +1,197 @@
+package util
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// RegexPatternPrefix marks a NamePattern as a regular expression.
+const RegexPatternPrefix = "re:"
+
+// NamePattern matches campaign and test case names (and the other values a
+// TestCaseFilter looks at) in one of three ways:
+//   - "re:<expression>" is a Go regular expression, matched anywhere in the
+//     name unless anchored;
+//   - a pattern containing *, ? or [ is a glob (path.Match syntax);
+//   - anything else must equal the name exactly.
+type NamePattern struct {
+	raw  string
+	glob bool
+	re   *regexp.Regexp
+}
+
+// NewNamePattern parses a NamePattern.
+//
+// Errors:
+//   - Returns an error if the pattern is blank.
+//   - Returns an error if a regular expression or glob is malformed.
+func NewNamePattern(s string) (NamePattern, error) {
+	if s == "" {
+		return NamePattern{}, fmt.Errorf("blank name pattern")
+	}
+	if expr, ok := strings.CutPrefix(s, RegexPatternPrefix); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return NamePattern{}, fmt.Errorf("invalid regular expression %q: %w", expr, err)
+		}
+		return NamePattern{raw: s, re: re}, nil
+	}
+	if strings.ContainsAny(s, "*?[") {
+		if _, err := path.Match(s, ""); err != nil {
+			return NamePattern{}, fmt.Errorf("invalid glob %q: %w", s, err)
+		}
+		return NamePattern{raw: s, glob: true}, nil
+	}
+	return NamePattern{raw: s}, nil
+}
+
+// NewNamePatterns parses each of patterns with NewNamePattern.
+func NewNamePatterns(patterns []string) ([]NamePattern, error) {
+	parsed := make([]NamePattern, 0, len(patterns))
+	for _, s := range patterns {
+		p, err := NewNamePattern(s)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, p)
+	}
+	return parsed, nil
+}
+
+// Match reports whether name matches the pattern.
+func (p NamePattern) Match(name string) bool {
+	switch {
+	case p.re != nil:
+		return p.re.MatchString(name)
+	case p.glob:
+		ok, _ := path.Match(p.raw, name)
+		return ok
+	}
+	return p.raw == name
+}
+
+// String returns the pattern as it was written.
+func (p NamePattern) String() string {
+	return p.raw
+}
+
+// The fields a TestCaseFilter term can select on.
+const (
+	TestCaseFilterTechnique    = "technique"
+	TestCaseFilterStatus       = "status"
+	TestCaseFilterTag          = "tag"
+	TestCaseFilterOrganization = "organization"
+	TestCaseFilterName         = "name"
+)
+
+var testCaseFilterFields = []string{
+	TestCaseFilterTechnique,
+	TestCaseFilterStatus,
+	TestCaseFilterTag,
+	TestCaseFilterOrganization,
+	TestCaseFilterName,
+}
+
+// TestCaseFields is what a TestCaseFilter looks at in a test case.
+type TestCaseFields struct {
+	Name          string
+	Technique     string // MITRE id, e.g. T1059.001
+	Status        string
+	Tags          []string
+	Organizations []string
+}
+
+// TestCaseFilter selects test cases on restore. It is built from
+// "field=pattern" terms, where field is one of technique, status, tag,
+// organization or name and pattern is a NamePattern. A test case is selected
+// if, for every field with terms, it matches at least one of them: terms on
+// the same field are alternatives, terms on different fields must all hold.
+// Tag and organization terms match if any of the test case's tags or
+// organizations does.
+type TestCaseFilter struct {
+	terms map[string][]NamePattern
+	raw   []string
+}
+
+// NewTestCaseFilter parses "field=pattern" terms into a TestCaseFilter. No
+// terms means no filter.
+//
+// Errors:
+//   - Returns an error if a term has no "=" or names an unknown field.
+//   - Returns an error if a term's pattern is invalid (see NewNamePattern).
+func NewTestCaseFilter(terms []string) (*TestCaseFilter, error) {
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	f := &TestCaseFilter{terms: map[string][]NamePattern{}}
+	for _, term := range terms {
+		field, pattern, ok := strings.Cut(term, "=")
+		field = strings.ToLower(strings.TrimSpace(field))
+		if !ok {
+			return nil, fmt.Errorf("test case filter %q: want field=pattern", term)
+		}
+		if !slices.Contains(testCaseFilterFields, field) {
+			return nil, fmt.Errorf("test case filter %q: unknown field %q, want one of %s", term, field, strings.Join(testCaseFilterFields, ", "))
+		}
+		p, err := NewNamePattern(strings.TrimSpace(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("test case filter %q: %w", term, err)
+		}
+		f.terms[field] = append(f.terms[field], p)
+		f.raw = append(f.raw, term)
+	}
+	return f, nil
+}
+
+// Match reports whether the filter selects tc. A nil filter selects every
+// test case.
+func (f *TestCaseFilter) Match(tc TestCaseFields) bool {
+	if f == nil {
+		return true
+	}
+	for field, patterns := range f.terms {
+		var values []string
+		switch field {
+		case TestCaseFilterTechnique:
+			values = []string{tc.Technique}
+		case TestCaseFilterStatus:
+			values = []string{tc.Status}
+		case TestCaseFilterTag:
+			values = tc.Tags
+		case TestCaseFilterOrganization:
+			values = tc.Organizations
+		case TestCaseFilterName:
+			values = []string{tc.Name}
+		}
+		if !slices.ContainsFunc(patterns, func(p NamePattern) bool {
+			return slices.ContainsFunc(values, func(v string) bool {
+				return p.Match(v) || (field == TestCaseFilterStatus && p.matchStatus(v))
+			})
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchStatus lets an exact status pattern ignore case and spaces, since
+// VECTR reads back "In Progress" for what it writes as "InProgress".
+func (p NamePattern) matchStatus(status string) bool {
+	if p.re != nil || p.glob {
+		return false
+	}
+	squash := func(s string) string { return strings.ToLower(strings.ReplaceAll(s, " ", "")) }
+	return squash(p.raw) == squash(status)
+}
+
+// String returns the filter's terms as they were written, for logs and to
+// tell filters apart.
+func (f *TestCaseFilter) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(f.raw, " ")
+}
@@ -0,0 +1,71 @@
+package util_test
+
+import (
+	"sra/vat/internal/util"
+	"testing"
+)
+
+func TestNamePattern(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"Initial Access", "Initial Access", true},
+		{"Initial Access", "Initial Access 2", false},
+		{"Initial*", "Initial Access", true},
+		{"Phase [12]", "Phase 2", true},
+		{"Phase [12]", "Phase 3", false},
+		{"re:^T10(59|86)", "T1059.001", true},
+		{"re:^T10(59|86)", "T1105", false},
+		// Regex metacharacters are literal in an exact name.
+		{"a.b", "axb", false},
+	}
+	for _, c := range cases {
+		p, err := util.NewNamePattern(c.pattern)
+		if err != nil {
+			t.Fatalf("NewNamePattern(%q): %v", c.pattern, err)
+		}
+		if got := p.Match(c.name); got != c.want {
+			t.Errorf("%q.Match(%q) = %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+
+	for _, bad := range []string{"", "re:(", "[unclosed"} {
+		if _, err := util.NewNamePattern(bad); err == nil {
+			t.Errorf("NewNamePattern(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestTestCaseFilter(t *testing.T) {
+	f, err := util.NewTestCaseFilter([]string{"technique=T1059*", "technique=T1105", "status=In Progress", "tag=re:^prio-"})
+	if err != nil {
+		t.Fatalf("NewTestCaseFilter: %v", err)
+	}
+	cases := map[string]struct {
+		tc   util.TestCaseFields
+		want bool
+	}{
+		"all fields match":  {util.TestCaseFields{Technique: "T1059.001", Status: "InProgress", Tags: []string{"x", "prio-1"}}, true},
+		"other technique":   {util.TestCaseFields{Technique: "T1105", Status: "In Progress", Tags: []string{"prio-2"}}, true},
+		"technique missing": {util.TestCaseFields{Technique: "T1003", Status: "InProgress", Tags: []string{"prio-1"}}, false},
+		"wrong status":      {util.TestCaseFields{Technique: "T1059", Status: "Completed", Tags: []string{"prio-1"}}, false},
+		"no tags":           {util.TestCaseFields{Technique: "T1059", Status: "InProgress"}, false},
+	}
+	for name, c := range cases {
+		if got := f.Match(c.tc); got != c.want {
+			t.Errorf("%s: Match(%+v) = %v, want %v", name, c.tc, got, c.want)
+		}
+	}
+
+	var none *util.TestCaseFilter
+	if !none.Match(util.TestCaseFields{}) {
+		t.Error("a nil filter should select every test case")
+	}
+	for _, bad := range []string{"technique", "color=red", "name=re:("} {
+		if _, err := util.NewTestCaseFilter([]string{bad}); err == nil {
+			t.Errorf("NewTestCaseFilter(%q): expected an error", bad)
+		}
+	}
+}
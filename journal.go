@@ -0,0 +1,199 @@
+package vat
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// RestoreJournal records everything a restore has written to the target
+// instance, one completed step at a time, so a restore that dies part way
+// through (a network blip, Ctrl+C) can be resumed without creating anything
+// twice. It is plain data and round-trips through encoding/json.
+//
+// The journal is only as fine-grained as VECTR's mutations: a step whose
+// request was sent but whose response never came back isn't recorded, so a
+// resume will redo it.
+type RestoreJournal struct {
+	// Identity of the restore the journal belongs to, checked on resume.
+	Db                   string
+	SourceAssessmentName string
+	SourceGlobalId       string
+	SourceCampaignName   string // set for a campaign restore: its campaign name patterns, comma separated
+	TestCaseFilter       string // RestoreOptionalParams.TestCaseFilter, if any
+	AsTemplate           bool   // RestoreOptionalParams.AsTemplate
+
+	// AssessmentName and AssessmentId are the assessment in the target
+	// instance: the one created by RestoreAssessment, or the existing one
+	// RestoreCampaign adds to. For AsTemplate they, Campaigns and TestCases
+	// hold the library assessment, campaigns and test cases instead.
+	AssessmentName string
+	AssessmentId   string
+
+	// UpdatedExisting is set when RestoreModeUpdate found the assessment
+	// already in the target instance; PreExisting holds the target ids of the
+	// campaigns and test cases it had before the restore touched it, which a
+	// rollback must leave alone.
+	UpdatedExisting bool
+	PreExisting     map[string]bool
+
+	AssetsReconciled bool
+	DefenseTools     map[string]string // DefenseToolRef.Key() -> target tool id
+	Campaigns        map[string]string // campaign name -> target campaign id
+	TestCases        map[string]string // source test case id -> target test case id
+	TimelineEvents   map[string]bool   // source timeline event ids written
+
+	// LibraryNameMatches maps source library test case ids to the target
+	// library test case ids TemplateMatchName or TemplateMatchIdThenName
+	// matched them to by name.
+	LibraryNameMatches map[string]string
+
+	// Created lists the objects outside the target assessment that the
+	// restore created, so RollbackRestore can remove them again.
+	Created CreatedObjects
+
+	// PriorOutcomes holds the report settings of the outcomes an environment
+	// restore updated, keyed by target outcome id, as they were before the
+	// first update, so RollbackRestore can put them back.
+	PriorOutcomes map[string]EnvOutcome
+
+	Complete bool
+	// RolledBack is set once RollbackRestore has deleted everything the
+	// restore created; there is nothing left to resume or roll back.
+	RolledBack bool
+}
+
+// CreatedObjects holds the ids of objects a restore created outside the
+// target assessment. Anything it reused or only updated is left out, since
+// rolling back must never delete what was there before the restore.
+type CreatedObjects struct {
+	DefenseTools         []string
+	DefenseLayers        []string // db-scoped layers cloned from a library layer
+	DefenseToolProducts  []string
+	LibraryDefenseLayers []string
+	TestCaseTemplates    []string // library test cases, from OverrideAssessmentTemplate
+	CampaignTemplates    []string // library campaigns, from AsTemplate
+	AssessmentTemplates  []string // library assessments, from AsTemplate
+}
+
+// JournalWriter persists a RestoreJournal. Restore calls it after every step
+// it completes; a failed write stops the restore, since carrying on would
+// leave the journal behind what's actually in the target instance.
+type JournalWriter interface {
+	WriteJournal(ctx context.Context, journal *RestoreJournal) error
+}
+
+// ErrJournalMismatch is returned when resuming with a journal that was
+// written for a different database, assessment or campaign.
+var ErrJournalMismatch = fmt.Errorf("restore journal does not match this restore")
+
+// NewRestoreJournal returns an empty journal, ready to record a restore.
+func NewRestoreJournal() *RestoreJournal {
+	j := &RestoreJournal{}
+	j.init()
+	return j
+}
+
+// init allocates any nil maps, e.g. on a journal decoded from a file written
+// before anything was recorded into them.
+func (j *RestoreJournal) init() {
+	if j.DefenseTools == nil {
+		j.DefenseTools = map[string]string{}
+	}
+	if j.LibraryNameMatches == nil {
+		j.LibraryNameMatches = map[string]string{}
+	}
+	if j.Campaigns == nil {
+		j.Campaigns = map[string]string{}
+	}
+	if j.TestCases == nil {
+		j.TestCases = map[string]string{}
+	}
+	if j.TimelineEvents == nil {
+		j.TimelineEvents = map[string]bool{}
+	}
+	if j.PreExisting == nil {
+		j.PreExisting = map[string]bool{}
+	}
+	if j.PriorOutcomes == nil {
+		j.PriorOutcomes = map[string]EnvOutcome{}
+	}
+}
+
+// forgetAssessment drops everything recorded under the target assessment,
+// after a rollback has deleted what the restore wrote there, so a later
+// resume starts the assessment over instead of writing into what no longer
+// exists.
+func (j *RestoreJournal) forgetAssessment() {
+	j.AssessmentId = ""
+	j.UpdatedExisting = false
+	j.PreExisting = map[string]bool{}
+	j.Campaigns = map[string]string{}
+	j.TestCases = map[string]string{}
+	j.TimelineEvents = map[string]bool{}
+}
+
+// journal returns the restore's journal, starting an in-memory one if the
+// caller didn't supply one.
+func (p *RestoreOptionalParams) journal() *RestoreJournal {
+	if p.Journal == nil {
+		p.Journal = NewRestoreJournal()
+	}
+	p.Journal.init()
+	return p.Journal
+}
+
+// checkpoint persists the journal with the configured JournalWriter, if any.
+func (p *RestoreOptionalParams) checkpoint(ctx context.Context) error {
+	if p.JournalWriter == nil {
+		return nil
+	}
+	if err := p.JournalWriter.WriteJournal(ctx, p.journal()); err != nil {
+		return fmt.Errorf("could not write the restore journal: %w", err)
+	}
+	return nil
+}
+
+// recordCreated appends ids to one of the journal's Created lists and
+// persists the journal straight away: an object created but never recorded
+// is one a rollback can't find.
+func (p *RestoreOptionalParams) recordCreated(ctx context.Context, list *[]string, ids ...string) error {
+	*list = append(*list, ids...)
+	return p.checkpoint(ctx)
+}
+
+// startJournal ties the journal to the restore of ad into db (and, for a
+// campaign restore, sourceCampaignName, the selected campaign patterns) with
+// the test case filter in use. A fresh journal is stamped with that identity;
+// a journal that already has one is being resumed and must match it.
+func (p *RestoreOptionalParams) startJournal(ctx context.Context, db, sourceCampaignName string, ad *AssessmentData) error {
+	j := p.journal()
+	testCaseFilter := p.TestCaseFilter.String()
+	if j.Db == "" {
+		j.Db = db
+		j.SourceAssessmentName = ad.Assessment.Name
+		j.SourceGlobalId = ad.Assessment.GlobalId
+		j.SourceCampaignName = sourceCampaignName
+		j.TestCaseFilter = testCaseFilter
+		j.AsTemplate = p.AsTemplate
+		return p.checkpoint(ctx)
+	}
+
+	if j.AsTemplate != p.AsTemplate {
+		return fmt.Errorf("journal is for a restore with as-template %v, not %v: %w", j.AsTemplate, p.AsTemplate, ErrJournalMismatch)
+	}
+
+	if j.Db != db || j.SourceAssessmentName != ad.Assessment.Name || j.SourceGlobalId != ad.Assessment.GlobalId || j.SourceCampaignName != sourceCampaignName || j.TestCaseFilter != testCaseFilter {
+		return fmt.Errorf("journal is for assessment %q (globalId %s, campaign %q, test case filter %q) in %s, not %q (globalId %s, campaign %q, test case filter %q) in %s: %w",
+			j.SourceAssessmentName, j.SourceGlobalId, j.SourceCampaignName, j.TestCaseFilter, j.Db,
+			ad.Assessment.Name, ad.Assessment.GlobalId, sourceCampaignName, testCaseFilter, db, ErrJournalMismatch)
+	}
+	slog.InfoContext(ctx, "Resuming restore from journal",
+		"db", db,
+		"assessment-name", j.AssessmentName,
+		"assessment-id", j.AssessmentId,
+		"campaign-count", len(j.Campaigns),
+		"test-case-count", len(j.TestCases),
+		"complete", j.Complete)
+	return nil
+}
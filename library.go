@@ -0,0 +1,413 @@
+package vat
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"sra/vat/internal/dao"
+	"sra/vat/internal/util"
+
+	"github.com/Khan/genqlient/graphql"
+)
+
+// ResourceLibraryAssessments is the resource a library archive (see
+// LibraryData) carries its library assessments in. Their library test cases
+// go in the "librarytestcases" resource, as in an assessment archive.
+const ResourceLibraryAssessments = "libraryassessments"
+
+// LibraryData is the in-memory model of a library archive: library
+// assessments with their library campaigns and test cases, moved the way
+// VECTR's ISV export would. Like AssessmentData it composes individually
+// versioned resources (see libraryResourceRegistry) with the file's manifest.
+type LibraryData struct {
+	Manifest    Manifest
+	Assessments []LibraryAssessment
+	// TestCases is every library test case the campaigns use, keyed by
+	// library test case id.
+	TestCases LibraryTestCasesResource
+	// OrgMap is every organization the assessments, campaigns and test
+	// cases name, which the target instance has to have.
+	OrgMap OrgMapResource
+}
+
+// LibraryAssessment is a library assessment. Names are as VECTR shows them,
+// with any "<prefix> - " in front; the prefix itself is in the "prefix"
+// metadata, as for any library object (see splitTemplatePrefix).
+type LibraryAssessment struct {
+	Name          string
+	Description   string
+	KillChainId   string
+	Organizations []string
+	Metadata      []dao.MetadataKeyValuePairInput
+	Campaigns     []LibraryCampaign
+}
+
+// LibraryCampaign is a library campaign. TestCases are library test case
+// ids, in order.
+type LibraryCampaign struct {
+	Name          string
+	Description   string
+	Organizations []string
+	Metadata      []dao.MetadataKeyValuePairInput
+	TestCases     []string
+}
+
+// libraryResourceRegistry is resourceRegistry for library archives. The
+// library test cases are the "librarytestcases" resource of an assessment
+// archive, but "libraryassessments" is required, so neither kind of archive
+// decodes as the other.
+var libraryResourceRegistry = []resourceDescriptor[LibraryData]{
+	{
+		Name:     ResourceLibraryAssessments,
+		Required: ResourceRequired,
+		Encode: func(l *LibraryData) (json.RawMessage, error) {
+			return json.Marshal(l.Assessments)
+		},
+		Decode: func(l *LibraryData, raw json.RawMessage) error {
+			return json.Unmarshal(raw, &l.Assessments)
+		},
+	},
+	{
+		Name:     ResourceLibraryTestCases,
+		Required: ResourceRequired,
+		Encode: func(l *LibraryData) (json.RawMessage, error) {
+			return json.Marshal(l.TestCases)
+		},
+		Decode: func(l *LibraryData, raw json.RawMessage) error {
+			return json.Unmarshal(raw, &l.TestCases)
+		},
+	},
+	{
+		Name:     ResourceOrgMap,
+		Required: ResourceRequired,
+		Encode: func(l *LibraryData) (json.RawMessage, error) {
+			return json.Marshal(l.OrgMap)
+		},
+		Decode: func(l *LibraryData, raw json.RawMessage) error {
+			return json.Unmarshal(raw, &l.OrgMap)
+		},
+	},
+}
+
+// EncodeLibraryToJson serializes a LibraryData into the manifest+resource
+// envelope wire format.
+func EncodeLibraryToJson(data *LibraryData) ([]byte, error) {
+	return encodeEnvelope(data.Manifest, libraryResourceRegistry, data)
+}
+
+// DecodeLibraryJson deserializes a library archive. Any other archive fails
+// with ErrMissingRequiredResource.
+func DecodeLibraryJson(raw []byte) (*LibraryData, error) {
+	l := &LibraryData{}
+	manifest, err := decodeEnvelope(raw, libraryResourceRegistry, l)
+	if err != nil {
+		return nil, err
+	}
+	l.Manifest = manifest
+	return l, nil
+}
+
+// SaveLibrary captures the library assessments whose name, with or without
+// its template prefix, matches one of names, or whose template prefix
+// matches one of prefixes, with their library campaigns and test cases.
+//
+// Errors:
+//   - Returns ErrNoAssessmentsFound if no library assessment is selected.
+//   - Returns ErrMissingLibraryTestCases, listing them, if a campaign uses
+//     library test cases VECTR doesn't have.
+//   - Returns a wrapped error with additional context if any GraphQL query fails.
+func SaveLibrary(ctx context.Context, client graphql.Client, names []util.NamePattern, prefixes []util.NamePattern) (*LibraryData, error) {
+	slog.InfoContext(ctx, "Starting SaveLibrary", "names", names, "prefixes", prefixes)
+	assessments, err := dao.ListLibraryAssessments(ctx, client)
+	if err != nil {
+		if gqlObject, ok := gqlErrParse(err); ok {
+			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+		}
+		return nil, fmt.Errorf("could not fetch library assessments: %w", err)
+	}
+
+	data := &LibraryData{
+		Manifest:  NewManifestMetadata(ctx),
+		TestCases: LibraryTestCasesResource{},
+		OrgMap:    OrgMapResource{},
+	}
+	addOrgs := func(orgs []string) {
+		for _, o := range orgs {
+			data.OrgMap[o] = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentOrganizationsOrganization{Name: o}
+		}
+	}
+	var ids []string
+	for _, a := range assessments {
+		metadata := make([]dao.MetadataKeyValuePairInput, 0, len(a.Metadata))
+		for _, md := range a.Metadata {
+			metadata = append(metadata, dao.MetadataKeyValuePairInput(md))
+		}
+		name, prefix := splitTemplatePrefix(a.Name, metadata)
+		if !matchAnyPattern(names, a.Name, name) && (prefix == "" || !matchAnyPattern(prefixes, prefix)) {
+			continue
+		}
+
+		la := LibraryAssessment{
+			Name:        a.Name,
+			Description: a.Description,
+			KillChainId: a.KillChain.Id,
+			Metadata:    metadata,
+		}
+		for _, o := range a.Organizations {
+			la.Organizations = append(la.Organizations, o.Name)
+		}
+		addOrgs(la.Organizations)
+		campaigns := slices.Clone(a.Campaigns)
+		slices.SortStableFunc(campaigns, func(x, y dao.GetAllLibraryAssessmentsLibraryAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign) int {
+			return cmp.Compare(x.Offset, y.Offset)
+		})
+		for _, c := range campaigns {
+			lc := LibraryCampaign{Name: c.Name, Description: c.Description}
+			for _, o := range c.Organizations {
+				lc.Organizations = append(lc.Organizations, o.Name)
+			}
+			addOrgs(lc.Organizations)
+			for _, md := range c.Metadata {
+				lc.Metadata = append(lc.Metadata, dao.MetadataKeyValuePairInput(md))
+			}
+			testCases := slices.Clone(c.TestCases)
+			slices.SortStableFunc(testCases, func(x, y dao.GetAllLibraryAssessmentsLibraryAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase) int {
+				return cmp.Compare(x.Offset, y.Offset)
+			})
+			for _, tc := range testCases {
+				lc.TestCases = append(lc.TestCases, tc.LibraryTestCaseId)
+				ids = append(ids, tc.LibraryTestCaseId)
+			}
+			la.Campaigns = append(la.Campaigns, lc)
+		}
+		data.Assessments = append(data.Assessments, la)
+	}
+	if len(data.Assessments) == 0 {
+		return nil, fmt.Errorf("no library assessment matches names %v or prefixes %v: %w", names, prefixes, ErrNoAssessmentsFound)
+	}
+
+	ids = slices.Compact(slices.Sorted(slices.Values(ids)))
+	if len(ids) > 0 {
+		missing, err := findMissingLibraryTestCases(ctx, client, ids, "the library")
+		if err != nil {
+			return nil, err
+		}
+		if len(missing) > 0 {
+			slog.ErrorContext(ctx, "Library campaigns use library test cases VECTR doesn't have", "missing-ids", missing)
+			return nil, fmt.Errorf("the campaigns use %d library test cases VECTR doesn't have (%s): %w", len(missing), strings.Join(missing, ", "), ErrMissingLibraryTestCases)
+		}
+		r, err := dao.GetLibraryTestCases(ctx, client, ids)
+		if err != nil {
+			if gqlObject, ok := gqlErrParse(err); ok {
+				slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+			}
+			return nil, fmt.Errorf("could not fetch library test cases: %w", err)
+		}
+		for _, tc := range r.LibraryTestcasesByIds.Nodes {
+			data.TestCases[tc.LibraryTestCaseId] = tc
+			for _, o := range tc.Organizations {
+				addOrgs([]string{o.Name})
+			}
+		}
+	}
+
+	slog.InfoContext(ctx, "Finished saving library",
+		"assessment-count", len(data.Assessments),
+		"test-case-count", len(data.TestCases),
+		"organization-count", len(data.OrgMap))
+	return data, nil
+}
+
+// matchAnyPattern reports whether any of values matches any of patterns.
+func matchAnyPattern(patterns []util.NamePattern, values ...string) bool {
+	for _, p := range patterns {
+		for _, v := range values {
+			if p.Match(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LibraryReport is what RestoreLibrary did with each library assessment.
+type LibraryReport struct {
+	Created []string // library assessments created
+	Skipped []string // library assessments already in the target library
+	// ReusedCampaigns is library campaigns that were already in the target
+	// library, which the created assessments use as they are.
+	ReusedCampaigns []string
+	// MismatchedCampaigns is the reused campaigns whose library test cases
+	// differ from the saved ones.
+	MismatchedCampaigns []string
+}
+
+// RestoreLibrary adds the library assessments of a library archive, with
+// their library campaigns and test cases, to the target instance's library:
+//   - A library assessment already in the target library (by name) is
+//     skipped, so running a restore again only adds what's missing.
+//   - Library test cases are created under their saved library id if the
+//     target lacks them, as CreateMissingTemplates does (see
+//     writeLibraryTestCases); existing ones are left untouched.
+//   - A library campaign already in the target library is reused as is,
+//     since library assessments share campaigns; missing ones are created.
+//
+// Everything it creates is recorded in optionalParams' journal, so
+// DeleteOnFailure and RollbackRestore can remove it again.
+//
+// Errors:
+//   - Returns ErrOrgNotFound if the target instance lacks an organization.
+//   - Returns ErrJournalMismatch if optionalParams' journal is not for a
+//     library restore.
+//   - Returns a wrapped error with additional context if any GraphQL query fails.
+func RestoreLibrary(ctx context.Context, client graphql.Client, lib *LibraryData, optionalParams *RestoreOptionalParams) (*LibraryReport, error) {
+	slog.InfoContext(ctx, "Starting RestoreLibrary", "assessment-count", len(lib.Assessments))
+	restoreInfo := NewVatOpMetadata(ctx)
+	if lib.Manifest.VectrVersion != "" && lib.Manifest.VectrVersion != restoreInfo.VectrVersion {
+		slog.WarnContext(ctx, "Save data does not match version you are loading into. The restore may not work correctly", "save-vectr-version", lib.Manifest.VectrVersion, "live-vectr-version", restoreInfo.VectrVersion)
+	}
+	journal := optionalParams.journal()
+	if journal.Db != "" || journal.SourceAssessmentName != "" {
+		return nil, fmt.Errorf("journal is for a restore of %s into %s, not a library restore: %w", journal.SourceAssessmentName, journal.Db, ErrJournalMismatch)
+	}
+	// Everything is in the library, which RollbackRestore handles the same
+	// way as for a restore AsTemplate.
+	journal.AsTemplate = true
+
+	report, err := restoreLibrary(ctx, client, lib, optionalParams)
+	if err != nil {
+		if optionalParams.DeleteOnFailure {
+			optionalParams.rollbackOnFailure(ctx, client)
+		}
+		return nil, err
+	}
+	journal.Complete = true
+	if err := optionalParams.checkpoint(ctx); err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "Library restored successfully", "created-count", len(report.Created), "skipped-count", len(report.Skipped))
+	return report, nil
+}
+
+func restoreLibrary(ctx context.Context, client graphql.Client, lib *LibraryData, optionalParams *RestoreOptionalParams) (*LibraryReport, error) {
+	report := &LibraryReport{}
+	journal := optionalParams.journal()
+	fail := func(what string, err error) (*LibraryReport, error) {
+		if gqlObject, ok := gqlErrParse(err); ok {
+			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+		}
+		return nil, fmt.Errorf("could not %s: %w", what, err)
+	}
+
+	org_map, err := validateRestorePrerequisites(ctx, client, "", lib.OrgMap)
+	if err != nil {
+		return nil, err
+	}
+	existingCampaigns, err := dao.ListLibraryCampaigns(ctx, client)
+	if err != nil {
+		return fail("fetch library campaigns", err)
+	}
+	campaignIds := make(map[string]string, len(existingCampaigns))
+	campaignTestCases := make(map[string][]string, len(existingCampaigns))
+	for _, c := range existingCampaigns {
+		campaignIds[c.Name] = c.Id
+		for _, tc := range c.TestCases {
+			campaignTestCases[c.Name] = append(campaignTestCases[c.Name], tc.LibraryTestCaseId)
+		}
+	}
+
+	for _, a := range lib.Assessments {
+		existing, err := dao.FindLibraryAssessment(ctx, client, a.Name)
+		if err != nil {
+			return fail(fmt.Sprintf("look up library assessment %s", a.Name), err)
+		}
+		if len(existing.LibraryAssessments.Nodes) > 0 {
+			slog.InfoContext(ctx, "Library assessment already exists, skipping it", "assessment-name", a.Name)
+			report.Skipped = append(report.Skipped, a.Name)
+			continue
+		}
+
+		var ids []string
+		for _, c := range a.Campaigns {
+			ids = append(ids, c.TestCases...)
+		}
+		if len(ids) > 0 {
+			if err := writeLibraryTestCases(ctx, client, "", a.Name, lib.TestCases, ids, false, optionalParams); err != nil {
+				return nil, err
+			}
+		}
+
+		assessment := dao.CreateAssessmentTemplateDataInput{
+			Description: a.Description,
+			KillChainId: a.KillChainId,
+			Metadata:    a.Metadata,
+		}
+		assessment.Name, assessment.TemplatePrefix = splitTemplatePrefix(a.Name, a.Metadata)
+		for _, o := range a.Organizations {
+			assessment.OrganizationIds = append(assessment.OrganizationIds, org_map[o].Id)
+		}
+		for _, c := range a.Campaigns {
+			if id, ok := campaignIds[c.Name]; ok {
+				if !slices.Contains(journal.Created.CampaignTemplates, id) && !slices.Contains(report.ReusedCampaigns, c.Name) {
+					slog.InfoContext(ctx, "Library campaign already exists, using it as is", "campaign", c.Name, "assessment-name", a.Name)
+					report.ReusedCampaigns = append(report.ReusedCampaigns, c.Name)
+					want := slices.Compact(slices.Sorted(slices.Values(c.TestCases)))
+					have := slices.Compact(slices.Sorted(slices.Values(campaignTestCases[c.Name])))
+					if !slices.Equal(want, have) {
+						slog.WarnContext(ctx, "Existing library campaign has different library test cases than the saved one, the library assessment uses the existing ones", "campaign", c.Name, "assessment-name", a.Name, "saved-test-case-ids", want, "existing-test-case-ids", have)
+						report.MismatchedCampaigns = append(report.MismatchedCampaigns, c.Name)
+					}
+				}
+				assessment.LibraryCampaignIds = append(assessment.LibraryCampaignIds, id)
+				continue
+			}
+			campaign := dao.CreateCampaignTemplateDataInput{
+				Description:        c.Description,
+				LibraryTestCaseIds: c.TestCases,
+				Metadata:           c.Metadata,
+			}
+			campaign.Name, campaign.TemplatePrefix = splitTemplatePrefix(c.Name, c.Metadata)
+			for _, o := range c.Organizations {
+				campaign.OrganizationIds = append(campaign.OrganizationIds, org_map[o].Id)
+			}
+			r, err := dao.CreateCampaignTemplates(ctx, client, dao.CreateCampaignTemplateInput{
+				Overwrite:            false,
+				CampaignTemplateData: []dao.CreateCampaignTemplateDataInput{campaign},
+			})
+			if err != nil {
+				return fail(fmt.Sprintf("create library campaign %s", c.Name), err)
+			}
+			if len(r.Campaign.CreateTemplate.Campaigns) != 1 {
+				return nil, fmt.Errorf("creating library campaign %s returned %d campaigns, want 1", c.Name, len(r.Campaign.CreateTemplate.Campaigns))
+			}
+			id := r.Campaign.CreateTemplate.Campaigns[0].Id
+			campaignIds[c.Name] = id
+			if err := optionalParams.recordCreated(ctx, &journal.Created.CampaignTemplates, id); err != nil {
+				return nil, err
+			}
+			assessment.LibraryCampaignIds = append(assessment.LibraryCampaignIds, id)
+		}
+
+		r, err := dao.CreateAssessmentTemplate(ctx, client, dao.CreateAssessmentTemplateInput{
+			Overwrite:              false,
+			AssessmentTemplateData: []dao.CreateAssessmentTemplateDataInput{assessment},
+		})
+		if err != nil {
+			return fail(fmt.Sprintf("create library assessment %s", a.Name), err)
+		}
+		if len(r.Assessment.CreateTemplate.Assessments) != 1 {
+			return nil, fmt.Errorf("creating library assessment %s returned %d assessments, want 1", a.Name, len(r.Assessment.CreateTemplate.Assessments))
+		}
+		if err := optionalParams.recordCreated(ctx, &journal.Created.AssessmentTemplates, r.Assessment.CreateTemplate.Assessments[0].Id); err != nil {
+			return nil, err
+		}
+		slog.InfoContext(ctx, "Library assessment created", "assessment-name", a.Name, "template-prefix", assessment.TemplatePrefix, "campaign-count", len(a.Campaigns))
+		report.Created = append(report.Created, a.Name)
+	}
+	return report, nil
+}
@@ -1,6 +1,7 @@
 package vat
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 	"time"
 
 	"sra/vat/internal/dao"
+	"sra/vat/internal/util"
 
 	"github.com/Khan/genqlient/graphql"
 	"github.com/google/uuid"
@@ -21,8 +23,16 @@ import (
 type RestoreOptionalParams struct {
 	AssessmentName             string // Set desired assessment name to this one, if blank, use existing assessment name
 	OverrideAssessmentTemplate bool   // Flag to override using the use of the existing template assessment. Directly import the tests instead (lower fidelty)
-	DeleteOnFailure            bool   // Flag to delete assessments if the campaign import failed
+	DeleteOnFailure            bool   // Flag to delete everything the restore created if it fails (see RollbackRestore)
 	ForceEnvOnly               bool   // FLag to ignore template test cases even if one exists in the source
+	// CreateMissingTemplates creates only the library test cases the target
+	// instance is missing, from the archive, leaving existing ones untouched
+	// (a middle way between OverrideAssessmentTemplate and ForceEnvOnly).
+	CreateMissingTemplates bool
+	// TemplateMatch says how test cases are linked to library test cases in
+	// the target instance; blank is TemplateMatchId. Anything else can't be
+	// combined with ForceEnvOnly or OverrideAssessmentTemplate.
+	TemplateMatch TemplateMatch
 	// ResetGlobalId mints a new globalId for the assessment being restored
 	// instead of reusing the one from the serialized data. VECTR rejects an
 	// assessment create when its globalId already exists in the target
@@ -30,13 +40,71 @@ type RestoreOptionalParams struct {
 	// into an instance that already holds a copy of it (e.g. under a
 	// different name) -- set this to land it as an independent copy.
 	ResetGlobalId bool
+	// Mode says what to do when the assessment is already in the target
+	// instance; blank is RestoreModeCreate. Only RestoreAssessment honours
+	// RestoreModeUpdate, and it can't be combined with ResetGlobalId.
+	Mode RestoreMode
+	// PreviousSync is the last sync's record of which target campaigns and
+	// test cases the source ones were written to (see SyncAssessment).
+	// RestoreModeUpdate matches on those ids first and, when the record has
+	// any, appends whatever it has no id for instead of matching it by name.
+	// Nil matches by name only.
+	PreviousSync *SyncRecord
+	// AsTemplate has RestoreAssessment create a library (template) assessment
+	// with its campaigns and library test cases from the archive instead of
+	// an assessment in db (see restoreAsTemplate). It can't be combined with
+	// RestoreModeUpdate.
+	AsTemplate bool
+	// Retest has RestoreAssessment restore the test plan without its results
+	// (see resetForRetest), recording the source globalId in the assessment's
+	// metadata under RetestOfMetadataKey. It can't be combined with
+	// RestoreModeUpdate.
+	Retest bool
+	// OrgMapping renames source organizations to target ones before the
+	// organizations are validated against the target instance. Nil restores
+	// organizations under their source names.
+	OrgMapping *util.OrgMapping
+	// DefenseToolMapping pins source defense tools to existing target tools
+	// (by id or name), bypassing automatic matching for the mapped tools.
+	DefenseToolMapping *util.DefenseToolMapping
+	// NoCreateDefenseTools forbids creating or modifying defense tools,
+	// products and layers in the target instance: every source tool must be
+	// mapped or match an existing tool, otherwise restore fails listing all
+	// of the unmatched tools before making any change.
+	NoCreateDefenseTools bool
+	// StrictDefenseToolMatch fails restore when a source tool matches more
+	// than one target tool or product, instead of picking one.
+	StrictDefenseToolMatch bool
+	// UpdateOutcomes has RestoreEnvironment write the archive's outcome
+	// report settings over the target's. Outcomes are instance-wide, so this
+	// changes them for every db; without it differences are only reported.
+	UpdateOutcomes bool
+	// TestCaseFilter restores only the test cases it selects; campaigns left
+	// with none are skipped. Nil restores every test case.
+	TestCaseFilter *util.TestCaseFilter
+	// Journal records what the restore writes to the target instance (see
+	// RestoreJournal). Pass the journal of an earlier, failed restore to
+	// resume it; nil starts a fresh one. Either way the journal in use is
+	// left here for the caller.
+	Journal *RestoreJournal
+	// JournalWriter persists Journal after every completed step. Nil keeps
+	// the journal in memory only.
+	JournalWriter JournalWriter
 }
 
 var ErrOrgNotFound = fmt.Errorf("could not find org(s)")
 var ErrMissingLibraryAssessment = fmt.Errorf("missing library assessment")
+
+// ErrMissingLibraryTestCases is returned when test cases refer to library
+// test cases the target instance doesn't have and the restore can't create.
+var ErrMissingLibraryTestCases = fmt.Errorf("missing library test cases")
 var ErrInvalidAssessmentName = fmt.Errorf("assessment name override is invalid (blank?)")
 var ErrAssessmentAlreadyExists = fmt.Errorf("assessment already exists")
 var ErrCampaignNotFound = fmt.Errorf("campaign not found")
+
+// ErrNoTestCasesSelected is returned when TestCaseFilter leaves nothing to
+// restore.
+var ErrNoTestCasesSelected = fmt.Errorf("no test cases match the test case filter")
 var ErrDuplicateGlobalId = fmt.Errorf("assessment globalId already exists in target instance, retry with --reset-id")
 
 // ErrIncompleteDefenseToolData is returned when a DefenseToolRef is missing
@@ -50,6 +118,18 @@ var ErrDuplicateGlobalId = fmt.Errorf("assessment globalId already exists in tar
 // stops instead.
 var ErrIncompleteDefenseToolData = fmt.Errorf("defense tool data is incomplete")
 
+// ErrUnmatchedDefenseTools is returned when NoCreateDefenseTools is set and
+// one or more source defense tools have no map entry and no existing match.
+var ErrUnmatchedDefenseTools = fmt.Errorf("defense tool(s) have no match in the target instance")
+
+// ErrAmbiguousDefenseToolMatch is returned when StrictDefenseToolMatch is set
+// and a source defense tool matches more than one target tool or product.
+var ErrAmbiguousDefenseToolMatch = fmt.Errorf("defense tool(s) match more than one target")
+
+// ErrDefenseToolMapTargetNotFound is returned when a DefenseToolMapping entry
+// points at a tool id or name that doesn't exist in the target instance.
+var ErrDefenseToolMapTargetNotFound = fmt.Errorf("defense tool map target not found")
+
 // executorMap maps automation executor types (e.g., "powershell") to their corresponding internal representation.
 // The read part of the API does not return an ENUM or fixed type, just a generic string. This maps it back
 // to the object type
@@ -104,58 +184,150 @@ func NewGroupedCreateTestCaseWithLibraryIdInput(db, campaignId string) *GroupedC
 	return &GroupedCreateTestCaseWithLibraryIdInput{
 		db:         db,
 		campaignId: campaignId,
-		groups:     make(map[string][]dao.CreateTestCaseDataWithLibraryIdInput),
 	}
 }
 
 // GroupedCreateTestCaseWithLibraryIdInput splits queued test case inserts
 // into batches, so a single CreateTestCasesByLibraryId request never contains
-// the same libraryTestCaseId twice (VECTR rejects such a request).
+// the same libraryTestCaseId twice (VECTR rejects such a request). Entries
+// keep the order they were added in: VECTR lists test cases in creation
+// order, so that's the order the restored campaign reads in.
 type GroupedCreateTestCaseWithLibraryIdInput struct {
 	db, campaignId string
-	groups         map[string][]dao.CreateTestCaseDataWithLibraryIdInput
+	entries        []dao.CreateTestCaseDataWithLibraryIdInput
 }
 
 func (g *GroupedCreateTestCaseWithLibraryIdInput) Add(tcd dao.CreateTestCaseDataWithLibraryIdInput) {
-	g.groups[tcd.LibraryTestCaseId] = append(g.groups[tcd.LibraryTestCaseId], tcd)
+	g.entries = append(g.entries, tcd)
 }
 
 func (g *GroupedCreateTestCaseWithLibraryIdInput) Len() int {
-	size := 0
-	for _, tcs := range g.groups {
-		size += len(tcs)
-	}
-	return size
+	return len(g.entries)
 }
 
-// GenerateInsertsData spreads each libraryTestCaseId group's entries across
-// batches by position, so batch i never contains two entries sharing a
-// libraryTestCaseId.
+// GenerateInsertsData cuts the queued entries into consecutive batches,
+// starting a new batch whenever the next entry's libraryTestCaseId is already
+// in the current one, so batch i never contains two entries sharing a
+// libraryTestCaseId and the batches, written in order, preserve the order the
+// entries were added in.
 func (g *GroupedCreateTestCaseWithLibraryIdInput) GenerateInsertsData() []dao.CreateTestCaseMatchByLibraryIdInput {
-	maxSize := 0
-	for _, entries := range g.groups {
-		if len(entries) > maxSize {
-			maxSize = len(entries)
+	if len(g.entries) == 0 {
+		return nil
+	}
+
+	var batches []dao.CreateTestCaseMatchByLibraryIdInput
+	var inBatch map[string]bool
+	for _, e := range g.entries {
+		if len(batches) == 0 || inBatch[e.LibraryTestCaseId] {
+			batches = append(batches, dao.CreateTestCaseMatchByLibraryIdInput{
+				Db:                         g.db,
+				CampaignId:                 g.campaignId,
+				SuppressAutoTimelineEvents: true,
+			})
+			inBatch = make(map[string]bool)
 		}
+		inBatch[e.LibraryTestCaseId] = true
+		last := &batches[len(batches)-1]
+		last.CreateTestCaseInputs = append(last.CreateTestCaseInputs, e)
 	}
-	if maxSize == 0 {
+	return batches
+}
+
+// applyOrgMapping renames the organizations in ad according to mapping,
+// before validateRestorePrerequisites looks them up in the target instance.
+// Explicit entries always apply; the fallback applies only to a source
+// organization with no entry of its own that doesn't exist in the target
+// instance. The assessment, campaign, test case and library test case
+// organizations are all rewritten, and ad.OrgMap is re-keyed by target name.
+//
+// Every substitution is logged, so the output of a restore lists exactly
+// which organizations were swapped and why.
+func applyOrgMapping(ctx context.Context, client graphql.Client, db string, ad *AssessmentData, mapping *util.OrgMapping) error {
+	if mapping == nil {
 		return nil
 	}
 
-	batches := make([]dao.CreateTestCaseMatchByLibraryIdInput, maxSize)
-	for i := range batches {
-		batches[i] = dao.CreateTestCaseMatchByLibraryIdInput{
-			Db:                         g.db,
-			CampaignId:                 g.campaignId,
-			SuppressAutoTimelineEvents: true,
+	subst := make(map[string]string)
+	for _, name := range slices.Sorted(maps.Keys(ad.OrgMap)) {
+		if target, ok := mapping.Target(name); ok {
+			if target != name {
+				subst[name] = target
+				slog.InfoContext(ctx, "organization substitution", "db", db, "source-org", name, "target-org", target, "via", "org-map entry")
+			}
+			continue
+		}
+		fallback := mapping.Fallback()
+		if fallback == "" || fallback == name {
+			continue
+		}
+		r, err := dao.FindOrganization(ctx, client, name)
+		if err != nil {
+			if gqlObject, ok := gqlErrParse(err); ok {
+				slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+			}
+			return fmt.Errorf("could not fetch organization: %s: %w", name, err)
 		}
+		if len(r.Organizations.Nodes) == 0 {
+			subst[name] = fallback
+			slog.InfoContext(ctx, "organization substitution", "db", db, "source-org", name, "target-org", fallback, "via", "org-map fallback")
+		}
+	}
+	slog.InfoContext(ctx, "organization mapping applied", "db", db, "organization-count", len(ad.OrgMap), "substitution-count", len(subst))
+	if len(subst) == 0 {
+		return nil
 	}
-	for _, entries := range g.groups {
-		for i, e := range entries {
-			batches[i].CreateTestCaseInputs = append(batches[i].CreateTestCaseInputs, e)
+
+	orgMap := make(map[string]dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentOrganizationsOrganization, len(ad.OrgMap))
+	for name, org := range ad.OrgMap {
+		if target, ok := subst[name]; ok {
+			org.Name = target
 		}
+		orgMap[org.Name] = org
 	}
-	return batches
+	ad.OrgMap = orgMap
+
+	ad.Assessment.Organizations = renameOrgs(ad.Assessment.Organizations, func(o *dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentOrganizationsOrganization) *string {
+		return &o.Name
+	}, subst)
+	for ci := range ad.Assessment.Campaigns {
+		c := &ad.Assessment.Campaigns[ci]
+		c.Organizations = renameOrgs(c.Organizations, func(o *dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignOrganizationsOrganization) *string {
+			return &o.Name
+		}, subst)
+		for ti := range c.TestCases {
+			tc := &c.TestCases[ti]
+			tc.Organizations = renameOrgs(tc.Organizations, func(o *dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCaseOrganizationsOrganization) *string {
+				return &o.Name
+			}, subst)
+		}
+	}
+	for id, ltc := range ad.LibraryTestCases {
+		ltc.Organizations = renameOrgs(ltc.Organizations, func(o *dao.GetLibraryTestCasesLibraryTestcasesByIdsTestCaseConnectionNodesTestCaseOrganizationsOrganization) *string {
+			return &o.Name
+		}, subst)
+		ad.LibraryTestCases[id] = ltc
+	}
+	return nil
+}
+
+// renameOrgs applies subst to the organization names in orgs (name returns
+// a pointer to an org's name field) and drops any org that ends up a
+// duplicate, which happens when two source orgs map to the same target.
+func renameOrgs[T any](orgs []T, name func(*T) *string, subst map[string]string) []T {
+	seen := make(map[string]bool, len(orgs))
+	out := orgs[:0]
+	for _, o := range orgs {
+		n := name(&o)
+		if target, ok := subst[*n]; ok {
+			*n = target
+		}
+		if seen[*n] {
+			continue
+		}
+		seen[*n] = true
+		out = append(out, o)
+	}
+	return out
 }
 
 // validateRestorePrerequisites checks if organizations required for the
@@ -256,25 +428,32 @@ func validateDefenseToolRefs(toolsToReconcile map[string]DefenseToolRef) error {
 // product, active, layers) to coexist; when that happens vat can't tell
 // them apart and deterministically picks the more recently updated one
 // (falling back to more recently created) -- a documented limitation, not a
-// bug.
-func reconcileDefenseTools(ctx context.Context, client graphql.Client, db string, toolsToReconcile map[string]DefenseToolRef) (map[string]string, error) {
+// bug. optionalParams.StrictDefenseToolMatch turns that guess into an error.
+//
+// Before anything is created, planDefenseToolMatches settles the refs that
+// don't need automatic matching (DefenseToolMapping entries, and every ref
+// under NoCreateDefenseTools) and rejects the run if any of them can't be
+// settled, so those policies never leave the target half-modified.
+func reconcileDefenseTools(ctx context.Context, client graphql.Client, db string, toolsToReconcile map[string]DefenseToolRef, optionalParams *RestoreOptionalParams) (map[string]string, error) {
 	slog.InfoContext(ctx, "Starting defense tool reconciliation", "db", db, "tool_count", len(toolsToReconcile))
 
 	if err := validateDefenseToolRefs(toolsToReconcile); err != nil {
 		return nil, err
 	}
 
-	existingTools, err := dao.GetAllDefenseTools(ctx, client, db)
+	existingTools, err := dao.ListDefenseTools(ctx, client, db)
 	if err != nil {
 		if gqlObject, ok := gqlErrParse(err); ok {
 			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
 		}
 		return nil, fmt.Errorf("could not fetch tools: %w", err)
 	}
-	toolsByKey := make(map[string]dao.GetAllDefenseToolsBluetoolsBlueToolConnectionNodesBlueTool, len(existingTools.Bluetools.Nodes))
-	for _, t := range existingTools.Bluetools.Nodes {
+	toolsByKey := make(map[string]dao.GetAllDefenseToolsBluetoolsBlueToolConnectionNodesBlueTool, len(existingTools))
+	duplicateToolKeys := make(map[string]bool)
+	for _, t := range existingTools {
 		key := defenseToolKey(t.Name, t.DefenseToolProduct.Id, t.Active)
 		if prev, ok := toolsByKey[key]; ok {
+			duplicateToolKeys[key] = true
 			slog.WarnContext(ctx, "target instance has more than one defense tool with the same name+product+active; picking the more recently updated one", "db", db, "tool-name", t.Name)
 			if t.UpdateTime < prev.UpdateTime || (t.UpdateTime == prev.UpdateTime && t.CreateTime <= prev.CreateTime) {
 				continue // prev is newer (or equally new) -- keep it
@@ -283,71 +462,57 @@ func reconcileDefenseTools(ctx context.Context, client graphql.Client, db string
 		toolsByKey[key] = t
 	}
 
-	existingProductsResp, err := dao.GetAllDefenseToolProducts(ctx, client)
+	existingProducts, err := dao.ListDefenseToolProducts(ctx, client)
 	if err != nil {
 		if gqlObject, ok := gqlErrParse(err); ok {
 			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
 		}
 		return nil, fmt.Errorf("could not fetch defense tool products: %w", err)
 	}
-	productsByRef := make(map[string]dao.GetAllDefenseToolProductsDefenseToolProductsDefenseToolProductsConnectionNodesDefenseToolProduct, len(existingProductsResp.DefenseToolProducts.Nodes))
-	// productsByName is the name fallback used when a source ref doesn't
-	// match anything on the target (see resolveOrCreateDefenseToolProduct).
-	// VECTR doesn't enforce unique product names, and this index is
-	// case-insensitive on top of that, so two products can collapse onto one
-	// entry; the last one seen wins, which is an arbitrary choice among
-	// equals. Warn when it happens rather than resolving silently: if the
-	// existing target tool hangs off the product that lost, its tool key
-	// won't match and vat will create a second, near-identical tool next to
-	// it. Same known limitation as the duplicate-tool case below, and it
-	// converges the same way -- a later restore sees both tools under one key
-	// and picks the more recently updated one -- but the duplicate stays in
-	// the target until someone cleans it up. Products matched by ref are
-	// unaffected: that path is checked first and refs are unique per
-	// instance.
-	productsByName := make(map[string]dao.GetAllDefenseToolProductsDefenseToolProductsDefenseToolProductsConnectionNodesDefenseToolProduct, len(existingProductsResp.DefenseToolProducts.Nodes))
-	for _, p := range existingProductsResp.DefenseToolProducts.Nodes {
-		productsByRef[p.Ref] = p
-		nameKey := strings.ToLower(p.Name)
-		if prev, ok := productsByName[nameKey]; ok {
-			slog.WarnContext(ctx, "target instance has more than one defense tool product with the same name (case-insensitively); the name fallback can only resolve to one of them, which may create a duplicate defense tool",
-				"product-name", p.Name, "kept-product-id", p.Id, "kept-product-ref", p.Ref, "ignored-product-id", prev.Id, "ignored-product-ref", prev.Ref)
-		}
-		productsByName[nameKey] = p
+	productsByRef, productsByName, duplicateProductNames := indexDefenseToolProducts(ctx, existingProducts)
+
+	result, err := planDefenseToolMatches(ctx, db, toolsToReconcile, existingTools, toolsByKey, duplicateToolKeys, productsByRef, productsByName, duplicateProductNames, optionalParams)
+	if err != nil {
+		return nil, err
+	}
+	if optionalParams.NoCreateDefenseTools {
+		return result, nil
 	}
 
-	existingLayersResp, err := dao.GetAllDefensiveLayers(ctx, client, db)
+	existingLayers, err := dao.ListDefensiveLayers(ctx, client, db)
 	if err != nil {
 		if gqlObject, ok := gqlErrParse(err); ok {
 			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
 		}
 		return nil, fmt.Errorf("could not fetch defensive layers: %w", err)
 	}
-	layersByName := make(map[string]dao.GetAllDefensiveLayersDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer, len(existingLayersResp.Defensivelayers.Nodes))
-	for _, l := range existingLayersResp.Defensivelayers.Nodes {
+	layersByName := make(map[string]dao.GetAllDefensiveLayersDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer, len(existingLayers))
+	for _, l := range existingLayers {
 		layersByName[strings.ToLower(l.Name)] = l
 	}
 
-	existingLibraryLayersResp, err := dao.GetAllLibraryDefensiveLayers(ctx, client)
+	existingLibraryLayers, err := dao.ListLibraryDefensiveLayers(ctx, client)
 	if err != nil {
 		if gqlObject, ok := gqlErrParse(err); ok {
 			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
 		}
 		return nil, fmt.Errorf("could not fetch library defensive layers: %w", err)
 	}
-	libraryLayersByName := make(map[string]dao.GetAllLibraryDefensiveLayersLibraryDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer, len(existingLibraryLayersResp.LibraryDefensivelayers.Nodes))
-	for _, l := range existingLibraryLayersResp.LibraryDefensivelayers.Nodes {
+	libraryLayersByName := make(map[string]dao.GetAllLibraryDefensiveLayersLibraryDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer, len(existingLibraryLayers))
+	for _, l := range existingLibraryLayers {
 		libraryLayersByName[strings.ToLower(l.Name)] = l
 	}
 
-	result := make(map[string]string, len(toolsToReconcile))
 	for key, ref := range toolsToReconcile {
+		if _, ok := result[key]; ok {
+			continue // settled by planDefenseToolMatches
+		}
 		if len(ref.Layers) == 0 {
 			slog.WarnContext(ctx, "defense tool has no defense layers; assigning placeholder layer so it can still be created -- review and reassign the correct layer(s)", "db", db, "tool-name", ref.Name, "placeholder-layer", PLACEHOLDER_DEFENSE_LAYER_NAME)
 			ref.Layers = []string{PLACEHOLDER_DEFENSE_LAYER_NAME}
 		}
 
-		product, err := resolveOrCreateDefenseToolProduct(ctx, client, ref.Product, productsByRef, productsByName, libraryLayersByName)
+		product, err := resolveOrCreateDefenseToolProduct(ctx, client, ref.Product, productsByRef, productsByName, libraryLayersByName, optionalParams)
 		if err != nil {
 			return nil, err
 		}
@@ -355,7 +520,7 @@ func reconcileDefenseTools(ctx context.Context, client graphql.Client, db string
 		targetKey := defenseToolKey(ref.Name, product.Id, ref.Active)
 		if existing, ok := toolsByKey[targetKey]; ok {
 			slog.DebugContext(ctx, "defense tool matched existing", "tool-name", ref.Name, "product-id", product.Id, "active", ref.Active, "target-tool-id", existing.Id)
-			id, err := reconcileExistingDefenseTool(ctx, client, db, existing, ref, layersByName, libraryLayersByName)
+			id, err := reconcileExistingDefenseTool(ctx, client, db, existing, ref, layersByName, libraryLayersByName, optionalParams)
 			if err != nil {
 				return nil, err
 			}
@@ -364,7 +529,7 @@ func reconcileDefenseTools(ctx context.Context, client graphql.Client, db string
 		}
 		slog.DebugContext(ctx, "defense tool has no existing match, resolving layers to create it", "tool-name", ref.Name, "product-id", product.Id, "active", ref.Active)
 
-		layerIds, err := resolveOrCreateDefenseLayerIds(ctx, client, db, ref.Layers, layersByName, libraryLayersByName)
+		layerIds, err := resolveOrCreateDefenseLayerIds(ctx, client, db, ref.Layers, layersByName, libraryLayersByName, optionalParams)
 		if err != nil {
 			return nil, err
 		}
@@ -390,6 +555,9 @@ func reconcileDefenseTools(ctx context.Context, client graphql.Client, db string
 		}
 		created := r.DefenseTool.Create.DefenseTools[0]
 		slog.DebugContext(ctx, "defense tool created", "tool-name", ref.Name, "product-id", product.Id, "target-tool-id", created.Id, "layer-ids", layerIds)
+		if err := optionalParams.recordCreated(ctx, &optionalParams.journal().Created.DefenseTools, created.Id); err != nil {
+			return nil, err
+		}
 		result[key] = created.Id
 
 		// Fold the new tool into toolsByKey so a later ref in this same run
@@ -424,32 +592,58 @@ func reconcileDefenseTools(ctx context.Context, client graphql.Client, db string
 	return result, nil
 }
 
+// indexDefenseToolProducts indexes the target instance's defense tool
+// products by ref and by case-insensitive name, and reports the names more
+// than one product shares.
+func indexDefenseToolProducts(ctx context.Context, existingProducts []dao.GetAllDefenseToolProductsDefenseToolProductsDefenseToolProductsConnectionNodesDefenseToolProduct) (productsByRef, productsByName map[string]dao.GetAllDefenseToolProductsDefenseToolProductsDefenseToolProductsConnectionNodesDefenseToolProduct, duplicateProductNames map[string]bool) {
+	productsByRef = make(map[string]dao.GetAllDefenseToolProductsDefenseToolProductsDefenseToolProductsConnectionNodesDefenseToolProduct, len(existingProducts))
+	// productsByName is the name fallback used when a source ref doesn't
+	// match anything on the target (see resolveOrCreateDefenseToolProduct).
+	// VECTR doesn't enforce unique product names, and this index is
+	// case-insensitive on top of that, so two products can collapse onto one
+	// entry; the last one seen wins, which is an arbitrary choice among
+	// equals. Warn when it happens rather than resolving silently: if the
+	// existing target tool hangs off the product that lost, its tool key
+	// won't match and vat will create a second, near-identical tool next to
+	// it. Same known limitation as reconcileDefenseTools' duplicate-tool case, and it
+	// converges the same way -- a later restore sees both tools under one key
+	// and picks the more recently updated one -- but the duplicate stays in
+	// the target until someone cleans it up. Products matched by ref are
+	// unaffected: that path is checked first and refs are unique per
+	// instance.
+	productsByName = make(map[string]dao.GetAllDefenseToolProductsDefenseToolProductsDefenseToolProductsConnectionNodesDefenseToolProduct, len(existingProducts))
+	duplicateProductNames = make(map[string]bool)
+	for _, p := range existingProducts {
+		productsByRef[p.Ref] = p
+		nameKey := strings.ToLower(p.Name)
+		if prev, ok := productsByName[nameKey]; ok {
+			duplicateProductNames[nameKey] = true
+			slog.WarnContext(ctx, "target instance has more than one defense tool product with the same name (case-insensitively); the name fallback can only resolve to one of them, which may create a duplicate defense tool",
+				"product-name", p.Name, "kept-product-id", p.Id, "kept-product-ref", p.Ref, "ignored-product-id", prev.Id, "ignored-product-ref", prev.Ref)
+		}
+		productsByName[nameKey] = p
+	}
+	return productsByRef, productsByName, duplicateProductNames
+}
+
 // reconcileExistingDefenseTool adds any defense layers ref has that existing
 // lacks (creating layers as needed), leaving name/description/product/active
 // untouched -- those aren't part of the match criteria (see DefenseToolRef's
 // doc comment) so they're never overwritten on an already-matching tool.
-func reconcileExistingDefenseTool(ctx context.Context, client graphql.Client, db string, existing dao.GetAllDefenseToolsBluetoolsBlueToolConnectionNodesBlueTool, ref DefenseToolRef, layersByName map[string]dao.GetAllDefensiveLayersDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer, libraryLayersByName map[string]dao.GetAllLibraryDefensiveLayersLibraryDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer) (string, error) {
-	have := make(map[string]bool, len(existing.DefensiveLayers))
-	layerIds := make([]string, 0, len(existing.DefensiveLayers))
-	for _, l := range existing.DefensiveLayers {
-		have[strings.ToLower(l.Name)] = true
-		layerIds = append(layerIds, l.Id)
-	}
-
-	var missing []string
-	for _, name := range ref.Layers {
-		if !have[strings.ToLower(name)] {
-			missing = append(missing, name)
-		}
-	}
+func reconcileExistingDefenseTool(ctx context.Context, client graphql.Client, db string, existing dao.GetAllDefenseToolsBluetoolsBlueToolConnectionNodesBlueTool, ref DefenseToolRef, layersByName map[string]dao.GetAllDefensiveLayersDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer, libraryLayersByName map[string]dao.GetAllLibraryDefensiveLayersLibraryDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer, optionalParams *RestoreOptionalParams) (string, error) {
+	missing := missingDefenseLayers(existing, ref)
 	if len(missing) == 0 {
 		return existing.Id, nil
 	}
 
-	newIds, err := resolveOrCreateDefenseLayerIds(ctx, client, db, missing, layersByName, libraryLayersByName)
+	newIds, err := resolveOrCreateDefenseLayerIds(ctx, client, db, missing, layersByName, libraryLayersByName, optionalParams)
 	if err != nil {
 		return "", err
 	}
+	layerIds := make([]string, 0, len(existing.DefensiveLayers)+len(newIds))
+	for _, l := range existing.DefensiveLayers {
+		layerIds = append(layerIds, l.Id)
+	}
 	layerIds = append(layerIds, newIds...)
 
 	r, err := dao.UpdateDefenseTool(ctx, client, dao.UpdateDefenseToolInput{
@@ -488,7 +682,7 @@ func reconcileExistingDefenseTool(ctx context.Context, client graphql.Client, db
 // libraryLayersByName/resolveOrCreateLibraryDefenseLayerIds, reusing one that
 // already exists) and clones that into the db scope, which is the supported
 // path regardless of whether the library layer pre-existed.
-func resolveOrCreateDefenseLayerIds(ctx context.Context, client graphql.Client, db string, names []string, layersByName map[string]dao.GetAllDefensiveLayersDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer, libraryLayersByName map[string]dao.GetAllLibraryDefensiveLayersLibraryDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer) ([]string, error) {
+func resolveOrCreateDefenseLayerIds(ctx context.Context, client graphql.Client, db string, names []string, layersByName map[string]dao.GetAllDefensiveLayersDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer, libraryLayersByName map[string]dao.GetAllLibraryDefensiveLayersLibraryDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer, optionalParams *RestoreOptionalParams) ([]string, error) {
 	ids := make([]string, 0, len(names))
 	for _, name := range names {
 		key := strings.ToLower(name)
@@ -498,7 +692,7 @@ func resolveOrCreateDefenseLayerIds(ctx context.Context, client graphql.Client,
 			continue
 		}
 
-		libraryLayerIds, err := resolveOrCreateLibraryDefenseLayerIds(ctx, client, []DefenseLayer{{Name: name}}, libraryLayersByName)
+		libraryLayerIds, err := resolveOrCreateLibraryDefenseLayerIds(ctx, client, []DefenseLayer{{Name: name}}, libraryLayersByName, optionalParams)
 		if err != nil {
 			return nil, fmt.Errorf("could not resolve library defense layer to clone for defense layer %q: %w", name, err)
 		}
@@ -518,6 +712,9 @@ func resolveOrCreateDefenseLayerIds(ctx context.Context, client graphql.Client,
 		}
 		created := r.DefenseLayer.Clone.DefenseLayers[0]
 		slog.DebugContext(ctx, "defense layer created", "layer-name", name, "target-layer-id", created.Id, "library-layer-id", libraryLayerIds[0])
+		if err := optionalParams.recordCreated(ctx, &optionalParams.journal().Created.DefenseLayers, created.Id); err != nil {
+			return nil, err
+		}
 		layersByName[key] = dao.GetAllDefensiveLayersDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer{Id: created.Id, Name: created.Name}
 		ids = append(ids, created.Id)
 	}
@@ -535,7 +732,7 @@ func resolveOrCreateDefenseLayerIds(ctx context.Context, client graphql.Client,
 // DefenseTool (see resolveOrCreateDefenseLayerIds) -- they live in their own
 // id space even when names collide, so they're resolved and cached
 // separately rather than sharing layersByName.
-func resolveOrCreateLibraryDefenseLayerIds(ctx context.Context, client graphql.Client, layers []DefenseLayer, libraryLayersByName map[string]dao.GetAllLibraryDefensiveLayersLibraryDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer) ([]string, error) {
+func resolveOrCreateLibraryDefenseLayerIds(ctx context.Context, client graphql.Client, layers []DefenseLayer, libraryLayersByName map[string]dao.GetAllLibraryDefensiveLayersLibraryDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer, optionalParams *RestoreOptionalParams) ([]string, error) {
 	ids := make([]string, 0, len(layers))
 	for _, layer := range layers {
 		key := strings.ToLower(layer.Name)
@@ -558,12 +755,168 @@ func resolveOrCreateLibraryDefenseLayerIds(ctx context.Context, client graphql.C
 		}
 		created := r.DefenseLayer.CreateLibrary.DefenseLayers[0]
 		slog.DebugContext(ctx, "library defense layer created", "layer-name", layer.Name, "target-layer-id", created.Id)
+		if err := optionalParams.recordCreated(ctx, &optionalParams.journal().Created.LibraryDefenseLayers, created.Id); err != nil {
+			return nil, err
+		}
 		libraryLayersByName[key] = dao.GetAllLibraryDefensiveLayersLibraryDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer{Id: created.Id, Name: created.Name}
 		ids = append(ids, created.Id)
 	}
 	return ids, nil
 }
 
+// findDefenseToolProduct looks ref's product up on the target without
+// creating anything: by ref first, then by case-insensitive name (see
+// resolveOrCreateDefenseToolProduct for why). byName reports which of the two
+// matched.
+func findDefenseToolProduct(ref DefenseToolProductRef, productsByRef map[string]dao.GetAllDefenseToolProductsDefenseToolProductsDefenseToolProductsConnectionNodesDefenseToolProduct, productsByName map[string]dao.GetAllDefenseToolProductsDefenseToolProductsDefenseToolProductsConnectionNodesDefenseToolProduct) (product dao.GetAllDefenseToolProductsDefenseToolProductsDefenseToolProductsConnectionNodesDefenseToolProduct, byName bool, ok bool) {
+	if p, ok := productsByRef[ref.Ref]; ok {
+		return p, false, true
+	}
+	if p, ok := productsByName[strings.ToLower(ref.Name)]; ok {
+		return p, true, true
+	}
+	return product, false, false
+}
+
+// planDefenseToolMatches settles, without changing anything in the target
+// instance, every ref in toolsToReconcile that reconcileDefenseTools must not
+// match automatically, and checks the policies in optionalParams up front:
+//   - A ref with a DefenseToolMapping entry resolves to the mapped tool, by
+//     id first and then by name.
+//   - Under StrictDefenseToolMatch, a ref whose product only matches by a
+//     duplicated name, or whose tool key is held by more than one target
+//     tool, is ambiguous.
+//   - Under NoCreateDefenseTools, every other ref must match an existing
+//     tool; it's reused as-is, without adding any layers it's missing.
+//
+// The returned map holds the settled refs' target tool ids, keyed by
+// DefenseToolRef.Key(). All problems are collected and returned together, so
+// one run reports every unmatched or ambiguous tool rather than the first.
+func planDefenseToolMatches(
+	ctx context.Context,
+	db string,
+	toolsToReconcile map[string]DefenseToolRef,
+	existingTools []dao.GetAllDefenseToolsBluetoolsBlueToolConnectionNodesBlueTool,
+	toolsByKey map[string]dao.GetAllDefenseToolsBluetoolsBlueToolConnectionNodesBlueTool,
+	duplicateToolKeys map[string]bool,
+	productsByRef map[string]dao.GetAllDefenseToolProductsDefenseToolProductsDefenseToolProductsConnectionNodesDefenseToolProduct,
+	productsByName map[string]dao.GetAllDefenseToolProductsDefenseToolProductsDefenseToolProductsConnectionNodesDefenseToolProduct,
+	duplicateProductNames map[string]bool,
+	optionalParams *RestoreOptionalParams,
+) (map[string]string, error) {
+	result := make(map[string]string, len(toolsToReconcile))
+	var notFound, ambiguous, unmatched []string
+
+	for _, key := range slices.Sorted(maps.Keys(toolsToReconcile)) {
+		ref := toolsToReconcile[key]
+		describe := fmt.Sprintf("%q (product %q, ref %q, active %t)", ref.Name, ref.Product.Name, ref.Product.Ref, ref.Active)
+
+		if optionalParams.DefenseToolMapping != nil {
+			if target, ok := optionalParams.DefenseToolMapping.Target(ref.Name, ref.Product.Ref, ref.Active); ok {
+				id, matches := findMappedDefenseTool(target, existingTools)
+				switch {
+				case matches == 0:
+					notFound = append(notFound, fmt.Sprintf("%s -> %q", describe, target))
+				case matches > 1 && optionalParams.StrictDefenseToolMatch:
+					ambiguous = append(ambiguous, fmt.Sprintf("%s -> %q matches %d target tools by name", describe, target, matches))
+				default:
+					if matches > 1 {
+						slog.WarnContext(ctx, "defense tool map target names more than one tool in the target instance; picking the more recently updated one", "db", db, "tool-name", ref.Name, "map-target", target, "target-tool-id", id)
+					}
+					slog.InfoContext(ctx, "defense tool mapped", "db", db, "tool-name", ref.Name, "product-ref", ref.Product.Ref, "active", ref.Active, "map-target", target, "target-tool-id", id)
+					result[key] = id
+				}
+				continue
+			}
+		}
+
+		if !optionalParams.StrictDefenseToolMatch && !optionalParams.NoCreateDefenseTools {
+			continue
+		}
+		product, byName, ok := findDefenseToolProduct(ref.Product, productsByRef, productsByName)
+		if ok && byName && duplicateProductNames[strings.ToLower(ref.Product.Name)] && optionalParams.StrictDefenseToolMatch {
+			ambiguous = append(ambiguous, fmt.Sprintf("%s matches more than one target product named %q", describe, ref.Product.Name))
+			continue
+		}
+		var existing dao.GetAllDefenseToolsBluetoolsBlueToolConnectionNodesBlueTool
+		if ok {
+			targetKey := defenseToolKey(ref.Name, product.Id, ref.Active)
+			if duplicateToolKeys[targetKey] && optionalParams.StrictDefenseToolMatch {
+				ambiguous = append(ambiguous, fmt.Sprintf("%s matches more than one target tool", describe))
+				continue
+			}
+			existing, ok = toolsByKey[targetKey]
+		}
+		if !optionalParams.NoCreateDefenseTools {
+			continue
+		}
+		if !ok {
+			unmatched = append(unmatched, describe)
+			continue
+		}
+		if missing := missingDefenseLayers(existing, ref); len(missing) > 0 {
+			slog.WarnContext(ctx, "defense tool matched existing but lacks some of the source's defense layers; not adding them because defense tool creation is disabled", "db", db, "tool-name", ref.Name, "target-tool-id", existing.Id, "missing-layers", missing)
+		}
+		result[key] = existing.Id
+	}
+
+	var errs []error
+	if len(notFound) > 0 {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrDefenseToolMapTargetNotFound, strings.Join(notFound, "; ")))
+	}
+	if len(ambiguous) > 0 {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrAmbiguousDefenseToolMatch, strings.Join(ambiguous, "; ")))
+	}
+	if len(unmatched) > 0 {
+		errs = append(errs, fmt.Errorf("%w and defense tool creation is disabled: %s", ErrUnmatchedDefenseTools, strings.Join(unmatched, "; ")))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return result, nil
+}
+
+// findMappedDefenseTool resolves a DefenseToolMapping target, which is a tool
+// id or a tool name, against the target instance's tools. It returns the
+// matched tool's id and how many tools matched: an id match is always
+// unique; for a name shared by several tools the more recently updated one
+// is returned, the same tiebreak reconcileDefenseTools uses.
+func findMappedDefenseTool(target string, existingTools []dao.GetAllDefenseToolsBluetoolsBlueToolConnectionNodesBlueTool) (string, int) {
+	for _, t := range existingTools {
+		if t.Id == target {
+			return t.Id, 1
+		}
+	}
+	var best dao.GetAllDefenseToolsBluetoolsBlueToolConnectionNodesBlueTool
+	matches := 0
+	for _, t := range existingTools {
+		if !strings.EqualFold(t.Name, target) {
+			continue
+		}
+		if matches == 0 || t.UpdateTime > best.UpdateTime || (t.UpdateTime == best.UpdateTime && t.CreateTime > best.CreateTime) {
+			best = t
+		}
+		matches++
+	}
+	return best.Id, matches
+}
+
+// missingDefenseLayers returns the layer names ref has that existing lacks
+// (case-insensitive).
+func missingDefenseLayers(existing dao.GetAllDefenseToolsBluetoolsBlueToolConnectionNodesBlueTool, ref DefenseToolRef) []string {
+	have := make(map[string]bool, len(existing.DefensiveLayers))
+	for _, l := range existing.DefensiveLayers {
+		have[strings.ToLower(l.Name)] = true
+	}
+	var missing []string
+	for _, name := range ref.Layers {
+		if !have[strings.ToLower(name)] {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
 // resolveOrCreateDefenseToolProduct finds ref's matching product, creating
 // it (and resolving its vendor by name and library defense layers, if any)
 // if absent. productsByRef and productsByName are updated in place with
@@ -580,14 +933,14 @@ func resolveOrCreateLibraryDefenseLayerIds(ctx context.Context, client graphql.C
 // If a product already exists (by either match), it's taken as-is -- its
 // layers are never diffed or backfilled here (unlike
 // reconcileExistingDefenseTool's handling of a tool's own db-scoped layers).
-func resolveOrCreateDefenseToolProduct(ctx context.Context, client graphql.Client, ref DefenseToolProductRef, productsByRef map[string]dao.GetAllDefenseToolProductsDefenseToolProductsDefenseToolProductsConnectionNodesDefenseToolProduct, productsByName map[string]dao.GetAllDefenseToolProductsDefenseToolProductsDefenseToolProductsConnectionNodesDefenseToolProduct, libraryLayersByName map[string]dao.GetAllLibraryDefensiveLayersLibraryDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer) (dao.GetAllDefenseToolProductsDefenseToolProductsDefenseToolProductsConnectionNodesDefenseToolProduct, error) {
-	if p, ok := productsByRef[ref.Ref]; ok {
-		slog.DebugContext(ctx, "defense tool product matched existing by ref", "product-name", ref.Name, "product-ref", ref.Ref, "target-product-id", p.Id)
-		return p, nil
-	}
-	if p, ok := productsByName[strings.ToLower(ref.Name)]; ok {
-		slog.DebugContext(ctx, "defense tool product matched existing by name fallback (ref mismatch)", "product-name", ref.Name, "source-ref", ref.Ref, "target-product-id", p.Id, "target-product-ref", p.Ref)
-		productsByRef[ref.Ref] = p
+func resolveOrCreateDefenseToolProduct(ctx context.Context, client graphql.Client, ref DefenseToolProductRef, productsByRef map[string]dao.GetAllDefenseToolProductsDefenseToolProductsDefenseToolProductsConnectionNodesDefenseToolProduct, productsByName map[string]dao.GetAllDefenseToolProductsDefenseToolProductsDefenseToolProductsConnectionNodesDefenseToolProduct, libraryLayersByName map[string]dao.GetAllLibraryDefensiveLayersLibraryDefensivelayersDefensiveLayerConnectionNodesDefensiveLayer, optionalParams *RestoreOptionalParams) (dao.GetAllDefenseToolProductsDefenseToolProductsDefenseToolProductsConnectionNodesDefenseToolProduct, error) {
+	if p, byName, ok := findDefenseToolProduct(ref, productsByRef, productsByName); ok {
+		if byName {
+			slog.DebugContext(ctx, "defense tool product matched existing by name fallback (ref mismatch)", "product-name", ref.Name, "source-ref", ref.Ref, "target-product-id", p.Id, "target-product-ref", p.Ref)
+			productsByRef[ref.Ref] = p
+		} else {
+			slog.DebugContext(ctx, "defense tool product matched existing by ref", "product-name", ref.Name, "product-ref", ref.Ref, "target-product-id", p.Id)
+		}
 		return p, nil
 	}
 
@@ -614,7 +967,7 @@ func resolveOrCreateDefenseToolProduct(ctx context.Context, client graphql.Clien
 		layers = []DefenseLayer{{Name: PLACEHOLDER_DEFENSE_LAYER_NAME}}
 	}
 
-	layerIds, err := resolveOrCreateLibraryDefenseLayerIds(ctx, client, layers, libraryLayersByName)
+	layerIds, err := resolveOrCreateLibraryDefenseLayerIds(ctx, client, layers, libraryLayersByName, optionalParams)
 	if err != nil {
 		return dao.GetAllDefenseToolProductsDefenseToolProductsDefenseToolProductsConnectionNodesDefenseToolProduct{}, fmt.Errorf("could not resolve library defense layers for product %q (source ref %q): %w", ref.Name, ref.Ref, err)
 	}
@@ -642,11 +995,199 @@ func resolveOrCreateDefenseToolProduct(ctx context.Context, client graphql.Clien
 		Ref:  created.Ref,
 	}
 	slog.DebugContext(ctx, "defense tool product created", "product-name", ref.Name, "source-ref", ref.Ref, "target-product-id", product.Id, "target-product-ref", product.Ref, "vendor-id", vendorId, "layer-ids", layerIds)
+	if err := optionalParams.recordCreated(ctx, &optionalParams.journal().Created.DefenseToolProducts, product.Id); err != nil {
+		return dao.GetAllDefenseToolProductsDefenseToolProductsDefenseToolProductsConnectionNodesDefenseToolProduct{}, err
+	}
 	productsByRef[product.Ref] = product
 	productsByName[strings.ToLower(product.Name)] = product
 	return product, nil
 }
 
+// reconcileAssets makes sure every target and source referenced by the
+// campaigns' test cases exists in the target instance with its full record,
+// before the test cases that link to it by name are created.
+//
+// Assets are reused by name. VECTR has no query for targets or sources on
+// their own, so "existing" means already linked to a test case in db. A
+// missing asset is created with its saved description and property type,
+// matched by name in the target instance and falling back to the saved id.
+// An asset with no saved record or no resolvable property type is logged and
+// left for VECTR to create from the bare name, which is what happened before
+// assets were saved at all.
+//
+// Parameters:
+//   - ctx: The context for managing request deadlines, cancellations, and other request-scoped values.
+//   - client: The GraphQL client used to make API calls.
+//   - db: The name of the target database.
+//   - campaigns: The campaigns about to be restored.
+//   - assets: The saved asset records; nil (a file that predates
+//     AssetsResource) makes this a no-op.
+//
+// Returns:
+//   - error: Returns nil on success, or an error if any GraphQL call fails.
+func reconcileAssets(ctx context.Context, client graphql.Client, db string, campaigns []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign, assets *AssetsResource) error {
+	if assets == nil {
+		slog.DebugContext(ctx, "no asset records in the save data, test cases will link targets and sources by name only", "db", db)
+		return nil
+	}
+
+	missingTargets := map[string]bool{}
+	missingSources := map[string]bool{}
+	for _, c := range campaigns {
+		for _, tc := range c.TestCases {
+			for _, t := range tc.Targets {
+				missingTargets[t.Name] = true
+			}
+			for _, src := range tc.Sources {
+				missingSources[src.Name] = true
+			}
+		}
+	}
+	if len(missingTargets) == 0 && len(missingSources) == 0 {
+		return nil
+	}
+
+	existing, err := dao.ListTestCasesForDb(ctx, client, db)
+	if err != nil {
+		if gqlObject, ok := gqlErrParse(err); ok {
+			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+		}
+		return fmt.Errorf("could not fetch existing targets and sources for %s: %w", db, err)
+	}
+	for _, tc := range existing {
+		for _, t := range tc.Targets {
+			delete(missingTargets, t.Name)
+		}
+		for _, src := range tc.Sources {
+			delete(missingSources, src.Name)
+		}
+	}
+	if len(missingTargets) == 0 && len(missingSources) == 0 {
+		slog.DebugContext(ctx, "every target and source already exists", "db", db)
+		return nil
+	}
+
+	pt, err := dao.ListAssetPropertyTypes(ctx, client)
+	if err != nil {
+		if gqlObject, ok := gqlErrParse(err); ok {
+			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+		}
+		return fmt.Errorf("could not fetch asset property types for %s: %w", db, err)
+	}
+	propertyTypeIdsByName := make(map[string]string, len(pt))
+	propertyTypeIds := make(map[string]bool, len(pt))
+	for _, n := range pt {
+		propertyTypeIdsByName[n.Name] = n.Id
+		propertyTypeIds[n.Id] = true
+	}
+
+	skipped := 0
+	// plan resolves each missing asset of one kind to a create input, in name
+	// order so the creates (and the logs) are deterministic.
+	plan := func(kind string, missing map[string]bool, records map[string]Asset) []Asset {
+		var planned []Asset
+		for _, name := range slices.Sorted(maps.Keys(missing)) {
+			a, ok := records[name]
+			if !ok {
+				slog.WarnContext(ctx, "asset has no saved record, leaving it to be created from its name", "asset-kind", kind, "asset-name", name)
+				skipped++
+				continue
+			}
+			if id, ok := propertyTypeIdsByName[a.PropertyType]; ok && a.PropertyType != "" {
+				a.PropertyTypeId = id
+			} else if !propertyTypeIds[a.PropertyTypeId] {
+				slog.WarnContext(ctx, "asset property type not found in the target instance, leaving the asset to be created from its name",
+					"asset-kind", kind,
+					"asset-name", name,
+					"asset-property-type", a.PropertyType,
+					"asset-property-type-id", a.PropertyTypeId)
+				skipped++
+				continue
+			}
+			planned = append(planned, a)
+		}
+		return planned
+	}
+
+	targets := plan("target", missingTargets, assets.Targets)
+	if len(targets) > 0 {
+		input := dao.CreateTargetInput{Db: db}
+		for _, a := range targets {
+			input.TargetDataInputs = append(input.TargetDataInputs, dao.CreateTargetDataInput{Name: a.Name, PlatformType: a.PropertyTypeId, Description: a.Description})
+		}
+		if _, err := dao.CreateTargets(ctx, client, input); err != nil {
+			if gqlObject, ok := gqlErrParse(err); ok {
+				slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+			}
+			return fmt.Errorf("could not create %d target(s) in %s: %w", len(targets), db, err)
+		}
+	}
+
+	sources := plan("source", missingSources, assets.Sources)
+	if len(sources) > 0 {
+		input := dao.CreateSourceInput{Db: db}
+		for _, a := range sources {
+			input.SourceDataInputs = append(input.SourceDataInputs, dao.CreateSourceDataInput{Name: a.Name, PlatformType: a.PropertyTypeId, Description: a.Description})
+		}
+		if _, err := dao.CreateSources(ctx, client, input); err != nil {
+			if gqlObject, ok := gqlErrParse(err); ok {
+				slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+			}
+			return fmt.Errorf("could not create %d source(s) in %s: %w", len(sources), db, err)
+		}
+	}
+
+	slog.InfoContext(ctx, "Reconciled assets", "db", db, "created-target-count", len(targets), "created-source-count", len(sources), "skipped-asset-count", skipped)
+	return nil
+}
+
+// reconcileDefenseToolsOnce is reconcileDefenseTools for a journaled
+// restore: tools the journal already resolved are taken from it instead of
+// being reconciled again, and the rest are recorded once resolved.
+func reconcileDefenseToolsOnce(ctx context.Context, client graphql.Client, db string, toolsToReconcile map[string]DefenseToolRef, optionalParams *RestoreOptionalParams) (map[string]string, error) {
+	journal := optionalParams.journal()
+	pending := make(map[string]DefenseToolRef, len(toolsToReconcile))
+	for key, ref := range toolsToReconcile {
+		if _, ok := journal.DefenseTools[key]; !ok {
+			pending[key] = ref
+		}
+	}
+	if len(pending) > 0 || len(toolsToReconcile) == 0 {
+		toolIdByKey, err := reconcileDefenseTools(ctx, client, db, pending, optionalParams)
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(journal.DefenseTools, toolIdByKey)
+		if err := optionalParams.checkpoint(ctx); err != nil {
+			return nil, err
+		}
+	} else {
+		slog.InfoContext(ctx, "Defense tools already reconciled by the resumed restore", "db", db, "tool-count", len(toolsToReconcile))
+	}
+
+	toolIdByKey := make(map[string]string, len(toolsToReconcile))
+	for key := range toolsToReconcile {
+		toolIdByKey[key] = journal.DefenseTools[key]
+	}
+	return toolIdByKey, nil
+}
+
+// reconcileAssetsOnce is reconcileAssets for a journaled restore. The
+// existing-asset lookup only sees assets linked to a test case, so assets
+// created by an interrupted restore whose test cases weren't written yet
+// would be created again; the journal is what prevents that.
+func reconcileAssetsOnce(ctx context.Context, client graphql.Client, db string, campaigns []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign, assets *AssetsResource, optionalParams *RestoreOptionalParams) error {
+	journal := optionalParams.journal()
+	if journal.AssetsReconciled {
+		return nil
+	}
+	if err := reconcileAssets(ctx, client, db, campaigns, assets); err != nil {
+		return err
+	}
+	journal.AssetsReconciled = true
+	return optionalParams.checkpoint(ctx)
+}
+
 // restoreCampaigns creates campaigns and their associated test cases within a
 // specified assessment. It handles the mapping of organizations, tools, and
 // metadata from the serialized data to the target VECTR instance.
@@ -687,13 +1228,26 @@ func restoreCampaigns(
 	idToolsMap map[string]DefenseToolRef,
 	optionalParams *RestoreOptionalParams,
 ) error {
-	// Step 5: Create the campaigns
+	// VECTR lists campaigns and test cases in creation order and its create
+	// inputs take no offset, so creating them in source offset order is how
+	// the restored assessment keeps the source's ordering.
+	campaignsToRestore = slices.Clone(campaignsToRestore)
+	slices.SortStableFunc(campaignsToRestore, func(a, b dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+
+	journal := optionalParams.journal()
+
+	// Step 5: Create the campaigns (those a resumed restore hasn't already)
 	campaigns := dao.CreateCampaignInput{
 		Db:           db,
 		AssessmentId: assessmentId,
 		CampaignData: []dao.CreateCampaignDataInput{},
 	}
 	for _, c := range campaignsToRestore {
+		if _, ok := journal.Campaigns[c.Name]; ok {
+			continue
+		}
 		campaign := dao.CreateCampaignDataInput{
 			Name:        c.Name,
 			Description: c.Description,
@@ -704,32 +1258,56 @@ func restoreCampaigns(
 		for _, md := range c.Metadata {
 			campaign.Metadata = append(campaign.Metadata, dao.MetadataKeyValuePairInput(md))
 		}
+		// CreateCampaignDataInput has no icon or tags field and there is no
+		// campaign update mutation, so these can't be written back; say so
+		// rather than dropping them silently.
+		if c.Icon != "" || len(c.Tags) > 0 {
+			tags := make([]string, 0, len(c.Tags))
+			for _, tag := range c.Tags {
+				tags = append(tags, tag.Name)
+			}
+			slog.WarnContext(ctx, "VECTR's campaign create API cannot set a campaign icon or tags, they will not be restored",
+				"assessment-name", assessmentName,
+				"campaign_name", c.Name,
+				"icon", c.Icon,
+				"tags", tags)
+		}
 		campaigns.CampaignData = append(campaigns.CampaignData, campaign)
 	}
-	slog.DebugContext(ctx, "Creating campaigns",
-		"count", len(campaigns.CampaignData),
-		"assessment_name", assessmentName)
-	r, err := dao.CreateCampaigns(ctx, client, campaigns)
-	if err != nil {
-		if gqlObject, ok := gqlErrParse(err); ok {
-			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
-		}
-		return fmt.Errorf("could not create campaigns for %s, suggest deleting the assessment: %w", assessmentName, err)
-	}
-	// Note that this creates a bug where if two campaigns are the same name, it will not work.
+	// Note that campaigns are mapped by name, which creates a bug where if two campaigns are the same name, it will not work.
 	// To be fixed if you'll need to insert each campaign individually so you can map them
 	// For now this is fine
-	campaign_map := make(map[string]string)
-	for _, cdata := range r.Campaign.Create.Campaigns {
-		campaign_map[cdata.Name] = cdata.Id
+	campaign_map := journal.Campaigns
+	if len(campaigns.CampaignData) > 0 {
+		slog.DebugContext(ctx, "Creating campaigns",
+			"count", len(campaigns.CampaignData),
+			"assessment_name", assessmentName)
+		r, err := dao.CreateCampaigns(ctx, client, campaigns)
+		if err != nil {
+			if gqlObject, ok := gqlErrParse(err); ok {
+				slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+			}
+			return fmt.Errorf("could not create campaigns for %s, suggest deleting the assessment: %w", assessmentName, err)
+		}
+		for _, cdata := range r.Campaign.Create.Campaigns {
+			campaign_map[cdata.Name] = cdata.Id
+		}
+		if err := optionalParams.checkpoint(ctx); err != nil {
+			return err
+		}
 	}
 
 	slog.InfoContext(ctx, "Campaigns created",
 		"count", len(campaigns.CampaignData),
+		"resumed-count", len(campaignsToRestore)-len(campaigns.CampaignData),
 		"assessment_name", assessmentName)
 
 	// Step 6: Create the test cases but need to do a calculation if the highest outcome from the tool doesn't match the test case, set override
 	testCaseCount := 0
+	// how the test cases written were linked to the library, by TemplateMatch
+	linkedByIdCount, linkedByNameCount, envOnlyCount := 0, 0, 0
+	skippedAttachmentCount := 0
+	skippedUnstructuredLogCount := 0
 	for _, c := range campaignsToRestore {
 		// there could be a mix of test case types in a campaign, so add both types in
 		tc_with_library := NewGroupedCreateTestCaseWithLibraryIdInput(db, campaign_map[c.Name])
@@ -741,17 +1319,85 @@ func restoreCampaigns(
 			SuppressAutoTimelineEvents: true,
 		}
 
+		// source test case ID (clientId) -> new test case ID, for this campaign
+		// only, starting from whatever a resumed restore already created.
+		testCaseIdMap := make(map[string]string, len(c.TestCases))
+		for _, tc := range c.TestCases {
+			if id, ok := journal.TestCases[tc.Id]; ok {
+				testCaseIdMap[tc.Id] = id
+			}
+		}
+		// test cases written by the restore being resumed, whose skipped
+		// attachment files and unstructured logs were already reported
+		resumedTestCases := maps.Clone(testCaseIdMap)
+		// createPending writes the queued test cases and clears the queues. It
+		// runs whenever the next test case switches between the template and
+		// no-template create, since the two go through separate mutations and
+		// writing one queue after the other would reorder the campaign.
+		createPending := func() error {
+			slog.DebugContext(ctx, "Creating test cases",
+				"campaign_name", c.Name,
+				"test_case_count", tc_with_library.Len(),
+				"test-case-count-no-template", len(tc_no_template.TestCaseData),
+				"assessment_name", assessmentName)
+			for _, batch := range tc_with_library.GenerateInsertsData() {
+				r, err := dao.CreateTestCasesByLibraryId(ctx, client, batch)
+				if err != nil {
+					if gqlObject, ok := gqlErrParse(err); ok {
+						slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+					}
+					return fmt.Errorf("could not write test cases for %s, campaign: %s; check vectr version: %w", assessmentName, c.Name, err)
+				}
+				for _, item := range r.TestCase.CreateWithTemplateMatchByLibraryId.TestCaseCreateItems {
+					testCaseIdMap[item.ClientId] = item.TestCase.Id
+					journal.TestCases[item.ClientId] = item.TestCase.Id
+				}
+				testCaseCount += len(batch.CreateTestCaseInputs)
+				if err := optionalParams.checkpoint(ctx); err != nil {
+					return err
+				}
+			}
+			if len(tc_no_template.TestCaseData) > 0 {
+				r, err := dao.CreateTestCasesNoTemplate(ctx, client, tc_no_template)
+				if err != nil {
+					if gqlObject, ok := gqlErrParse(err); ok {
+						slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+					}
+					return fmt.Errorf("could not write test cases for %s: %w", assessmentName, err)
+				}
+				for _, item := range r.TestCase.CreateWithoutTemplate.TestCaseCreateItems {
+					testCaseIdMap[item.ClientId] = item.TestCase.Id
+					journal.TestCases[item.ClientId] = item.TestCase.Id
+				}
+				testCaseCount += len(tc_no_template.TestCaseData)
+				if err := optionalParams.checkpoint(ctx); err != nil {
+					return err
+				}
+			}
+			tc_with_library = NewGroupedCreateTestCaseWithLibraryIdInput(db, campaign_map[c.Name])
+			tc_no_template.TestCaseData = []dao.CreateTestCaseDataInput{}
+			return nil
+		}
+
+		orderedTestCases := slices.Clone(c.TestCases)
+		slices.SortStableFunc(orderedTestCases, func(a, b dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase) int {
+			return cmp.Compare(a.Offset, b.Offset)
+		})
+
 		timelineEntriesCount := 0
 		// have to do this here (maybe make this an object in the future)
 		// but basically, I need to check if the outcome is in the map
 		// if it is not, throw an error
-		for _, serialized_tc := range c.TestCases {
+		for _, serialized_tc := range orderedTestCases {
+			timelineEntriesCount += len(serialized_tc.TimelineEvents)
+			if _, ok := testCaseIdMap[serialized_tc.Id]; ok {
+				continue // created by the restore being resumed
+			}
 			status, ok := outcomeStatusMap[serialized_tc.Status]
 			if !ok {
 				slog.WarnContext(ctx, "could not find outcome for this test case, passing it through as-is (forwards compat)", "outcome", serialized_tc.Status, "test-case", serialized_tc.Name, "campaign", c.Name, "campaign-id", c.Id, "test-case-id", serialized_tc.Id)
 				status = dao.TestCaseStatus(serialized_tc.Status)
 			}
-			timelineEntriesCount += len(serialized_tc.TimelineEvents)
 			// OrgMap is a resource vat itself manages and requires -- every test
 			// case must carry an organization. Confirmed against a live instance
 			// that VECTR rejects a create with a blank organization, but we don't
@@ -863,52 +1509,42 @@ func restoreCampaigns(
 				})
 			}
 			// if there is no library test case id, then add with no template
-			if optionalParams.ForceEnvOnly || (serialized_tc.LibraryTestCaseId == "" || serialized_tc.LibraryTestCaseId == "null") {
+			noTemplate := optionalParams.ForceEnvOnly || (serialized_tc.LibraryTestCaseId == "" || serialized_tc.LibraryTestCaseId == "null")
+			if (noTemplate && tc_with_library.Len() > 0) || (!noTemplate && len(tc_no_template.TestCaseData) > 0) {
+				if err := createPending(); err != nil {
+					return err
+				}
+			}
+			if noTemplate {
 				tc_no_template.TestCaseData = append(tc_no_template.TestCaseData, testCaseData)
+				envOnlyCount++
 			} else {
 				// otherwise, create with template
+				libraryTestCaseId, byName := journal.linkedLibraryTestCaseId(serialized_tc.LibraryTestCaseId)
 				tcd := dao.CreateTestCaseDataWithLibraryIdInput{
-					LibraryTestCaseId:    serialized_tc.LibraryTestCaseId,
+					LibraryTestCaseId:    libraryTestCaseId,
 					CreateNewIfNotExists: false,
 					TestCaseData:         testCaseData,
 				}
 				tc_with_library.Add(tcd)
-			}
-		}
-		slog.DebugContext(ctx, "Creating test cases",
-			"campaign_name", c.Name,
-			"test_case_count", tc_with_library.Len(),
-			"test-case-count-no-template", len(tc_no_template.TestCaseData),
-			"assessment_name", assessmentName)
-		// source test case ID (clientId) -> new test case ID, for this campaign only.
-		testCaseIdMap := make(map[string]string, tc_with_library.Len()+len(tc_no_template.TestCaseData))
-		if tc_with_library.Len() > 0 {
-			for _, batch := range tc_with_library.GenerateInsertsData() {
-				r, err := dao.CreateTestCasesByLibraryId(ctx, client, batch)
-				if err != nil {
-					if gqlObject, ok := gqlErrParse(err); ok {
-						slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
-					}
-					return fmt.Errorf("could not write test cases for %s, campaign: %s; check vectr version: %w", assessmentName, c.Name, err)
-				}
-				for _, item := range r.TestCase.CreateWithTemplateMatchByLibraryId.TestCaseCreateItems {
-					testCaseIdMap[item.ClientId] = item.TestCase.Id
+				templateMatch := TemplateMatchId
+				if byName {
+					templateMatch = TemplateMatchName
+					linkedByNameCount++
+				} else {
+					linkedByIdCount++
 				}
-				testCaseCount += len(batch.CreateTestCaseInputs)
+				slog.DebugContext(ctx, "Linking test case to library test case",
+					"assessment-name", assessmentName,
+					"campaign_name", c.Name,
+					"test-case-name", serialized_tc.Name,
+					"source-library-test-case-id", serialized_tc.LibraryTestCaseId,
+					"library-test-case-id", libraryTestCaseId,
+					"template-match", templateMatch)
 			}
 		}
-		if len(tc_no_template.TestCaseData) > 0 {
-			r, err := dao.CreateTestCasesNoTemplate(ctx, client, tc_no_template)
-			if err != nil {
-				if gqlObject, ok := gqlErrParse(err); ok {
-					slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
-				}
-				return fmt.Errorf("could not write test cases for %s: %w", assessmentName, err)
-			}
-			for _, item := range r.TestCase.CreateWithoutTemplate.TestCaseCreateItems {
-				testCaseIdMap[item.ClientId] = item.TestCase.Id
-			}
-			testCaseCount += len(tc_no_template.TestCaseData)
+		if err := createPending(); err != nil {
+			return err
 		}
 		// Here's where we add the timelines
 		if timelineEntriesCount > 0 {
@@ -916,12 +1552,17 @@ func restoreCampaigns(
 				Db:     db,
 				Events: make([]dao.TimelineEventInput, 0, timelineEntriesCount),
 			}
+			// clientId -> source timeline event id, for journaling what was written
+			sourceEventIds := make(map[string]string, timelineEntriesCount)
 
 			for _, stc := range c.TestCases {
 				if _, ok := testCaseIdMap[stc.Id]; !ok {
 					continue
 				}
 				for _, te := range stc.TimelineEvents {
+					if te.Id != "" && journal.TimelineEvents[te.Id] {
+						continue // written by the restore being resumed
+					}
 					teToInsert := &dao.TimelineEventInput{
 						ClientId:    uuid.NewString(),
 						TestCaseId:  testCaseIdMap[stc.Id],
@@ -994,62 +1635,131 @@ func restoreCampaigns(
 						"test-case-id", teToInsert.TestCaseId,
 						"event-type", te.Type,
 						"event", teToInsert)
+					sourceEventIds[teToInsert.ClientId] = te.Id
 					timelineEventInsert.Events = append(timelineEventInsert.Events, *teToInsert)
 				}
 			}
-			respTimelineResponse, err := dao.CreateTimelineEvents(ctx, client, *timelineEventInsert)
-			if err != nil {
-				if gqlObject, ok := gqlErrParse(err); ok {
-					slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+			if len(timelineEventInsert.Events) > 0 {
+				respTimelineResponse, err := dao.CreateTimelineEvents(ctx, client, *timelineEventInsert)
+				if err != nil {
+					if gqlObject, ok := gqlErrParse(err); ok {
+						slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+					}
+					return fmt.Errorf("could not write timeline events for %s, campaign: %s; check vectr version: %w", assessmentName, c.Name, err)
 				}
-				return fmt.Errorf("could not write timeline events for %s, campaign: %s; check vectr version: %w", assessmentName, c.Name, err)
-			}
-			// this is a way to check if errors happened as well
-			if respTimelineResponse.TimelineEvent.Create.Summary.Failed > 0 {
-				for _, errmsg := range respTimelineResponse.TimelineEvent.Create.Items {
-					if len(errmsg.Errors) > 0 {
-						for _, te := range timelineEventInsert.Events {
-							if strings.EqualFold(errmsg.ClientId, te.ClientId) {
-								slog.ErrorContext(ctx, "failed to create timeline event",
-									"assessment-name", assessmentName,
-									"campaign_name", c.Name,
-									"client-id", te.ClientId,
-									"test-case-id", te.TestCaseId,
-									"event", te,
-									"errors", errmsg.Errors)
-								break
-							}
+				// Journal every event that didn't come back with an error, so a
+				// resume after a partial failure only retries the failed ones.
+				failedClientIds := make(map[string]bool)
+				for _, item := range respTimelineResponse.TimelineEvent.Create.Items {
+					if len(item.Errors) > 0 {
+						failedClientIds[item.ClientId] = true
+					}
+				}
+				for clientId, sourceEventId := range sourceEventIds {
+					if sourceEventId != "" && !failedClientIds[clientId] {
+						journal.TimelineEvents[sourceEventId] = true
+					}
+				}
+				if err := optionalParams.checkpoint(ctx); err != nil {
+					return err
+				}
+				// this is a way to check if errors happened as well
+				if respTimelineResponse.TimelineEvent.Create.Summary.Failed > 0 {
+					for _, errmsg := range respTimelineResponse.TimelineEvent.Create.Items {
+						if len(errmsg.Errors) > 0 {
+							for _, te := range timelineEventInsert.Events {
+								if strings.EqualFold(errmsg.ClientId, te.ClientId) {
+									slog.ErrorContext(ctx, "failed to create timeline event",
+										"assessment-name", assessmentName,
+										"campaign_name", c.Name,
+										"client-id", te.ClientId,
+										"test-case-id", te.TestCaseId,
+										"event", te,
+										"errors", errmsg.Errors)
+									break
+								}
 
+							}
 						}
 					}
+					return fmt.Errorf("could not write timeline events for %s, campaign: %s; %d", assessmentName, c.Name, respTimelineResponse.TimelineEvent.Create.Summary.Failed)
 				}
-				return fmt.Errorf("could not write timeline events for %s, campaign: %s; %d", assessmentName, c.Name, respTimelineResponse.TimelineEvent.Create.Summary.Failed)
 			}
 		}
+		skippedAttachmentCount += skipUnrestorable(ctx, assessmentName, c, testCaseIdMap, resumedTestCases, "attachment file", func(tc dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase) int {
+			return len(tc.AttachmentFiles)
+		})
+		skippedUnstructuredLogCount += skipUnrestorable(ctx, assessmentName, c, testCaseIdMap, resumedTestCases, "unstructured log", func(tc dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase) int {
+			return len(tc.UnstructuredLogs)
+		})
 	}
-	slog.InfoContext(ctx, "Test cases created", "assessment-name", assessmentName, "test-case-count", testCaseCount)
+	slog.InfoContext(ctx, "Test cases created",
+		"assessment-name", assessmentName,
+		"test-case-count", testCaseCount,
+		"linked-by-id-count", linkedByIdCount,
+		"linked-by-name-count", linkedByNameCount,
+		"env-only-count", envOnlyCount,
+		"skipped-attachment-count", skippedAttachmentCount,
+		"skipped-unstructured-log-count", skippedUnstructuredLogCount)
 
 	return nil
 }
 
+// skipUnrestorable reports the content count finds on each of c's test cases
+// written by this run as skipped, and returns how many there were. It covers
+// what VECTR's API can read but not write: attachment files, whose metadata
+// is exposed but whose contents can't be downloaded or uploaded, and
+// unstructured logs, which can be read and deleted but not created. The
+// per-campaign warning and the count in the restore summary keep the drop
+// from being silent. kind names the content in the warning. Test cases in
+// resumed were written by the restore being resumed, which already reported
+// theirs.
+func skipUnrestorable(ctx context.Context, assessmentName string, c dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign, testCaseIdMap, resumed map[string]string, kind string, count func(dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase) int) int {
+	skipped := 0
+	for _, stc := range c.TestCases {
+		_, written := testCaseIdMap[stc.Id]
+		if _, ok := resumed[stc.Id]; written && !ok {
+			skipped += count(stc)
+		}
+	}
+	if skipped > 0 {
+		slog.WarnContext(ctx, "VECTR's API can't write these, not restoring them for this campaign", "assessment-name", assessmentName, "campaign_name", c.Name, "kind", kind, "skipped-count", skipped)
+	}
+	return skipped
+}
+
 // validateLibraryTestCases checks if a list of library test case IDs exist in the target VECTR instance.
-// It performs a query and specifically handles the GraphQL error case where some IDs are not found,
-// returning a detailed error message.
+// It returns a detailed error naming how many of the IDs are not found.
 func validateLibraryTestCases(ctx context.Context, client graphql.Client, libraryTestCaseIDs []string, templateAssessmentName string) error {
+	missing_ids, err := findMissingLibraryTestCases(ctx, client, libraryTestCaseIDs, templateAssessmentName)
+	if err != nil {
+		return err
+	}
+	if len(missing_ids) > 0 {
+		slog.ErrorContext(ctx, "could not find all the ids in the instance", "missing-ids", missing_ids)
+		return fmt.Errorf("could not find all the ids in the instance, override templates or create the missing ones to insert, missing id count: %d: %w", len(missing_ids), ErrMissingLibraryTestCases)
+	}
+
+	return nil
+}
+
+// findMissingLibraryTestCases returns the library test case IDs that don't exist in the target VECTR instance.
+// It performs a query and specifically handles the GraphQL error case where some IDs are not found.
+func findMissingLibraryTestCases(ctx context.Context, client graphql.Client, libraryTestCaseIDs []string, templateAssessmentName string) ([]string, error) {
 	if len(libraryTestCaseIDs) == 0 {
-		return nil
+		return nil, nil
 	}
 	// first time, we never really need to check the response, if the missing ids remain none,
 	// we don't need to do anything
 	_, err := dao.GetLibraryTestCases(ctx, client, libraryTestCaseIDs)
 	if err == nil {
-		return nil
+		return nil, nil
 	}
 
 	var missing_ids []string
 	gqlerrlist, ok := err.(gqlerror.List)
 	if !ok {
-		return fmt.Errorf("could not fetch library test cases for %s: %w", templateAssessmentName, err)
+		return nil, fmt.Errorf("could not fetch library test cases for %s: %w", templateAssessmentName, err)
 	}
 
 	// the error type we expect only has one entry for this path
@@ -1057,7 +1767,7 @@ func validateLibraryTestCases(ctx context.Context, client graphql.Client, librar
 		if gqlObject, ok := gqlErrParse(err); ok {
 			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
 		}
-		return fmt.Errorf("could not fetch library test cases for %s: %w", templateAssessmentName, err)
+		return nil, fmt.Errorf("could not fetch library test cases for %s: %w", templateAssessmentName, err)
 	}
 	// there should be an `ids` field in the extensions object
 	rawids, ok := gqlerrlist[0].Extensions["ids"]
@@ -1065,7 +1775,7 @@ func validateLibraryTestCases(ctx context.Context, client graphql.Client, librar
 		if gqlObject, ok := gqlErrParse(err); ok {
 			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
 		}
-		return fmt.Errorf("could not fetch library test cases for %s: %w", templateAssessmentName, err)
+		return nil, fmt.Errorf("could not fetch library test cases for %s: %w", templateAssessmentName, err)
 	}
 	// the `ids` filed should only have one entry
 	ids, ok := rawids.([]any)
@@ -1073,7 +1783,7 @@ func validateLibraryTestCases(ctx context.Context, client graphql.Client, librar
 		if gqlObject, ok := gqlErrParse(err); ok {
 			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
 		}
-		return fmt.Errorf("could not fetch library test cases for %s: %w", templateAssessmentName, err)
+		return nil, fmt.Errorf("could not fetch library test cases for %s: %w", templateAssessmentName, err)
 	}
 
 	id := ids[0].(string)
@@ -1081,21 +1791,15 @@ func validateLibraryTestCases(ctx context.Context, client graphql.Client, librar
 		if gqlObject, ok := gqlErrParse(err); ok {
 			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
 		}
-		return fmt.Errorf("could not fetch library test cases for %s: %w", templateAssessmentName, err)
+		return nil, fmt.Errorf("could not fetch library test cases for %s: %w", templateAssessmentName, err)
 	}
 	// this is a case where we got an error back for an otherwise valid query, one or more of the ids are not valid
 	mids, err := ParseLibraryTestcasesByIdsError(id)
 	if err != nil {
-		return fmt.Errorf("could not fetch library test cases for %s: %w", templateAssessmentName, err)
+		return nil, fmt.Errorf("could not fetch library test cases for %s: %w", templateAssessmentName, err)
 	}
 	missing_ids = append(missing_ids, mids...)
-
-	if len(missing_ids) > 0 {
-		slog.ErrorContext(ctx, "could not find all the ids in the instance", "missing-ids", missing_ids)
-		return fmt.Errorf("could not find all the ids in the instance, override templates to insert, missing id count: %d", len(missing_ids))
-	}
-
-	return nil
+	return missing_ids, nil
 }
 
 // RestoreAssessment restores an assessment to a VECTR instance by deserializing
@@ -1133,21 +1837,38 @@ func validateLibraryTestCases(ctx context.Context, client graphql.Client, librar
 //   - If `OverrideAssessmentTemplate` is set, it creates template test cases
 //     directly from the serialized data.
 //   - Otherwise, it validates that the required template assessment or
-//     individual library test cases exist in the target instance.
+//     individual library test cases exist in the target instance, after
+//     matching them by name under `TemplateMatch`.
 //
 // 4. **Override Assessment Name**:
 //   - If `optionalParams.AssessmentName` is provided, it overrides the name
 //     of the assessment in the serialized data.
 //
-// 5. **Create Assessment**:
+// 5. **Reconcile Assets**:
+//   - Calls `reconcileAssets` to create any target or source the test cases
+//     reference that the target instance doesn't have yet.
+//
+// 6. **Create Assessment**:
 //   - Creates the assessment in the target instance using the serialized data.
 //   - Includes metadata and organization mappings.
 //
-// 6. **Restore Campaigns**:
+// 7. **Restore Campaigns**:
 //   - Calls `restoreCampaigns` to populate the assessment with campaigns
 //     and test cases.
-//   - If `DeleteOnFailure` is true, it rolls back the assessment creation
-//     if campaign restoration fails.
+//
+// With `optionalParams.Mode` set to `RestoreModeUpdate`, an assessment
+// already in the target instance (by globalId, then name) replaces steps 4-6:
+// `updateExistingAssessment` updates its matching test cases, and step 7
+// only appends the campaigns, test cases and timeline events it is missing.
+//
+// With `optionalParams.AsTemplate` set, `restoreAsTemplate` runs instead and
+// the archive becomes a library assessment rather than one in db.
+//
+// With `optionalParams.Retest` set, test case results are reset before
+// anything is written, so the copy is ready to be run again.
+//
+// If any step fails and `DeleteOnFailure` is true, everything the restore
+// created is deleted again with `RollbackRestore`.
 //
 // Error Handling:
 // The function returns detailed errors for the following scenarios:
@@ -1155,6 +1876,10 @@ func validateLibraryTestCases(ctx context.Context, client graphql.Client, librar
 //   - Missing library assessments (`ErrMissingLibraryAssessment`).
 //   - A local assessment already exists (`ErrAssessmentAlreadyExists`).
 //   - Invalid or blank assessment name overrides (`ErrInvalidAssessmentName`).
+//   - An unknown mode, or update mode with ResetGlobalId, AsTemplate or
+//     Retest (`ErrInvalidRestoreMode`).
+//   - An unknown template match strategy, or name matching with ForceEnvOnly
+//     or OverrideAssessmentTemplate (`ErrInvalidTemplateMatch`).
 //   - GraphQL API errors during organization, tool, template, assessment,
 //     campaign, or test case creation.
 func RestoreAssessment(ctx context.Context, client graphql.Client, db string, ad *AssessmentData, optionalParams *RestoreOptionalParams) error {
@@ -1170,16 +1895,132 @@ func RestoreAssessment(ctx context.Context, client graphql.Client, db string, ad
 		slog.WarnContext(ctx, "Save data does not match version you are loading into. The restore may not work correctly", "save-vectr-version", ad.Manifest.VectrVersion, "live-vectr-version", restoreInfo.VectrVersion)
 	}
 
+	mode, err := ParseRestoreMode(string(optionalParams.Mode))
+	if err != nil {
+		return err
+	}
+	if mode == RestoreModeUpdate && optionalParams.ResetGlobalId {
+		return fmt.Errorf("%q can't reset the globalId it finds the assessment by: %w", mode, ErrInvalidRestoreMode)
+	}
+	if err := checkTemplateMatch(optionalParams); err != nil {
+		return err
+	}
+	if mode == RestoreModeUpdate && optionalParams.AsTemplate {
+		return fmt.Errorf("%q can't restore as a template: %w", mode, ErrInvalidRestoreMode)
+	}
+	if mode == RestoreModeUpdate && optionalParams.Retest {
+		return fmt.Errorf("%q can't reset the results of the assessment it updates: %w", mode, ErrInvalidRestoreMode)
+	}
+
+	if err := optionalParams.startJournal(ctx, db, "", ad); err != nil {
+		return err
+	}
+	if optionalParams.journal().Complete {
+		slog.InfoContext(ctx, "Journal shows this restore already completed, nothing to resume", "assessment-name", optionalParams.journal().AssessmentName, "db", db)
+		return nil
+	}
+
+	restore := restoreAssessment
+	if optionalParams.AsTemplate {
+		restore = restoreAsTemplate
+	}
+	if err := restore(ctx, client, db, ad, restoreInfo, optionalParams); err != nil {
+		if optionalParams.DeleteOnFailure {
+			optionalParams.rollbackOnFailure(ctx, client)
+		}
+		return err
+	}
+
+	slog.InfoContext(ctx, "Assessment restored successfully", "assessment-name", ad.Assessment.Name)
+	return nil
+
+}
+
+// restoreAssessment runs the journaled steps of RestoreAssessment, from
+// reconciling the prerequisites through to the last test case.
+func restoreAssessment(ctx context.Context, client graphql.Client, db string, ad *AssessmentData, restoreInfo VatOpMetadata, optionalParams *RestoreOptionalParams) error {
+	campaigns, err := filterTestCases(ctx, ad.Assessment.Campaigns, optionalParams.TestCaseFilter)
+	if err != nil {
+		return err
+	}
+	ad.Assessment.Campaigns = campaigns
+	if optionalParams.Retest {
+		resetForRetest(ctx, ad)
+	}
+
+	if err := applyOrgMapping(ctx, client, db, ad, optionalParams.OrgMapping); err != nil {
+		return err
+	}
+
 	org_map, err := validateRestorePrerequisites(ctx, client, db, ad.OrgMap)
 	if err != nil {
 		return err
 	}
 
-	toolIdByKey, err := reconcileDefenseTools(ctx, client, db, ad.ToolsMap)
+	toolIdByKey, err := reconcileDefenseToolsOnce(ctx, client, db, ad.ToolsMap, optionalParams)
 	if err != nil {
 		return err
 	}
 
+	journal := optionalParams.journal()
+	firstRun := journal.AssessmentId == ""
+	if firstRun && optionalParams.Mode == RestoreModeUpdate {
+		existingId, existingName, err := findAssessmentToUpdate(ctx, client, db, ad, optionalParams)
+		if err != nil {
+			return err
+		}
+		if existingId != "" {
+			if err := prepareTemplates(ctx, client, db, ad, optionalParams); err != nil {
+				return err
+			}
+			if err := reconcileAssetsOnce(ctx, client, db, ad.Assessment.Campaigns, ad.Assets, optionalParams); err != nil {
+				return err
+			}
+			// Not checkpointed until updateExistingAssessment has recorded
+			// which campaigns and test cases were already there, so a
+			// rollback can never mistake them for ones the restore added.
+			journal.AssessmentName = existingName
+			journal.AssessmentId = existingId
+			journal.UpdatedExisting = true
+		} else {
+			slog.InfoContext(ctx, "No assessment to update found, creating it", "assessment-name", ad.Assessment.Name, "global-id", ad.Assessment.GlobalId, "db", db)
+		}
+	}
+	if journal.AssessmentId == "" {
+		assessmentId, err := createRestoredAssessment(ctx, client, db, ad, org_map, restoreInfo, optionalParams)
+		if err != nil {
+			return err
+		}
+		journal.AssessmentName = ad.Assessment.Name
+		journal.AssessmentId = assessmentId
+		if err := optionalParams.checkpoint(ctx); err != nil {
+			return err
+		}
+	} else {
+		if !firstRun {
+			slog.InfoContext(ctx, "Assessment already created by the resumed restore, continuing in it", "assessment-name", journal.AssessmentName, "assessment-id", journal.AssessmentId, "db", db)
+		}
+		ad.Assessment.Name = journal.AssessmentName
+	}
+	if journal.UpdatedExisting {
+		if err := updateExistingAssessment(ctx, client, db, ad, toolIdByKey, firstRun, optionalParams); err != nil {
+			return err
+		}
+	}
+
+	err = restoreCampaigns(ctx, client, db, journal.AssessmentId, ad.Assessment.Name, ad.Assessment.Campaigns, org_map, toolIdByKey, ad.IdToolsMap, optionalParams)
+	if err != nil {
+		return fmt.Errorf("could not create campaigns and test cases for assessment %s: %w", ad.Assessment.Name, err)
+	}
+
+	journal.Complete = true
+	return optionalParams.checkpoint(ctx)
+}
+
+// createRestoredAssessment creates the assessment container for
+// RestoreAssessment (steps 3-6 of its workflow) and returns its id. It is
+// skipped entirely when resuming a restore that already got this far.
+func createRestoredAssessment(ctx context.Context, client graphql.Client, db string, ad *AssessmentData, org_map map[string]dao.FindOrganizationOrganizationsOrganizationConnectionNodesOrganization, restoreInfo VatOpMetadata, optionalParams *RestoreOptionalParams) (string, error) {
 	if optionalParams.AssessmentName != "" {
 		slog.DebugContext(ctx, "overiding assessment name", "old-assessment-name", ad.Assessment.Name, "new-assessment-name", optionalParams.AssessmentName)
 		ad.Assessment.Name = optionalParams.AssessmentName
@@ -1188,7 +2029,7 @@ func RestoreAssessment(ctx context.Context, client graphql.Client, db string, ad
 	if optionalParams.ResetGlobalId {
 		newGlobalId, err := uuid.NewRandom()
 		if err != nil {
-			return fmt.Errorf("when re-writing global id (--reset-id) could not generate uuid: %w", err)
+			return "", fmt.Errorf("when re-writing global id (--reset-id) could not generate uuid: %w", err)
 		}
 		slog.DebugContext(ctx, "resetting assessment global id", "assessment-name", ad.Assessment.Name, "old-global-id", ad.Assessment.GlobalId, "new-global-id", newGlobalId.String())
 		ad.Assessment.GlobalId = newGlobalId.String()
@@ -1199,101 +2040,19 @@ func RestoreAssessment(ctx context.Context, client graphql.Client, db string, ad
 		if gqlObject, ok := gqlErrParse(err); ok {
 			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
 		}
-		return fmt.Errorf("could not fetch data about assessment %s, error: %w", ad.Assessment.Name, err)
+		return "", fmt.Errorf("could not fetch data about assessment %s, error: %w", ad.Assessment.Name, err)
 	}
 	if len(lookup_assessments.Assessments.Nodes) > 0 {
-		return fmt.Errorf("could not add %s into %s: %w", ad.Assessment.Name, db, ErrAssessmentAlreadyExists)
+		return "", fmt.Errorf("could not add %s into %s: %w", ad.Assessment.Name, db, ErrAssessmentAlreadyExists)
 	}
 
-	// Step 3: Check if there is a template name in the seralized data, if so check in the instance (error if not)
-	// If the user wants to ignore error, go ahead and import template test cases
-	// If no template name, then go ahead and add template test cases in
-	if optionalParams.ForceEnvOnly {
-		slog.WarnContext(ctx, "--force-env-only set, skipping template/library test case validation", "assessment-name", ad.Assessment.Name)
-	} else {
-		if optionalParams.OverrideAssessmentTemplate {
-			slog.DebugContext(ctx, "adding template test cases directly")
-			input := dao.CreateTestCaseTemplateInput{
-				Overwrite:            true,
-				TestCaseTemplateData: []dao.CreateTestCaseTemplateDataInput{},
-			}
-
-			if len(ad.LibraryTestCases) > 0 {
-				for _, template_test_case := range ad.LibraryTestCases {
-					slog.DebugContext(ctx, "library test case", "name", template_test_case.Name, "template_id", template_test_case.LibraryTestCaseId)
-					tctd, errors, err := createTemplateData(template_test_case)
-					if err != nil {
-						slog.ErrorContext(ctx, "could not build template test case data",
-							"test-case-id", template_test_case.Id,
-							"test-case-library-id", template_test_case.LibraryTestCaseId,
-							"test-case-name", template_test_case.Name,
-							"assessment-name", ad.Assessment.Name,
-							"db", db,
-							"err", err,
-						)
-						return err
-					}
-					if len(errors) > 0 {
-						for _, err := range errors {
-							slog.WarnContext(ctx, "parsing discrepencies found, they were recovered but review if needed",
-								"test-case-id", template_test_case.Id,
-								"test-case-library-id", template_test_case.LibraryTestCaseId,
-								"test-case-name", template_test_case.Name,
-								"assessment-name", ad.Assessment.Name,
-								"db", db,
-								"err", err,
-							)
-
-						}
-					}
-					input.TestCaseTemplateData = append(input.TestCaseTemplateData, tctd)
-				}
-
-				_, err := dao.CreateTemplateTestCases(ctx, client, input)
-				if err != nil {
-					if gqlObject, ok := gqlErrParse(err); ok {
-						slog.ErrorContext(ctx, "full gql error", "error", gqlObject)
-					}
-
-					return fmt.Errorf("could not write template test cases: %w", err)
-				}
-				slog.InfoContext(ctx, "inserted all library test cases", "total", len(input.TestCaseTemplateData))
-			} else {
-				slog.InfoContext(ctx, "No library test cases found", "assessment-name", ad.Assessment.Name)
-			}
-
-		} else {
-			if ad.TemplateAssessment != "" {
-				slog.DebugContext(ctx, "Validating template assessment in instance",
-					"template_assessment", ad.TemplateAssessment,
-					"override_template", optionalParams.OverrideAssessmentTemplate)
-				prefix := ""
-				for _, md := range ad.Assessment.Metadata {
-					if md.Key == "prefix" {
-						prefix = md.Value + " - "
-						break
-					}
-				}
-				t, err := dao.FindLibraryAssessment(ctx, client, prefix+ad.TemplateAssessment)
-				if err != nil {
-					if gqlObject, ok := gqlErrParse(err); ok {
-						slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
-					}
-					return fmt.Errorf("could not fetch library assessment for %s: %w", ad.TemplateAssessment, err)
-				}
-				// if the defined library assessment does not exist, check to see if we have all library test cases
-				if len(t.LibraryAssessments.Nodes) == 0 {
-					slog.WarnContext(ctx, "Could not find library assessment, but checking all the test cases.", "template_assessment", ad.TemplateAssessment)
-				}
-			}
-			// now let's check the actual data
-			ids := slices.Collect(maps.Keys(ad.LibraryTestCases))
-			if err := validateLibraryTestCases(ctx, client, ids, ad.TemplateAssessment); err != nil {
-				return err
-			}
-
-		}
+	if err := prepareTemplates(ctx, client, db, ad, optionalParams); err != nil {
+		return "", err
+	}
+	if err := reconcileAssetsOnce(ctx, client, db, ad.Assessment.Campaigns, ad.Assets, optionalParams); err != nil {
+		return "", err
 	}
+
 	// Step 4: Create the assessment
 	slog.InfoContext(ctx, "Creating assessment",
 		"assessment_name", ad.Assessment.Name)
@@ -1326,75 +2085,267 @@ func RestoreAssessment(ctx context.Context, client graphql.Client, db string, ad
 			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
 		}
 		if isDuplicateGlobalIdError(err) {
-			return fmt.Errorf("could not create assessment container: %s, global-id: %s: %w", assessment.AssessmentData[0].Name, assessment.AssessmentData[0].GlobalId, ErrDuplicateGlobalId)
+			return "", fmt.Errorf("could not create assessment container: %s, global-id: %s: %w", assessment.AssessmentData[0].Name, assessment.AssessmentData[0].GlobalId, ErrDuplicateGlobalId)
+		}
+		return "", fmt.Errorf("could not create assessment container: %s: %w", assessment.AssessmentData[0].Name, err)
+	}
+	return a.Assessment.Create.Assessments[0].Id, nil
+}
+
+// prepareTemplates makes sure the library test cases the restored test cases
+// are created from are in the target instance (step 3 of RestoreAssessment):
+// it writes them under OverrideAssessmentTemplate, writes just the missing
+// ones under CreateMissingTemplates, and otherwise checks they already exist.
+func prepareTemplates(ctx context.Context, client graphql.Client, db string, ad *AssessmentData, optionalParams *RestoreOptionalParams) error {
+	// Step 3: Check if there is a template name in the seralized data, if so check in the instance (error if not)
+	// If the user wants to ignore error, go ahead and import template test cases
+	// If no template name, then go ahead and add template test cases in
+	if optionalParams.ForceEnvOnly {
+		slog.WarnContext(ctx, "--force-env-only set, skipping template/library test case validation", "assessment-name", ad.Assessment.Name)
+		return nil
+	}
+	if optionalParams.OverrideAssessmentTemplate {
+		slog.DebugContext(ctx, "adding template test cases directly")
+		if len(ad.LibraryTestCases) == 0 {
+			slog.InfoContext(ctx, "No library test cases found", "assessment-name", ad.Assessment.Name)
+			return nil
 		}
-		return fmt.Errorf("could not create assessment container: %s: %w", assessment.AssessmentData[0].Name, err)
+		return writeLibraryTestCases(ctx, client, db, ad.Assessment.Name, ad.LibraryTestCases, slices.Collect(maps.Keys(ad.LibraryTestCases)), true, optionalParams)
 	}
-	//a.Assessment.Create.Assessments[0].Id
 
-	err = restoreCampaigns(ctx, client, db, a.Assessment.Create.Assessments[0].Id, ad.Assessment.Name, ad.Assessment.Campaigns, org_map, toolIdByKey, ad.IdToolsMap, optionalParams)
+	if ad.TemplateAssessment != "" {
+		slog.DebugContext(ctx, "Validating template assessment in instance",
+			"template_assessment", ad.TemplateAssessment,
+			"override_template", optionalParams.OverrideAssessmentTemplate)
+		prefix := ""
+		for _, md := range ad.Assessment.Metadata {
+			if md.Key == "prefix" {
+				prefix = md.Value + " - "
+				break
+			}
+		}
+		t, err := dao.FindLibraryAssessment(ctx, client, prefix+ad.TemplateAssessment)
+		if err != nil {
+			if gqlObject, ok := gqlErrParse(err); ok {
+				slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+			}
+			return fmt.Errorf("could not fetch library assessment for %s: %w", ad.TemplateAssessment, err)
+		}
+		// if the defined library assessment does not exist, check to see if we have all library test cases
+		if len(t.LibraryAssessments.Nodes) == 0 {
+			slog.WarnContext(ctx, "Could not find library assessment, but checking all the test cases.", "template_assessment", ad.TemplateAssessment)
+		}
+	}
+	// now let's check the actual data
+	return ensureLibraryTestCases(ctx, client, db, ad, slices.Collect(maps.Keys(ad.LibraryTestCases)), optionalParams)
+}
+
+// ensureLibraryTestCases checks the library test cases ids refers to exist in
+// the target instance, first writing the missing ones from the archive under
+// CreateMissingTemplates. Under TemplateMatchName (all of ids) or
+// TemplateMatchIdThenName (the ones missing), ids are first matched by name,
+// and only the ones left unmatched need to exist by id.
+func ensureLibraryTestCases(ctx context.Context, client graphql.Client, db string, ad *AssessmentData, ids []string, optionalParams *RestoreOptionalParams) error {
+	ids = slices.Compact(slices.Sorted(slices.Values(ids)))
+	byName := ids
+	switch optionalParams.TemplateMatch {
+	case TemplateMatchIdThenName:
+		missing, err := findMissingLibraryTestCases(ctx, client, ids, ad.TemplateAssessment)
+		if err != nil {
+			return err
+		}
+		byName = missing
+		fallthrough
+	case TemplateMatchName:
+		if err := matchLibraryTestCasesByName(ctx, client, ad, byName, optionalParams); err != nil {
+			return err
+		}
+		journal := optionalParams.journal()
+		ids = slices.DeleteFunc(ids, func(id string) bool {
+			_, ok := journal.LibraryNameMatches[id]
+			return ok
+		})
+		slog.InfoContext(ctx, "Matched library test cases", "assessment-name", ad.Assessment.Name, "template-match", optionalParams.TemplateMatch, "by-name-count", len(journal.LibraryNameMatches), "by-id-count", len(ids))
+	}
+	if optionalParams.CreateMissingTemplates {
+		return writeLibraryTestCases(ctx, client, db, ad.Assessment.Name, ad.LibraryTestCases, ids, false, optionalParams)
+	}
+	return validateLibraryTestCases(ctx, client, ids, ad.TemplateAssessment)
+}
+
+// writeLibraryTestCases creates library test cases from library, the ones
+// saved with the assessment or library assessment called name, with
+// CreateTemplateTestCases. With overwrite, every one of ids is written,
+// replacing any that already exist; without it, only the ones missing from
+// the target instance are, and the existing ones are left untouched. Either
+// way only the ones missing beforehand count as created by this restore, so a
+// rollback never deletes a library test case that was already there.
+func writeLibraryTestCases(ctx context.Context, client graphql.Client, db string, name string, library LibraryTestCasesResource, ids []string, overwrite bool, optionalParams *RestoreOptionalParams) error {
+	ids = slices.Compact(slices.Sorted(slices.Values(ids)))
+	missing, err := findMissingLibraryTestCases(ctx, client, ids, name)
 	if err != nil {
-		if optionalParams.DeleteOnFailure {
-			slog.ErrorContext(ctx, "deleting assessment since a failure occured", "assessment-name", ad.Assessment.Name, "db", db)
-			ids, delErr := dao.DeleteAssessment(ctx, client, db, []string{a.Assessment.Create.Assessments[0].Id})
-			if delErr != nil {
-				// uh oh, things are very bad here.
-				slog.ErrorContext(ctx, "could not delete assessment", "error", delErr, "assessment-name", ad.Assessment.Name, "db", db)
-			} else { // use an else to fall back to the same return below since we want to output both problems
-				if len(ids.Assessment.Delete.DeletedIds) == 1 && strings.EqualFold(ids.Assessment.Delete.DeletedIds[0], a.Assessment.Create.Assessments[0].Id) {
-					slog.InfoContext(ctx, "Assessment cleaned up successfully...", "assessment-name", ad.Assessment.Name, "db", db)
-				} else {
-					slog.ErrorContext(ctx, "delete mismatch, the wrong item was deleted (not sure how this happened)", "expected-id", a.Assessment.Create.Assessments[0].Id, "deleted-id(s)", ids.Assessment.Delete.DeletedIds)
-				}
+		return err
+	}
+	toWrite := ids
+	if !overwrite {
+		toWrite = missing
+	}
+	if len(toWrite) == 0 {
+		slog.InfoContext(ctx, "All library test cases already exist in the instance", "assessment-name", name, "count", len(ids))
+		return nil
+	}
+
+	input := dao.CreateTestCaseTemplateInput{
+		Overwrite:            overwrite,
+		TestCaseTemplateData: []dao.CreateTestCaseTemplateDataInput{},
+	}
+	var notInArchive []string
+	for _, id := range toWrite {
+		template_test_case, ok := library[id]
+		if !ok {
+			notInArchive = append(notInArchive, id)
+			continue
+		}
+		slog.DebugContext(ctx, "library test case", "name", template_test_case.Name, "template_id", template_test_case.LibraryTestCaseId)
+		tctd, errors, err := createTemplateData(template_test_case)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not build template test case data",
+				"test-case-id", template_test_case.Id,
+				"test-case-library-id", template_test_case.LibraryTestCaseId,
+				"test-case-name", template_test_case.Name,
+				"assessment-name", name,
+				"db", db,
+				"err", err,
+			)
+			return err
+		}
+		if len(errors) > 0 {
+			for _, err := range errors {
+				slog.WarnContext(ctx, "parsing discrepencies found, they were recovered but review if needed",
+					"test-case-id", template_test_case.Id,
+					"test-case-library-id", template_test_case.LibraryTestCaseId,
+					"test-case-name", template_test_case.Name,
+					"assessment-name", name,
+					"db", db,
+					"err", err,
+				)
+
 			}
 		}
-		return fmt.Errorf("could not create campaigns and test cases for assessment %s: %w", ad.Assessment.Name, err)
+		input.TestCaseTemplateData = append(input.TestCaseTemplateData, tctd)
+	}
+	if len(notInArchive) > 0 {
+		slog.ErrorContext(ctx, "library test cases missing from the instance are not in the archive either, so they can't be created", "missing-ids", notInArchive)
+		return fmt.Errorf("could not create missing library test cases, %d are not in the archive: %w", len(notInArchive), ErrMissingLibraryTestCases)
 	}
 
-	slog.InfoContext(ctx, "Assessment restored successfully", "assessment-name", ad.Assessment.Name)
-	return nil
+	r, err := dao.CreateTemplateTestCases(ctx, client, input)
+	if err != nil {
+		if gqlObject, ok := gqlErrParse(err); ok {
+			slog.ErrorContext(ctx, "full gql error", "error", gqlObject)
+		}
 
+		return fmt.Errorf("could not write template test cases: %w", err)
+	}
+	var created []string
+	for _, tc := range r.TestCase.CreateTemplate.TestCases {
+		if slices.Contains(missing, tc.LibraryTestCaseId) {
+			created = append(created, tc.Id)
+		}
+	}
+	if err := optionalParams.recordCreated(ctx, &optionalParams.journal().Created.TestCaseTemplates, created...); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "inserted library test cases", "total", len(input.TestCaseTemplateData), "created", len(created), "overwrite", overwrite)
+	return nil
 }
 
-// RestoreCampaign restores a specific campaign from serialized assessment data
-// into an existing assessment in the target VECTR instance. It validates
-// prerequisites such as organizations and tools before proceeding with the
-// restore.
+// RestoreCampaign restores selected campaigns from serialized assessment
+// data into an existing assessment in the target VECTR instance. It validates
+// prerequisites such as organizations and tools once, for the combined
+// selection, before proceeding with the restore.
 //
 // Parameters:
 //   - ctx: The context for managing request lifetimes and cancellations.
 //   - client: The GraphQL client for interacting with the VECTR instance.
 //   - db: The database name in the VECTR instance.
-//   - ad: The serialized assessment data containing the campaign to restore.
-//   - sourceCampaignName: The name of the campaign within the assessment data
-//     to be restored.
+//   - ad: The serialized assessment data containing the campaigns to restore.
+//   - sourceCampaigns: Patterns (see util.NewNamePattern: exact names,
+//     globs, or "re:" regular expressions) selecting the campaigns within
+//     the assessment data to be restored. Each must match at least one.
 //   - targetAssessmentName: The name of the existing assessment in the target
-//     instance where the campaign should be added.
+//     instance where the campaigns should be added.
+//
+// `optionalParams.TestCaseFilter` further narrows the restore to the test
+// cases it selects; campaigns it leaves empty are skipped.
 //
 // Returns:
-//   - error: Returns nil on success, or an error if the campaign cannot be
+//   - error: Returns nil on success, or an error if a campaign cannot be
 //     found, prerequisites are missing, or the restore process fails.
 //
 // Error Handling:
-//   - Returns `ErrCampaignNotFound` if the source campaign is not in the data.
+//   - Returns `ErrCampaignNotFound` if a pattern matches no source campaign.
+//   - Returns `ErrNoTestCasesSelected` if the filter leaves nothing to restore.
 //   - Returns an error if the target assessment is not found in the database.
 //   - Returns an error if library test cases, organizations, or tools are
 //     missing in the target instance.
 //   - Returns any error propagated from `restoreCampaigns`.
-func RestoreCampaign(ctx context.Context, client graphql.Client, db string, ad *AssessmentData, sourceCampaignName, targetAssessmentName string, optionalParams *RestoreOptionalParams) error {
-	slog.InfoContext(ctx, "Starting RestoreCampaign", "db", db, "source_campaign", sourceCampaignName, "target_assessment", targetAssessmentName)
+//
+// If it fails and `DeleteOnFailure` is true, the campaigns and anything else
+// the restore created are deleted again with `RollbackRestore`.
+func RestoreCampaign(ctx context.Context, client graphql.Client, db string, ad *AssessmentData, sourceCampaigns []string, targetAssessmentName string, optionalParams *RestoreOptionalParams) error {
+	slog.InfoContext(ctx, "Starting RestoreCampaign", "db", db, "source_campaigns", sourceCampaigns, "target_assessment", targetAssessmentName, "test-case-filter", optionalParams.TestCaseFilter.String())
 
-	var campaignToRestore dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign
-	found := false
-	for _, c := range ad.Assessment.Campaigns {
-		if c.Name == sourceCampaignName {
-			campaignToRestore = c
-			found = true
-			break
+	patterns, err := util.NewNamePatterns(sourceCampaigns)
+	if err != nil {
+		return fmt.Errorf("invalid source campaign selection: %w", err)
+	}
+	if len(patterns) == 0 {
+		return fmt.Errorf("no source campaign selected: %w", ErrCampaignNotFound)
+	}
+	if err := checkTemplateMatch(optionalParams); err != nil {
+		return err
+	}
+
+	if err := optionalParams.startJournal(ctx, db, strings.Join(sourceCampaigns, ","), ad); err != nil {
+		return err
+	}
+	journal := optionalParams.journal()
+	if journal.Complete {
+		slog.InfoContext(ctx, "Journal shows this restore already completed, nothing to resume", "source-campaigns", sourceCampaigns, "target-assessment", journal.AssessmentName, "db", db)
+		return nil
+	}
+
+	if err := restoreCampaign(ctx, client, db, ad, patterns, targetAssessmentName, optionalParams); err != nil {
+		if optionalParams.DeleteOnFailure {
+			optionalParams.rollbackOnFailure(ctx, client)
 		}
+		return err
+	}
+	return nil
+}
+
+// restoreCampaign runs the journaled steps of RestoreCampaign.
+func restoreCampaign(ctx context.Context, client graphql.Client, db string, ad *AssessmentData, patterns []util.NamePattern, targetAssessmentName string, optionalParams *RestoreOptionalParams) error {
+	journal := optionalParams.journal()
+	campaigns, err := selectCampaigns(ad, patterns)
+	if err != nil {
+		return err
 	}
-	if !found {
-		return fmt.Errorf("in assessment data for '%s': %w: %s", ad.Assessment.Name, ErrCampaignNotFound, sourceCampaignName)
+	// Filter before mapping organizations, as restoreAssessment does, so an
+	// organization filter always matches source organization names.
+	campaigns, err = filterTestCases(ctx, campaigns, optionalParams.TestCaseFilter)
+	if err != nil {
+		return err
+	}
+	ad.Assessment.Campaigns = campaigns
+	if err := applyOrgMapping(ctx, client, db, ad, optionalParams.OrgMapping); err != nil {
+		return err
+	}
+	campaigns = ad.Assessment.Campaigns
+	campaignNames := make([]string, 0, len(campaigns))
+	for _, c := range campaigns {
+		campaignNames = append(campaignNames, c.Name)
 	}
 
 	targetAssessment, err := dao.FindExistingAssessment(ctx, client, db, targetAssessmentName)
@@ -1408,39 +2359,50 @@ func RestoreCampaign(ctx context.Context, client graphql.Client, db string, ad *
 		return fmt.Errorf("target assessment '%s' not found in database '%s'", targetAssessmentName, db)
 	}
 	targetAssessmentId := targetAssessment.Assessments.Nodes[0].Id
+	if journal.AssessmentId != "" && journal.AssessmentId != targetAssessmentId {
+		return fmt.Errorf("journal is for target assessment %q (id %s), not %q (id %s): %w", journal.AssessmentName, journal.AssessmentId, targetAssessmentName, targetAssessmentId, ErrJournalMismatch)
+	}
+	journal.AssessmentName = targetAssessmentName
+	journal.AssessmentId = targetAssessmentId
+	if err := optionalParams.checkpoint(ctx); err != nil {
+		return err
+	}
 
-	// Collect and validate library test case IDs for the specific campaign
+	// Collect and validate library test case IDs for the selected campaigns
 	libraryTestCaseIDs := []string{}
-	for _, tc := range campaignToRestore.TestCases {
-		if tc.LibraryTestCaseId != "" && tc.LibraryTestCaseId != "null" {
-			libraryTestCaseIDs = append(libraryTestCaseIDs, tc.LibraryTestCaseId)
+	for _, c := range campaigns {
+		for _, tc := range c.TestCases {
+			if tc.LibraryTestCaseId != "" && tc.LibraryTestCaseId != "null" {
+				libraryTestCaseIDs = append(libraryTestCaseIDs, tc.LibraryTestCaseId)
+			}
 		}
 	}
 
 	if optionalParams.ForceEnvOnly {
-		slog.WarnContext(ctx, "--force-env-only set, skipping library test case validation", "assessment-name", ad.Assessment.Name, "campaign-name", sourceCampaignName)
+		slog.WarnContext(ctx, "--force-env-only set, skipping library test case validation", "assessment-name", ad.Assessment.Name, "campaign-names", campaignNames)
 	} else {
-		if err := validateLibraryTestCases(ctx, client, libraryTestCaseIDs, ad.TemplateAssessment); err != nil {
+		if err := ensureLibraryTestCases(ctx, client, db, ad, libraryTestCaseIDs, optionalParams); err != nil {
 			return err
 		}
 	}
 
-	// Collect tools for the specific campaign
+	// Collect tools and organizations for the selected campaigns, so each is
+	// reconciled once however many campaigns use it
 	campaignToolsToReconcile := make(map[string]DefenseToolRef)
-	for _, tc := range campaignToRestore.TestCases {
-		for _, outcome := range tc.DefenseToolOutcomes {
-			toolID := strconv.Itoa(outcome.DefenseToolId)
-			if tool, ok := ad.IdToolsMap[toolID]; ok {
-				campaignToolsToReconcile[tool.Key()] = tool
+	campaignOrgMap := make(map[string]dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentOrganizationsOrganization)
+	for _, c := range campaigns {
+		for _, tc := range c.TestCases {
+			for _, outcome := range tc.DefenseToolOutcomes {
+				toolID := strconv.Itoa(outcome.DefenseToolId)
+				if tool, ok := ad.IdToolsMap[toolID]; ok {
+					campaignToolsToReconcile[tool.Key()] = tool
+				}
 			}
 		}
-	}
-
-	// Restrict the org map to the organizations used by this campaign
-	campaignOrgMap := make(map[string]dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentOrganizationsOrganization, len(campaignToRestore.Organizations))
-	for _, org := range campaignToRestore.Organizations {
-		if orgDetail, ok := ad.OrgMap[org.Name]; ok {
-			campaignOrgMap[org.Name] = orgDetail
+		for _, org := range c.Organizations {
+			if orgDetail, ok := ad.OrgMap[org.Name]; ok {
+				campaignOrgMap[org.Name] = orgDetail
+			}
 		}
 	}
 
@@ -1449,12 +2411,88 @@ func RestoreCampaign(ctx context.Context, client graphql.Client, db string, ad *
 		return err
 	}
 
-	toolIdByKey, err := reconcileDefenseTools(ctx, client, db, campaignToolsToReconcile)
+	toolIdByKey, err := reconcileDefenseToolsOnce(ctx, client, db, campaignToolsToReconcile, optionalParams)
 	if err != nil {
 		return err
 	}
 
-	return restoreCampaigns(ctx, client, db, targetAssessmentId, targetAssessmentName, []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign{campaignToRestore}, org_map, toolIdByKey, ad.IdToolsMap, optionalParams)
+	if err := reconcileAssetsOnce(ctx, client, db, campaigns, ad.Assets, optionalParams); err != nil {
+		return err
+	}
+
+	if err := restoreCampaigns(ctx, client, db, targetAssessmentId, targetAssessmentName, campaigns, org_map, toolIdByKey, ad.IdToolsMap, optionalParams); err != nil {
+		return err
+	}
+	journal.Complete = true
+	return optionalParams.checkpoint(ctx)
+}
+
+// selectCampaigns returns the campaigns in ad matching any of patterns, in
+// their order in ad. Every pattern has to match at least one campaign, so a
+// typo isn't silently a smaller restore.
+func selectCampaigns(ad *AssessmentData, patterns []util.NamePattern) ([]dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign, error) {
+	var selected []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign
+	used := make([]bool, len(patterns))
+	for _, c := range ad.Assessment.Campaigns {
+		matched := false
+		for i, p := range patterns {
+			if p.Match(c.Name) {
+				used[i] = true
+				matched = true
+			}
+		}
+		if matched {
+			selected = append(selected, c)
+		}
+	}
+	var unmatched []string
+	for i, p := range patterns {
+		if !used[i] {
+			unmatched = append(unmatched, p.String())
+		}
+	}
+	if len(unmatched) > 0 {
+		return nil, fmt.Errorf("in assessment data for '%s': %w: %s", ad.Assessment.Name, ErrCampaignNotFound, strings.Join(unmatched, ", "))
+	}
+	return selected, nil
+}
+
+// filterTestCases narrows campaigns to the test cases filter selects,
+// dropping campaigns left with none. A nil filter returns campaigns as-is.
+func filterTestCases(ctx context.Context, campaigns []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign, filter *util.TestCaseFilter) ([]dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign, error) {
+	if filter == nil {
+		return campaigns, nil
+	}
+	var kept []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign
+	total, selected := 0, 0
+	for _, c := range campaigns {
+		var testCases []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase
+		for _, tc := range c.TestCases {
+			fields := util.TestCaseFields{Name: tc.Name, Technique: tc.MitreId, Status: tc.Status}
+			for _, tag := range tc.Tags {
+				fields.Tags = append(fields.Tags, tag.Name)
+			}
+			for _, org := range tc.Organizations {
+				fields.Organizations = append(fields.Organizations, org.Name)
+			}
+			if filter.Match(fields) {
+				testCases = append(testCases, tc)
+			}
+		}
+		total += len(c.TestCases)
+		selected += len(testCases)
+		if len(testCases) == 0 {
+			slog.InfoContext(ctx, "Skipping campaign, no test cases match the test case filter", "campaign-name", c.Name, "test-case-filter", filter.String())
+			continue
+		}
+		c.TestCases = testCases
+		kept = append(kept, c)
+	}
+	if selected == 0 {
+		return nil, fmt.Errorf("%d test case(s) in %d campaign(s), filter %q: %w", total, len(campaigns), filter.String(), ErrNoTestCasesSelected)
+	}
+	slog.InfoContext(ctx, "Selected test cases with the test case filter", "test-case-filter", filter.String(), "selected-count", selected, "total-count", total, "campaign-count", len(kept))
+	return kept, nil
 }
 
 func loadVatMetadata(md []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentMetadataMetadataKeyValuePair, manifest Manifest, restoreInfo VatOpMetadata) []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentMetadataMetadataKeyValuePair {
@@ -1514,18 +2552,24 @@ func createTemplateData(template_test_case dao.GetLibraryTestCasesLibraryTestcas
 	if template_test_case.AutomationCmd != "" {
 		ttc.AttackAutomation, errors = buildAttackAutomationInput(&template_test_case)
 	}
+	ttc.Name, ttc.TemplatePrefix = splitTemplatePrefix(template_test_case.Name, ttc.BlueTeamMetadata)
+	return ttc, errors, nil
+}
+
+// splitTemplatePrefix returns the template prefix a library object's
+// "prefix" metadata names, if any, and name without the "<prefix> - " VECTR
+// shows in front of it.
+func splitTemplatePrefix(name string, metadata []dao.MetadataKeyValuePairInput) (string, string) {
 	// check for the prefix
-	for _, md := range template_test_case.Metadata {
+	for _, md := range metadata {
 		if md.Key == "prefix" {
-			ttc.TemplatePrefix = md.Value
 			// There is a bug in the template test case create where if there is a prefix it will keep adding,
 			// it onto the name, you gotta remove it to insert it.
 			// #VECTRBUG
-			ttc.Name = strings.TrimPrefix(template_test_case.Name, ttc.TemplatePrefix+" - ")
-			break
+			return strings.TrimPrefix(name, md.Value+" - "), md.Value
 		}
 	}
-	return ttc, errors, nil
+	return name, ""
 }
 
 func buildAttackAutomationInput[A AutomationArgumentTypes, PA pointerAutomationArgs[A], T Automator[A]](automator T) (*dao.AttackAutomationInput, []error) {
@@ -5,13 +5,20 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"reflect"
 	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"sra/vat/internal/dao"
+	"sra/vat/internal/util"
 
 	"github.com/Khan/genqlient/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
 	"pgregory.net/rapid"
 )
 
@@ -94,40 +101,77 @@ func TestCreateTemplateData_MissingOrganization(t *testing.T) {
 	}
 }
 
+// TestSkipUnrestorable verifies the attachment files and unstructured logs
+// of the test cases a restore writes are counted as skipped, leaving out test
+// cases that weren't restored or were written by the restore being resumed.
+func TestSkipUnrestorable(t *testing.T) {
+	type attachmentFile = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCaseAttachmentFilesAttachmentFile
+	type unstructuredLog = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCaseUnstructuredLogsUnstructuredLog
+	campaign := dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign{
+		Name: "campaign-1",
+		TestCases: []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase{
+			{Id: "src-1", Name: "first",
+				AttachmentFiles: []attachmentFile{
+					{Id: 1, Filename: "screenshot.png"},
+					{Id: 2, Filename: "evidence.txt"},
+				},
+				UnstructuredLogs: []unstructuredLog{{Filename: "siem.log", Content: "event=1"}},
+			},
+			{Id: "src-2", Name: "not restored",
+				AttachmentFiles:  []attachmentFile{{Id: 3, Filename: "ignored.png"}},
+				UnstructuredLogs: []unstructuredLog{{Filename: "ignored.log", Content: "event=2"}},
+			},
+			{Id: "src-3", Name: "resumed",
+				AttachmentFiles:  []attachmentFile{{Id: 4, Filename: "earlier.png"}},
+				UnstructuredLogs: []unstructuredLog{{Filename: "earlier.log", Content: "event=3"}},
+			},
+		},
+	}
+	testCaseIdMap := map[string]string{"src-1": "new-1", "src-3": "new-3"}
+	resumed := map[string]string{"src-3": "new-3"}
+
+	skipped := skipUnrestorable(context.Background(), "assessment", campaign, testCaseIdMap, resumed, "attachment file", func(tc dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase) int {
+		return len(tc.AttachmentFiles)
+	})
+	if skipped != 2 {
+		t.Errorf("skipped %d attachment files, want 2", skipped)
+	}
+	skipped = skipUnrestorable(context.Background(), "assessment", campaign, testCaseIdMap, resumed, "unstructured log", func(tc dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase) int {
+		return len(tc.UnstructuredLogs)
+	})
+	if skipped != 1 {
+		t.Errorf("skipped %d unstructured logs, want 1", skipped)
+	}
+}
+
 // TestGroupedCreateTestCaseWithLibraryIdInput_Batching is a property test:
-// for any assignment of source test cases to library test case IDs (with
-// repeats allowed, to simulate the same library test case used multiple
-// times in a campaign), GenerateInsertsData must split entries into exactly
-// maxGroupSize batches, never put the same libraryTestCaseId in a batch
-// twice, and account for every added entry (identified by its
-// TestCaseData.ClientId) exactly once.
+// for any sequence of source test cases referencing library test case IDs
+// (with repeats allowed, to simulate the same library test case used
+// multiple times in a campaign), GenerateInsertsData must never put the same
+// libraryTestCaseId in a batch twice, must need at least maxGroupSize
+// batches, and must account for every added entry (identified by its
+// TestCaseData.ClientId) exactly once, in the order it was added.
 func TestGroupedCreateTestCaseWithLibraryIdInput_Batching(t *testing.T) {
 	rapid.Check(t, func(t *rapid.T) {
 		numLibraryIds := rapid.IntRange(1, 6).Draw(t, "numLibraryIds")
+		total := rapid.IntRange(0, 20).Draw(t, "total")
 
 		g := NewGroupedCreateTestCaseWithLibraryIdInput("test-db", "campaign-1")
 
+		groupSizes := make(map[string]int)
 		maxGroupSize := 0
-		total := 0
-		clientCounter := 0
-		wantClientIds := make(map[string]bool)
-
-		for i := 0; i < numLibraryIds; i++ {
-			libId := fmt.Sprintf("lib-%d", i)
-			count := rapid.IntRange(0, 5).Draw(t, fmt.Sprintf("count-%d", i))
-			if count > maxGroupSize {
-				maxGroupSize = count
-			}
-			for j := 0; j < count; j++ {
-				clientId := fmt.Sprintf("src-%d", clientCounter)
-				clientCounter++
-				total++
-				wantClientIds[clientId] = true
-				g.Add(dao.CreateTestCaseDataWithLibraryIdInput{
-					LibraryTestCaseId: libId,
-					TestCaseData:      dao.CreateTestCaseDataInput{ClientId: clientId},
-				})
-			}
+		wantClientIds := make([]string, 0, total)
+
+		for i := 0; i < total; i++ {
+			libId := fmt.Sprintf("lib-%d", rapid.IntRange(0, numLibraryIds-1).Draw(t, "libId"))
+			groupSizes[libId]++
+			maxGroupSize = max(maxGroupSize, groupSizes[libId])
+			clientId := fmt.Sprintf("src-%d", i)
+			wantClientIds = append(wantClientIds, clientId)
+			g.Add(dao.CreateTestCaseDataWithLibraryIdInput{
+				LibraryTestCaseId: libId,
+				TestCaseData:      dao.CreateTestCaseDataInput{ClientId: clientId},
+			})
 		}
 
 		if got := g.Len(); got != total {
@@ -141,36 +185,27 @@ func TestGroupedCreateTestCaseWithLibraryIdInput_Batching(t *testing.T) {
 			}
 			return
 		}
-		if len(batches) != maxGroupSize {
-			t.Fatalf("got %d batches, want %d (max group size)", len(batches), maxGroupSize)
+		if len(batches) < maxGroupSize {
+			t.Fatalf("got %d batches, want at least %d (max group size)", len(batches), maxGroupSize)
 		}
 
-		seenClientIds := make(map[string]bool)
-		totalEntries := 0
+		gotClientIds := make([]string, 0, total)
 		for bi, batch := range batches {
+			if len(batch.CreateTestCaseInputs) == 0 {
+				t.Fatalf("batch %d is empty", bi)
+			}
 			seenLibIdsInBatch := make(map[string]bool)
 			for _, input := range batch.CreateTestCaseInputs {
 				if seenLibIdsInBatch[input.LibraryTestCaseId] {
 					t.Fatalf("batch %d contains duplicate libraryTestCaseId %q", bi, input.LibraryTestCaseId)
 				}
 				seenLibIdsInBatch[input.LibraryTestCaseId] = true
-
-				clientId := input.TestCaseData.ClientId
-				if seenClientIds[clientId] {
-					t.Fatalf("clientId %q appeared in more than one batch", clientId)
-				}
-				seenClientIds[clientId] = true
-				totalEntries++
+				gotClientIds = append(gotClientIds, input.TestCaseData.ClientId)
 			}
 		}
 
-		if totalEntries != total {
-			t.Fatalf("total entries across all batches = %d, want %d", totalEntries, total)
-		}
-		for clientId := range wantClientIds {
-			if !seenClientIds[clientId] {
-				t.Fatalf("clientId %q was added but never appeared in any batch", clientId)
-			}
+		if !slices.Equal(gotClientIds, wantClientIds) {
+			t.Fatalf("batches hold %v, want every entry once in insertion order %v", gotClientIds, wantClientIds)
 		}
 	})
 }
@@ -182,6 +217,7 @@ func TestGroupedCreateTestCaseWithLibraryIdInput_Batching(t *testing.T) {
 // and with what input.
 type scriptedGraphQLClient struct {
 	responses map[string]json.RawMessage
+	errs      map[string]error
 	calls     []string
 	variables map[string]json.RawMessage
 }
@@ -194,6 +230,9 @@ func (s *scriptedGraphQLClient) MakeRequest(_ context.Context, req *graphql.Requ
 		}
 		s.variables[req.OpName] = raw
 	}
+	if err, ok := s.errs[req.OpName]; ok {
+		return err
+	}
 	raw, ok := s.responses[req.OpName]
 	if !ok {
 		return fmt.Errorf("scriptedGraphQLClient: no stubbed response for operation %q", req.OpName)
@@ -270,7 +309,7 @@ func TestReconcileDefenseTools_CleanMatch(t *testing.T) {
 
 	result, err := reconcileDefenseTools(context.Background(), client, "test-db", map[string]DefenseToolRef{
 		existingToolRef.Key(): existingToolRef,
-	})
+	}, &RestoreOptionalParams{})
 	if err != nil {
 		t.Fatalf("reconcileDefenseTools returned an error: %v", err)
 	}
@@ -319,7 +358,7 @@ func TestReconcileDefenseTools_MissingLayers(t *testing.T) {
 
 	result, err := reconcileDefenseTools(context.Background(), client, "test-db", map[string]DefenseToolRef{
 		ref.Key(): ref,
-	})
+	}, &RestoreOptionalParams{})
 	if err != nil {
 		t.Fatalf("reconcileDefenseTools returned an error: %v", err)
 	}
@@ -357,7 +396,7 @@ func TestReconcileDefenseTools_ToolMatchViaProductRefMismatch(t *testing.T) {
 
 	result, err := reconcileDefenseTools(context.Background(), client, "test-db", map[string]DefenseToolRef{
 		ref.Key(): ref,
-	})
+	}, &RestoreOptionalParams{})
 	if err != nil {
 		t.Fatalf("reconcileDefenseTools returned an error: %v", err)
 	}
@@ -419,9 +458,10 @@ func TestReconcileDefenseTools_NoMatch(t *testing.T) {
 		}`),
 	}}
 
+	params := &RestoreOptionalParams{}
 	result, err := reconcileDefenseTools(context.Background(), client, "test-db", map[string]DefenseToolRef{
 		ref.Key(): ref,
-	})
+	}, params)
 	if err != nil {
 		t.Fatalf("reconcileDefenseTools returned an error: %v", err)
 	}
@@ -437,6 +477,14 @@ func TestReconcileDefenseTools_NoMatch(t *testing.T) {
 	if client.called("UpdateDefenseTool") {
 		t.Error("expected no update to the unrelated existing tool")
 	}
+	want := CreatedObjects{
+		DefenseTools:         []string{"target-tool-2"},
+		DefenseToolProducts:  []string{"target-product-2"},
+		LibraryDefenseLayers: []string{"target-library-layer-placeholder"},
+	}
+	if got := params.journal().Created; !reflect.DeepEqual(got, want) {
+		t.Errorf("journal.Created = %+v, want %+v (the reused Endpoint layer isn't created)", got, want)
+	}
 
 	var vars struct {
 		Input dao.CreateDefenseToolProductInput `json:"input"`
@@ -504,7 +552,7 @@ func TestReconcileDefenseTools_ToolWithNoLayersGetsPlaceholder(t *testing.T) {
 
 	result, err := reconcileDefenseTools(context.Background(), client, "test-db", map[string]DefenseToolRef{
 		ref.Key(): ref,
-	})
+	}, &RestoreOptionalParams{})
 	if err != nil {
 		t.Fatalf("reconcileDefenseTools returned an error: %v", err)
 	}
@@ -571,7 +619,7 @@ func TestReconcileDefenseTools_PlaceholderLayerAlreadyExistsIsReused(t *testing.
 
 	result, err := reconcileDefenseTools(context.Background(), client, "test-db", map[string]DefenseToolRef{
 		ref.Key(): ref,
-	})
+	}, &RestoreOptionalParams{})
 	if err != nil {
 		t.Fatalf("reconcileDefenseTools returned an error: %v", err)
 	}
@@ -644,7 +692,7 @@ func TestReconcileDefenseTools_NewProductWithLibraryLayers(t *testing.T) {
 
 	result, err := reconcileDefenseTools(context.Background(), client, "test-db", map[string]DefenseToolRef{
 		ref.Key(): ref,
-	})
+	}, &RestoreOptionalParams{})
 	if err != nil {
 		t.Fatalf("reconcileDefenseTools returned an error: %v", err)
 	}
@@ -730,7 +778,7 @@ func TestReconcileDefenseTools_NoDuplicateToolWithinOneRun(t *testing.T) {
 	result, err := reconcileDefenseTools(context.Background(), client, "test-db", map[string]DefenseToolRef{
 		refA.Key(): refA,
 		refB.Key(): refB,
-	})
+	}, &RestoreOptionalParams{})
 	if err != nil {
 		t.Fatalf("reconcileDefenseTools returned an error: %v", err)
 	}
@@ -795,7 +843,7 @@ func TestReconcileDefenseTools_ProductMatchByNameFallback(t *testing.T) {
 
 	result, err := reconcileDefenseTools(context.Background(), client, "test-db", map[string]DefenseToolRef{
 		ref.Key(): ref,
-	})
+	}, &RestoreOptionalParams{})
 	if err != nil {
 		t.Fatalf("reconcileDefenseTools returned an error: %v", err)
 	}
@@ -862,7 +910,7 @@ func TestReconcileDefenseTools_BlankDataRejected(t *testing.T) {
 
 			_, err := reconcileDefenseTools(context.Background(), client, "test-db", map[string]DefenseToolRef{
 				ref.Key(): ref,
-			})
+			}, &RestoreOptionalParams{})
 			if err == nil {
 				t.Fatal("expected an error for blank defense tool data, got nil")
 			}
@@ -875,3 +923,2085 @@ func TestReconcileDefenseTools_BlankDataRejected(t *testing.T) {
 		})
 	}
 }
+
+// TestSaveLookups verifies a failed lookup is fetched again by the next
+// caller while a successful one is fetched once for the whole dump.
+func TestSaveLookups(t *testing.T) {
+	client := &scriptedGraphQLClient{
+		responses: map[string]json.RawMessage{
+			"GetAllDefenseTools": json.RawMessage(`{"bluetools": {"nodes": [{"id": "1", "name": "Falcon Sensor"}], "pageInfo": {"hasNextPage": false}}}`),
+		},
+		errs: map[string]error{"GetAllDefenseTools": errors.New("connection reset")},
+	}
+	lookups := newSaveLookups()
+
+	if _, err := lookups.defenseTools(context.Background(), client, "test-db"); err == nil {
+		t.Fatal("expected the first lookup to fail")
+	}
+	delete(client.errs, "GetAllDefenseTools")
+	for range 2 {
+		tools, err := lookups.defenseTools(context.Background(), client, "test-db")
+		if err != nil || len(tools) != 1 {
+			t.Fatalf("defenseTools = %v, %v; want the one tool", tools, err)
+		}
+	}
+	if n := len(client.calls); n != 2 {
+		t.Errorf("made %d requests, want 2: one failed, then one cached", n)
+	}
+}
+
+// TestRestoreCampaigns_PreservesSourceOrder verifies that campaigns are
+// created in source offset order, and that test cases are written in source
+// offset order even when they alternate between the template and
+// no-template create mutations.
+func TestRestoreCampaigns_PreservesSourceOrder(t *testing.T) {
+	client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"CreateCampaigns":            json.RawMessage(`{"campaign": {"create": {"campaigns": [{"id": "new-c1", "name": "first"}, {"id": "new-c2", "name": "second"}]}}}`),
+		"CreateTestCasesByLibraryId": json.RawMessage(`{"testCase": {"createWithTemplateMatchByLibraryId": {"testCaseCreateItems": []}}}`),
+		"CreateTestCasesNoTemplate":  json.RawMessage(`{"testCase": {"createWithoutTemplate": {"testCaseCreateItems": []}}}`),
+	}}
+
+	org := []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCaseOrganizationsOrganization{{Name: "org"}}
+	campaignsToRestore := []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign{
+		{Name: "second", Offset: 1},
+		{
+			Name:   "first",
+			Offset: 0,
+			TestCases: []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase{
+				{Id: "tc-c", Offset: 2, LibraryTestCaseId: "lib-2", Organizations: org},
+				{Id: "tc-b", Offset: 1, Organizations: org},
+				{Id: "tc-a", Offset: 0, LibraryTestCaseId: "lib-1", Organizations: org},
+			},
+		},
+	}
+
+	err := restoreCampaigns(
+		context.Background(),
+		client,
+		"test-db",
+		"assessment-1",
+		"assessment-name",
+		campaignsToRestore,
+		map[string]dao.FindOrganizationOrganizationsOrganizationConnectionNodesOrganization{},
+		map[string]string{},
+		map[string]DefenseToolRef{},
+		&RestoreOptionalParams{},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var campaignVars struct {
+		Input dao.CreateCampaignInput `json:"input"`
+	}
+	if err := json.Unmarshal(client.variables["CreateCampaigns"], &campaignVars); err != nil {
+		t.Fatalf("could not decode CreateCampaigns variables: %v", err)
+	}
+	var gotCampaigns []string
+	for _, c := range campaignVars.Input.CampaignData {
+		gotCampaigns = append(gotCampaigns, c.Name)
+	}
+	if want := []string{"first", "second"}; !slices.Equal(gotCampaigns, want) {
+		t.Errorf("campaigns created as %v, want %v", gotCampaigns, want)
+	}
+
+	wantCalls := []string{"CreateCampaigns", "CreateTestCasesByLibraryId", "CreateTestCasesNoTemplate", "CreateTestCasesByLibraryId"}
+	if !slices.Equal(client.calls, wantCalls) {
+		t.Errorf("calls = %v, want %v", client.calls, wantCalls)
+	}
+
+	var lastLibraryVars struct {
+		Input dao.CreateTestCaseMatchByLibraryIdInput `json:"input"`
+	}
+	if err := json.Unmarshal(client.variables["CreateTestCasesByLibraryId"], &lastLibraryVars); err != nil {
+		t.Fatalf("could not decode CreateTestCasesByLibraryId variables: %v", err)
+	}
+	if got := lastLibraryVars.Input.CreateTestCaseInputs; len(got) != 1 || got[0].TestCaseData.ClientId != "tc-c" {
+		t.Errorf("expected the last library create to hold only tc-c, got %+v", got)
+	}
+}
+
+// TestApplyOrgMapping verifies explicit org-map entries and the fallback are
+// applied to every place an organization is named, that the fallback is only
+// used for orgs missing from the target instance, and that orgs collapsing
+// onto the same target aren't listed twice.
+func TestApplyOrgMapping(t *testing.T) {
+	client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"FindOrganization": json.RawMessage(`{"organizations": {"nodes": []}}`),
+	}}
+	mapping, err := util.NewOrgMapping(strings.NewReader(`"Acme Red","Default"` + "\n" + `"Kept","Kept"` + "\n" + `"*","Default"` + "\n"))
+	if err != nil {
+		t.Fatalf("could not build org mapping: %v", err)
+	}
+
+	type assessmentOrg = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentOrganizationsOrganization
+	ad := &AssessmentData{
+		OrgMap: map[string]assessmentOrg{
+			"Acme Red": {Name: "Acme Red"},
+			"Missing":  {Name: "Missing"},
+			"Kept":     {Name: "Kept"},
+		},
+		LibraryTestCases: LibraryTestCasesResource{
+			"lib-1": {Organizations: []dao.GetLibraryTestCasesLibraryTestcasesByIdsTestCaseConnectionNodesTestCaseOrganizationsOrganization{{Name: "Missing"}}},
+		},
+	}
+	ad.Assessment.Organizations = []assessmentOrg{{Name: "Acme Red"}, {Name: "Missing"}, {Name: "Kept"}}
+	ad.Assessment.Campaigns = []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign{{
+		Name:          "campaign-1",
+		Organizations: []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignOrganizationsOrganization{{Name: "Acme Red"}},
+		TestCases: []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase{{
+			Id:            "tc-1",
+			Organizations: []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCaseOrganizationsOrganization{{Name: "Kept"}, {Name: "Missing"}},
+		}},
+	}}
+
+	if err := applyOrgMapping(context.Background(), client, "test-db", ad, mapping); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := slices.Sorted(maps.Keys(ad.OrgMap)); !slices.Equal(got, []string{"Default", "Kept"}) {
+		t.Errorf("OrgMap keys = %v, want [Default Kept]", got)
+	}
+	var assessmentOrgs []string
+	for _, o := range ad.Assessment.Organizations {
+		assessmentOrgs = append(assessmentOrgs, o.Name)
+	}
+	if want := []string{"Default", "Kept"}; !slices.Equal(assessmentOrgs, want) {
+		t.Errorf("assessment orgs = %v, want %v", assessmentOrgs, want)
+	}
+	if got := ad.Assessment.Campaigns[0].Organizations[0].Name; got != "Default" {
+		t.Errorf("campaign org = %q, want Default", got)
+	}
+	if got := ad.Assessment.Campaigns[0].TestCases[0].Organizations; got[0].Name != "Kept" || got[1].Name != "Default" {
+		t.Errorf("test case orgs = %+v, want Kept, Default", got)
+	}
+	if got := ad.LibraryTestCases["lib-1"].Organizations[0].Name; got != "Default" {
+		t.Errorf("library test case org = %q, want Default", got)
+	}
+	// Only "Missing" has no explicit entry, so it's the only org looked up.
+	if n := len(client.calls); n != 1 {
+		t.Errorf("expected exactly one FindOrganization lookup, got %v", client.calls)
+	}
+}
+
+// TestRestoreCampaign_FiltersBeforeOrgMapping verifies an organization test
+// case filter on a campaign restore matches source organization names, as on
+// an assessment restore, with the org-map applied to what it selects.
+func TestRestoreCampaign_FiltersBeforeOrgMapping(t *testing.T) {
+	type assessmentOrg = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentOrganizationsOrganization
+	type testCaseOrg = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCaseOrganizationsOrganization
+	mapping, err := util.NewOrgMapping(strings.NewReader(`"Acme Red","Default"` + "\n"))
+	if err != nil {
+		t.Fatalf("could not build org mapping: %v", err)
+	}
+	newAssessmentData := func() *AssessmentData {
+		ad := &AssessmentData{OrgMap: map[string]assessmentOrg{"Acme Red": {Name: "Acme Red"}, "Other": {Name: "Other"}}}
+		ad.Assessment.Campaigns = []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign{{
+			Name: "campaign-1",
+			TestCases: []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase{
+				{Id: "tc-1", Organizations: []testCaseOrg{{Name: "Acme Red"}}},
+				{Id: "tc-2", Organizations: []testCaseOrg{{Name: "Other"}}},
+			},
+		}}
+		return ad
+	}
+	// No target assessment: restoreCampaign stops right after filtering and
+	// mapping.
+	client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"FindExistingAssessment": json.RawMessage(`{"assessments": {"nodes": []}}`),
+	}}
+	patterns, err := util.NewNamePatterns([]string{"campaign-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filter, err := util.NewTestCaseFilter([]string{"organization=Acme Red"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ad := newAssessmentData()
+	err = restoreCampaign(context.Background(), client, "test-db", ad, patterns, "target", &RestoreOptionalParams{OrgMapping: mapping, TestCaseFilter: filter})
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected the missing target assessment error, got: %v", err)
+	}
+	if tcs := ad.Assessment.Campaigns[0].TestCases; len(tcs) != 1 || tcs[0].Id != "tc-1" || tcs[0].Organizations[0].Name != "Default" {
+		t.Errorf("test cases = %+v, want only tc-1, mapped to Default", tcs)
+	}
+
+	filter, err = util.NewTestCaseFilter([]string{"organization=Default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = restoreCampaign(context.Background(), client, "test-db", newAssessmentData(), patterns, "target", &RestoreOptionalParams{OrgMapping: mapping, TestCaseFilter: filter})
+	if !errors.Is(err, ErrNoTestCasesSelected) {
+		t.Errorf("expected ErrNoTestCasesSelected for a target org name, got: %v", err)
+	}
+}
+
+// unmatchedToolRef is a tool with no counterpart (tool or product) in
+// existingToolsResponse/existingProductsResponse.
+var unmatchedToolRef = DefenseToolRef{
+	Name:   "Defender",
+	Active: true,
+	Layers: []string{"Endpoint"},
+	Product: DefenseToolProductRef{
+		Ref:        "msft-defender",
+		Name:       "Defender for Endpoint",
+		VendorName: "Microsoft",
+	},
+}
+
+// TestReconcileDefenseTools_NoCreateListsUnmatched verifies that under
+// NoCreateDefenseTools every unmatched tool is reported in one error and
+// nothing at all is created or updated, not even for the tools that did
+// match.
+func TestReconcileDefenseTools_NoCreateListsUnmatched(t *testing.T) {
+	ref := existingToolRef
+	ref.Layers = []string{"Endpoint", "Network"} // would normally trigger an update
+	other := unmatchedToolRef
+	other.Name = "Sysmon"
+	other.Product.Ref = "sysmon"
+
+	client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"GetAllDefenseTools":        json.RawMessage(existingToolsResponse),
+		"GetAllDefenseToolProducts": json.RawMessage(existingProductsResponse),
+	}}
+
+	_, err := reconcileDefenseTools(context.Background(), client, "test-db", map[string]DefenseToolRef{
+		ref.Key():              ref,
+		unmatchedToolRef.Key(): unmatchedToolRef,
+		other.Key():            other,
+	}, &RestoreOptionalParams{NoCreateDefenseTools: true})
+	if !errors.Is(err, ErrUnmatchedDefenseTools) {
+		t.Fatalf("expected ErrUnmatchedDefenseTools, got: %v", err)
+	}
+	for _, name := range []string{"Defender", "Sysmon"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("expected the error to list %q, got: %v", name, err)
+		}
+	}
+	if want := []string{"GetAllDefenseTools", "GetAllDefenseToolProducts"}; !slices.Equal(client.calls, want) {
+		t.Errorf("expected only the lookups %v, got: %v", want, client.calls)
+	}
+}
+
+// TestReconcileDefenseTools_NoCreateReusesMatches verifies that under
+// NoCreateDefenseTools a matching tool is reused as-is, even when it lacks
+// some of the source's layers.
+func TestReconcileDefenseTools_NoCreateReusesMatches(t *testing.T) {
+	ref := existingToolRef
+	ref.Layers = []string{"Endpoint", "Network"}
+
+	client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"GetAllDefenseTools":        json.RawMessage(existingToolsResponse),
+		"GetAllDefenseToolProducts": json.RawMessage(existingProductsResponse),
+	}}
+
+	result, err := reconcileDefenseTools(context.Background(), client, "test-db", map[string]DefenseToolRef{
+		ref.Key(): ref,
+	}, &RestoreOptionalParams{NoCreateDefenseTools: true})
+	if err != nil {
+		t.Fatalf("reconcileDefenseTools returned an error: %v", err)
+	}
+	if got := result[ref.Key()]; got != "target-tool-1" {
+		t.Errorf("resolved id = %q, want %q", got, "target-tool-1")
+	}
+	if client.called("UpdateDefenseTool") || client.called("CloneDefenseLayer") {
+		t.Errorf("expected no mutations, calls: %v", client.calls)
+	}
+}
+
+// TestReconcileDefenseTools_MappedTool verifies that a DefenseToolMapping
+// entry settles a tool automatic matching would otherwise have created,
+// by target name or by target id, and that a map entry pointing at a tool
+// that doesn't exist is an error.
+func TestReconcileDefenseTools_MappedTool(t *testing.T) {
+	for _, target := range []string{"falcon sensor", "target-tool-1"} {
+		t.Run(target, func(t *testing.T) {
+			mapping, err := util.NewDefenseToolMapping(strings.NewReader(`"Defender","` + target + `"` + "\n"))
+			if err != nil {
+				t.Fatalf("could not build defense tool mapping: %v", err)
+			}
+			client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+				"GetAllDefenseTools":           json.RawMessage(existingToolsResponse),
+				"GetAllDefenseToolProducts":    json.RawMessage(existingProductsResponse),
+				"GetAllDefensiveLayers":        json.RawMessage(emptyLayersResponse),
+				"GetAllLibraryDefensiveLayers": json.RawMessage(emptyLibraryLayersResponse),
+			}}
+
+			result, err := reconcileDefenseTools(context.Background(), client, "test-db", map[string]DefenseToolRef{
+				unmatchedToolRef.Key(): unmatchedToolRef,
+			}, &RestoreOptionalParams{DefenseToolMapping: mapping})
+			if err != nil {
+				t.Fatalf("reconcileDefenseTools returned an error: %v", err)
+			}
+			if got := result[unmatchedToolRef.Key()]; got != "target-tool-1" {
+				t.Errorf("resolved id = %q, want %q", got, "target-tool-1")
+			}
+			if client.called("CreateDefenseToolProduct") || client.called("CreateDefenseTool") {
+				t.Errorf("expected no create mutation for a mapped tool, calls: %v", client.calls)
+			}
+		})
+	}
+
+	t.Run("missing target", func(t *testing.T) {
+		mapping, err := util.NewDefenseToolMapping(strings.NewReader(`"Defender","no such tool"` + "\n"))
+		if err != nil {
+			t.Fatalf("could not build defense tool mapping: %v", err)
+		}
+		client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+			"GetAllDefenseTools":        json.RawMessage(existingToolsResponse),
+			"GetAllDefenseToolProducts": json.RawMessage(existingProductsResponse),
+		}}
+		_, err = reconcileDefenseTools(context.Background(), client, "test-db", map[string]DefenseToolRef{
+			unmatchedToolRef.Key(): unmatchedToolRef,
+		}, &RestoreOptionalParams{DefenseToolMapping: mapping})
+		if !errors.Is(err, ErrDefenseToolMapTargetNotFound) {
+			t.Errorf("expected ErrDefenseToolMapTargetNotFound, got: %v", err)
+		}
+	})
+}
+
+// TestReconcileDefenseTools_StrictRejectsAmbiguousMatch verifies that under
+// StrictDefenseToolMatch two target tools sharing the matched identity fail
+// the run instead of one being picked.
+func TestReconcileDefenseTools_StrictRejectsAmbiguousMatch(t *testing.T) {
+	duplicateToolsResponse := `{"bluetools": {"nodes": [
+		{"id": "target-tool-1", "name": "Falcon Sensor", "active": true, "defenseToolProduct": {"id": "target-product-1", "ref": "crowdstrike-falcon"}, "createTime": 1, "updateTime": 1},
+		{"id": "target-tool-2", "name": "Falcon Sensor", "active": true, "defenseToolProduct": {"id": "target-product-1", "ref": "crowdstrike-falcon"}, "createTime": 2, "updateTime": 2}
+	]}}`
+	client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"GetAllDefenseTools":        json.RawMessage(duplicateToolsResponse),
+		"GetAllDefenseToolProducts": json.RawMessage(existingProductsResponse),
+	}}
+
+	_, err := reconcileDefenseTools(context.Background(), client, "test-db", map[string]DefenseToolRef{
+		existingToolRef.Key(): existingToolRef,
+	}, &RestoreOptionalParams{StrictDefenseToolMatch: true})
+	if !errors.Is(err, ErrAmbiguousDefenseToolMatch) {
+		t.Fatalf("expected ErrAmbiguousDefenseToolMatch, got: %v", err)
+	}
+	if client.called("UpdateDefenseTool") || client.called("CreateDefenseTool") {
+		t.Errorf("expected no mutations, calls: %v", client.calls)
+	}
+}
+
+func TestReconcileAssets(t *testing.T) {
+	type testCase = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase
+	campaigns := []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign{{
+		Name: "campaign-1",
+		TestCases: []testCase{{
+			Id:      "tc-1",
+			Targets: []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCaseTargetsTarget{{Name: "web01"}, {Name: "db01"}},
+			Sources: []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCaseSourcesSource{{Name: "kali"}},
+		}},
+	}}
+	assets := &AssetsResource{
+		Targets: map[string]Asset{
+			"web01": {Name: "web01", Description: "already there"},
+			"db01":  {Name: "db01", Description: "primary database", PropertyType: "Server", PropertyTypeId: "source-server-id"},
+		},
+		Sources: map[string]Asset{
+			"kali": {Name: "kali", PropertyType: "Attacker Box", PropertyTypeId: "source-attacker-id"},
+		},
+	}
+
+	t.Run("creates missing assets with their details", func(t *testing.T) {
+		client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+			"GetTestCaseforDb":         json.RawMessage(`{"testcases": {"nodes": [{"id": "1", "targets": [{"name": "web01"}], "sources": []}]}}`),
+			"GetAllAssetPropertyTypes": json.RawMessage(`{"assetPropertyTypes": {"nodes": [{"id": "target-server-id", "name": "Server"}]}}`),
+			"CreateTargets":            json.RawMessage(`{"target": {"create": {"target": [{"id": "9", "name": "db01"}]}}}`),
+		}}
+
+		if err := reconcileAssets(context.Background(), client, "test-db", campaigns, assets); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var vars struct {
+			Input dao.CreateTargetInput `json:"input"`
+		}
+		if err := json.Unmarshal(client.variables["CreateTargets"], &vars); err != nil {
+			t.Fatalf("could not decode CreateTargets variables: %v", err)
+		}
+		want := []dao.CreateTargetDataInput{{Name: "db01", PlatformType: "target-server-id", Description: "primary database"}}
+		if !slices.Equal(vars.Input.TargetDataInputs, want) {
+			t.Errorf("CreateTargets inputs = %+v, want %+v", vars.Input.TargetDataInputs, want)
+		}
+		// kali's property type doesn't exist in the target, so it's left to VECTR.
+		if client.called("CreateSources") {
+			t.Error("expected no CreateSources call for a source with an unresolvable property type")
+		}
+	})
+
+	t.Run("nil assets is a no-op", func(t *testing.T) {
+		client := &scriptedGraphQLClient{}
+		if err := reconcileAssets(context.Background(), client, "test-db", campaigns, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(client.calls) != 0 {
+			t.Errorf("expected no GraphQL calls, got %v", client.calls)
+		}
+	})
+}
+
+// countingJournalWriter records how often the journal was checkpointed and a
+// copy of what it held the last time.
+type countingJournalWriter struct {
+	writes int
+	last   RestoreJournal
+}
+
+func (w *countingJournalWriter) WriteJournal(_ context.Context, journal *RestoreJournal) error {
+	w.writes++
+	w.last = *journal
+	return nil
+}
+
+// TestRestoreCampaigns_ResumesFromJournal verifies a resumed restore creates
+// only what the journal doesn't already record: no campaign, only the test
+// case that wasn't written, and only the timeline event that wasn't.
+func TestRestoreCampaigns_ResumesFromJournal(t *testing.T) {
+	client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"CreateTestCasesNoTemplate": json.RawMessage(`{"testCase": {"createWithoutTemplate": {"testCaseCreateItems": [{"clientId": "tc-2", "testCase": {"id": "new-tc-2"}}]}}}`),
+		"CreateTimelineEvents":      json.RawMessage(`{"timelineEvent": {"create": {"items": [], "summary": {"total": 1, "succeeded": 1, "failed": 0}}}}`),
+	}}
+
+	type timelineEvent = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCaseTimelineEventsTimelineEvent
+	org := []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCaseOrganizationsOrganization{{Name: "org"}}
+	campaignsToRestore := []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign{{
+		Name: "campaign-1",
+		TestCases: []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase{
+			{Id: "tc-1", Offset: 0, Organizations: org, TimelineEvents: []*timelineEvent{{Id: "te-1", Type: "Manual"}}},
+			{Id: "tc-2", Offset: 1, Organizations: org, TimelineEvents: []*timelineEvent{{Id: "te-2", Type: "Manual"}}},
+		},
+	}}
+
+	journal := NewRestoreJournal()
+	journal.Campaigns["campaign-1"] = "new-c1"
+	journal.TestCases["tc-1"] = "new-tc-1"
+	journal.TimelineEvents["te-1"] = true
+	writer := &countingJournalWriter{}
+
+	err := restoreCampaigns(
+		context.Background(),
+		client,
+		"test-db",
+		"assessment-1",
+		"assessment-name",
+		campaignsToRestore,
+		map[string]dao.FindOrganizationOrganizationsOrganizationConnectionNodesOrganization{},
+		map[string]string{},
+		map[string]DefenseToolRef{},
+		&RestoreOptionalParams{Journal: journal, JournalWriter: writer},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"CreateTestCasesNoTemplate", "CreateTimelineEvents"}; !slices.Equal(client.calls, want) {
+		t.Errorf("calls = %v, want %v", client.calls, want)
+	}
+
+	var tcVars struct {
+		Input dao.CreateTestCaseWithoutTemplateInput `json:"input"`
+	}
+	if err := json.Unmarshal(client.variables["CreateTestCasesNoTemplate"], &tcVars); err != nil {
+		t.Fatalf("could not decode CreateTestCasesNoTemplate variables: %v", err)
+	}
+	if got := tcVars.Input.TestCaseData; len(got) != 1 || got[0].ClientId != "tc-2" {
+		t.Errorf("expected only tc-2 to be created, got %+v", got)
+	}
+
+	var teVars struct {
+		Input dao.CreateTimelineEventsInput `json:"input"`
+	}
+	if err := json.Unmarshal(client.variables["CreateTimelineEvents"], &teVars); err != nil {
+		t.Fatalf("could not decode CreateTimelineEvents variables: %v", err)
+	}
+	if got := teVars.Input.Events; len(got) != 1 || got[0].TestCaseId != "new-tc-2" {
+		t.Errorf("expected only tc-2's timeline event to be written, got %+v", got)
+	}
+
+	if writer.writes == 0 {
+		t.Fatal("expected the journal to be checkpointed")
+	}
+	if got := writer.last.TestCases["tc-2"]; got != "new-tc-2" {
+		t.Errorf("journal test case tc-2 = %q, want new-tc-2", got)
+	}
+	if !writer.last.TimelineEvents["te-2"] {
+		t.Error("expected te-2 to be journaled as written")
+	}
+}
+
+func TestStartJournal_RejectsMismatch(t *testing.T) {
+	ad := &AssessmentData{}
+	ad.Assessment.Name = "assessment"
+	ad.Assessment.GlobalId = "global-1"
+
+	params := &RestoreOptionalParams{}
+	if err := params.startJournal(context.Background(), "db-1", "", ad); err != nil {
+		t.Fatalf("unexpected error starting a fresh journal: %v", err)
+	}
+	if err := params.startJournal(context.Background(), "db-1", "", ad); err != nil {
+		t.Errorf("expected a matching journal to resume, got %v", err)
+	}
+	if err := params.startJournal(context.Background(), "db-2", "", ad); !errors.Is(err, ErrJournalMismatch) {
+		t.Errorf("expected ErrJournalMismatch for another database, got %v", err)
+	}
+	if err := params.startJournal(context.Background(), "db-1", "campaign-1", ad); !errors.Is(err, ErrJournalMismatch) {
+		t.Errorf("expected ErrJournalMismatch for a single-campaign restore, got %v", err)
+	}
+}
+
+func TestRollbackRestore(t *testing.T) {
+	// A single-campaign restore into an existing assessment: the campaign
+	// goes, the assessment it was added to stays.
+	journal := NewRestoreJournal()
+	journal.Db = "test-db"
+	journal.SourceCampaignName = "campaign-1"
+	journal.AssessmentName = "existing-assessment"
+	journal.AssessmentId = "target-assessment-1"
+	journal.Campaigns["campaign-1"] = "target-campaign-1"
+	journal.TestCases["source-tc-1"] = "target-tc-1"
+	journal.DefenseTools["tool-key"] = "target-tool-2"
+	journal.Created = CreatedObjects{
+		DefenseTools:         []string{"target-tool-2"},
+		DefenseLayers:        []string{"target-layer-2"},
+		DefenseToolProducts:  []string{"target-product-2"},
+		LibraryDefenseLayers: []string{"target-library-layer-2"},
+	}
+
+	client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"DeleteCampaigns":            json.RawMessage(`{"campaign": {"delete": {"deletedIds": ["target-campaign-1"]}}}`),
+		"DeleteDefenseTools":         json.RawMessage(`{"defenseTool": {"delete": {"ids": ["target-tool-2"]}}}`),
+		"DeleteDefenseLayers":        json.RawMessage(`{"defenseLayer": {"delete": {"ids": ["target-layer-2"]}}}`),
+		"DeleteDefenseToolProducts":  json.RawMessage(`{"defenseToolProduct": {"delete": {"deletedIds": ["target-product-2"]}}}`),
+		"DeleteLibraryDefenseLayers": json.RawMessage(`{"defenseLayer": {"deleteLibrary": {"ids": ["target-library-layer-2"]}}}`),
+	}}
+	writer := &countingJournalWriter{}
+
+	if err := RollbackRestore(context.Background(), client, journal, writer); err != nil {
+		t.Fatalf("RollbackRestore returned an error: %v", err)
+	}
+
+	wantCalls := []string{"DeleteCampaigns", "DeleteDefenseTools", "DeleteDefenseLayers", "DeleteDefenseToolProducts", "DeleteLibraryDefenseLayers"}
+	if !slices.Equal(client.calls, wantCalls) {
+		t.Errorf("calls = %v, want %v (reverse dependency order, no assessment delete)", client.calls, wantCalls)
+	}
+	if !reflect.DeepEqual(writer.last.Created, CreatedObjects{}) {
+		t.Errorf("journal still lists created objects after rollback: %+v", writer.last.Created)
+	}
+	if len(writer.last.Campaigns) != 0 || len(writer.last.TestCases) != 0 || len(writer.last.DefenseTools) != 0 {
+		t.Errorf("journal still records deleted objects: campaigns %v, test cases %v, tools %v", writer.last.Campaigns, writer.last.TestCases, writer.last.DefenseTools)
+	}
+	if !writer.last.RolledBack {
+		t.Error("journal not marked rolled back after a complete rollback")
+	}
+}
+
+func TestRollbackRestore_KeepsWhatIsLeftOnFailure(t *testing.T) {
+	journal := NewRestoreJournal()
+	journal.Db = "test-db"
+	journal.AssessmentName = "restored-assessment"
+	journal.AssessmentId = "target-assessment-1"
+	journal.Created = CreatedObjects{
+		DefenseTools:        []string{"target-tool-2"},
+		DefenseToolProducts: []string{"target-product-2"},
+	}
+
+	// No stubbed DeleteDefenseToolProducts: the product delete fails.
+	client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"DeleteAssessment":   json.RawMessage(`{"assessment": {"delete": {"deletedIds": ["target-assessment-1"]}}}`),
+		"DeleteDefenseTools": json.RawMessage(`{"defenseTool": {"delete": {"ids": ["target-tool-2"]}}}`),
+	}}
+	writer := &countingJournalWriter{}
+
+	if err := RollbackRestore(context.Background(), client, journal, writer); err == nil {
+		t.Fatal("expected an error when a delete fails")
+	}
+	if writer.last.AssessmentId != "" {
+		t.Errorf("journal still records the deleted assessment %q", writer.last.AssessmentId)
+	}
+	want := CreatedObjects{DefenseToolProducts: []string{"target-product-2"}}
+	if !reflect.DeepEqual(writer.last.Created, want) {
+		t.Errorf("journal.Created = %+v, want %+v so a rerun deletes only what's left", writer.last.Created, want)
+	}
+	if writer.last.RolledBack {
+		t.Error("journal marked rolled back after a failed rollback")
+	}
+}
+
+func TestPlanAssessmentUpdate(t *testing.T) {
+	type campaign = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign
+	type testCase = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase
+	type tag = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCaseTagsTag
+	type timelineEvent = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCaseTimelineEventsTimelineEvent
+
+	existing := &dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessment{
+		Id: "target-assessment-1",
+		Campaigns: []campaign{{
+			Id:   "target-campaign-1",
+			Name: "campaign-1",
+			TestCases: []testCase{
+				// Out of offset order: matching must still pair by offset.
+				{Id: "target-tc-2", Name: "T1", LibraryTestCaseId: "lib-1", Offset: 1, Description: "second"},
+				{Id: "target-tc-1", Name: "T1", LibraryTestCaseId: "lib-1", Offset: 0, Description: "old",
+					Tags:           []tag{{Name: "a"}},
+					TimelineEvents: []*timelineEvent{{Id: "target-te-1", Team: "RED", Type: "MANUAL", ManualDescription: "ran it", CreateTime: 1000}},
+				},
+			},
+		}},
+	}
+	ad := &AssessmentData{
+		AssessmentResource: AssessmentResource{Assessment: dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessment{
+			Campaigns: []campaign{
+				{Name: "campaign-1", TestCases: []testCase{
+					{Id: "source-tc-1", Name: "T1", LibraryTestCaseId: "lib-1", Offset: 0, Description: "new",
+						Tags: []tag{{Name: "b"}},
+						TimelineEvents: []*timelineEvent{
+							{Id: "source-te-1", Team: "RED", Type: "MANUAL", ManualDescription: "ran it", CreateTime: 1000},
+							{Id: "source-te-2", Team: "BLUE", Type: "MANUAL", ManualDescription: "saw it", CreateTime: 2000},
+						},
+					},
+					{Id: "source-tc-2", Name: "T1", LibraryTestCaseId: "lib-1", Offset: 1, Description: "second"},
+					{Id: "source-tc-3", Name: "T3", LibraryTestCaseId: "lib-3", Offset: 2},
+				}},
+				{Name: "campaign-2", TestCases: []testCase{{Id: "source-tc-4", Name: "T4"}}},
+			},
+		}},
+	}
+	journal := NewRestoreJournal()
+
+	updates := planAssessmentUpdate(context.Background(), journal, ad, existing, nil, nil, nil, true)
+
+	wantCampaigns := map[string]string{"campaign-1": "target-campaign-1"}
+	if !maps.Equal(journal.Campaigns, wantCampaigns) {
+		t.Errorf("Campaigns = %v, want %v (campaign-2 left for restoreCampaigns to append)", journal.Campaigns, wantCampaigns)
+	}
+	wantTestCases := map[string]string{"source-tc-1": "target-tc-1", "source-tc-2": "target-tc-2"}
+	if !maps.Equal(journal.TestCases, wantTestCases) {
+		t.Errorf("TestCases = %v, want %v", journal.TestCases, wantTestCases)
+	}
+	wantEvents := map[string]bool{"source-te-1": true}
+	if !maps.Equal(journal.TimelineEvents, wantEvents) {
+		t.Errorf("TimelineEvents = %v, want %v (only the event already on the target)", journal.TimelineEvents, wantEvents)
+	}
+	wantPreExisting := map[string]bool{"target-campaign-1": true, "target-tc-1": true, "target-tc-2": true}
+	if !maps.Equal(journal.PreExisting, wantPreExisting) {
+		t.Errorf("PreExisting = %v, want %v", journal.PreExisting, wantPreExisting)
+	}
+
+	if len(updates) != 1 {
+		t.Fatalf("got %d updates, want 1 (only target-tc-1 changed): %+v", len(updates), updates)
+	}
+	u := updates[0]
+	if u.TestCaseId != "target-tc-1" || u.Description == nil || *u.Description != "new" {
+		t.Errorf("update = %+v, want target-tc-1 with description %q", u, "new")
+	}
+	if !slices.Equal(u.AddTagsByName, []string{"b"}) || !slices.Equal(u.RemoveTagsByName, []string{"a"}) {
+		t.Errorf("tags add %v remove %v, want add [b] remove [a]", u.AddTagsByName, u.RemoveTagsByName)
+	}
+	if u.OperatorGuidance != nil || u.CurrentStatus != nil || u.OverrideOutcome != nil {
+		t.Errorf("unchanged fields set in update: %+v", u)
+	}
+}
+
+// TestPlanAssessmentUpdate_PreviousSync verifies that once a sync has
+// recorded target ids, campaigns and test cases renamed in the source are
+// matched by those ids, and ones it has no id for are appended rather than
+// matched by name.
+func TestPlanAssessmentUpdate_PreviousSync(t *testing.T) {
+	type campaign = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign
+	type testCase = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase
+
+	existing := &dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessment{
+		Id: "target-assessment-1",
+		Campaigns: []campaign{{
+			Id:   "target-campaign-1",
+			Name: "old campaign name",
+			TestCases: []testCase{
+				{Id: "target-tc-1", Name: "old name", LibraryTestCaseId: "lib-1", Description: "old"},
+				{Id: "target-tc-2", Name: "T2", LibraryTestCaseId: "lib-2"},
+			},
+		}},
+	}
+	ad := &AssessmentData{
+		AssessmentResource: AssessmentResource{Assessment: dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessment{
+			Campaigns: []campaign{{Id: "source-campaign-1", Name: "new campaign name", TestCases: []testCase{
+				{Id: "source-tc-1", Name: "new name", LibraryTestCaseId: "lib-1-remapped", Description: "new"},
+				// Same name and library id as target-tc-2, but never synced.
+				{Id: "source-tc-3", Name: "T2", LibraryTestCaseId: "lib-2"},
+			}}},
+		}},
+	}
+	previous := &SyncRecord{
+		Campaigns: map[string]string{"source-campaign-1": "target-campaign-1"},
+		TestCases: map[string]string{"source-tc-1": "target-tc-1", "source-tc-2": "target-tc-2"},
+	}
+	journal := NewRestoreJournal()
+
+	updates := planAssessmentUpdate(context.Background(), journal, ad, existing, previous, nil, nil, true)
+
+	wantCampaigns := map[string]string{"new campaign name": "target-campaign-1"}
+	if !maps.Equal(journal.Campaigns, wantCampaigns) {
+		t.Errorf("Campaigns = %v, want %v", journal.Campaigns, wantCampaigns)
+	}
+	wantTestCases := map[string]string{"source-tc-1": "target-tc-1"}
+	if !maps.Equal(journal.TestCases, wantTestCases) {
+		t.Errorf("TestCases = %v, want %v (source-tc-3 left for restoreCampaigns to append)", journal.TestCases, wantTestCases)
+	}
+	if len(updates) != 1 || updates[0].TestCaseId != "target-tc-1" {
+		t.Errorf("updates = %+v, want only target-tc-1", updates)
+	}
+}
+
+func TestTestCaseUpdate_Clears(t *testing.T) {
+	type testCase = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase
+	type outcome = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCaseDefenseToolOutcomesDefenseToolOutcome
+
+	src := &testCase{Id: "source-tc-1"}
+	existing := &testCase{Id: "target-tc-1", AttackSuccess: dao.AttackSuccessStateFail, DefenseToolOutcomes: []outcome{{DefenseToolId: 7, OutcomeId: "blocked"}}}
+
+	update, changed := testCaseUpdate(context.Background(), src, existing, nil, nil, nil)
+	if !changed {
+		t.Fatal("changed = false, want true when the source cleared attack success and defense tool outcomes")
+	}
+	body, err := json.Marshal(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"attackSuccess":null`, `"defenseToolOutcomes":[]`} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("update %s does not contain %s", body, want)
+		}
+	}
+
+	if _, changed := testCaseUpdate(context.Background(), src, &testCase{Id: "target-tc-1"}, nil, nil, nil); changed {
+		t.Error("changed = true for a test case that is already clear")
+	}
+}
+
+func TestRollbackRestore_UpdateModeKeepsExisting(t *testing.T) {
+	journal := NewRestoreJournal()
+	journal.Db = "test-db"
+	journal.AssessmentName = "existing-assessment"
+	journal.AssessmentId = "target-assessment-1"
+	journal.UpdatedExisting = true
+	journal.PreExisting = map[string]bool{"target-campaign-1": true, "target-tc-1": true}
+	journal.Campaigns = map[string]string{"campaign-1": "target-campaign-1", "campaign-2": "target-campaign-2"}
+	journal.TestCases = map[string]string{"source-tc-1": "target-tc-1", "source-tc-2": "target-tc-2", "source-tc-3": "target-tc-3"}
+
+	client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"DeleteTestCases": json.RawMessage(`{"testCase": {"delete": {"deletedIds": ["target-tc-2", "target-tc-3"]}}}`),
+		"DeleteCampaigns": json.RawMessage(`{"campaign": {"delete": {"deletedIds": ["target-campaign-2"]}}}`),
+	}}
+	writer := &countingJournalWriter{}
+
+	if err := RollbackRestore(context.Background(), client, journal, writer); err != nil {
+		t.Fatalf("RollbackRestore returned an error: %v", err)
+	}
+
+	wantCalls := []string{"DeleteTestCases", "DeleteCampaigns"}
+	if !slices.Equal(client.calls, wantCalls) {
+		t.Errorf("calls = %v, want %v (never the assessment)", client.calls, wantCalls)
+	}
+	var vars struct {
+		Ids []string `json:"ids"`
+	}
+	if err := json.Unmarshal(client.variables["DeleteTestCases"], &vars); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(vars.Ids, []string{"target-tc-2", "target-tc-3"}) {
+		t.Errorf("deleted test cases %v, want only the appended ones", vars.Ids)
+	}
+	if writer.last.UpdatedExisting || writer.last.AssessmentId != "" {
+		t.Errorf("journal still points at the updated assessment: %+v", writer.last)
+	}
+}
+
+func TestFilterUpdatedSince(t *testing.T) {
+	type campaign = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign
+	type testCase = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase
+	ad := &AssessmentData{AssessmentResource: AssessmentResource{Assessment: dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessment{
+		Campaigns: []campaign{
+			{Name: "unchanged", UpdateTime: 100, TestCases: []testCase{{Id: "tc-1", UpdateTime: 100}}},
+			{Name: "one-changed", UpdateTime: 100, TestCases: []testCase{{Id: "tc-2", UpdateTime: 100}, {Id: "tc-3", UpdateTime: 300}}},
+			{Name: "new-and-empty", UpdateTime: 300},
+		},
+	}}}
+
+	if got := latestUpdateTime(ad); got != 300 {
+		t.Errorf("latestUpdateTime = %v, want 300", got)
+	}
+	campaigns, testCases := filterUpdatedSince(ad, 200)
+	if campaigns != 2 || testCases != 1 {
+		t.Errorf("filterUpdatedSince = %d campaigns, %d test cases, want 2, 1", campaigns, testCases)
+	}
+	var names, ids []string
+	for _, c := range ad.Assessment.Campaigns {
+		names = append(names, c.Name)
+		for _, tc := range c.TestCases {
+			ids = append(ids, tc.Id)
+		}
+	}
+	if !slices.Equal(names, []string{"one-changed", "new-and-empty"}) || !slices.Equal(ids, []string{"tc-3"}) {
+		t.Errorf("kept campaigns %v, test cases %v", names, ids)
+	}
+}
+
+func TestSyncAssessment_NothingUpdated(t *testing.T) {
+	type campaign = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign
+	type testCase = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase
+	ad := &AssessmentData{AssessmentResource: AssessmentResource{Assessment: dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessment{
+		Name:      "lab-assessment",
+		GlobalId:  "global-1",
+		Campaigns: []campaign{{Name: "campaign-1", UpdateTime: 100, TestCases: []testCase{{Id: "tc-1", UpdateTime: 100}}}},
+	}}}
+	state := NewSyncState()
+	state.Assessments["global-1"] = SyncRecord{AssessmentName: "lab-assessment", UpdatedThrough: 100,
+		Campaigns: map[string]string{"campaign-id-1": "target-campaign-1"},
+		TestCases: map[string]string{"tc-1": "target-tc-1"},
+	}
+
+	// Only the lookup is stubbed: anything past it would fail the sync.
+	client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"GetAssessmentIdsForDb": json.RawMessage(`{"assessments": {"nodes": [{"id": "target-1", "name": "lab-assessment", "globalId": "global-1"}]}}`),
+	}}
+	params := &RestoreOptionalParams{}
+	if err := SyncAssessment(context.Background(), client, "test-db", ad, state, params); err != nil {
+		t.Fatalf("SyncAssessment returned an error: %v", err)
+	}
+	if !slices.Equal(client.calls, []string{"GetAssessmentIdsForDb"}) {
+		t.Errorf("calls = %v, want only the lookup", client.calls)
+	}
+	if params.Mode != RestoreModeUpdate {
+		t.Errorf("Mode = %q, want %q", params.Mode, RestoreModeUpdate)
+	}
+	if r := state.Assessments["global-1"]; r.UpdatedThrough != 100 || r.SyncedAt.IsZero() || r.TestCases["tc-1"] != "target-tc-1" {
+		t.Errorf("state record = %+v, want UpdatedThrough 100, SyncedAt set and the synced ids kept", r)
+	}
+	if !params.PreviousSync.matchesById() {
+		t.Error("PreviousSync not set from the state record")
+	}
+}
+
+func TestSyncRecord_RecordSyncedIds(t *testing.T) {
+	type campaign = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign
+	type testCase = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase
+	ad := &AssessmentData{AssessmentResource: AssessmentResource{Assessment: dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessment{
+		Campaigns: []campaign{{Id: "source-campaign-2", Name: "campaign-2", TestCases: []testCase{{Id: "source-tc-2"}}}},
+	}}}
+	journal := NewRestoreJournal()
+	journal.Campaigns = map[string]string{"campaign-2": "target-campaign-2"}
+	journal.TestCases = map[string]string{"source-tc-2": "target-tc-2"}
+	record := SyncRecord{
+		Campaigns: map[string]string{"source-campaign-1": "target-campaign-1"},
+		TestCases: map[string]string{"source-tc-1": "target-tc-1"},
+	}
+
+	record.recordSyncedIds(ad, journal)
+
+	wantCampaigns := map[string]string{"source-campaign-1": "target-campaign-1", "source-campaign-2": "target-campaign-2"}
+	wantTestCases := map[string]string{"source-tc-1": "target-tc-1", "source-tc-2": "target-tc-2"}
+	if !maps.Equal(record.Campaigns, wantCampaigns) || !maps.Equal(record.TestCases, wantTestCases) {
+		t.Errorf("record = %v, %v; want %v, %v (earlier ids kept)", record.Campaigns, record.TestCases, wantCampaigns, wantTestCases)
+	}
+}
+
+func TestSelectCampaignsAndFilterTestCases(t *testing.T) {
+	type campaign = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign
+	type testCase = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase
+	ad := &AssessmentData{AssessmentResource: AssessmentResource{Assessment: dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessment{
+		Name: "assessment-1",
+		Campaigns: []campaign{
+			{Name: "Discovery", TestCases: []testCase{{Id: "tc-1", MitreId: "T1087"}}},
+			{Name: "Execution 1", TestCases: []testCase{{Id: "tc-2", MitreId: "T1059.001"}, {Id: "tc-3", MitreId: "T1106"}}},
+			{Name: "Execution 2", TestCases: []testCase{{Id: "tc-4", MitreId: "T1106"}}},
+			{Name: "Exfiltration", TestCases: []testCase{{Id: "tc-5", MitreId: "T1041"}}},
+		},
+	}}}
+
+	patterns, err := util.NewNamePatterns([]string{"Execution*", "Discovery"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	campaigns, err := selectCampaigns(ad, patterns)
+	if err != nil {
+		t.Fatalf("selectCampaigns: %v", err)
+	}
+	var names []string
+	for _, c := range campaigns {
+		names = append(names, c.Name)
+	}
+	if !slices.Equal(names, []string{"Discovery", "Execution 1", "Execution 2"}) {
+		t.Errorf("selected %v, want the source order", names)
+	}
+
+	filter, err := util.NewTestCaseFilter([]string{"technique=T1059*", "technique=T1087"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	campaigns, err = filterTestCases(context.Background(), campaigns, filter)
+	if err != nil {
+		t.Fatalf("filterTestCases: %v", err)
+	}
+	var ids []string
+	names = nil
+	for _, c := range campaigns {
+		names = append(names, c.Name)
+		for _, tc := range c.TestCases {
+			ids = append(ids, tc.Id)
+		}
+	}
+	if !slices.Equal(names, []string{"Discovery", "Execution 1"}) || !slices.Equal(ids, []string{"tc-1", "tc-2"}) {
+		t.Errorf("kept campaigns %v, test cases %v; want Execution 2 dropped and only tc-1, tc-2", names, ids)
+	}
+
+	patterns, _ = util.NewNamePatterns([]string{"Discovery", "Lateral*"})
+	if _, err := selectCampaigns(ad, patterns); !errors.Is(err, ErrCampaignNotFound) {
+		t.Errorf("selectCampaigns with an unmatched pattern: err = %v, want ErrCampaignNotFound", err)
+	}
+	filter, _ = util.NewTestCaseFilter([]string{"name=nothing"})
+	if _, err := filterTestCases(context.Background(), ad.Assessment.Campaigns, filter); !errors.Is(err, ErrNoTestCasesSelected) {
+		t.Errorf("filterTestCases selecting nothing: err = %v, want ErrNoTestCasesSelected", err)
+	}
+}
+
+// TestWriteLibraryTestCases_CreatesOnlyMissing verifies the hybrid template
+// mode: only the library test cases the instance reports missing are sent,
+// without overwrite, and only they are journaled as created.
+func TestWriteLibraryTestCases_CreatesOnlyMissing(t *testing.T) {
+	const existingId = "6a3f1f1e-2b1c-4c1d-9e1f-1a2b3c4d5e6f"
+	const missingId = "7b4e2a2f-3c2d-4d2e-8f2a-2b3c4d5e6f70"
+	client := &scriptedGraphQLClient{
+		errs: map[string]error{
+			"GetLibraryTestCases": gqlerror.List{{
+				Message:    "invalid ids",
+				Path:       ast.Path{ast.PathName("libraryTestcasesByIds")},
+				Extensions: map[string]any{"ids": []any{"The following IDs were not valid: " + missingId}},
+			}},
+		},
+		responses: map[string]json.RawMessage{
+			"CreateTemplateTestCases": json.RawMessage(`{"testCase": {"createTemplate": {"testCases": [{"id": "new-template-1", "libraryTestCaseId": "` + missingId + `"}]}}}`),
+		},
+	}
+
+	type libraryTestCase = dao.GetLibraryTestCasesLibraryTestcasesByIdsTestCaseConnectionNodesTestCase
+	org := []dao.GetLibraryTestCasesLibraryTestcasesByIdsTestCaseConnectionNodesTestCaseOrganizationsOrganization{{Name: "org"}}
+	ad := &AssessmentData{LibraryTestCases: map[string]libraryTestCase{
+		existingId: {Name: "existing", LibraryTestCaseId: existingId, Organizations: org},
+		missingId:  {Name: "missing", LibraryTestCaseId: missingId, Organizations: org},
+	}}
+	ad.Assessment.Name = "assessment-name"
+	writer := &countingJournalWriter{}
+	optionalParams := &RestoreOptionalParams{Journal: NewRestoreJournal(), JournalWriter: writer}
+
+	err := writeLibraryTestCases(context.Background(), client, "test-db", ad.Assessment.Name, ad.LibraryTestCases, []string{missingId, existingId, missingId}, false, optionalParams)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var sent struct {
+		Input dao.CreateTestCaseTemplateInput `json:"input"`
+	}
+	if err := json.Unmarshal(client.variables["CreateTemplateTestCases"], &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent.Input.Overwrite {
+		t.Error("sent overwrite = true, want existing library test cases left untouched")
+	}
+	if len(sent.Input.TestCaseTemplateData) != 1 || sent.Input.TestCaseTemplateData[0].Name != "missing" {
+		t.Errorf("sent %+v, want only the missing library test case", sent.Input.TestCaseTemplateData)
+	}
+	if got := optionalParams.Journal.Created.TestCaseTemplates; !slices.Equal(got, []string{"new-template-1"}) {
+		t.Errorf("journaled created templates %v, want [new-template-1]", got)
+	}
+
+	delete(ad.LibraryTestCases, missingId)
+	err = writeLibraryTestCases(context.Background(), client, "test-db", ad.Assessment.Name, ad.LibraryTestCases, []string{missingId, existingId}, false, optionalParams)
+	if !errors.Is(err, ErrMissingLibraryTestCases) {
+		t.Errorf("missing library test case absent from the archive: err = %v, want ErrMissingLibraryTestCases", err)
+	}
+}
+
+// missingLibraryClient is a scriptedGraphQLClient whose GetLibraryTestCases
+// reports missing as an invalid id whenever it is asked for it, the way
+// VECTR does, and succeeds otherwise.
+type missingLibraryClient struct {
+	*scriptedGraphQLClient
+	missing string
+}
+
+func (c missingLibraryClient) MakeRequest(ctx context.Context, req *graphql.Request, resp *graphql.Response) error {
+	if req.OpName == "GetLibraryTestCases" {
+		raw, _ := json.Marshal(req.Variables)
+		if strings.Contains(string(raw), c.missing) {
+			c.calls = append(c.calls, req.OpName)
+			return gqlerror.List{{
+				Message:    "invalid ids",
+				Path:       ast.Path{ast.PathName("libraryTestcasesByIds")},
+				Extensions: map[string]any{"ids": []any{"The following IDs were not valid: " + c.missing}},
+			}}
+		}
+	}
+	return c.scriptedGraphQLClient.MakeRequest(ctx, req, resp)
+}
+
+// TestEnsureLibraryTestCases_IdThenName verifies TemplateMatchIdThenName
+// looks up only the library test cases missing by id, by name, picks the
+// candidate with the same prefix, and links test cases to it.
+func TestEnsureLibraryTestCases_IdThenName(t *testing.T) {
+	const presentId = "6a3f1f1e-2b1c-4c1d-9e1f-1a2b3c4d5e6f"
+	const missingId = "7b4e2a2f-3c2d-4d2e-8f2a-2b3c4d5e6f70"
+	client := missingLibraryClient{missing: missingId, scriptedGraphQLClient: &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"GetLibraryTestCases": json.RawMessage(`{"libraryTestcasesByIds": {"nodes": []}}`),
+		"FindLibraryTestCasesByName": json.RawMessage(`{"libraryTestcases": {"nodes": [
+			{"id": "other-1", "name": "ACME - Dump LSASS", "libraryTestCaseId": "other-lib", "metadata": [{"key": "prefix", "value": "OTHER"}]},
+			{"id": "target-1", "name": "ACME - Dump LSASS", "libraryTestCaseId": "target-lib", "metadata": [{"key": "prefix", "value": "ACME"}]}
+		]}}`),
+	}}}
+
+	type libraryTestCase = dao.GetLibraryTestCasesLibraryTestcasesByIdsTestCaseConnectionNodesTestCase
+	type metadata = dao.GetLibraryTestCasesLibraryTestcasesByIdsTestCaseConnectionNodesTestCaseMetadataMetadataKeyValuePair
+	ad := &AssessmentData{LibraryTestCases: map[string]libraryTestCase{
+		presentId: {Name: "ACME - Present", LibraryTestCaseId: presentId},
+		missingId: {Name: "ACME - Dump LSASS", LibraryTestCaseId: missingId, Metadata: []metadata{{Key: "prefix", Value: "ACME"}}},
+	}}
+	writer := &countingJournalWriter{}
+	optionalParams := &RestoreOptionalParams{TemplateMatch: TemplateMatchIdThenName, JournalWriter: writer}
+
+	if err := ensureLibraryTestCases(context.Background(), client, "test-db", ad, []string{presentId, missingId, missingId}, optionalParams); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := map[string]string{missingId: "target-lib"}; !maps.Equal(writer.last.LibraryNameMatches, want) {
+		t.Errorf("journaled name matches %v, want %v", writer.last.LibraryNameMatches, want)
+	}
+	if !strings.Contains(string(client.variables["FindLibraryTestCasesByName"]), "Dump LSASS") {
+		t.Errorf("looked up %s by name, want only the missing library test case", client.variables["FindLibraryTestCasesByName"])
+	}
+	if id, byName := optionalParams.Journal.linkedLibraryTestCaseId(missingId); id != "target-lib" || !byName {
+		t.Errorf("missing id links to %s (by name %v), want target-lib by name", id, byName)
+	}
+	if id, byName := optionalParams.Journal.linkedLibraryTestCaseId(presentId); id != presentId || byName {
+		t.Errorf("present id links to %s (by name %v), want itself by id", id, byName)
+	}
+
+	if err := checkTemplateMatch(&RestoreOptionalParams{TemplateMatch: TemplateMatchName, ForceEnvOnly: true}); !errors.Is(err, ErrInvalidTemplateMatch) {
+		t.Errorf("name matching with force-env-only: err = %v, want ErrInvalidTemplateMatch", err)
+	}
+	if _, err := ParseTemplateMatch("fuzzy"); !errors.Is(err, ErrInvalidTemplateMatch) {
+		t.Errorf("ParseTemplateMatch(fuzzy): err = %v, want ErrInvalidTemplateMatch", err)
+	}
+}
+
+// TestMatchLibraryTestCasesByName_Ambiguous verifies a name and prefix shared
+// by more than one target library test case fails, naming every candidate,
+// instead of linking to one of them.
+func TestMatchLibraryTestCasesByName_Ambiguous(t *testing.T) {
+	client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"FindLibraryTestCasesByName": json.RawMessage(`{"libraryTestcases": {"nodes": [
+			{"id": "target-1", "name": "ACME - Dump LSASS", "libraryTestCaseId": "target-lib-1", "metadata": [{"key": "prefix", "value": "ACME"}]},
+			{"id": "target-2", "name": "ACME - Dump LSASS", "libraryTestCaseId": "target-lib-2", "metadata": [{"key": "prefix", "value": "ACME"}]}
+		], "pageInfo": {"endCursor": "", "hasNextPage": false}}}`),
+	}}
+	type libraryTestCase = dao.GetLibraryTestCasesLibraryTestcasesByIdsTestCaseConnectionNodesTestCase
+	type metadata = dao.GetLibraryTestCasesLibraryTestcasesByIdsTestCaseConnectionNodesTestCaseMetadataMetadataKeyValuePair
+	ad := &AssessmentData{LibraryTestCases: map[string]libraryTestCase{
+		"source-lib": {Name: "ACME - Dump LSASS", LibraryTestCaseId: "source-lib", Metadata: []metadata{{Key: "prefix", Value: "ACME"}}},
+	}}
+	optionalParams := &RestoreOptionalParams{TemplateMatch: TemplateMatchName}
+
+	err := matchLibraryTestCasesByName(context.Background(), client, ad, []string{"source-lib"}, optionalParams)
+	if !errors.Is(err, ErrAmbiguousTemplateMatch) {
+		t.Fatalf("err = %v, want ErrAmbiguousTemplateMatch", err)
+	}
+	for _, id := range []string{"target-lib-1", "target-lib-2"} {
+		if !strings.Contains(err.Error(), id) {
+			t.Errorf("error %q does not name candidate %s", err, id)
+		}
+	}
+	if len(optionalParams.journal().LibraryNameMatches) != 0 {
+		t.Errorf("LibraryNameMatches = %v, want none", optionalParams.journal().LibraryNameMatches)
+	}
+}
+
+// libraryEchoClient is a scriptedGraphQLClient whose CreateTemplateTestCases
+// echoes back the library test cases it was sent, the way VECTR does, since
+// restoreAsTemplate mints their ids itself.
+type libraryEchoClient struct {
+	*scriptedGraphQLClient
+}
+
+func (c libraryEchoClient) MakeRequest(ctx context.Context, req *graphql.Request, resp *graphql.Response) error {
+	if req.OpName == "CreateTemplateTestCases" {
+		c.calls = append(c.calls, req.OpName)
+		raw, _ := json.Marshal(req.Variables)
+		if c.variables == nil {
+			c.variables = make(map[string]json.RawMessage)
+		}
+		c.variables[req.OpName] = raw
+		var sent struct {
+			Input dao.CreateTestCaseTemplateInput `json:"input"`
+		}
+		if err := json.Unmarshal(raw, &sent); err != nil {
+			return err
+		}
+		r := resp.Data.(*dao.CreateTemplateTestCasesResponse)
+		for i, ttc := range sent.Input.TestCaseTemplateData {
+			r.TestCase.CreateTemplate.TestCases = append(r.TestCase.CreateTemplate.TestCases, dao.CreateTemplateTestCasesTestCaseTestCaseMutationsCreateTemplateCreateTestCasePayloadTestCasesTestCase{
+				Id:                fmt.Sprintf("template-%d", i+1),
+				LibraryTestCaseId: ttc.LibraryTestCaseId,
+			})
+		}
+		return nil
+	}
+	return c.scriptedGraphQLClient.MakeRequest(ctx, req, resp)
+}
+
+// TestRestoreAsTemplate verifies an archive restored as a library assessment
+// gets fresh library test cases without environment data, campaigns that
+// reference them in offset order, prefixes split off names, and a journal
+// RollbackRestore can undo completely.
+func TestRestoreAsTemplate(t *testing.T) {
+	type campaign = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign
+	type testCase = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase
+	org := []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCaseOrganizationsOrganization{{Name: "org"}}
+	ad := &AssessmentData{AssessmentResource: AssessmentResource{Assessment: dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessment{
+		Name: "Quarterly Purple Team",
+		Campaigns: []campaign{{
+			Name: "ACME - Credential Access",
+			Metadata: []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignMetadataMetadataKeyValuePair{
+				{Key: "prefix", Value: "ACME"},
+			},
+			TestCases: []testCase{
+				{Id: "tc-2", Name: "Kerberoast", Offset: 2, Status: "Completed", Organizations: org},
+				{Id: "tc-1", Name: "ACME - Dump LSASS", Offset: 1, Status: "Completed", Organizations: org,
+					Metadata: []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCaseMetadataMetadataKeyValuePair{
+						{Key: "prefix", Value: "ACME"},
+					}},
+			},
+		}},
+	}}}
+	client := libraryEchoClient{&scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"FindLibraryAssessment":     json.RawMessage(`{"libraryAssessments": {"nodes": []}}`),
+		"CreateCampaignTemplates":   json.RawMessage(`{"campaign": {"createTemplate": {"campaigns": [{"id": "library-campaign-1", "name": "Credential Access"}]}}}`),
+		"CreateAssessmentTemplate":  json.RawMessage(`{"assessment": {"createTemplate": {"assessments": [{"id": "library-assessment-1", "name": "Quarterly Purple Team"}]}}}`),
+		"DeleteAssessmentTemplates": json.RawMessage(`{"assessment": {"deleteTemplate": {"deletedIds": ["library-assessment-1"]}}}`),
+		"DeleteCampaignTemplates":   json.RawMessage(`{"campaign": {"deleteTemplate": {"deletedIds": ["library-campaign-1"]}}}`),
+		"DeleteTemplateTestCases":   json.RawMessage(`{"testCase": {"deleteTemplate": {"deletedIds": ["template-1", "template-2"]}}}`),
+	}}}
+	writer := &countingJournalWriter{}
+	optionalParams := &RestoreOptionalParams{AsTemplate: true, Journal: NewRestoreJournal(), JournalWriter: writer}
+	optionalParams.Journal.AsTemplate = true // as startJournal sets it
+
+	if err := restoreAsTemplate(context.Background(), client, "test-db", ad, VatOpMetadata{}, optionalParams); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	journal := optionalParams.Journal
+
+	var sentTestCases struct {
+		Input dao.CreateTestCaseTemplateInput `json:"input"`
+	}
+	if err := json.Unmarshal(client.variables["CreateTemplateTestCases"], &sentTestCases); err != nil {
+		t.Fatal(err)
+	}
+	if sentTestCases.Input.Overwrite {
+		t.Error("library test cases sent with overwrite = true")
+	}
+	if len(sentTestCases.Input.TestCaseTemplateData) != 2 {
+		t.Fatalf("sent %d library test cases, want 2", len(sentTestCases.Input.TestCaseTemplateData))
+	}
+	lsass := sentTestCases.Input.TestCaseTemplateData[0]
+	if lsass.Name != "Dump LSASS" || lsass.TemplatePrefix != "ACME" {
+		t.Errorf("sent name %q prefix %q, want the prefix split off", lsass.Name, lsass.TemplatePrefix)
+	}
+	if lsass.LibraryTestCaseId == "tc-1" || lsass.LibraryTestCaseId == "" {
+		t.Errorf("sent library id %q, want a fresh one", lsass.LibraryTestCaseId)
+	}
+	if want := []dao.MetadataKeyValuePairInput{{Key: "prefix", Value: "ACME"}}; !slices.Equal(lsass.BlueTeamMetadata, want) || lsass.RedTeamMetadata != nil {
+		t.Errorf("sent blue team metadata %v, red team metadata %v; want %v as blue team metadata, as createTemplateData writes it", lsass.BlueTeamMetadata, lsass.RedTeamMetadata, want)
+	}
+
+	var sentCampaign struct {
+		Input dao.CreateCampaignTemplateInput `json:"input"`
+	}
+	if err := json.Unmarshal(client.variables["CreateCampaignTemplates"], &sentCampaign); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{journal.TestCases["tc-1"], journal.TestCases["tc-2"]}
+	if c := sentCampaign.Input.CampaignTemplateData[0]; !slices.Equal(c.LibraryTestCaseIds, want) || c.Name != "Credential Access" || c.TemplatePrefix != "ACME" {
+		t.Errorf("sent campaign %+v, want name Credential Access, prefix ACME and library test cases %v", c, want)
+	}
+	if !strings.Contains(string(client.variables["CreateAssessmentTemplate"]), "library-campaign-1") {
+		t.Errorf("sent assessment %s, want it to reference library-campaign-1", client.variables["CreateAssessmentTemplate"])
+	}
+
+	wantCreated := CreatedObjects{
+		TestCaseTemplates:   []string{"template-1", "template-2"},
+		CampaignTemplates:   []string{"library-campaign-1"},
+		AssessmentTemplates: []string{"library-assessment-1"},
+	}
+	if !reflect.DeepEqual(writer.last.Created, wantCreated) || !writer.last.Complete {
+		t.Errorf("journal created %+v (complete %v), want %+v", writer.last.Created, writer.last.Complete, wantCreated)
+	}
+
+	client.calls = nil
+	if err := RollbackRestore(context.Background(), client, journal, writer); err != nil {
+		t.Fatalf("RollbackRestore returned an error: %v", err)
+	}
+	wantCalls := []string{"DeleteAssessmentTemplates", "DeleteCampaignTemplates", "DeleteTemplateTestCases"}
+	if !slices.Equal(client.calls, wantCalls) {
+		t.Errorf("rollback calls = %v, want %v", client.calls, wantCalls)
+	}
+	if !reflect.DeepEqual(writer.last.Created, CreatedObjects{}) {
+		t.Errorf("journal still lists created objects after rollback: %+v", writer.last.Created)
+	}
+}
+
+// TestResetForRetest verifies a retest keeps the test plan but none of its
+// results, and records the source globalId in place of an older one.
+func TestResetForRetest(t *testing.T) {
+	type campaign = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign
+	type testCase = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase
+	type metadata = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentMetadataMetadataKeyValuePair
+	ad := &AssessmentData{AssessmentResource: AssessmentResource{Assessment: dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessment{
+		Name:     "Q1 Purple Team",
+		GlobalId: "source-global-id",
+		Metadata: []metadata{{Key: "owner", Value: "red team"}, {Key: RetestOfMetadataKey, Value: "older-global-id"}},
+		Campaigns: []campaign{{Name: "Execution", TestCases: []testCase{{
+			Id:            "tc-1",
+			Name:          "PowerShell",
+			MitreId:       "T1059.001",
+			Status:        "Completed",
+			Outcome:       dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCaseOutcome{Path: "Blocked"},
+			OutcomeNotes:  "blocked by EDR",
+			AttackSuccess: dao.AttackSuccessStateFail,
+			DefenseToolOutcomes: []dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCaseDefenseToolOutcomesDefenseToolOutcome{
+				{DefenseToolId: 1, OutcomeId: "blocked"},
+			},
+			TimelineEvents: []*dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCaseTimelineEventsTimelineEvent{{Id: "te-1"}},
+		}}}},
+	}}}
+
+	resetForRetest(context.Background(), ad)
+
+	tc := ad.Assessment.Campaigns[0].TestCases[0]
+	if tc.Status != string(dao.TestCaseStatusNotperformed) || tc.Outcome.Path != "" || tc.OutcomeNotes != "" || tc.AttackSuccess != "" || tc.DefenseToolOutcomes != nil || tc.TimelineEvents != nil {
+		t.Errorf("test case still carries results: %+v", tc)
+	}
+	if tc.Name != "PowerShell" || tc.MitreId != "T1059.001" {
+		t.Errorf("test case lost its plan: %+v", tc)
+	}
+	want := []metadata{{Key: "owner", Value: "red team"}, {Key: RetestOfMetadataKey, Value: "source-global-id"}}
+	if !slices.Equal(ad.Assessment.Metadata, want) {
+		t.Errorf("metadata = %v, want %v", ad.Assessment.Metadata, want)
+	}
+
+	err := RestoreAssessment(context.Background(), &scriptedGraphQLClient{}, "test-db", ad, &RestoreOptionalParams{Mode: RestoreModeUpdate, Retest: true})
+	if !errors.Is(err, ErrInvalidRestoreMode) {
+		t.Errorf("update mode with Retest: err = %v, want ErrInvalidRestoreMode", err)
+	}
+}
+
+// TestDumpInstance_FetchesOnlyFilteredAssessments verifies dump lists a db's
+// assessments cheaply and fetches in full only the ones the filter keeps.
+func TestDumpInstance_FetchesOnlyFilteredAssessments(t *testing.T) {
+	client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"GetAllDatabases": json.RawMessage(`{"databases": [{"id": 1, "name": "db1"}, {"id": 2, "name": "db2"}]}`),
+		"GetAssessmentIdsForDb": json.RawMessage(`{"assessments": {"nodes": [
+			{"id": "a-1", "name": "Keep Me", "globalId": "g-1"},
+			{"id": "a-2", "name": "Skip Me", "globalId": "g-2"}
+		]}}`),
+		"GetAssessmentsByIds": json.RawMessage(`{"assessmentsByIds": {"nodes": [{"id": "a-1", "name": "Keep Me", "globalId": "g-1"}]}}`),
+		"GetAllDefenseTools":  json.RawMessage(`{"bluetools": {"nodes": []}}`),
+	}}
+	filter, err := util.NewFilter(strings.NewReader("db1,Keep Me\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dumped, err := DumpInstance(context.Background(), client, filter, &SaveOptionalParams{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dumped) != 1 || dumped[0].AssessmentName != "Keep Me" || dumped[0].Ad == nil || dumped[0].Ad.Assessment.Id != "a-1" {
+		t.Fatalf("dumped %+v, want only Keep Me", dumped)
+	}
+	if n := strings.Count(strings.Join(client.calls, " "), "GetAssessmentIdsForDb"); n != 1 {
+		t.Errorf("listed assessments %d times, want once, for db1 only", n)
+	}
+	if n := strings.Count(strings.Join(client.calls, " "), "GetAssessmentsByIds"); n != 1 {
+		t.Errorf("fetched assessments in full %d times, want once", n)
+	}
+	if !strings.Contains(string(client.variables["GetAssessmentsByIds"]), `["a-1"]`) {
+		t.Errorf("fetched %s, want only a-1", client.variables["GetAssessmentsByIds"])
+	}
+	if client.called("GetAssessmentUpdateTimesForDb") {
+		t.Error("listed campaign and test case update times without a since state")
+	}
+}
+
+// dumpPoolClient serves a dump of two databases of three assessments each to
+// concurrent callers, failing the fetch of fail and cancelling on the fetch of
+// cancelAt. It holds the first fetches until wantInflight are in flight at
+// once (or a second passes), and records the most it saw.
+type dumpPoolClient struct {
+	fail, cancelAt string
+	cancel         context.CancelFunc
+	wantInflight   int
+
+	mu          sync.Mutex
+	calls       map[string]int
+	fetched     []string
+	inflight    int
+	maxInflight int
+	released    bool
+	full        chan struct{}
+}
+
+func (c *dumpPoolClient) MakeRequest(_ context.Context, req *graphql.Request, resp *graphql.Response) error {
+	raw, err := json.Marshal(req.Variables)
+	if err != nil {
+		return err
+	}
+	var vars struct {
+		Db  string   `json:"db"`
+		Ids []string `json:"ids"`
+	}
+	if err := json.Unmarshal(raw, &vars); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.calls[req.OpName]++
+	c.mu.Unlock()
+
+	switch req.OpName {
+	case "GetAllDatabases":
+		return json.Unmarshal([]byte(`{"databases": [{"id": 1, "name": "db1"}, {"id": 2, "name": "db2"}]}`), resp.Data)
+	case "GetAssessmentIdsForDb":
+		return json.Unmarshal(fmt.Appendf(nil, `{"assessments": {"nodes": [
+			{"id": "%[1]s-1", "name": "%[1]s A1"}, {"id": "%[1]s-2", "name": "%[1]s A2"}, {"id": "%[1]s-3", "name": "%[1]s A3"}
+		]}}`, vars.Db), resp.Data)
+	case "GetAllDefenseTools":
+		return json.Unmarshal([]byte(`{"bluetools": {"nodes": []}}`), resp.Data)
+	case "GetAssessmentsByIds":
+		id := vars.Ids[0]
+		c.mu.Lock()
+		c.fetched = append(c.fetched, id)
+		c.inflight++
+		c.maxInflight = max(c.maxInflight, c.inflight)
+		if c.inflight == c.wantInflight && !c.released {
+			c.released = true
+			close(c.full)
+		}
+		c.mu.Unlock()
+		select {
+		case <-c.full:
+		case <-time.After(time.Second):
+		}
+		c.mu.Lock()
+		c.inflight--
+		c.mu.Unlock()
+		if id == c.cancelAt {
+			c.cancel()
+		}
+		if id == c.fail {
+			return fmt.Errorf("fetch of %s failed", id)
+		}
+		return json.Unmarshal(fmt.Appendf(nil, `{"assessmentsByIds": {"nodes": [{"id": %q, "name": %q}]}}`, id, id), resp.Data)
+	}
+	return fmt.Errorf("dumpPoolClient: unexpected operation %q", req.OpName)
+}
+
+func newDumpPoolClient(wantInflight int) *dumpPoolClient {
+	return &dumpPoolClient{wantInflight: wantInflight, calls: map[string]int{}, full: make(chan struct{})}
+}
+
+func TestDumpInstance_Concurrent(t *testing.T) {
+	client := newDumpPoolClient(3)
+	client.fail = "db1-2"
+	filter, err := util.NewFilter(strings.NewReader(`"*","*"` + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dumped, err := DumpInstance(context.Background(), client, filter, &SaveOptionalParams{Concurrency: 3})
+	if !errors.Is(err, ErrDumpAssessmentFailure) {
+		t.Fatalf("err = %v, want ErrDumpAssessmentFailure for the failed fetch", err)
+	}
+	var names []string
+	for _, ae := range dumped {
+		names = append(names, ae.Db+"/"+ae.AssessmentName)
+		if (ae.Err != nil) != (ae.AssessmentName == "db1 A2") {
+			t.Errorf("%s: err = %v, want an error only for db1 A2", ae.AssessmentName, ae.Err)
+		}
+		if ae.Err == nil && (ae.Ad == nil || ae.Ad.Assessment.Id == "") {
+			t.Errorf("%s: no assessment data", ae.AssessmentName)
+		}
+	}
+	if fmt.Sprint(names) != "[db1/db1 A1 db1/db1 A2 db1/db1 A3 db2/db2 A1 db2/db2 A2 db2/db2 A3]" {
+		t.Errorf("dumped %v, want listing order", names)
+	}
+	if client.maxInflight != 3 {
+		t.Errorf("at most %d fetches in flight, want 3", client.maxInflight)
+	}
+	if n := client.calls["GetAllDefenseTools"]; n != 2 {
+		t.Errorf("fetched defense tools %d times, want once per db", n)
+	}
+}
+
+func TestDumpInstance_CancelStopsFetching(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := newDumpPoolClient(1)
+	client.cancelAt = "db1-2"
+	client.cancel = cancel
+	filter, err := util.NewFilter(strings.NewReader(`"*","*"` + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dumped, err := DumpInstance(ctx, client, filter, &SaveOptionalParams{Concurrency: 1})
+	if !errors.Is(err, ErrDumpAssessmentFailure) {
+		t.Fatalf("err = %v, want ErrDumpAssessmentFailure", err)
+	}
+	if len(dumped) != 6 {
+		t.Fatalf("got %d entries, want all 6 listed", len(dumped))
+	}
+	if dumped[0].Err != nil {
+		t.Errorf("first assessment: %v, want it dumped before the cancel", dumped[0].Err)
+	}
+	for _, ae := range dumped[2:] {
+		if !errors.Is(ae.Err, context.Canceled) {
+			t.Errorf("%s: err = %v, want context.Canceled", ae.AssessmentName, ae.Err)
+		}
+	}
+	if fmt.Sprint(client.fetched) != "[db1-1 db1-2]" {
+		t.Errorf("fetched %v, want nothing after the cancel", client.fetched)
+	}
+}
+
+func TestDumpInstance_SinceState(t *testing.T) {
+	client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"GetAllDatabases": json.RawMessage(`{"databases": [{"id": 1, "name": "db1"}]}`),
+		"GetAssessmentIdsForDb": json.RawMessage(`{"assessments": {"nodes": [
+			{"id": "a-1", "name": "Same", "globalId": "g-1", "updateTime": 100},
+			{"id": "a-2", "name": "Test Case Edited", "globalId": "g-2", "updateTime": 100},
+			{"id": "a-3", "name": "Never Dumped", "globalId": "g-3", "updateTime": 100}
+		]}}`),
+		"GetAssessmentUpdateTimesForDb": json.RawMessage(`{"assessments": {"nodes": [
+			{"id": "a-1", "updateTime": 100, "campaigns": [{"updateTime": 150, "testCases": [{"updateTime": 200}]}]},
+			{"id": "a-2", "updateTime": 100, "campaigns": [{"updateTime": 100, "testCases": [{"updateTime": 300}]}]},
+			{"id": "a-3", "updateTime": 100}
+		]}}`),
+		"GetAssessmentsByIds": json.RawMessage(`{"assessmentsByIds": {"nodes": [{"id": "a-2", "name": "Test Case Edited", "globalId": "g-2"}]}}`),
+		"GetAllDefenseTools":  json.RawMessage(`{"bluetools": {"nodes": []}}`),
+	}}
+	filter, err := util.NewFilter(strings.NewReader(`"*","*"` + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := NewDumpState()
+	state.Assessments["g-1"] = DumpRecord{Db: "db1", AssessmentName: "Same", UpdateTime: 100, LatestUpdateTime: 200}
+	state.Assessments["g-2"] = DumpRecord{Db: "db1", AssessmentName: "Test Case Edited", UpdateTime: 100, LatestUpdateTime: 200}
+
+	dumped, err := DumpInstance(context.Background(), client, filter, &SaveOptionalParams{SinceState: state})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]DumpChange{"Same": DumpUnchanged, "Test Case Edited": DumpUpdated, "Never Dumped": DumpNew}
+	for _, ae := range dumped {
+		if ae.Change != want[ae.AssessmentName] {
+			t.Errorf("%s: change = %q, want %q", ae.AssessmentName, ae.Change, want[ae.AssessmentName])
+		}
+		if (ae.Ad == nil) != (ae.Change == DumpUnchanged) {
+			t.Errorf("%s: fetched = %v, want only changed assessments fetched", ae.AssessmentName, ae.Ad != nil)
+		}
+	}
+	if n := strings.Count(strings.Join(client.calls, " "), "GetAssessmentsByIds"); n != 2 {
+		t.Errorf("fetched %d assessments in full, want the 2 changed ones", n)
+	}
+
+	state.Record(dumped[1])
+	if r := state.Assessments["g-2"]; r.LatestUpdateTime != 300 || r.DumpedAt.IsZero() {
+		t.Errorf("recorded %+v, want the new update time and when it was dumped", r)
+	}
+	if r := state.Assessments["g-1"]; r.LatestUpdateTime != 200 {
+		t.Errorf("unchanged record became %+v, want it kept", r)
+	}
+}
+
+func TestDumpInstance_FiltersOnListedFields(t *testing.T) {
+	client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"GetAllDatabases": json.RawMessage(`{"databases": [{"id": 1, "name": "db1"}]}`),
+		"GetAssessmentIdsForDb": json.RawMessage(`{"assessments": {"nodes": [
+			{"id": "a-1", "name": "Tagged", "globalId": "g-1", "updateTime": 1717200000000, "tags": [{"name": "red-team"}], "metadata": [{"key": "bundle", "value": "Atomic Basics"}]},
+			{"id": "a-2", "name": "Tagged But Old", "globalId": "g-2", "updateTime": 1672531200000, "tags": [{"name": "red-team"}]},
+			{"id": "a-3", "name": "Untagged", "globalId": "g-3", "updateTime": 1717200000000}
+		]}}`),
+		"GetAssessmentsByIds": json.RawMessage(`{"assessmentsByIds": {"nodes": [{"id": "a-1", "name": "Tagged", "globalId": "g-1"}]}}`),
+		"GetAllDefenseTools":  json.RawMessage(`{"bluetools": {"nodes": []}}`),
+	}}
+	filter, err := util.NewExpressionFilter([]string{"tag=red-team", "updated>=2024-01-01", "metadata.bundle=Atomic*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dumped, err := DumpInstance(context.Background(), client, filter, &SaveOptionalParams{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dumped) != 1 || dumped[0].AssessmentName != "Tagged" {
+		t.Fatalf("dumped %+v, want only Tagged", dumped)
+	}
+}
+
+func TestSaveAssessmentData_DuplicateNames(t *testing.T) {
+	client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"GetAllAssessments": json.RawMessage(`{"assessments": {"nodes": [{"id": "a-1", "name": "Q1"}, {"id": "a-2", "name": "Q1"}]}}`),
+	}}
+	_, err := SaveAssessmentData(context.Background(), client, "db1", "Q1")
+	if !errors.Is(err, ErrTooManyAssessmentsFound) {
+		t.Fatalf("err = %v, want ErrTooManyAssessmentsFound", err)
+	}
+}
+
+func TestSaveAssessmentByGlobalId(t *testing.T) {
+	listing := json.RawMessage(`{"assessments": {"nodes": [
+		{"id": "a-1", "name": "Q1", "globalId": "g-1"},
+		{"id": "a-2", "name": "Q1", "globalId": "g-2"},
+		{"id": "a-3", "name": "Copy", "globalId": "g-dup"},
+		{"id": "a-4", "name": "Other Copy", "globalId": "g-dup"}
+	]}}`)
+	newClient := func() *scriptedGraphQLClient {
+		return &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+			"GetAssessmentIdsForDb": listing,
+			"GetAssessmentsByIds":   json.RawMessage(`{"assessmentsByIds": {"nodes": [{"id": "a-2", "name": "Q1", "globalId": "g-2"}]}}`),
+			"GetAllDefenseTools":    json.RawMessage(`{"bluetools": {"nodes": []}}`),
+		}}
+	}
+
+	t.Run("fetches the matching id", func(t *testing.T) {
+		client := newClient()
+		data, err := SaveAssessmentByGlobalId(context.Background(), client, "db1", "g-2")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if data.Assessment.GlobalId != "g-2" {
+			t.Errorf("saved globalId %q, want g-2", data.Assessment.GlobalId)
+		}
+		if !strings.Contains(string(client.variables["GetAssessmentsByIds"]), `"a-2"`) {
+			t.Errorf("GetAssessmentsByIds sent %s, want id a-2", client.variables["GetAssessmentsByIds"])
+		}
+	})
+
+	for name, tc := range map[string]struct {
+		globalId string
+		want     error
+	}{
+		"unknown globalId":   {"g-9", ErrNoAssessmentsFound},
+		"duplicate globalId": {"g-dup", ErrTooManyAssessmentsFound},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := newClient()
+			_, err := SaveAssessmentByGlobalId(context.Background(), client, "db1", tc.globalId)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("err = %v, want %v", err, tc.want)
+			}
+			if client.called("GetAssessmentsByIds") {
+				t.Error("fetched an assessment without a single globalId match")
+			}
+		})
+	}
+}
+
+func TestSaveAssessmentById_Missing(t *testing.T) {
+	client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"GetAssessmentsByIds": json.RawMessage(`{"assessmentsByIds": {"nodes": []}}`),
+	}}
+	_, err := SaveAssessmentById(context.Background(), client, "db1", "a-9")
+	if !errors.Is(err, ErrNoAssessmentsFound) {
+		t.Fatalf("err = %v, want ErrNoAssessmentsFound", err)
+	}
+}
+
+// environmentClient stubs a target instance with the tool, product and layer
+// of existingToolRef, one organization, one vendor, a TEST_CASE tag and two
+// outcomes.
+func environmentClient() *scriptedGraphQLClient {
+	return &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"GetAllOrganizations":          json.RawMessage(`{"organizations": {"nodes": [{"id": "org-1", "name": "SRA"}]}}`),
+		"GetAllLibraryVendors":         json.RawMessage(`{"libraryVendors": {"nodes": [{"id": "target-vendor-1", "name": "CrowdStrike"}]}}`),
+		"GetAllDefenseTools":           json.RawMessage(existingToolsResponse),
+		"GetAllDefenseToolProducts":    json.RawMessage(existingProductsResponse),
+		"GetAllDefensiveLayers":        json.RawMessage(singleEndpointLayerResponse),
+		"GetAllLibraryDefensiveLayers": json.RawMessage(`{"libraryDefensivelayers": {"nodes": [{"id": "target-library-layer-endpoint", "name": "Endpoint"}]}}`),
+		"GetAllTagTypes":               json.RawMessage(`{"tagTypes": [{"id": "tt-1", "name": "Test Case", "ref": "TEST_CASE"}]}`),
+		"GetAllTags":                   json.RawMessage(`{"tags": {"nodes": [{"id": "tag-1", "name": "red-team", "tagTypeId": "tt-1"}]}}`),
+		"GetAllOutcomes": json.RawMessage(`{"outcomes": [
+			{"id": "outcome-1", "name": "Blocked", "path": "Blocked", "abbreviation": "B", "reportText": "Blocked", "reportTextColor": "#4CAF50", "coverageScore": 1},
+			{"id": "outcome-2", "name": "Detected", "path": "Detected", "abbreviation": "D", "reportText": "Detected", "reportTextColor": "#2196F3", "coverageScore": 0.5}
+		]}`),
+		"CreateTag":      json.RawMessage(`{"tag": {"create": {"tags": [{"id": "tag-2", "name": "purple-team"}]}}}`),
+		"UpdateOutcomes": json.RawMessage(`{"outcome": {"update": {"outcomes": [{"id": "outcome-2", "path": "Detected"}]}}}`),
+	}}
+}
+
+// TestRestoreEnvironment_MatchesExisting verifies an environment the target
+// already has except for a tag and an outcome setting only creates that tag
+// and, with UpdateOutcomes, updates that outcome, and reports what VECTR
+// can't create.
+func TestRestoreEnvironment_MatchesExisting(t *testing.T) {
+	client := environmentClient()
+	env := &EnvironmentData{
+		DefenseTools:  ToolsMapResource{existingToolRef.Key(): existingToolRef},
+		Products:      []DefenseToolProductRef{existingToolRef.Product},
+		Vendors:       []string{"CrowdStrike", "ACME"},
+		DefenseLayers: []DefenseLayer{{Name: "Endpoint"}},
+		Tags: []EnvTag{
+			{Name: "red-team", TagType: "TEST_CASE"},
+			{Name: "purple-team", TagType: "TEST_CASE", Color: "#9C27B0"},
+		},
+		Outcomes: []EnvOutcome{
+			{Path: "Blocked", Name: "Blocked", Abbreviation: "B", ReportText: "Blocked", ReportTextColor: "#4CAF50", CoverageScore: 1},
+			{Path: "Detected", Name: "Detected", Abbreviation: "D", ReportText: "Detected", ReportTextColor: "#2196F3", CoverageScore: 0.75},
+			{Path: "Custom/Logged", Name: "Logged"},
+		},
+		Organizations: []EnvOrganization{{Name: "SRA"}, {Name: "Globex"}},
+	}
+	writer := &countingJournalWriter{}
+
+	report, err := RestoreEnvironment(context.Background(), client, "test-db", env, &RestoreOptionalParams{UpdateOutcomes: true, JournalWriter: writer})
+	if err != nil {
+		t.Fatalf("RestoreEnvironment returned an error: %v", err)
+	}
+	want := &EnvironmentReport{
+		MissingOrganizations: []string{"Globex"},
+		MissingVendors:       []string{"ACME"},
+		MissingOutcomes:      []string{"Custom/Logged"},
+		CreatedTags:          1,
+		UpdatedOutcomes:      1,
+		DefenseTools:         1,
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+	for _, op := range []string{"CreateDefenseTool", "UpdateDefenseTool", "CreateDefenseToolProduct", "CloneDefenseLayer", "CreateLibraryDefenseLayer"} {
+		if client.called(op) {
+			t.Errorf("expected no %s for an environment the target already has, calls: %v", op, client.calls)
+		}
+	}
+	var tag struct{ Input dao.CreateTagInput }
+	if err := json.Unmarshal(client.variables["CreateTag"], &tag); err != nil {
+		t.Fatal(err)
+	}
+	if tag.Input.Name != "purple-team" || tag.Input.TagType != dao.TagTypeEnumTestCase || tag.Input.TagColor != "#9C27B0" {
+		t.Errorf("CreateTag input = %+v, want the missing purple-team tag", tag.Input)
+	}
+	var outcomes struct{ Input dao.UpdateOutcomeInput }
+	if err := json.Unmarshal(client.variables["UpdateOutcomes"], &outcomes); err != nil {
+		t.Fatal(err)
+	}
+	if len(outcomes.Input.UpdateOutcomeData) != 1 || outcomes.Input.UpdateOutcomeData[0].Id != "outcome-2" || outcomes.Input.UpdateOutcomeData[0].CoverageScore != 0.75 {
+		t.Errorf("UpdateOutcomes input = %+v, want only outcome-2's coverage score changed", outcomes.Input)
+	}
+	if !writer.last.Complete || writer.last.Db != "test-db" {
+		t.Errorf("journal = %+v, want a complete journal for test-db", writer.last)
+	}
+	wantPrior := map[string]EnvOutcome{"outcome-2": {Path: "Detected", Name: "Detected", Abbreviation: "D", ReportText: "Detected", ReportTextColor: "#2196F3", CoverageScore: 0.5}}
+	if !reflect.DeepEqual(writer.last.PriorOutcomes, wantPrior) {
+		t.Errorf("journal prior outcomes = %+v, want %+v", writer.last.PriorOutcomes, wantPrior)
+	}
+}
+
+// TestRestoreEnvironment_ReportsDifferingOutcomes verifies outcomes, which
+// are instance-wide, are only reported and not written without
+// UpdateOutcomes.
+func TestRestoreEnvironment_ReportsDifferingOutcomes(t *testing.T) {
+	client := environmentClient()
+	env := &EnvironmentData{
+		DefenseTools: ToolsMapResource{},
+		Outcomes: []EnvOutcome{
+			{Path: "Blocked", Name: "Blocked", Abbreviation: "B", ReportText: "Blocked", ReportTextColor: "#4CAF50", CoverageScore: 1},
+			{Path: "Detected", Name: "Detected", Abbreviation: "D", ReportText: "Detected", ReportTextColor: "#2196F3", CoverageScore: 0.75},
+		},
+	}
+	writer := &countingJournalWriter{}
+
+	report, err := RestoreEnvironment(context.Background(), client, "test-db", env, &RestoreOptionalParams{JournalWriter: writer})
+	if err != nil {
+		t.Fatalf("RestoreEnvironment returned an error: %v", err)
+	}
+	if !reflect.DeepEqual(report.DifferingOutcomes, []string{"Detected"}) || report.UpdatedOutcomes != 0 {
+		t.Errorf("report = %+v, want only Detected reported as differing and nothing updated", report)
+	}
+	if client.called("UpdateOutcomes") {
+		t.Errorf("expected no UpdateOutcomes without UpdateOutcomes set, calls: %v", client.calls)
+	}
+	if len(writer.last.PriorOutcomes) != 0 {
+		t.Errorf("journal prior outcomes = %+v, want none", writer.last.PriorOutcomes)
+	}
+}
+
+// TestRestoreEnvironment_RollbackRestoresOutcomes verifies a failed outcome
+// update is rolled back by writing the journaled prior settings back.
+func TestRestoreEnvironment_RollbackRestoresOutcomes(t *testing.T) {
+	client := environmentClient()
+	client.errs = map[string]error{"UpdateOutcomes": errors.New("gateway timeout")}
+	env := &EnvironmentData{
+		DefenseTools: ToolsMapResource{},
+		Outcomes: []EnvOutcome{
+			{Path: "Detected", Name: "Detected", Abbreviation: "DT", ReportText: "Seen", ReportTextColor: "#000000", CoverageScore: 0.75},
+		},
+	}
+	writer := &countingJournalWriter{}
+
+	_, err := RestoreEnvironment(context.Background(), client, "test-db", env, &RestoreOptionalParams{UpdateOutcomes: true, JournalWriter: writer})
+	if err == nil {
+		t.Fatal("expected an error when updating outcomes fails")
+	}
+	if writer.last.PriorOutcomes["outcome-2"].CoverageScore != 0.5 {
+		t.Fatalf("journal prior outcomes = %+v, want outcome-2's settings from before the update", writer.last.PriorOutcomes)
+	}
+
+	delete(client.errs, "UpdateOutcomes")
+	journal := writer.last
+	if err := RollbackRestore(context.Background(), client, &journal, writer); err != nil {
+		t.Fatalf("RollbackRestore returned an error: %v", err)
+	}
+	var outcomes struct{ Input dao.UpdateOutcomeInput }
+	if err := json.Unmarshal(client.variables["UpdateOutcomes"], &outcomes); err != nil {
+		t.Fatal(err)
+	}
+	want := []dao.UpdateOutcomeDataInput{{Id: "outcome-2", Abbreviation: "D", ReportText: "Detected", ReportTextColor: "#2196F3", CoverageScore: 0.5}}
+	if !reflect.DeepEqual(outcomes.Input.UpdateOutcomeData, want) {
+		t.Errorf("rollback UpdateOutcomes input = %+v, want %+v", outcomes.Input.UpdateOutcomeData, want)
+	}
+	if len(writer.last.PriorOutcomes) != 0 || !writer.last.RolledBack {
+		t.Errorf("journal = %+v, want it rolled back with no prior outcomes left", writer.last)
+	}
+}
+
+// TestRestoreEnvironment_CreatesUnusedProductsAndLayers verifies products
+// and defense layers no defense tool uses are still created, and journaled
+// so a rollback can remove them.
+func TestRestoreEnvironment_CreatesUnusedProductsAndLayers(t *testing.T) {
+	client := environmentClient()
+	client.responses["FindVendor"] = json.RawMessage(`{"libraryVendors": {"nodes": []}}`)
+	client.responses["CreateLibraryDefenseLayer"] = json.RawMessage(`{
+		"defenseLayer": {"createLibrary": {"defenseLayers": [{"id": "target-library-layer-network", "name": "Network"}]}}
+	}`)
+	client.responses["CreateDefenseToolProduct"] = json.RawMessage(`{
+		"defenseToolProduct": {"create": {"defenseToolProducts": [{"id": "target-product-2", "name": "Sensor", "ref": "acme-sensor"}]}}
+	}`)
+	client.responses["CloneDefenseLayer"] = json.RawMessage(`{
+		"defenseLayer": {"clone": {"defenseLayers": [{"id": "target-layer-network", "name": "Network"}]}}
+	}`)
+	env := &EnvironmentData{
+		DefenseTools: ToolsMapResource{},
+		Products: []DefenseToolProductRef{{
+			Ref: "acme-sensor", Name: "Sensor", VendorName: "ACME",
+			Layers: []DefenseLayer{{Name: "Network", Description: "Network sensors"}},
+		}},
+		DefenseLayers: []DefenseLayer{{Name: "Endpoint"}, {Name: "Network", Description: "Network sensors"}},
+	}
+	writer := &countingJournalWriter{}
+
+	if _, err := RestoreEnvironment(context.Background(), client, "test-db", env, &RestoreOptionalParams{JournalWriter: writer}); err != nil {
+		t.Fatalf("RestoreEnvironment returned an error: %v", err)
+	}
+	want := CreatedObjects{
+		DefenseToolProducts:  []string{"target-product-2"},
+		DefenseLayers:        []string{"target-layer-network"},
+		LibraryDefenseLayers: []string{"target-library-layer-network"},
+	}
+	if !reflect.DeepEqual(writer.last.Created, want) {
+		t.Errorf("journal created %+v, want %+v", writer.last.Created, want)
+	}
+}
+
+// TestRestoreEnvironment_DeleteOnFailure verifies a failed restore removes
+// what it had already created.
+func TestRestoreEnvironment_DeleteOnFailure(t *testing.T) {
+	client := environmentClient()
+	client.responses["FindVendor"] = json.RawMessage(`{"libraryVendors": {"nodes": []}}`)
+	client.responses["CreateDefenseToolProduct"] = json.RawMessage(`{
+		"defenseToolProduct": {"create": {"defenseToolProducts": [{"id": "target-product-2", "name": "Sensor", "ref": "acme-sensor"}]}}
+	}`)
+	client.responses["DeleteDefenseToolProducts"] = json.RawMessage(`{"defenseToolProduct": {"delete": {"deletedIds": ["target-product-2"]}}}`)
+	client.errs = map[string]error{"CreateTag": errors.New("tag service unavailable")}
+	env := &EnvironmentData{
+		DefenseTools: ToolsMapResource{},
+		Products:     []DefenseToolProductRef{{Ref: "acme-sensor", Name: "Sensor", Layers: []DefenseLayer{{Name: "Endpoint"}}}},
+		Tags:         []EnvTag{{Name: "purple-team", TagType: "TEST_CASE"}},
+	}
+
+	_, err := RestoreEnvironment(context.Background(), client, "test-db", env, &RestoreOptionalParams{DeleteOnFailure: true, JournalWriter: &countingJournalWriter{}})
+	if err == nil {
+		t.Fatal("expected an error when creating a tag fails")
+	}
+	if !client.called("DeleteDefenseToolProducts") {
+		t.Errorf("expected the created product to be deleted, calls: %v", client.calls)
+	}
+}
+
+// TestRestoreEnvironment_RejectsOtherJournals verifies --resume only takes
+// the journal of an environment restore into the same database.
+func TestRestoreEnvironment_RejectsOtherJournals(t *testing.T) {
+	cases := map[string]RestoreJournal{
+		"another database":     {Db: "other-db"},
+		"an assessment":        {Db: "test-db", SourceAssessmentName: "Quarterly Purple Team"},
+		"a target assessment":  {Db: "test-db", AssessmentId: "assessment-1"},
+		"a library assessment": {AsTemplate: true},
+	}
+	for name, journal := range cases {
+		t.Run(name, func(t *testing.T) {
+			client := &scriptedGraphQLClient{}
+			_, err := RestoreEnvironment(context.Background(), client, "test-db", &EnvironmentData{}, &RestoreOptionalParams{Journal: &journal})
+			if !errors.Is(err, ErrJournalMismatch) {
+				t.Errorf("err = %v, want ErrJournalMismatch", err)
+			}
+			if len(client.calls) != 0 {
+				t.Errorf("made requests %v before rejecting the journal", client.calls)
+			}
+		})
+	}
+}
+
+// Library test case ids, which VECTR requires to be UUIDs.
+const (
+	libTestCase1 = "0c9b6d1e-5f4a-4b2c-9d3e-1f2a3b4c5d01"
+	libTestCase2 = "0c9b6d1e-5f4a-4b2c-9d3e-1f2a3b4c5d02"
+)
+
+const libraryAssessmentsResponse = `{"libraryAssessments": {"nodes": [
+	{
+		"id": "la-1", "name": "ACME - Ransomware", "description": "Ransomware plan",
+		"killChain": {"id": "kc-1"},
+		"organizations": [{"name": "SRA"}],
+		"metadata": [{"key": "prefix", "value": "ACME"}],
+		"campaigns": [{
+			"id": "lc-1", "name": "ACME - Initial Access", "offset": 1,
+			"organizations": [{"name": "SRA"}],
+			"metadata": [{"key": "prefix", "value": "ACME"}],
+			"testCases": [
+				{"id": "lt-2", "libraryTestCaseId": "` + libTestCase2 + `", "offset": 2},
+				{"id": "lt-1", "libraryTestCaseId": "` + libTestCase1 + `", "offset": 1}
+			]
+		}]
+	},
+	{"id": "la-2", "name": "Baseline", "campaigns": []}
+]}}`
+
+// TestSaveLibrary verifies library assessments are selected by name, with or
+// without their prefix, or by prefix, and saved with their campaigns' library
+// test cases in order and every organization they need.
+func TestSaveLibrary(t *testing.T) {
+	newClient := func() *scriptedGraphQLClient {
+		return &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+			"GetAllLibraryAssessments": json.RawMessage(libraryAssessmentsResponse),
+			"GetLibraryTestCases": json.RawMessage(`{"libraryTestcasesByIds": {"nodes": [
+				{"id": "lt-1", "libraryTestCaseId": "` + libTestCase1 + `", "name": "Phishing", "organizations": [{"name": "SRA"}]},
+				{"id": "lt-2", "libraryTestCaseId": "` + libTestCase2 + `", "name": "Drive-by", "organizations": [{"name": "Red Team"}]}
+			]}}`),
+		}}
+	}
+	patterns := func(s ...string) []util.NamePattern {
+		p, err := util.NewNamePatterns(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	for name, tc := range map[string]struct {
+		names, prefixes []util.NamePattern
+	}{
+		"prefix":                       {prefixes: patterns("AC*")},
+		"name with prefix":             {names: patterns("ACME - Ransomware")},
+		"name without prefix":          {names: patterns("Ransomware")},
+		"regex on name without prefix": {names: patterns("re:^Ransom"), prefixes: patterns("Other")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := newClient()
+			data, err := SaveLibrary(context.Background(), client, tc.names, tc.prefixes)
+			if err != nil {
+				t.Fatalf("SaveLibrary returned an error: %v", err)
+			}
+			if len(data.Assessments) != 1 || data.Assessments[0].Name != "ACME - Ransomware" {
+				t.Fatalf("saved %+v, want only ACME - Ransomware", data.Assessments)
+			}
+			if got := data.Assessments[0].Campaigns[0].TestCases; !slices.Equal(got, []string{libTestCase1, libTestCase2}) {
+				t.Errorf("campaign test cases = %v, want the library test cases in offset order", got)
+			}
+			if len(data.TestCases) != 2 || data.Assessments[0].KillChainId != "kc-1" {
+				t.Errorf("saved %d library test cases and kill chain %q, want 2 and kc-1", len(data.TestCases), data.Assessments[0].KillChainId)
+			}
+			if _, ok := data.OrgMap["Red Team"]; !ok || len(data.OrgMap) != 2 {
+				t.Errorf("org map = %v, want SRA and Red Team", data.OrgMap)
+			}
+		})
+	}
+
+	client := newClient()
+	data, err := SaveLibrary(context.Background(), client, patterns("Baseline"), nil)
+	if err != nil {
+		t.Fatalf("SaveLibrary returned an error: %v", err)
+	}
+	if len(data.Assessments) != 1 || client.called("GetLibraryTestCases") {
+		t.Errorf("saved %+v (calls %v), want just Baseline and no library test cases fetched", data.Assessments, client.calls)
+	}
+	if _, err := SaveLibrary(context.Background(), newClient(), patterns("Nothing"), nil); !errors.Is(err, ErrNoAssessmentsFound) {
+		t.Errorf("no match: err = %v, want ErrNoAssessmentsFound", err)
+	}
+
+	client = newClient()
+	client.errs = map[string]error{"GetLibraryTestCases": gqlerror.List{{
+		Message:    "invalid ids",
+		Path:       ast.Path{ast.PathName("libraryTestcasesByIds")},
+		Extensions: map[string]any{"ids": []any{"The following IDs were not valid: " + libTestCase2}},
+	}}}
+	if _, err := SaveLibrary(context.Background(), client, patterns("Ransomware"), nil); !errors.Is(err, ErrMissingLibraryTestCases) || !strings.Contains(err.Error(), libTestCase2) {
+		t.Errorf("missing library test case: err = %v, want ErrMissingLibraryTestCases naming %s", err, libTestCase2)
+	}
+}
+
+// libraryToRestore is a library archive with one library assessment, whose
+// first campaign is new and second already in the target library.
+func libraryToRestore() *LibraryData {
+	org := []dao.GetLibraryTestCasesLibraryTestcasesByIdsTestCaseConnectionNodesTestCaseOrganizationsOrganization{{Name: "SRA"}}
+	prefix := []dao.MetadataKeyValuePairInput{{Key: "prefix", Value: "ACME"}}
+	return &LibraryData{
+		Assessments: []LibraryAssessment{{
+			Name:          "ACME - Ransomware",
+			KillChainId:   "kc-1",
+			Organizations: []string{"SRA"},
+			Metadata:      prefix,
+			Campaigns: []LibraryCampaign{
+				{Name: "ACME - Initial Access", Metadata: prefix, Organizations: []string{"SRA"}, TestCases: []string{libTestCase1, libTestCase2}},
+				{Name: "Shared Recon", TestCases: []string{libTestCase1}},
+			},
+		}},
+		TestCases: LibraryTestCasesResource{
+			libTestCase1: {Name: "Phishing", LibraryTestCaseId: libTestCase1, Organizations: org},
+			libTestCase2: {Name: "Drive-by", LibraryTestCaseId: libTestCase2, Organizations: org},
+		},
+		OrgMap: OrgMapResource{"SRA": {Name: "SRA"}},
+	}
+}
+
+// TestRestoreLibrary verifies a library assessment missing from the target
+// library is created with its prefix split off, its missing library test
+// cases and campaigns are created, an existing campaign is reused, and
+// everything created is journaled for a rollback.
+func TestRestoreLibrary(t *testing.T) {
+	client := &scriptedGraphQLClient{
+		responses: map[string]json.RawMessage{
+			"FindOrganization":         json.RawMessage(`{"organizations": {"nodes": [{"id": "org-1", "name": "SRA"}]}}`),
+			"GetAllLibraryCampaigns":   json.RawMessage(`{"libraryCampaigns": {"nodes": [{"id": "lc-shared", "name": "Shared Recon", "testCases": [{"libraryTestCaseId": "` + libTestCase1 + `"}]}]}}`),
+			"FindLibraryAssessment":    json.RawMessage(`{"libraryAssessments": {"nodes": []}}`),
+			"CreateTemplateTestCases":  json.RawMessage(`{"testCase": {"createTemplate": {"testCases": [{"id": "new-lt-2", "libraryTestCaseId": "` + libTestCase2 + `"}]}}}`),
+			"CreateCampaignTemplates":  json.RawMessage(`{"campaign": {"createTemplate": {"campaigns": [{"id": "new-lc-1", "name": "Initial Access"}]}}}`),
+			"CreateAssessmentTemplate": json.RawMessage(`{"assessment": {"createTemplate": {"assessments": [{"id": "new-la-1", "name": "Ransomware"}]}}}`),
+		},
+		errs: map[string]error{
+			"GetLibraryTestCases": gqlerror.List{{
+				Message:    "invalid ids",
+				Path:       ast.Path{ast.PathName("libraryTestcasesByIds")},
+				Extensions: map[string]any{"ids": []any{"The following IDs were not valid: " + libTestCase2}},
+			}},
+		},
+	}
+	writer := &countingJournalWriter{}
+
+	report, err := RestoreLibrary(context.Background(), client, libraryToRestore(), &RestoreOptionalParams{JournalWriter: writer})
+	if err != nil {
+		t.Fatalf("RestoreLibrary returned an error: %v", err)
+	}
+	want := &LibraryReport{Created: []string{"ACME - Ransomware"}, ReusedCampaigns: []string{"Shared Recon"}}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+
+	var campaign struct {
+		Input dao.CreateCampaignTemplateInput
+	}
+	if err := json.Unmarshal(client.variables["CreateCampaignTemplates"], &campaign); err != nil {
+		t.Fatal(err)
+	}
+	if c := campaign.Input.CampaignTemplateData[0]; c.Name != "Initial Access" || c.TemplatePrefix != "ACME" || !slices.Equal(c.LibraryTestCaseIds, []string{libTestCase1, libTestCase2}) || !slices.Equal(c.OrganizationIds, []string{"org-1"}) {
+		t.Errorf("created campaign %+v, want Initial Access under prefix ACME with both library test cases and org-1", c)
+	}
+	var assessment struct {
+		Input dao.CreateAssessmentTemplateInput
+	}
+	if err := json.Unmarshal(client.variables["CreateAssessmentTemplate"], &assessment); err != nil {
+		t.Fatal(err)
+	}
+	if a := assessment.Input.AssessmentTemplateData[0]; a.Name != "Ransomware" || a.TemplatePrefix != "ACME" || !slices.Equal(a.LibraryCampaignIds, []string{"new-lc-1", "lc-shared"}) {
+		t.Errorf("created assessment %+v, want Ransomware under prefix ACME with campaigns new-lc-1 and lc-shared", a)
+	}
+
+	wantCreated := CreatedObjects{
+		TestCaseTemplates:   []string{"new-lt-2"},
+		CampaignTemplates:   []string{"new-lc-1"},
+		AssessmentTemplates: []string{"new-la-1"},
+	}
+	if !reflect.DeepEqual(writer.last.Created, wantCreated) || !writer.last.Complete || !writer.last.AsTemplate {
+		t.Errorf("journal created %+v (complete %v, as-template %v), want %+v", writer.last.Created, writer.last.Complete, writer.last.AsTemplate, wantCreated)
+	}
+}
+
+// TestRestoreLibrary_ReportsMismatchedCampaigns verifies an existing library
+// campaign reused under its name, but with other library test cases than
+// the saved one, is reported.
+func TestRestoreLibrary_ReportsMismatchedCampaigns(t *testing.T) {
+	client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"FindOrganization":         json.RawMessage(`{"organizations": {"nodes": [{"id": "org-1", "name": "SRA"}]}}`),
+		"GetAllLibraryCampaigns":   json.RawMessage(`{"libraryCampaigns": {"nodes": [{"id": "lc-shared", "name": "Shared Recon", "testCases": [{"libraryTestCaseId": "` + libTestCase2 + `"}]}]}}`),
+		"FindLibraryAssessment":    json.RawMessage(`{"libraryAssessments": {"nodes": []}}`),
+		"GetLibraryTestCases":      json.RawMessage(`{"libraryTestcasesByIds": {"nodes": []}}`),
+		"CreateCampaignTemplates":  json.RawMessage(`{"campaign": {"createTemplate": {"campaigns": [{"id": "new-lc-1", "name": "Initial Access"}]}}}`),
+		"CreateAssessmentTemplate": json.RawMessage(`{"assessment": {"createTemplate": {"assessments": [{"id": "new-la-1", "name": "Ransomware"}]}}}`),
+	}}
+
+	report, err := RestoreLibrary(context.Background(), client, libraryToRestore(), &RestoreOptionalParams{JournalWriter: &countingJournalWriter{}})
+	if err != nil {
+		t.Fatalf("RestoreLibrary returned an error: %v", err)
+	}
+	if !slices.Equal(report.MismatchedCampaigns, []string{"Shared Recon"}) {
+		t.Errorf("mismatched campaigns = %v, want [Shared Recon]", report.MismatchedCampaigns)
+	}
+}
+
+// TestRestoreLibrary_SkipsExisting verifies a library assessment already in
+// the target library is left alone, and that a journal from an assessment
+// restore can't be resumed as a library restore.
+func TestRestoreLibrary_SkipsExisting(t *testing.T) {
+	client := &scriptedGraphQLClient{responses: map[string]json.RawMessage{
+		"FindOrganization":       json.RawMessage(`{"organizations": {"nodes": [{"id": "org-1", "name": "SRA"}]}}`),
+		"GetAllLibraryCampaigns": json.RawMessage(`{"libraryCampaigns": {"nodes": []}}`),
+		"FindLibraryAssessment":  json.RawMessage(`{"libraryAssessments": {"nodes": [{"name": "ACME - Ransomware"}]}}`),
+	}}
+
+	report, err := RestoreLibrary(context.Background(), client, libraryToRestore(), &RestoreOptionalParams{})
+	if err != nil {
+		t.Fatalf("RestoreLibrary returned an error: %v", err)
+	}
+	if !slices.Equal(report.Skipped, []string{"ACME - Ransomware"}) || len(report.Created) != 0 {
+		t.Errorf("report = %+v, want ACME - Ransomware skipped", report)
+	}
+	for _, op := range []string{"CreateTemplateTestCases", "CreateCampaignTemplates", "CreateAssessmentTemplate"} {
+		if client.called(op) {
+			t.Errorf("expected no %s for an existing library assessment", op)
+		}
+	}
+
+	journal := NewRestoreJournal()
+	journal.Db = "test-db"
+	journal.SourceAssessmentName = "Q1 Red Team"
+	if _, err := RestoreLibrary(context.Background(), client, libraryToRestore(), &RestoreOptionalParams{Journal: journal}); !errors.Is(err, ErrJournalMismatch) {
+		t.Errorf("assessment restore journal: err = %v, want ErrJournalMismatch", err)
+	}
+}
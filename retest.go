@@ -0,0 +1,54 @@
+package vat
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+
+	"sra/vat/internal/dao"
+)
+
+// RetestOfMetadataKey is the assessment metadata key a Retest restore records
+// the source assessment's globalId under, so the retest can later be
+// compared with the assessment it retests.
+const RetestOfMetadataKey = "vat-retest-of"
+
+// resetForRetest strips every result from ad's test cases, leaving the test
+// plan: statuses go back to Not Performed, and outcomes, outcome notes,
+// defense tool outcomes, attack success and timeline events are cleared. It
+// also records the source assessment's globalId under RetestOfMetadataKey,
+// replacing one a retest of a retest carries over.
+//
+// It must run before the globalId is reset for the copy.
+func resetForRetest(ctx context.Context, ad *AssessmentData) {
+	reset := 0
+	for ci := range ad.Assessment.Campaigns {
+		c := &ad.Assessment.Campaigns[ci]
+		for ti := range c.TestCases {
+			tc := &c.TestCases[ti]
+			if tc.Status != string(dao.TestCaseStatusNotperformed) && tc.Status != "Not Performed" {
+				reset++
+			}
+			tc.Status = string(dao.TestCaseStatusNotperformed)
+			tc.Outcome = dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCaseOutcome{}
+			tc.OutcomeNotes = ""
+			tc.OverrideOutcome = false
+			tc.DefenseToolOutcomes = nil
+			tc.AttackSuccess = ""
+			tc.TimelineEvents = nil
+			tc.AttackStart = nil
+			tc.AttackStop = nil
+			tc.DetectionTime = nil
+			tc.CompleteTime = nil
+		}
+	}
+
+	ad.Assessment.Metadata = slices.DeleteFunc(slices.Clone(ad.Assessment.Metadata), func(md dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentMetadataMetadataKeyValuePair) bool {
+		return md.Key == RetestOfMetadataKey
+	})
+	ad.Assessment.Metadata = append(ad.Assessment.Metadata, dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentMetadataMetadataKeyValuePair{
+		Key:   RetestOfMetadataKey,
+		Value: ad.Assessment.GlobalId,
+	})
+	slog.InfoContext(ctx, "Reset test case results for a retest", "assessment-name", ad.Assessment.Name, "retest-of", ad.Assessment.GlobalId, "reset-count", reset)
+}
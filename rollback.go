@@ -0,0 +1,284 @@
+package vat
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"sra/vat/internal/dao"
+
+	"github.com/Khan/genqlient/graphql"
+)
+
+// RollbackRestore deletes everything the journaled restore created in the
+// target instance, in reverse dependency order:
+//
+//  1. the restored assessment, or for a single-campaign restore the
+//     campaigns it added (their test cases go with them; the existing
+//     assessment they were added to is left alone), or for a
+//     RestoreModeUpdate restore into an existing assessment the test cases
+//     and campaigns it appended
+//  2. defense tools
+//  3. db-scoped defense layers
+//  4. defense tool products
+//  5. library defense layers
+//  6. library test cases written by OverrideAssessmentTemplate
+//
+// and then writes back the report settings of any outcome an environment
+// restore with UpdateOutcomes changed.
+//
+// A restore AsTemplate wrote only to the library: its library assessment,
+// then its library campaigns, are deleted ahead of step 6 instead of step 1.
+//
+// Only objects the restore created are deleted; defense tools it matched or
+// added layers to, and library test cases it overwrote, were there before it
+// ran and stay. Targets and sources can't be deleted through VECTR's API, so
+// any the restore created are left in place.
+//
+// The journal is updated (and written with writer, which may be nil) after
+// each step, so a rollback that fails part way through can simply be run
+// again with the same journal.
+func RollbackRestore(ctx context.Context, client graphql.Client, journal *RestoreJournal, writer JournalWriter) error {
+	p := &RestoreOptionalParams{Journal: journal, JournalWriter: writer}
+	j := p.journal()
+	slog.InfoContext(ctx, "Rolling back restore", "db", j.Db, "assessment-name", j.AssessmentName, "assessment-id", j.AssessmentId, "source-campaign", j.SourceCampaignName)
+
+	if j.AsTemplate {
+		// Everything it created is in j.Created, deleted below.
+		j.forgetAssessment()
+	} else if j.UpdatedExisting {
+		if err := rollbackUpdate(ctx, client, j); err != nil {
+			return err
+		}
+	} else if j.SourceCampaignName == "" && j.AssessmentId != "" {
+		r, err := dao.DeleteAssessment(ctx, client, j.Db, []string{j.AssessmentId})
+		if err != nil {
+			if gqlObject, ok := gqlErrParse(err); ok {
+				slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+			}
+			return fmt.Errorf("could not delete assessment %s (id %s) from %s: %w", j.AssessmentName, j.AssessmentId, j.Db, err)
+		}
+		if len(r.Assessment.Delete.DeletedIds) == 1 && strings.EqualFold(r.Assessment.Delete.DeletedIds[0], j.AssessmentId) {
+			slog.InfoContext(ctx, "Assessment cleaned up successfully...", "assessment-name", j.AssessmentName, "db", j.Db)
+		} else {
+			slog.ErrorContext(ctx, "delete mismatch, the wrong item was deleted (not sure how this happened)", "expected-id", j.AssessmentId, "deleted-id(s)", r.Assessment.Delete.DeletedIds)
+		}
+		// Nothing recorded under the assessment exists any more.
+		j.forgetAssessment()
+	} else if j.SourceCampaignName != "" && len(j.Campaigns) > 0 {
+		ids := make([]string, 0, len(j.Campaigns))
+		for _, id := range j.Campaigns {
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+		r, err := dao.DeleteCampaigns(ctx, client, j.Db, ids)
+		if err != nil {
+			if gqlObject, ok := gqlErrParse(err); ok {
+				slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+			}
+			return fmt.Errorf("could not delete campaign(s) %v from assessment %s in %s: %w", ids, j.AssessmentName, j.Db, err)
+		}
+		logDeleted(ctx, "campaign", ids, r.Campaign.Delete.DeletedIds)
+		j.forgetAssessment()
+	}
+	// Tool ids the restore resolved may point at tools deleted below; a
+	// resume reconciles them again.
+	j.DefenseTools = map[string]string{}
+	j.Complete = false
+	if err := p.checkpoint(ctx); err != nil {
+		return err
+	}
+
+	steps := []struct {
+		kind string
+		ids  *[]string
+		del  func(ids []string) ([]string, error)
+	}{
+		{"library assessment", &j.Created.AssessmentTemplates, func(ids []string) ([]string, error) {
+			r, err := dao.DeleteAssessmentTemplates(ctx, client, ids)
+			if err != nil {
+				return nil, err
+			}
+			return r.Assessment.DeleteTemplate.DeletedIds, nil
+		}},
+		{"library campaign", &j.Created.CampaignTemplates, func(ids []string) ([]string, error) {
+			r, err := dao.DeleteCampaignTemplates(ctx, client, ids)
+			if err != nil {
+				return nil, err
+			}
+			return r.Campaign.DeleteTemplate.DeletedIds, nil
+		}},
+		{"defense tool", &j.Created.DefenseTools, func(ids []string) ([]string, error) {
+			r, err := dao.DeleteDefenseTools(ctx, client, ids)
+			if err != nil {
+				return nil, err
+			}
+			return r.DefenseTool.Delete.Ids, nil
+		}},
+		{"defense layer", &j.Created.DefenseLayers, func(ids []string) ([]string, error) {
+			r, err := dao.DeleteDefenseLayers(ctx, client, ids)
+			if err != nil {
+				return nil, err
+			}
+			return r.DefenseLayer.Delete.Ids, nil
+		}},
+		{"defense tool product", &j.Created.DefenseToolProducts, func(ids []string) ([]string, error) {
+			r, err := dao.DeleteDefenseToolProducts(ctx, client, ids)
+			if err != nil {
+				return nil, err
+			}
+			return r.DefenseToolProduct.Delete.DeletedIds, nil
+		}},
+		{"library defense layer", &j.Created.LibraryDefenseLayers, func(ids []string) ([]string, error) {
+			r, err := dao.DeleteLibraryDefenseLayers(ctx, client, ids)
+			if err != nil {
+				return nil, err
+			}
+			return r.DefenseLayer.DeleteLibrary.Ids, nil
+		}},
+		{"library test case", &j.Created.TestCaseTemplates, func(ids []string) ([]string, error) {
+			r, err := dao.DeleteTemplateTestCases(ctx, client, ids)
+			if err != nil {
+				return nil, err
+			}
+			return r.TestCase.DeleteTemplate.DeletedIds, nil
+		}},
+	}
+	for _, step := range steps {
+		if len(*step.ids) == 0 {
+			continue
+		}
+		deleted, err := step.del(*step.ids)
+		if err != nil {
+			if gqlObject, ok := gqlErrParse(err); ok {
+				slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+			}
+			return fmt.Errorf("could not delete %d created %s(s): %w", len(*step.ids), step.kind, err)
+		}
+		logDeleted(ctx, step.kind, *step.ids, deleted)
+		*step.ids = nil
+		if err := p.checkpoint(ctx); err != nil {
+			return err
+		}
+	}
+
+	if len(j.PriorOutcomes) > 0 {
+		ids := make([]string, 0, len(j.PriorOutcomes))
+		for id := range j.PriorOutcomes {
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+		prior := make([]dao.UpdateOutcomeDataInput, 0, len(ids))
+		for _, id := range ids {
+			o := j.PriorOutcomes[id]
+			prior = append(prior, dao.UpdateOutcomeDataInput{
+				Id:              id,
+				Abbreviation:    o.Abbreviation,
+				ReportText:      o.ReportText,
+				ReportTextColor: o.ReportTextColor,
+				CoverageScore:   o.CoverageScore,
+			})
+		}
+		if _, err := dao.UpdateOutcomes(ctx, client, dao.UpdateOutcomeInput{UpdateOutcomeData: prior}); err != nil {
+			if gqlObject, ok := gqlErrParse(err); ok {
+				slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+			}
+			return fmt.Errorf("could not restore the settings of %d updated outcome(s): %w", len(prior), err)
+		}
+		slog.InfoContext(ctx, "Restored outcome settings", "count", len(prior))
+		j.PriorOutcomes = map[string]EnvOutcome{}
+		if err := p.checkpoint(ctx); err != nil {
+			return err
+		}
+	}
+
+	if j.AssetsReconciled {
+		slog.WarnContext(ctx, "VECTR's API cannot delete targets or sources, any the restore created are left in place", "db", j.Db)
+	}
+	j.RolledBack = true
+	if err := p.checkpoint(ctx); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Rollback complete", "db", j.Db)
+	return nil
+}
+
+// rollbackUpdate deletes the test cases and then the campaigns a
+// RestoreModeUpdate restore appended to an existing assessment. The
+// assessment itself, and whatever it held before, stay; updates made to the
+// test cases it already had can't be told from later edits and are kept.
+func rollbackUpdate(ctx context.Context, client graphql.Client, j *RestoreJournal) error {
+	var testCaseIds, campaignIds []string
+	updated := 0
+	for _, id := range j.TestCases {
+		if j.PreExisting[id] {
+			updated++
+			continue
+		}
+		testCaseIds = append(testCaseIds, id)
+	}
+	for _, id := range j.Campaigns {
+		if !j.PreExisting[id] {
+			campaignIds = append(campaignIds, id)
+		}
+	}
+	slices.Sort(testCaseIds)
+	slices.Sort(campaignIds)
+
+	if len(testCaseIds) > 0 {
+		r, err := dao.DeleteTestCases(ctx, client, j.Db, testCaseIds)
+		if err != nil {
+			if gqlObject, ok := gqlErrParse(err); ok {
+				slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+			}
+			return fmt.Errorf("could not delete test case(s) appended to assessment %s in %s: %w", j.AssessmentName, j.Db, err)
+		}
+		logDeleted(ctx, "test case", testCaseIds, r.TestCase.Delete.DeletedIds)
+	}
+	if len(campaignIds) > 0 {
+		r, err := dao.DeleteCampaigns(ctx, client, j.Db, campaignIds)
+		if err != nil {
+			if gqlObject, ok := gqlErrParse(err); ok {
+				slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+			}
+			return fmt.Errorf("could not delete campaign(s) appended to assessment %s in %s: %w", j.AssessmentName, j.Db, err)
+		}
+		logDeleted(ctx, "campaign", campaignIds, r.Campaign.Delete.DeletedIds)
+	}
+	if updated > 0 {
+		slog.WarnContext(ctx, "updates to test cases the assessment already had, and timeline events added to them, are not undone", "assessment-name", j.AssessmentName, "db", j.Db, "test-case-count", updated)
+	}
+	j.forgetAssessment()
+	return nil
+}
+
+// logDeleted reports a delete mutation's result, warning about any requested
+// id VECTR didn't say it deleted.
+func logDeleted(ctx context.Context, kind string, requested, deleted []string) {
+	var missing []string
+	for _, id := range requested {
+		if !slices.ContainsFunc(deleted, func(d string) bool { return strings.EqualFold(d, id) }) {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		slog.WarnContext(ctx, "delete did not report every id as deleted, they may already have been removed", "kind", kind, "missing-ids", missing)
+	}
+	slog.InfoContext(ctx, "Deleted created objects", "kind", kind, "count", len(requested)-len(missing))
+}
+
+// rollbackOnFailure runs RollbackRestore for DeleteOnFailure. A failed
+// rollback is only logged, so the caller still returns the error that
+// caused it; the journal keeps whatever is left for `vat rollback`.
+func (p *RestoreOptionalParams) rollbackOnFailure(ctx context.Context, client graphql.Client) {
+	// The restore may have failed because ctx was cancelled (Ctrl+C); the
+	// cleanup still has to reach VECTR.
+	ctx = context.WithoutCancel(ctx)
+	slog.ErrorContext(ctx, "rolling back everything the restore created since a failure occured", "db", p.journal().Db)
+	if err := RollbackRestore(ctx, client, p.journal(), p.JournalWriter); err != nil {
+		// uh oh, things are very bad here.
+		slog.ErrorContext(ctx, "could not roll back the restore", "error", err, "db", p.journal().Db)
+	}
+}
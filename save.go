@@ -8,6 +8,7 @@ import (
 	"slices"
 	"sra/vat/internal/dao"
 	"strconv"
+	"strings"
 
 	"github.com/Khan/genqlient/graphql"
 )
@@ -15,6 +16,16 @@ import (
 var ErrNoAssessmentsFound = fmt.Errorf("no assessments found")
 var ErrTooManyAssessmentsFound = fmt.Errorf("more than one assessment matched")
 
+// SaveOptionalParams holds the optional settings for DumpInstance.
+type SaveOptionalParams struct {
+	// Concurrency is how many assessments DumpInstance fetches and saves at
+	// once. Zero or negative means one at a time.
+	Concurrency int
+	// SinceState makes DumpInstance skip the assessments it records as
+	// unchanged since they were last dumped. Nil dumps everything.
+	SinceState *DumpState
+}
+
 // SaveAssessmentData fetches and processes assessment data from a database.
 //
 // This function performs the following steps:
@@ -64,10 +75,10 @@ func SaveAssessmentData(ctx context.Context, client graphql.Client, db string, a
 		return nil, ErrNoAssessmentsFound
 	}
 	if len(assessment.Assessments.Nodes) > 1 {
-		return nil, fmt.Errorf("error searching %s, %w", assessment_name, err)
+		return nil, fmt.Errorf("%d assessments named %s, select one by id or globalId instead: %w", len(assessment.Assessments.Nodes), assessment_name, ErrTooManyAssessmentsFound)
 	}
 
-	result, err := saveAssessment(ctx, client, assessment.Assessments.Nodes[0], data, db)
+	result, err := saveAssessment(ctx, client, assessment.Assessments.Nodes[0], data, db, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -75,13 +86,67 @@ func SaveAssessmentData(ctx context.Context, client graphql.Client, db string, a
 	return result, nil
 }
 
+// SaveAssessmentById is SaveAssessmentData for the assessment with the given
+// id rather than name. Ids are unique within a database, so unlike names they
+// always select exactly one assessment.
+//
+// Errors:
+//   - Returns `ErrNoAssessmentsFound` if db has no assessment with that id.
+//   - Returns a wrapped error with additional context if any GraphQL query fails.
+func SaveAssessmentById(ctx context.Context, client graphql.Client, db string, id string) (*AssessmentData, error) {
+	slog.InfoContext(ctx, "Starting SaveAssessmentById", "db", db, "assessment-id", id)
+	result, err := dumpAssessment(ctx, client, db, id, nil)
+	if err != nil {
+		if gqlObject, ok := gqlErrParse(err); ok {
+			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+		}
+		return nil, err
+	}
+	slog.InfoContext(ctx, "Finished saving assessment", "assessment-name", result.Assessment.Name, "assessment-id", id, "db", db)
+	return result, nil
+}
+
+// SaveAssessmentByGlobalId is SaveAssessmentData for the assessment with the
+// given globalId, which, unlike its name or id, stays the same when the
+// assessment is renamed or transferred to another instance.
+//
+// Errors:
+//   - Returns `ErrNoAssessmentsFound` if db has no assessment with that globalId.
+//   - Returns `ErrTooManyAssessmentsFound` if more than one assessment has it.
+//   - Returns a wrapped error with additional context if any GraphQL query fails.
+func SaveAssessmentByGlobalId(ctx context.Context, client graphql.Client, db string, globalId string) (*AssessmentData, error) {
+	slog.InfoContext(ctx, "Starting SaveAssessmentByGlobalId", "db", db, "global-id", globalId)
+	assessments, err := dao.ListAssessmentIdsForDb(ctx, client, db)
+	if err != nil {
+		if gqlObject, ok := gqlErrParse(err); ok {
+			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+		}
+		return nil, fmt.Errorf("could not list assessments in %s: %w", db, err)
+	}
+	var ids []string
+	for _, assessment := range assessments {
+		if assessment.GlobalId == globalId {
+			ids = append(ids, assessment.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("globalId %s: %w", globalId, ErrNoAssessmentsFound)
+	}
+	if len(ids) > 1 {
+		return nil, fmt.Errorf("globalId %s matches assessments %s, select one by id instead: %w", globalId, strings.Join(ids, ", "), ErrTooManyAssessmentsFound)
+	}
+	return SaveAssessmentById(ctx, client, db, ids[0])
+}
+
 // saveAssessment processes the assessment data and fetches associated library test cases and defense tools.
 //
 // This function performs the following steps:
 //   - Processes the assessment object to populate the `AssessmentData` struct.
 //   - Extracts library test cases using their IDs and fetches them via the `GetLibraryTestCases` function.
-//   - Fetches all defense tools for the given database using the `GetAllDefenseTools` function.
+//   - Fetches all defense tools for the given database using the `GetAllDefenseTools` function
+//     (once per database for a whole dump, see saveLookups).
 //   - Populates the `ToolsMap` and `IdToolsMap` with defense tool information.
+//   - Records the full target and source records in `Assets`.
 //
 // Parameters:
 //   - ctx: The context for managing request deadlines, cancellations, and other request-scoped values.
@@ -89,6 +154,7 @@ func SaveAssessmentData(ctx context.Context, client graphql.Client, db string, a
 //   - assessment: The assessment object containing campaigns and test cases.
 //   - data: The `AssessmentData` struct to be populated.
 //   - db: The name of the database to query.
+//   - lookups: Lookups shared between the assessments of one dump; nil fetches them for this assessment alone.
 //
 // Returns:
 //   - A pointer to an `AssessmentData` struct containing:
@@ -100,7 +166,7 @@ func SaveAssessmentData(ctx context.Context, client graphql.Client, db string, a
 //
 // Errors:
 //   - Returns a wrapped error with additional context if any GraphQL query fails.
-func saveAssessment(ctx context.Context, client graphql.Client, assessment dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessment, data *AssessmentData, db string) (*AssessmentData, error) {
+func saveAssessment(ctx context.Context, client graphql.Client, assessment dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessment, data *AssessmentData, db string, lookups *saveLookups) (*AssessmentData, error) {
 
 	data.Assessment = assessment
 
@@ -185,7 +251,7 @@ func saveAssessment(ctx context.Context, client graphql.Client, assessment dao.G
 
 	slog.DebugContext(ctx, "Fetching defense tools",
 		"db", db)
-	btr, err := dao.GetAllDefenseTools(ctx, client, db)
+	btr, err := lookups.defenseTools(ctx, client, db)
 	if err != nil {
 		if gqlObject, ok := gqlErrParse(err); ok {
 			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
@@ -198,8 +264,8 @@ func saveAssessment(ctx context.Context, client graphql.Client, assessment dao.G
 	// description/active/product ref, so resolving through this index (rather
 	// than tc.BlueTools' fields directly) keeps every ref fully populated
 	// regardless of which loop finds it first.
-	bluetoolsById := make(map[string]dao.GetAllDefenseToolsBluetoolsBlueToolConnectionNodesBlueTool, len(btr.Bluetools.Nodes))
-	for _, bt := range btr.Bluetools.Nodes {
+	bluetoolsById := make(map[string]dao.GetAllDefenseToolsBluetoolsBlueToolConnectionNodesBlueTool, len(btr))
+	for _, bt := range btr {
 		bluetoolsById[bt.Id] = bt
 	}
 
@@ -235,12 +301,77 @@ func saveAssessment(ctx context.Context, client graphql.Client, assessment dao.G
 		}
 	}
 
+	assets, err := saveAssets(ctx, client, lookups, db, data.Assessment)
+	if err != nil {
+		return nil, err
+	}
+	data.Assets = assets
+
 	slog.DebugContext(ctx, "Finished dumping assessment", "date", data.Manifest.Created, "vat-version", data.Manifest.VatVersion, "assessment-name", data.Assessment.Name, "db", db)
 
 	return data, nil
 
 }
 
+// saveAssets collects every target and source referenced by the
+// assessment's test cases into an AssetsResource, resolving each asset
+// property type id to its name so restore can match it in another instance.
+// Property types are only fetched if some asset actually has one.
+func saveAssets(ctx context.Context, client graphql.Client, lookups *saveLookups, db string, assessment dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessment) (*AssetsResource, error) {
+	assets := &AssetsResource{
+		Targets: map[string]Asset{},
+		Sources: map[string]Asset{},
+	}
+	needsPropertyTypes := false
+	for _, c := range assessment.Campaigns {
+		for _, tc := range c.TestCases {
+			for _, t := range tc.Targets {
+				if _, ok := assets.Targets[t.Name]; !ok {
+					assets.Targets[t.Name] = Asset{Name: t.Name, Description: t.Description, PropertyTypeId: t.AssetPropertyTypeId}
+					needsPropertyTypes = needsPropertyTypes || t.AssetPropertyTypeId != ""
+				}
+			}
+			for _, s := range tc.Sources {
+				if _, ok := assets.Sources[s.Name]; !ok {
+					assets.Sources[s.Name] = Asset{Name: s.Name, PropertyTypeId: s.AssetPropertyTypeId}
+					needsPropertyTypes = needsPropertyTypes || s.AssetPropertyTypeId != ""
+				}
+			}
+		}
+	}
+
+	if needsPropertyTypes {
+		pt, err := lookups.assetPropertyTypes(ctx, client)
+		if err != nil {
+			if gqlObject, ok := gqlErrParse(err); ok {
+				slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+			}
+			return nil, fmt.Errorf("could not fetch asset property types for %s: %w", db, err)
+		}
+		namesById := make(map[string]string, len(pt))
+		for _, n := range pt {
+			namesById[n.Id] = n.Name
+		}
+		for _, m := range []map[string]Asset{assets.Targets, assets.Sources} {
+			for name, a := range m {
+				if a.PropertyTypeId == "" {
+					continue
+				}
+				propertyType, ok := namesById[a.PropertyTypeId]
+				if !ok {
+					slog.WarnContext(ctx, "asset references an unknown asset property type, saving its id only", "asset-name", name, "asset-property-type-id", a.PropertyTypeId)
+					continue
+				}
+				a.PropertyType = propertyType
+				m[name] = a
+			}
+		}
+	}
+
+	slog.DebugContext(ctx, "Saved assets", "assessment-name", assessment.Name, "db", db, "target-count", len(assets.Targets), "source-count", len(assets.Sources))
+	return assets, nil
+}
+
 // toDefenseToolRef projects a full GetAllDefenseTools BlueTool node down to
 // the durable, cross-instance identity restore needs (see DefenseToolRef's
 // doc comment).
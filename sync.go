@@ -0,0 +1,171 @@
+package vat
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"time"
+
+	"github.com/Khan/genqlient/graphql"
+)
+
+// SyncState is what `vat sync` remembers between runs: how far each source
+// assessment has been synced, keyed by its globalId.
+type SyncState struct {
+	Assessments map[string]SyncRecord
+}
+
+// SyncRecord is one assessment's entry in SyncState.
+type SyncRecord struct {
+	AssessmentName string
+	// UpdatedThrough is the newest updateTime (VECTR's epoch milliseconds) of
+	// any campaign or test case the last successful sync sent; the next sync
+	// only sends what was updated after it. That leaves a gap: an edit made
+	// in that same millisecond, but after the last sync read the source, is
+	// not sent until the campaign or test case is updated again. Comparing
+	// with >= instead would close it, at the cost of resending the newest
+	// test case on every sync, so nothing-changed syncs would never be no-ops.
+	UpdatedThrough float64
+	SyncedAt       time.Time
+	// Campaigns and TestCases map source campaign and test case ids to the
+	// target ids they were synced to, so later syncs update those even after
+	// a rename on the source instead of appending a copy.
+	Campaigns map[string]string
+	TestCases map[string]string
+}
+
+// matchesById reports whether RestoreModeUpdate should match on r's ids
+// rather than by name: only the first sync, or one from a state file written
+// before ids were recorded, falls back to names.
+func (r *SyncRecord) matchesById() bool {
+	return r != nil && (len(r.Campaigns) > 0 || len(r.TestCases) > 0)
+}
+
+// recordSyncedIds adds the source -> target campaign and test case ids the
+// restore of ad recorded in journal to r.
+func (r *SyncRecord) recordSyncedIds(ad *AssessmentData, journal *RestoreJournal) {
+	if r.Campaigns == nil {
+		r.Campaigns = map[string]string{}
+	}
+	if r.TestCases == nil {
+		r.TestCases = map[string]string{}
+	}
+	for _, c := range ad.Assessment.Campaigns {
+		if id, ok := journal.Campaigns[c.Name]; ok {
+			r.Campaigns[c.Id] = id
+		}
+		for _, tc := range c.TestCases {
+			if id, ok := journal.TestCases[tc.Id]; ok {
+				r.TestCases[tc.Id] = id
+			}
+		}
+	}
+}
+
+// NewSyncState returns an empty SyncState: every assessment's first sync
+// sends all of it.
+func NewSyncState() *SyncState {
+	return &SyncState{Assessments: map[string]SyncRecord{}}
+}
+
+// ErrMissingGlobalId is returned by SyncAssessment for save data without an
+// assessment globalId, which sync state is keyed by.
+var ErrMissingGlobalId = fmt.Errorf("assessment has no globalId")
+
+// SyncAssessment brings the target instance's copy of ad up to date with
+// RestoreModeUpdate, sending only the campaigns and test cases updated since
+// the assessment's last sync in state. Campaigns and test cases synced before
+// are matched by the target ids recorded for them; names are only used on the
+// first sync. On success it records the new high-water mark and ids in
+// state; the caller persists it. optionalParams.Mode is always
+// RestoreModeUpdate and optionalParams.PreviousSync is set from state.
+//
+// If the target instance doesn't have the assessment (the first sync, or its
+// copy was deleted since), everything is sent so it is created whole.
+func SyncAssessment(ctx context.Context, client graphql.Client, db string, ad *AssessmentData, state *SyncState, optionalParams *RestoreOptionalParams) error {
+	globalId := ad.Assessment.GlobalId
+	if globalId == "" {
+		return fmt.Errorf("could not sync %s: %w", ad.Assessment.Name, ErrMissingGlobalId)
+	}
+	if state.Assessments == nil {
+		state.Assessments = map[string]SyncRecord{}
+	}
+	optionalParams.Mode = RestoreModeUpdate
+
+	record, synced := state.Assessments[globalId]
+	since := record.UpdatedThrough
+	if synced {
+		existingId, _, err := findAssessmentToUpdate(ctx, client, db, ad, optionalParams)
+		if err != nil {
+			return err
+		}
+		if existingId == "" {
+			slog.WarnContext(ctx, "Assessment synced before is not in the target instance any more, sending all of it", "assessment-name", ad.Assessment.Name, "global-id", globalId, "db", db)
+			since = 0
+			record.Campaigns, record.TestCases = nil, nil
+		}
+	}
+	record.Campaigns, record.TestCases = maps.Clone(record.Campaigns), maps.Clone(record.TestCases)
+	optionalParams.PreviousSync = &record
+
+	updatedThrough := latestUpdateTime(ad)
+	campaignCount, testCaseCount := filterUpdatedSince(ad, since)
+	if campaignCount == 0 && since > 0 {
+		slog.InfoContext(ctx, "Nothing updated since the last sync", "assessment-name", ad.Assessment.Name, "global-id", globalId, "last-synced", record.SyncedAt)
+	} else {
+		slog.InfoContext(ctx, "Syncing updated campaigns and test cases", "assessment-name", ad.Assessment.Name, "global-id", globalId, "campaign-count", campaignCount, "test-case-count", testCaseCount, "last-synced", record.SyncedAt)
+		if err := RestoreAssessment(ctx, client, db, ad, optionalParams); err != nil {
+			return err
+		}
+		record.recordSyncedIds(ad, optionalParams.journal())
+	}
+
+	record.AssessmentName = ad.Assessment.Name
+	record.UpdatedThrough = max(updatedThrough, record.UpdatedThrough)
+	record.SyncedAt = time.Now().UTC()
+	state.Assessments[globalId] = record
+	return nil
+}
+
+// latestUpdateTime is the newest updateTime of any campaign or test case in
+// ad.
+func latestUpdateTime(ad *AssessmentData) float64 {
+	var latest float64
+	for _, c := range ad.Assessment.Campaigns {
+		latest = max(latest, c.UpdateTime)
+		for _, tc := range c.TestCases {
+			latest = max(latest, tc.UpdateTime)
+		}
+	}
+	return latest
+}
+
+// filterUpdatedSince drops from ad every test case not updated after since,
+// and every campaign left with none that wasn't itself updated after it. It
+// returns how many campaigns and test cases remain. Updates made in the
+// millisecond of since itself are dropped too (see SyncRecord.UpdatedThrough).
+func filterUpdatedSince(ad *AssessmentData, since float64) (campaignCount, testCaseCount int) {
+	if since <= 0 {
+		for _, c := range ad.Assessment.Campaigns {
+			testCaseCount += len(c.TestCases)
+		}
+		return len(ad.Assessment.Campaigns), testCaseCount
+	}
+	campaigns := ad.Assessment.Campaigns[:0]
+	for _, c := range ad.Assessment.Campaigns {
+		testCases := c.TestCases[:0]
+		for _, tc := range c.TestCases {
+			if tc.UpdateTime > since {
+				testCases = append(testCases, tc)
+			}
+		}
+		c.TestCases = testCases
+		if len(testCases) > 0 || c.UpdateTime > since {
+			campaigns = append(campaigns, c)
+			testCaseCount += len(testCases)
+		}
+	}
+	ad.Assessment.Campaigns = campaigns
+	return len(campaigns), testCaseCount
+}
@@ -0,0 +1,238 @@
+package vat
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"sra/vat/internal/dao"
+
+	"github.com/Khan/genqlient/graphql"
+	"github.com/google/uuid"
+)
+
+// restoreAsTemplate runs RestoreAssessment for AsTemplate: it turns the
+// archive into a library assessment, with a library campaign per campaign
+// and a library test case per test case, so it can be reused as a test plan.
+//
+// Library test cases get fresh ids, so no existing library test case is ever
+// overwritten, and everything is created without overwrite; a name that's
+// already taken in the library fails the restore. Only what a library object
+// can hold is carried over: statuses, outcomes, timelines, defense tool
+// outcomes, targets, sources, attachments and unstructured logs are
+// environment-only and dropped. Template prefixes are handled the way
+// createTemplateData does, from each object's "prefix" metadata.
+//
+// Every object is journaled as it is created (journal.TestCases,
+// journal.Campaigns and journal.AssessmentId, plus journal.Created for
+// RollbackRestore), so a failed restore can be resumed or rolled back.
+func restoreAsTemplate(ctx context.Context, client graphql.Client, db string, ad *AssessmentData, restoreInfo VatOpMetadata, optionalParams *RestoreOptionalParams) error {
+	journal := optionalParams.journal()
+	campaigns, err := filterTestCases(ctx, ad.Assessment.Campaigns, optionalParams.TestCaseFilter)
+	if err != nil {
+		return err
+	}
+	campaigns = slices.Clone(campaigns)
+	slices.SortStableFunc(campaigns, func(a, b dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+	ad.Assessment.Campaigns = campaigns
+
+	if err := applyOrgMapping(ctx, client, db, ad, optionalParams.OrgMapping); err != nil {
+		return err
+	}
+	org_map, err := validateRestorePrerequisites(ctx, client, db, ad.OrgMap)
+	if err != nil {
+		return err
+	}
+
+	if optionalParams.AssessmentName != "" {
+		slog.DebugContext(ctx, "overiding assessment name", "old-assessment-name", ad.Assessment.Name, "new-assessment-name", optionalParams.AssessmentName)
+		ad.Assessment.Name = optionalParams.AssessmentName
+	}
+	assessment := dao.CreateAssessmentTemplateDataInput{
+		Description: ad.Assessment.Description,
+		KillChainId: ad.Assessment.KillChain.Id,
+	}
+	for _, o := range ad.Assessment.Organizations {
+		assessment.OrganizationIds = append(assessment.OrganizationIds, org_map[o.Name].Id)
+	}
+	for _, md := range loadVatMetadata(ad.Assessment.Metadata, ad.Manifest, restoreInfo) {
+		assessment.Metadata = append(assessment.Metadata, dao.MetadataKeyValuePairInput(md))
+	}
+	assessment.Name, assessment.TemplatePrefix = splitTemplatePrefix(ad.Assessment.Name, assessment.Metadata)
+
+	if journal.AssessmentId == "" {
+		libraryName := assessment.Name
+		if assessment.TemplatePrefix != "" {
+			libraryName = assessment.TemplatePrefix + " - " + assessment.Name
+		}
+		existing, err := dao.FindLibraryAssessment(ctx, client, libraryName)
+		if err != nil {
+			if gqlObject, ok := gqlErrParse(err); ok {
+				slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+			}
+			return fmt.Errorf("could not look up library assessment %s: %w", libraryName, err)
+		}
+		if len(existing.LibraryAssessments.Nodes) > 0 {
+			return fmt.Errorf("could not add %s to the library: %w", libraryName, ErrAssessmentAlreadyExists)
+		}
+	}
+
+	stripped := 0
+	for _, c := range campaigns {
+		for _, tc := range c.TestCases {
+			if tc.Status != "" || tc.Outcome.Path != "" || len(tc.TimelineEvents) > 0 || len(tc.DefenseToolOutcomes) > 0 ||
+				len(tc.Targets) > 0 || len(tc.Sources) > 0 || len(tc.AttachmentFiles) > 0 || len(tc.UnstructuredLogs) > 0 {
+				stripped++
+			}
+		}
+	}
+	if stripped > 0 {
+		slog.InfoContext(ctx, "Library test cases can't hold environment data; statuses, outcomes, timelines, targets, sources, attachments and unstructured logs are left out", "assessment-name", ad.Assessment.Name, "test-case-count", stripped)
+	}
+
+	// Library test cases, a batch per campaign
+	for _, c := range campaigns {
+		input := dao.CreateTestCaseTemplateInput{
+			Overwrite:            false,
+			TestCaseTemplateData: []dao.CreateTestCaseTemplateDataInput{},
+		}
+		sourceIds := make(map[string]string) // new library test case id -> source test case id
+		testCases := slices.Clone(c.TestCases)
+		slices.SortStableFunc(testCases, func(a, b dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase) int {
+			return cmp.Compare(a.Offset, b.Offset)
+		})
+		for _, tc := range testCases {
+			if _, ok := journal.TestCases[tc.Id]; ok {
+				continue // created by the restore being resumed
+			}
+			ttc, errors, err := envTestCaseTemplateData(tc)
+			if err != nil {
+				slog.ErrorContext(ctx, "could not build template test case data", "test-case-id", tc.Id, "test-case-name", tc.Name, "campaign", c.Name, "assessment-name", ad.Assessment.Name, "err", err)
+				return err
+			}
+			for _, err := range errors {
+				slog.WarnContext(ctx, "parsing discrepencies found, they were recovered but review if needed", "test-case-id", tc.Id, "test-case-name", tc.Name, "campaign", c.Name, "assessment-name", ad.Assessment.Name, "err", err)
+			}
+			sourceIds[ttc.LibraryTestCaseId] = tc.Id
+			input.TestCaseTemplateData = append(input.TestCaseTemplateData, ttc)
+		}
+		if len(input.TestCaseTemplateData) == 0 {
+			continue
+		}
+		r, err := dao.CreateTemplateTestCases(ctx, client, input)
+		if err != nil {
+			if gqlObject, ok := gqlErrParse(err); ok {
+				slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+			}
+			return fmt.Errorf("could not write library test cases for campaign %s: %w", c.Name, err)
+		}
+		var created []string
+		for _, tc := range r.TestCase.CreateTemplate.TestCases {
+			if sourceId, ok := sourceIds[tc.LibraryTestCaseId]; ok {
+				journal.TestCases[sourceId] = tc.LibraryTestCaseId
+				created = append(created, tc.Id)
+			}
+		}
+		if err := optionalParams.recordCreated(ctx, &journal.Created.TestCaseTemplates, created...); err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "Library test cases created", "campaign", c.Name, "count", len(created))
+	}
+
+	// Library campaigns, one at a time so each id is known to be its own
+	for _, c := range campaigns {
+		if _, ok := journal.Campaigns[c.Name]; ok {
+			continue
+		}
+		campaign := dao.CreateCampaignTemplateDataInput{
+			Description: c.Description,
+		}
+		testCases := slices.Clone(c.TestCases)
+		slices.SortStableFunc(testCases, func(a, b dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase) int {
+			return cmp.Compare(a.Offset, b.Offset)
+		})
+		for _, tc := range testCases {
+			campaign.LibraryTestCaseIds = append(campaign.LibraryTestCaseIds, journal.TestCases[tc.Id])
+		}
+		for _, o := range c.Organizations {
+			campaign.OrganizationIds = append(campaign.OrganizationIds, org_map[o.Name].Id)
+		}
+		for _, md := range c.Metadata {
+			campaign.Metadata = append(campaign.Metadata, dao.MetadataKeyValuePairInput(md))
+		}
+		campaign.Name, campaign.TemplatePrefix = splitTemplatePrefix(c.Name, campaign.Metadata)
+		r, err := dao.CreateCampaignTemplates(ctx, client, dao.CreateCampaignTemplateInput{
+			Overwrite:            false,
+			CampaignTemplateData: []dao.CreateCampaignTemplateDataInput{campaign},
+		})
+		if err != nil {
+			if gqlObject, ok := gqlErrParse(err); ok {
+				slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+			}
+			return fmt.Errorf("could not create library campaign %s (is the name already taken in the library?): %w", c.Name, err)
+		}
+		if len(r.Campaign.CreateTemplate.Campaigns) != 1 {
+			return fmt.Errorf("creating library campaign %s returned %d campaigns, want 1", c.Name, len(r.Campaign.CreateTemplate.Campaigns))
+		}
+		id := r.Campaign.CreateTemplate.Campaigns[0].Id
+		journal.Campaigns[c.Name] = id
+		if err := optionalParams.recordCreated(ctx, &journal.Created.CampaignTemplates, id); err != nil {
+			return err
+		}
+	}
+	slog.InfoContext(ctx, "Library campaigns created", "assessment-name", ad.Assessment.Name, "count", len(campaigns))
+
+	// The library assessment
+	if journal.AssessmentId == "" {
+		for _, c := range campaigns {
+			assessment.LibraryCampaignIds = append(assessment.LibraryCampaignIds, journal.Campaigns[c.Name])
+		}
+		r, err := dao.CreateAssessmentTemplate(ctx, client, dao.CreateAssessmentTemplateInput{
+			Overwrite:              false,
+			AssessmentTemplateData: []dao.CreateAssessmentTemplateDataInput{assessment},
+		})
+		if err != nil {
+			if gqlObject, ok := gqlErrParse(err); ok {
+				slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+			}
+			return fmt.Errorf("could not create library assessment %s: %w", assessment.Name, err)
+		}
+		if len(r.Assessment.CreateTemplate.Assessments) != 1 {
+			return fmt.Errorf("creating library assessment %s returned %d assessments, want 1", assessment.Name, len(r.Assessment.CreateTemplate.Assessments))
+		}
+		journal.AssessmentName = assessment.Name
+		journal.AssessmentId = r.Assessment.CreateTemplate.Assessments[0].Id
+		if err := optionalParams.recordCreated(ctx, &journal.Created.AssessmentTemplates, journal.AssessmentId); err != nil {
+			return err
+		}
+	}
+	slog.InfoContext(ctx, "Library assessment created", "assessment-name", journal.AssessmentName, "template-prefix", assessment.TemplatePrefix, "assessment-id", journal.AssessmentId)
+
+	journal.Complete = true
+	return optionalParams.checkpoint(ctx)
+}
+
+// envTestCaseTemplateData builds the library test case restoreAsTemplate
+// creates for an assessment's test case, under a fresh library id. The test
+// case is read into the library test case type, whose selection it shares,
+// and converted by createTemplateData, so both write the same fields.
+func envTestCaseTemplateData(tc dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase) (dao.CreateTestCaseTemplateDataInput, []error, error) {
+	if len(tc.Organizations) == 0 {
+		return dao.CreateTestCaseTemplateDataInput{}, nil, fmt.Errorf("test case %s (id %s) has no organization set", tc.Name, tc.Id)
+	}
+	raw, err := json.Marshal(tc)
+	if err != nil {
+		return dao.CreateTestCaseTemplateDataInput{}, nil, fmt.Errorf("could not read test case %s (id %s): %w", tc.Name, tc.Id, err)
+	}
+	var ltc dao.GetLibraryTestCasesLibraryTestcasesByIdsTestCaseConnectionNodesTestCase
+	if err := json.Unmarshal(raw, &ltc); err != nil {
+		return dao.CreateTestCaseTemplateDataInput{}, nil, fmt.Errorf("could not read test case %s (id %s) as a library test case: %w", tc.Name, tc.Id, err)
+	}
+	ltc.LibraryTestCaseId = uuid.NewString()
+	return createTemplateData(ltc)
+}
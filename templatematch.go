@@ -0,0 +1,154 @@
+package vat
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"sra/vat/internal/dao"
+
+	"github.com/Khan/genqlient/graphql"
+)
+
+// TemplateMatch says how restored test cases are linked to library test
+// cases in the target instance.
+type TemplateMatch string
+
+const (
+	// TemplateMatchId, the default, links each test case to the library test
+	// case with the same id, which must exist in the target instance (or be
+	// created by OverrideAssessmentTemplate or CreateMissingTemplates).
+	TemplateMatchId TemplateMatch = "id"
+	// TemplateMatchName links each test case to the target library test case
+	// with the same name and prefix as its source one, whatever its id; one
+	// with no such match falls back to TemplateMatchId.
+	TemplateMatchName TemplateMatch = "name"
+	// TemplateMatchIdThenName links by id where the target instance has the
+	// id, and by name and prefix only for the ids it is missing. This is the
+	// one for instances that imported the same library content separately.
+	TemplateMatchIdThenName TemplateMatch = "id-then-name"
+)
+
+// ErrInvalidTemplateMatch is returned for a TemplateMatch other than the
+// ones above, or one combined with options it can't be used with.
+var ErrInvalidTemplateMatch = fmt.Errorf("invalid template match strategy")
+
+// ErrAmbiguousTemplateMatch is returned when more than one library test case
+// in the target instance has the name and prefix a test case is matched by.
+var ErrAmbiguousTemplateMatch = fmt.Errorf("library test case(s) match more than one target library test case")
+
+// ParseTemplateMatch returns the TemplateMatch named s; a blank s is
+// TemplateMatchId.
+func ParseTemplateMatch(s string) (TemplateMatch, error) {
+	switch TemplateMatch(s) {
+	case "", TemplateMatchId:
+		return TemplateMatchId, nil
+	case TemplateMatchName, TemplateMatchIdThenName:
+		return TemplateMatch(s), nil
+	}
+	return "", fmt.Errorf("%q, want %q, %q or %q: %w", s, TemplateMatchId, TemplateMatchName, TemplateMatchIdThenName, ErrInvalidTemplateMatch)
+}
+
+// checkTemplateMatch validates optionalParams.TemplateMatch. Matching by name
+// means nothing when ForceEnvOnly links no test case, or when
+// OverrideAssessmentTemplate writes every library test case under its
+// source id.
+func checkTemplateMatch(optionalParams *RestoreOptionalParams) error {
+	match, err := ParseTemplateMatch(string(optionalParams.TemplateMatch))
+	if err != nil {
+		return err
+	}
+	if match != TemplateMatchId && (optionalParams.ForceEnvOnly || optionalParams.OverrideAssessmentTemplate) {
+		return fmt.Errorf("%q can't be combined with force-env-only or override-template-assessment: %w", match, ErrInvalidTemplateMatch)
+	}
+	return nil
+}
+
+// matchLibraryTestCasesByName looks up each of ids' library test case (from
+// ad.LibraryTestCases) in the target instance by name and prefix, and
+// records every match in journal.LibraryNameMatches for restoreCampaigns to
+// link against. Ids with no archived library test case, or no match, are
+// left for the id path. Ids matching more than one target library test case
+// fail with ErrAmbiguousTemplateMatch, listing every candidate, once all of
+// ids have been looked up.
+//
+// VECTR's createWithTemplateMatchByName would do the match itself, but it
+// creates a library test case when nothing matches, which the journal can't
+// tell apart from an existing one; matching up front keeps rollback from
+// ever deleting a library test case the restore didn't create.
+func matchLibraryTestCasesByName(ctx context.Context, client graphql.Client, ad *AssessmentData, ids []string, optionalParams *RestoreOptionalParams) error {
+	journal := optionalParams.journal()
+	matched := 0
+	var ambiguous []string
+	for _, id := range ids {
+		if _, ok := journal.LibraryNameMatches[id]; ok {
+			continue // matched by the restore being resumed
+		}
+		ltc, ok := ad.LibraryTestCases[id]
+		if !ok {
+			slog.WarnContext(ctx, "Library test case is not in the archive, so it can't be matched by name", "library-test-case-id", id)
+			continue
+		}
+		prefix := ""
+		for _, md := range ltc.Metadata {
+			if md.Key == "prefix" {
+				prefix = md.Value
+				break
+			}
+		}
+
+		nodes, err := dao.ListLibraryTestCasesByName(ctx, client, ltc.Name)
+		if err != nil {
+			if gqlObject, ok := gqlErrParse(err); ok {
+				slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+			}
+			return fmt.Errorf("could not look up library test case %q by name: %w", ltc.Name, err)
+		}
+		candidates := slices.DeleteFunc(nodes, func(n dao.FindLibraryTestCasesByNameLibraryTestcasesTestCaseConnectionNodesTestCase) bool {
+			candidatePrefix := ""
+			for _, md := range n.Metadata {
+				if md.Key == "prefix" {
+					candidatePrefix = md.Value
+					break
+				}
+			}
+			return candidatePrefix != prefix
+		})
+		if len(candidates) == 0 {
+			slog.InfoContext(ctx, "No library test case with the same name and prefix in the target instance", "library-test-case-id", id, "name", ltc.Name, "prefix", prefix)
+			continue
+		}
+		if len(candidates) > 1 {
+			candidateIds := make([]string, len(candidates))
+			for i, c := range candidates {
+				candidateIds[i] = c.LibraryTestCaseId
+			}
+			slog.ErrorContext(ctx, "More than one library test case has the same name and prefix", "library-test-case-id", id, "name", ltc.Name, "prefix", prefix, "candidate-ids", candidateIds)
+			ambiguous = append(ambiguous, fmt.Sprintf("%s (%q, prefix %q): %s", id, ltc.Name, prefix, strings.Join(candidateIds, ", ")))
+			continue
+		}
+		journal.LibraryNameMatches[id] = candidates[0].LibraryTestCaseId
+		matched++
+		slog.InfoContext(ctx, "Matched library test case by name", "library-test-case-id", id, "target-library-test-case-id", candidates[0].LibraryTestCaseId, "name", ltc.Name, "prefix", prefix)
+	}
+	if matched > 0 {
+		if err := optionalParams.checkpoint(ctx); err != nil {
+			return err
+		}
+	}
+	if len(ambiguous) > 0 {
+		return fmt.Errorf("%w: %s", ErrAmbiguousTemplateMatch, strings.Join(ambiguous, "; "))
+	}
+	return nil
+}
+
+// linkedLibraryTestCaseId returns the target library test case a test case
+// with source library id id is linked to, and whether it was matched by name.
+func (j *RestoreJournal) linkedLibraryTestCaseId(id string) (string, bool) {
+	if target, ok := j.LibraryNameMatches[id]; ok {
+		return target, true
+	}
+	return id, false
+}
@@ -0,0 +1,386 @@
+package vat
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sra/vat/internal/dao"
+
+	"github.com/Khan/genqlient/graphql"
+)
+
+// RestoreMode says what RestoreAssessment does about an assessment that is
+// already in the target instance.
+type RestoreMode string
+
+const (
+	// RestoreModeCreate, the default, always creates a new assessment and
+	// fails with ErrAssessmentAlreadyExists or ErrDuplicateGlobalId if it is
+	// already there.
+	RestoreModeCreate RestoreMode = "create"
+	// RestoreModeUpdate brings an existing assessment (found by globalId,
+	// then name) up to date with the save data instead, and creates it only
+	// if it isn't there yet. See updateExistingAssessment.
+	RestoreModeUpdate RestoreMode = "update"
+)
+
+// ErrInvalidRestoreMode is returned for a RestoreMode other than the ones
+// above, or one combined with options it can't be used with.
+var ErrInvalidRestoreMode = fmt.Errorf("invalid restore mode")
+
+// ParseRestoreMode returns the RestoreMode named s; a blank s is
+// RestoreModeCreate.
+func ParseRestoreMode(s string) (RestoreMode, error) {
+	switch RestoreMode(s) {
+	case "", RestoreModeCreate:
+		return RestoreModeCreate, nil
+	case RestoreModeUpdate:
+		return RestoreModeUpdate, nil
+	}
+	return "", fmt.Errorf("%q, want %q or %q: %w", s, RestoreModeCreate, RestoreModeUpdate, ErrInvalidRestoreMode)
+}
+
+// findAssessmentToUpdate looks for the assessment RestoreModeUpdate should
+// update: the one with the save data's globalId, or failing that the one
+// with the name the restore would give it. It returns a blank id if there is
+// neither.
+func findAssessmentToUpdate(ctx context.Context, client graphql.Client, db string, ad *AssessmentData, optionalParams *RestoreOptionalParams) (id, name string, err error) {
+	assessments, err := dao.ListAssessmentIdsForDb(ctx, client, db)
+	if err != nil {
+		if gqlObject, ok := gqlErrParse(err); ok {
+			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+		}
+		return "", "", fmt.Errorf("could not list assessments in %s: %w", db, err)
+	}
+	if ad.Assessment.GlobalId != "" {
+		for _, a := range assessments {
+			if a.GlobalId == ad.Assessment.GlobalId {
+				slog.InfoContext(ctx, "Found assessment to update by globalId", "db", db, "assessment-name", a.Name, "assessment-id", a.Id, "global-id", a.GlobalId)
+				return a.Id, a.Name, nil
+			}
+		}
+	}
+	name = ad.Assessment.Name
+	if optionalParams.AssessmentName != "" {
+		name = optionalParams.AssessmentName
+	}
+	for _, a := range assessments {
+		if a.Name == name {
+			slog.InfoContext(ctx, "Found assessment to update by name", "db", db, "assessment-name", a.Name, "assessment-id", a.Id, "global-id", a.GlobalId, "source-global-id", ad.Assessment.GlobalId)
+			return a.Id, a.Name, nil
+		}
+	}
+	return "", "", nil
+}
+
+// updateExistingAssessment prepares the journal so restoreCampaigns only
+// appends to the existing assessment, and updates the test cases it already
+// has:
+//
+//   - campaigns and test cases an earlier sync wrote are matched by the
+//     target ids it recorded (optionalParams.PreviousSync), so a rename on
+//     the source still updates them; records the sync has no id for are
+//     appended;
+//   - otherwise campaigns are matched by name, and test cases within a
+//     matched campaign by library test case id + name (the one at the same
+//     offset, else the first in offset order, when several share both);
+//   - matched test cases whose fields differ from the save data are updated
+//     with one UpdateTestCases call;
+//   - timeline events already on a matched test case (same team, type,
+//     field, action, description and time) are marked as written, so only
+//     the missing ones are added.
+//
+// It runs again on a resume, against the assessment as the interrupted
+// restore left it: everything it does is idempotent, and firstRun (false on
+// a resume) keeps the campaigns and test cases the restore appended out of
+// journal.PreExisting.
+func updateExistingAssessment(ctx context.Context, client graphql.Client, db string, ad *AssessmentData, toolIdByKey map[string]string, firstRun bool, optionalParams *RestoreOptionalParams) error {
+	journal := optionalParams.journal()
+	r, err := dao.GetAllAssessments(ctx, client, db, journal.AssessmentName)
+	if err != nil {
+		if gqlObject, ok := gqlErrParse(err); ok {
+			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+		}
+		return fmt.Errorf("could not fetch assessment %s to update: %w", journal.AssessmentName, err)
+	}
+	idx := slices.IndexFunc(r.Assessments.Nodes, func(a dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessment) bool {
+		return a.Id == journal.AssessmentId
+	})
+	if idx < 0 {
+		return fmt.Errorf("assessment %s (id %s) to update is no longer in %s", journal.AssessmentName, journal.AssessmentId, db)
+	}
+
+	outcomes, err := dao.GetAllOutcomes(ctx, client)
+	if err != nil {
+		if gqlObject, ok := gqlErrParse(err); ok {
+			slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+		}
+		return fmt.Errorf("could not fetch outcomes: %w", err)
+	}
+	outcomeIdsByPath := make(map[string]string, len(outcomes.Outcomes))
+	for _, o := range outcomes.Outcomes {
+		outcomeIdsByPath[o.Path] = o.Id
+	}
+
+	updates := planAssessmentUpdate(ctx, journal, ad, &r.Assessments.Nodes[idx], optionalParams.PreviousSync, toolIdByKey, outcomeIdsByPath, firstRun)
+	if len(updates) > 0 {
+		if _, err := dao.UpdateTestCases(ctx, client, dao.UpdateTestCaseInput{Db: db, TestCaseUpdates: updates}); err != nil {
+			if gqlObject, ok := gqlErrParse(err); ok {
+				slog.ErrorContext(ctx, "detailed error", "error", gqlObject)
+			}
+			return fmt.Errorf("could not update %d test case(s) in %s: %w", len(updates), journal.AssessmentName, err)
+		}
+	}
+	slog.InfoContext(ctx, "Updated existing test cases", "assessment-name", journal.AssessmentName, "db", db, "updated-count", len(updates))
+	return optionalParams.checkpoint(ctx)
+}
+
+// planAssessmentUpdate does the matching for updateExistingAssessment: it
+// records matches in journal and returns the updates for the matched test
+// cases that changed. previous, if it recorded any ids, replaces matching by
+// name (see SyncRecord.matchesById).
+func planAssessmentUpdate(ctx context.Context, journal *RestoreJournal, ad *AssessmentData, existing *dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessment, previous *SyncRecord, toolIdByKey map[string]string, outcomeIdsByPath map[string]string, firstRun bool) []dao.UpdateTestCaseDataInput {
+	if firstRun {
+		for _, ec := range existing.Campaigns {
+			journal.PreExisting[ec.Id] = true
+			for _, etc := range ec.TestCases {
+				journal.PreExisting[etc.Id] = true
+			}
+		}
+	}
+	byPreviousId := previous.matchesById()
+
+	existingCampaigns := make(map[string]*dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign, len(existing.Campaigns))
+	existingCampaignsById := make(map[string]*dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign, len(existing.Campaigns))
+	// every existing test case, whichever campaign it is in, for matching by
+	// a previous sync's ids
+	existingTestCasesById := make(map[string]*dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase)
+	for i := range existing.Campaigns {
+		ec := &existing.Campaigns[i]
+		if _, ok := existingCampaigns[ec.Name]; !ok {
+			existingCampaigns[ec.Name] = ec
+		}
+		existingCampaignsById[ec.Id] = ec
+		for j := range ec.TestCases {
+			existingTestCasesById[ec.TestCases[j].Id] = &ec.TestCases[j]
+		}
+	}
+	claimed := make(map[string]bool, len(journal.TestCases))
+	for _, id := range journal.TestCases {
+		claimed[id] = true
+	}
+
+	var updates []dao.UpdateTestCaseDataInput
+	for _, c := range ad.Assessment.Campaigns {
+		var ec *dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaign
+		if byPreviousId {
+			ec = existingCampaignsById[previous.Campaigns[c.Id]]
+		} else {
+			ec = existingCampaigns[c.Name]
+		}
+		if ec == nil {
+			continue // appended by restoreCampaigns
+		}
+		if id, ok := journal.Campaigns[c.Name]; ok && id != ec.Id {
+			continue // a same-named campaign the restore appended earlier
+		}
+		journal.Campaigns[c.Name] = ec.Id
+		if ec.Name != c.Name {
+			slog.WarnContext(ctx, "campaign was renamed in the source, but VECTR has no campaign update mutation, keeping the target name", "campaign-id", ec.Id, "campaign_name", ec.Name, "source-campaign-name", c.Name)
+		}
+
+		byId := make(map[string]*dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase, len(ec.TestCases))
+		unmatched := make(map[string][]*dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase)
+		existingTestCases := slices.Clone(ec.TestCases)
+		slices.SortStableFunc(existingTestCases, func(a, b dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase) int {
+			return cmp.Compare(a.Offset, b.Offset)
+		})
+		for i := range existingTestCases {
+			etc := &existingTestCases[i]
+			byId[etc.Id] = etc
+			if !claimed[etc.Id] {
+				key := testCaseMatchKey(etc.LibraryTestCaseId, etc.Name)
+				unmatched[key] = append(unmatched[key], etc)
+			}
+		}
+
+		sourceTestCases := slices.Clone(c.TestCases)
+		slices.SortStableFunc(sourceTestCases, func(a, b dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase) int {
+			return cmp.Compare(a.Offset, b.Offset)
+		})
+		for i := range sourceTestCases {
+			stc := &sourceTestCases[i]
+			var etc *dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase
+			if id, ok := journal.TestCases[stc.Id]; ok {
+				if etc = byId[id]; etc == nil && byPreviousId {
+					etc = existingTestCasesById[id]
+				}
+				if etc == nil {
+					continue
+				}
+			} else if byPreviousId {
+				id, ok := previous.TestCases[stc.Id]
+				if etc = existingTestCasesById[id]; !ok || etc == nil || claimed[id] {
+					continue // appended by restoreCampaigns
+				}
+				claimed[id] = true
+				journal.TestCases[stc.Id] = etc.Id
+			} else {
+				libraryTestCaseId, _ := journal.linkedLibraryTestCaseId(stc.LibraryTestCaseId)
+				key := testCaseMatchKey(libraryTestCaseId, stc.Name)
+				queue := unmatched[key]
+				if len(queue) == 0 {
+					continue // appended by restoreCampaigns
+				}
+				// Prefer the duplicate at the same offset, so a partial
+				// source (see SyncAssessment) still pairs them up right.
+				i := max(slices.IndexFunc(queue, func(e *dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase) bool {
+					return e.Offset == stc.Offset
+				}), 0)
+				etc = queue[i]
+				unmatched[key] = slices.Delete(slices.Clone(queue), i, i+1)
+				journal.TestCases[stc.Id] = etc.Id
+			}
+			if etc.Name != stc.Name {
+				slog.WarnContext(ctx, "test case was renamed in the source, but VECTR's test case update can't set a name, keeping the target name", "test-case-id", etc.Id, "test-case-name", etc.Name, "source-test-case-name", stc.Name)
+			}
+
+			present := make(map[string]bool, len(etc.TimelineEvents))
+			for _, te := range etc.TimelineEvents {
+				present[timelineEventMatchKey(te)] = true
+			}
+			for _, te := range stc.TimelineEvents {
+				if te.Id != "" && present[timelineEventMatchKey(te)] {
+					journal.TimelineEvents[te.Id] = true
+				}
+			}
+
+			if update, ok := testCaseUpdate(ctx, stc, etc, toolIdByKey, ad.IdToolsMap, outcomeIdsByPath); ok {
+				updates = append(updates, update)
+			}
+		}
+	}
+	return updates
+}
+
+// testCaseMatchKey is what RestoreModeUpdate matches test cases on within a
+// campaign.
+func testCaseMatchKey(libraryTestCaseId, name string) string {
+	if libraryTestCaseId == "null" {
+		libraryTestCaseId = ""
+	}
+	return libraryTestCaseId + "\x00" + name
+}
+
+// timelineEventMatchKey is what RestoreModeUpdate matches timeline events on.
+// Tool outcome changes are left out: their tool ids differ between instances.
+func timelineEventMatchKey(te *dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCaseTimelineEventsTimelineEvent) string {
+	return strings.Join([]string{
+		te.Team,
+		strings.ToLower(te.Type),
+		te.FieldName,
+		te.FieldAction,
+		te.ManualDescription,
+		strconv.FormatInt(int64(te.CreateTime), 10),
+	}, "\x00")
+}
+
+// testCaseUpdate returns the update that brings existing in line with src,
+// and whether there is anything to update. Only fields the update mutation
+// can write are compared. A blank attack success or an empty set of defense
+// tool outcomes in src clears the target's.
+func testCaseUpdate(ctx context.Context, src, existing *dao.GetAllAssessmentsAssessmentsAssessmentConnectionNodesAssessmentCampaignsCampaignTestCasesTestCase, toolIdByKey map[string]string, idToolsMap map[string]DefenseToolRef, outcomeIdsByPath map[string]string) (dao.UpdateTestCaseDataInput, bool) {
+	update := dao.UpdateTestCaseDataInput{TestCaseId: existing.Id}
+	changed := false
+	setString := func(field **string, want, have string) {
+		if want != have {
+			*field = &want
+			changed = true
+		}
+	}
+	setString(&update.Description, src.Description, existing.Description)
+	setString(&update.OperatorGuidance, src.OperatorGuidance, existing.OperatorGuidance)
+	setString(&update.OutcomeNotes, src.OutcomeNotes, existing.OutcomeNotes)
+	setString(&update.UserContext, src.UserContext, existing.UserContext)
+
+	if normalizeStatus(src.Status) != normalizeStatus(existing.Status) {
+		status := normalizeStatus(src.Status)
+		update.CurrentStatus = &status
+		changed = true
+	}
+	if src.Outcome.Path != "" && src.Outcome.Path != existing.Outcome.Path {
+		if id, ok := outcomeIdsByPath[src.Outcome.Path]; ok {
+			update.Outcome = &id
+			changed = true
+		} else {
+			slog.WarnContext(ctx, "outcome not found in the target instance, leaving the test case's outcome as it is", "test-case-id", existing.Id, "test-case-name", existing.Name, "outcome-path", src.Outcome.Path)
+		}
+	}
+	if src.OverrideOutcome != existing.OverrideOutcome {
+		override := src.OverrideOutcome
+		update.OverrideOutcome = &override
+		changed = true
+	}
+	if strings.TrimSpace(string(src.AttackSuccess)) != strings.TrimSpace(string(existing.AttackSuccess)) {
+		var attackSuccess *dao.AttackSuccessState
+		if strings.TrimSpace(string(src.AttackSuccess)) != "" {
+			want := src.AttackSuccess
+			attackSuccess = &want
+		}
+		update.AttackSuccess = &attackSuccess
+		changed = true
+	}
+
+	wantTags := make(map[string]bool, len(src.Tags))
+	for _, tag := range src.Tags {
+		wantTags[tag.Name] = true
+	}
+	haveTags := make(map[string]bool, len(existing.Tags))
+	for _, tag := range existing.Tags {
+		haveTags[tag.Name] = true
+		if !wantTags[tag.Name] {
+			update.RemoveTagsByName = append(update.RemoveTagsByName, tag.Name)
+		}
+	}
+	for _, tag := range src.Tags {
+		if !haveTags[tag.Name] {
+			update.AddTagsByName = append(update.AddTagsByName, tag.Name)
+		}
+	}
+	if len(update.AddTagsByName) > 0 || len(update.RemoveTagsByName) > 0 {
+		changed = true
+	}
+
+	var wantOutcomes, haveOutcomes []string
+	outcomes := []dao.DefenseToolOutcomeInput{}
+	for _, o := range src.DefenseToolOutcomes {
+		toolId := toolIdByKey[idToolsMap[strconv.Itoa(o.DefenseToolId)].Key()]
+		outcomes = append(outcomes, dao.DefenseToolOutcomeInput{DefenseToolId: toolId, OutcomeId: o.OutcomeId})
+		wantOutcomes = append(wantOutcomes, toolId+"/"+o.OutcomeId)
+	}
+	for _, o := range existing.DefenseToolOutcomes {
+		haveOutcomes = append(haveOutcomes, strconv.Itoa(o.DefenseToolId)+"/"+o.OutcomeId)
+	}
+	slices.Sort(wantOutcomes)
+	slices.Sort(haveOutcomes)
+	if !slices.Equal(wantOutcomes, haveOutcomes) {
+		update.DefenseToolOutcomes = &outcomes
+		changed = true
+	}
+
+	return update, changed
+}
+
+// normalizeStatus maps a saved test case status to the TestCaseStatus the
+// API takes, passing unknown ones through as-is.
+func normalizeStatus(status string) dao.TestCaseStatus {
+	if s, ok := outcomeStatusMap[status]; ok {
+		return s
+	}
+	return dao.TestCaseStatus(status)
+}
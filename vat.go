@@ -98,6 +98,8 @@ type AssessmentData struct {
 	OrgMap     OrgMapResource
 	ToolsMap   ToolsMapResource
 	IdToolsMap IdToolsMapResource
+	// Assets is optional (see AssetsResource): nil when the file predates it.
+	Assets *AssetsResource
 	// Manifest is save-time provenance and part of the wire file itself —
 	// see Manifest's doc comment. Stamped via NewManifestMetadata at save
 	// time; handed back as-is by DecodeJson.